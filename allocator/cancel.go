@@ -0,0 +1,155 @@
+package allocator
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"agones-pubsub-allocator/metrics"
+	"agones-pubsub-allocator/queues"
+
+	"github.com/rs/zerolog/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// errTicketCancelled is returned instead of publishing a final result for a ticket
+// whose allocation was aborted
+var errTicketCancelled = errors.New("ticket cancelled")
+
+// abortHandle lets an allocation-cancel message abort an allocation in flight
+type abortHandle struct {
+	cancel   context.CancelFunc
+	aborted  bool
+	finished bool
+}
+
+// runAllocation runs allocate under a context that an allocation-cancel message can
+// abort. An aborted ticket publishes no Success or Failure; the player's token is
+// removed again and it gets a Cancelled result, even if the allocation itself completed.
+func (c *Controller) runAllocation(ctx context.Context, req *queues.AllocationRequest) error {
+	actx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	h := &abortHandle{cancel: cancel}
+	c.abortMu.Lock()
+	if c.aborts == nil {
+		c.aborts = make(map[string]*abortHandle)
+	}
+	c.aborts[req.TicketID] = h
+	c.abortMu.Unlock()
+
+	err := c.allocate(actx, req)
+
+	c.abortMu.Lock()
+	delete(c.aborts, req.TicketID)
+	aborted := h.aborted
+	c.abortMu.Unlock()
+	if !aborted {
+		return err
+	}
+
+	log.Info().Str("ticketId", req.TicketID).Msg("controller: allocation aborted by cancel request")
+	if gsName, ok := c.queueManager.FindTicket(req.TicketID); ok {
+		c.queueManager.RemoveFromQueue(gsName, req.TicketID)
+		c.queueChanged(ctx, gsName)
+	}
+	if req.PlayerID != "" {
+		if err := c.removeTokenFromAllGameServers(ctx, req.Fleet, req.PlayerID, buildQuilkinToken(req.PlayerID)); err != nil {
+			log.Error().Err(err).Str("ticketId", req.TicketID).Msg("controller: failed to remove token of cancelled ticket")
+		}
+	}
+	return c.publishCancelled(ctx, req)
+}
+
+// abortInflight cancels the ticket's running allocation, reporting whether there was one
+func (c *Controller) abortInflight(ticketID string) bool {
+	c.abortMu.Lock()
+	defer c.abortMu.Unlock()
+	h, ok := c.aborts[ticketID]
+	if !ok || h.finished {
+		return false
+	}
+	h.aborted = true
+	h.cancel()
+	return true
+}
+
+// finishTicket claims the final result of a ticket. It reports false if the
+// ticket's allocation was aborted, in which case the result must not be published.
+// Afterwards a cancel no longer aborts the ticket and finds the result in the ledger.
+func (c *Controller) finishTicket(ticketID string) bool {
+	c.abortMu.Lock()
+	defer c.abortMu.Unlock()
+	h, ok := c.aborts[ticketID]
+	if !ok {
+		return true
+	}
+	if h.aborted {
+		return false
+	}
+	h.finished = true
+	return true
+}
+
+// releaseCancelledAllocation deletes a GameServer that a cancelled ticket moved
+// from Ready to Allocated, so the fleet replaces it, unless another player has
+// joined it since
+func (c *Controller) releaseCancelledAllocation(ctx context.Context, cl Cluster, name, token string) {
+	gs, err := cl.Agones.AgonesV1().GameServers(cl.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		log.Error().Err(err).Str("gameServerName", name).Str("cluster", cl.Name).Msg("controller: failed to get GameServer of cancelled ticket")
+		return
+	}
+	if tokens := splitAndTrim(gs.ObjectMeta.Annotations[quilkinTokensAnnotation]); len(tokens) > 1 || (len(tokens) == 1 && tokens[0] != token) {
+		log.Info().Str("gameServerName", name).Str("cluster", cl.Name).Msg("controller: GameServer of cancelled ticket has other players, keeping it")
+		return
+	}
+	rv := gs.ResourceVersion
+	err = cl.Agones.AgonesV1().GameServers(cl.Namespace).Delete(ctx, name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{ResourceVersion: &rv}})
+	if err != nil {
+		log.Error().Err(err).Str("gameServerName", name).Str("cluster", cl.Name).Msg("controller: failed to release GameServer of cancelled ticket")
+		return
+	}
+	log.Info().Str("gameServerName", name).Str("cluster", cl.Name).Msg("controller: released GameServer of cancelled ticket")
+}
+
+// cancel handles an allocation-cancel message. A queued ticket is removed from its
+// queue and an in-flight allocation is aborted; either way a Cancelled result is
+// published. Tickets that already finished are left alone. Unknown tickets are
+// recorded as Cancelled so a request delivered after its cancel is not allocated.
+func (c *Controller) cancel(ctx context.Context, req *queues.AllocationRequest) error {
+	log.Info().Str("ticketId", req.TicketID).Msg("controller: handling cancel request")
+	if c.abortInflight(req.TicketID) {
+		// runAllocation publishes the Cancelled result once the allocation unwinds
+		return nil
+	}
+
+	// Serialize with the queue worker and any redelivery of the ticket
+	release, err := c.acquireTicket(ctx, req.TicketID)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if gsName, ok := c.queueManager.FindTicket(req.TicketID); ok {
+		for _, entry := range c.queueManager.Entries(gsName) {
+			if entry.Request.TicketID == req.TicketID {
+				metrics.QueueWaitDuration.WithLabelValues("cancelled").Observe(time.Since(entry.Timestamp).Seconds())
+			}
+		}
+		c.queueManager.RemoveFromQueue(gsName, req.TicketID)
+		err := c.publishCancelled(ctx, req)
+		c.queueChanged(ctx, gsName)
+		return err
+	}
+
+	if c.ledger != nil {
+		prev, err := c.ledger.Get(ctx, req.TicketID)
+		if err != nil {
+			log.Warn().Err(err).Str("ticketId", req.TicketID).Msg("controller: ticket ledger lookup failed, cancelling anyway")
+		} else if prev != nil && prev.Status != queues.StatusQueued {
+			log.Info().Str("ticketId", req.TicketID).Str("status", string(prev.Status)).Msg("controller: ticket already finished, ignoring cancel")
+			return nil
+		}
+	}
+	return c.publishCancelled(ctx, req)
+}
@@ -0,0 +1,235 @@
+package allocator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"agones-pubsub-allocator/config"
+	"agones-pubsub-allocator/queues"
+
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	allocationv1 "agones.dev/agones/pkg/apis/allocation/v1"
+	agonesfake "agones.dev/agones/pkg/client/clientset/versioned/fake"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func cancelRequest(ticketID string) *queues.AllocationRequest {
+	return &queues.AllocationRequest{Type: queues.RequestTypeCancel, TicketID: ticketID}
+}
+
+func TestController_Cancel_Queued(t *testing.T) {
+	ctrl, pub, _ := newQueueTestController(t)
+	ctx := context.Background()
+	for _, req := range []*queues.AllocationRequest{joinRequest("t1", "p1"), joinRequest("t2", "p2")} {
+		_ = ctrl.joinExistingGameServer(ctx, req, time.Now(), []string{"gs-1"}, buildQuilkinToken(req.PlayerID))
+	}
+
+	if err := ctrl.Handle(ctx, cancelRequest("t1")); err != nil {
+		t.Fatalf("Handle(cancel) error: %v", err)
+	}
+	results := lastResults(pub)
+	if res := results["t1"]; res.Status != queues.StatusCancelled {
+		t.Errorf("t1 result = %s, want Cancelled", res.Status)
+	}
+	if res := results["t2"]; res.Status != queues.StatusQueued || *res.QueuePosition != 1 {
+		t.Errorf("t2 result = %#v, want Queued at position 1", res)
+	}
+	if _, ok := ctrl.queueManager.FindTicket("t1"); ok {
+		t.Error("cancelled ticket still queued")
+	}
+}
+
+func TestController_Cancel_NotQueued(t *testing.T) {
+	tests := []struct {
+		name       string
+		prev       *queues.AllocationResult
+		wantStatus queues.AllocationStatus // empty: nothing published
+	}{
+		{name: "unknown ticket", wantStatus: queues.StatusCancelled},
+		{name: "queued before restart", prev: queuedResult("t1", "gs-1", 1), wantStatus: queues.StatusCancelled},
+		{name: "already succeeded", prev: &queues.AllocationResult{TicketID: "t1", Status: queues.StatusSuccess}},
+		{name: "already failed", prev: &queues.AllocationResult{TicketID: "t1", Status: queues.StatusFailure}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ledger := NewMemoryLedger(time.Minute)
+			if tt.prev != nil {
+				_ = ledger.Put(context.Background(), tt.prev)
+			}
+			pub := &mockPublisher{}
+			ctrl := NewController(pub, "ns", WithTicketLedger(ledger))
+
+			if err := ctrl.Handle(context.Background(), cancelRequest("t1")); err != nil {
+				t.Fatalf("Handle(cancel) error: %v", err)
+			}
+			if tt.wantStatus == "" {
+				if len(pub.published) != 0 {
+					t.Errorf("published %#v, want nothing", pub.published)
+				}
+				return
+			}
+			if len(pub.published) != 1 || pub.published[0].Status != tt.wantStatus {
+				t.Fatalf("published %#v, want one %s result", pub.published, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestController_Cancel_BeforeRequest(t *testing.T) {
+	pub := &mockPublisher{}
+	ctrl := NewController(pub, "ns")
+	ctx := context.Background()
+
+	// The cancel overtakes its request; the request must not allocate
+	if err := ctrl.Handle(ctx, cancelRequest("t1")); err != nil {
+		t.Fatalf("Handle(cancel) error: %v", err)
+	}
+	if err := ctrl.Handle(ctx, &queues.AllocationRequest{TicketID: "t1", Fleet: "fleet", PlayerID: "p1"}); err != nil {
+		t.Fatalf("Handle(request) error: %v", err)
+	}
+	for _, res := range pub.published {
+		if res.Status != queues.StatusCancelled {
+			t.Errorf("published %s, want only Cancelled", res.Status)
+		}
+	}
+}
+
+func TestController_Cancel_AbortsInflight(t *testing.T) {
+	client := agonesfake.NewSimpleClientset()
+	entered := make(chan struct{})
+	unblock := make(chan struct{})
+	client.PrependReactor("create", "gameserverallocations", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		close(entered)
+		<-unblock
+		return true, nil, errors.New("allocation aborted")
+	})
+	pub := &mockPublisher{}
+	ctrl := NewController(pub, "ns")
+	ctrl.agones = client
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := ctrl.Start(ctx); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ctrl.Handle(ctx, &queues.AllocationRequest{TicketID: "t1", Fleet: "fleet", PlayerID: "p1"})
+	}()
+	<-entered
+	if err := ctrl.Handle(ctx, cancelRequest("t1")); err != nil {
+		t.Fatalf("Handle(cancel) error: %v", err)
+	}
+	close(unblock)
+	if err := <-done; err != nil {
+		t.Fatalf("Handle(request) error: %v", err)
+	}
+
+	if res := lastResults(pub)["t1"]; res == nil || res.Status != queues.StatusCancelled {
+		t.Errorf("final result = %#v, want Cancelled", res)
+	}
+}
+
+func TestController_Cancel_AfterAllocation(t *testing.T) {
+	client := agonesfake.NewSimpleClientset(newTestGameServer("gs-1", ""))
+	tok := buildQuilkinToken("p1")
+	allocated := make(chan struct{})
+	unblock := make(chan struct{})
+	client.PrependReactor("create", "gameserverallocations", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		close(allocated)
+		<-unblock
+		gsa := &allocationv1.GameServerAllocation{Status: allocationv1.GameServerAllocationStatus{
+			State:          allocationv1.GameServerAllocationAllocated,
+			GameServerName: "gs-1",
+			Address:        "10.0.0.1",
+			Ports:          []agonesv1.GameServerStatusPort{{Name: "default", Port: 7777}},
+			Metadata:       &allocationv1.GameServerMetadata{Annotations: map[string]string{quilkinTokensAnnotation: tok}},
+		}}
+		return true, gsa, nil
+	})
+	pub := &mockPublisher{}
+	ledger := NewMemoryLedger(time.Minute)
+	ctrl := NewController(pub, "ns", WithTicketLedger(ledger))
+	ctrl.agones = client
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := ctrl.Start(ctx); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+
+	// The cancel lands after the GameServer was allocated but before the result is published
+	done := make(chan error, 1)
+	go func() {
+		done <- ctrl.Handle(ctx, &queues.AllocationRequest{TicketID: "t1", Fleet: "fleet", PlayerID: "p1"})
+	}()
+	<-allocated
+	if err := ctrl.Handle(ctx, cancelRequest("t1")); err != nil {
+		t.Fatalf("Handle(cancel) error: %v", err)
+	}
+	close(unblock)
+	if err := <-done; err != nil {
+		t.Fatalf("Handle(request) error: %v", err)
+	}
+
+	for _, res := range pub.published {
+		if res.Status != queues.StatusCancelled {
+			t.Errorf("published %s, want only Cancelled", res.Status)
+		}
+	}
+	if len(pub.published) != 1 {
+		t.Errorf("published %d results, want 1", len(pub.published))
+	}
+	if res, _ := ledger.Get(ctx, "t1"); res == nil || res.Status != queues.StatusCancelled {
+		t.Errorf("ledger entry = %#v, want Cancelled", res)
+	}
+	if _, err := client.AgonesV1().GameServers("ns").Get(ctx, "gs-1", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("get allocated GameServer error = %v, want it released", err)
+	}
+}
+
+func TestController_Cancel_AfterResult(t *testing.T) {
+	ctrl := NewController(&mockPublisher{}, "ns")
+	ctrl.aborts = map[string]*abortHandle{"t1": {cancel: func() {}}}
+
+	// Once the result is claimed a cancel no longer aborts the ticket
+	if !ctrl.finishTicket("t1") {
+		t.Fatal("finishTicket() = false, want true")
+	}
+	if ctrl.abortInflight("t1") {
+		t.Error("abortInflight() after the result = true, want false")
+	}
+}
+
+func TestController_expireQueues(t *testing.T) {
+	ctrl, pub, _ := newQueueTestController(t)
+	ctrl.fleets["fleet"] = config.FleetConfig{
+		Capacity:     ctrl.fleets["fleet"].Capacity,
+		WhenFull:     config.WhenFullQueue,
+		MaxQueueWait: config.Duration(5 * time.Minute),
+	}
+	ctx := context.Background()
+	for _, req := range []*queues.AllocationRequest{joinRequest("t1", "p1"), joinRequest("t2", "p2")} {
+		_ = ctrl.joinExistingGameServer(ctx, req, time.Now(), []string{"gs-1"}, buildQuilkinToken(req.PlayerID))
+	}
+
+	ctrl.expireQueues(ctx, time.Now().Add(time.Minute))
+	if got := ctrl.queueManager.GetQueueLength("gs-1"); got != 2 {
+		t.Fatalf("queue length before max wait = %d, want 2", got)
+	}
+
+	ctrl.expireQueues(ctx, time.Now().Add(10*time.Minute))
+	for ticket, res := range lastResults(pub) {
+		if res.Status != queues.StatusFailure || res.ErrorMessage == nil || *res.ErrorMessage != "queue wait exceeded 5m0s" {
+			t.Errorf("%s result = %#v, want queue wait Failure", ticket, res)
+		}
+	}
+	if got := ctrl.queueManager.GetQueueLength("gs-1"); got != 0 {
+		t.Errorf("queue length = %d, want 0", got)
+	}
+}
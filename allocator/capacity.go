@@ -0,0 +1,114 @@
+package allocator
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"agones-pubsub-allocator/config"
+
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+)
+
+// errGameServerFull is returned when a GameServer has no room for another player
+var errGameServerFull = errors.New("gameserver is full")
+
+// reservePlayerSlot checks that gs has room for playerID under cc and records the
+// player in the capacity source so later checks see it. It reports whether gs changed.
+// A player already recorded on gs is not counted twice.
+func reservePlayerSlot(gs *agonesv1.GameServer, cc config.CapacityConfig, playerID string) (bool, error) {
+	switch cc.Source {
+	case "":
+		return false, nil
+
+	case config.CapacityList:
+		list, ok := gs.Status.Lists[cc.Name]
+		if !ok {
+			return false, fmt.Errorf("gameserver %s has no list %q", gs.Name, cc.Name)
+		}
+		for _, v := range list.Values {
+			if v == playerID {
+				return false, nil
+			}
+		}
+		if int64(len(list.Values)) >= list.Capacity {
+			return false, errGameServerFull
+		}
+		list.Values = append(list.Values, playerID)
+		gs.Status.Lists[cc.Name] = list
+		return true, nil
+
+	case config.CapacityCounter:
+		counter, ok := gs.Status.Counters[cc.Name]
+		if !ok {
+			return false, fmt.Errorf("gameserver %s has no counter %q", gs.Name, cc.Name)
+		}
+		// The player's token marks that they were already counted
+		if hasToken(gs, buildQuilkinToken(playerID)) {
+			return false, nil
+		}
+		if counter.Count >= counter.Capacity {
+			return false, errGameServerFull
+		}
+		counter.Count++
+		gs.Status.Counters[cc.Name] = counter
+		return true, nil
+
+	case config.CapacityPlayers:
+		// Player tracking is owned by the game server SDK; only check it
+		players := gs.Status.Players
+		if players == nil {
+			return false, fmt.Errorf("gameserver %s does not report player status", gs.Name)
+		}
+		if players.Count >= players.Capacity {
+			return false, errGameServerFull
+		}
+		return false, nil
+
+	case config.CapacityAnnotation:
+		if hasToken(gs, buildQuilkinToken(playerID)) {
+			return false, nil
+		}
+		limit, err := strconv.ParseInt(strings.TrimSpace(gs.ObjectMeta.Annotations[cc.Name]), 10, 64)
+		if err != nil {
+			return false, fmt.Errorf("gameserver %s annotation %q is not a player count: %w", gs.Name, cc.Name, err)
+		}
+		// The caller adds the player's token, which is what this source counts
+		if int64(len(splitAndTrim(gs.ObjectMeta.Annotations[quilkinTokensAnnotation]))) >= limit {
+			return false, errGameServerFull
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("unknown capacity source %q", cc.Source)
+}
+
+// releasePlayerSlot gives back the slot playerID holds in a list or counter capacity
+// source, reporting whether gs changed. A counter is only decremented while the
+// player's token is still on gs, so it must be called before the token is removed.
+func releasePlayerSlot(gs *agonesv1.GameServer, cc config.CapacityConfig, playerID string) bool {
+	switch cc.Source {
+	case config.CapacityList:
+		list, ok := gs.Status.Lists[cc.Name]
+		if !ok {
+			return false
+		}
+		for i, v := range list.Values {
+			if v == playerID {
+				list.Values = append(list.Values[:i:i], list.Values[i+1:]...)
+				gs.Status.Lists[cc.Name] = list
+				return true
+			}
+		}
+
+	case config.CapacityCounter:
+		counter, ok := gs.Status.Counters[cc.Name]
+		if !ok || counter.Count <= 0 || !hasToken(gs, buildQuilkinToken(playerID)) {
+			return false
+		}
+		counter.Count--
+		gs.Status.Counters[cc.Name] = counter
+		return true
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package allocator
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"agones-pubsub-allocator/config"
+
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+)
+
+func Test_reservePlayerSlot(t *testing.T) {
+	withList := func(capacity int64, values ...string) *agonesv1.GameServer {
+		gs := newTestGameServer("gs-1", "")
+		gs.Status.Lists = map[string]agonesv1.ListStatus{"players": {Capacity: capacity, Values: values}}
+		return gs
+	}
+	withCounter := func(count, capacity int64, tokens string) *agonesv1.GameServer {
+		gs := newTestGameServer("gs-1", tokens)
+		gs.Status.Counters = map[string]agonesv1.CounterStatus{"players": {Count: count, Capacity: capacity}}
+		return gs
+	}
+	withPlayers := func(count, capacity int64) *agonesv1.GameServer {
+		gs := newTestGameServer("gs-1", "")
+		gs.Status.Players = &agonesv1.PlayerStatus{Count: count, Capacity: capacity}
+		return gs
+	}
+	withMax := func(limit, tokens string) *agonesv1.GameServer {
+		gs := newTestGameServer("gs-1", tokens)
+		if gs.ObjectMeta.Annotations == nil {
+			gs.ObjectMeta.Annotations = map[string]string{}
+		}
+		gs.ObjectMeta.Annotations["example.com/max-players"] = limit
+		return gs
+	}
+	list := config.CapacityConfig{Source: config.CapacityList, Name: "players"}
+	counter := config.CapacityConfig{Source: config.CapacityCounter, Name: "players"}
+	players := config.CapacityConfig{Source: config.CapacityPlayers}
+	annotation := config.CapacityConfig{Source: config.CapacityAnnotation, Name: "example.com/max-players"}
+
+	tests := []struct {
+		name        string
+		gs          *agonesv1.GameServer
+		cc          config.CapacityConfig
+		wantChanged bool
+		wantFull    bool
+		wantErr     bool
+		check       func(t *testing.T, gs *agonesv1.GameServer)
+	}{
+		{name: "unchecked", gs: newTestGameServer("gs-1", ""), cc: config.CapacityConfig{}},
+		{
+			name: "list has room", gs: withList(2, "a"), cc: list, wantChanged: true,
+			check: func(t *testing.T, gs *agonesv1.GameServer) {
+				if got := gs.Status.Lists["players"].Values; !reflect.DeepEqual(got, []string{"a", "p"}) {
+					t.Errorf("list values = %v, want [a p]", got)
+				}
+			},
+		},
+		{name: "list full", gs: withList(1, "a"), cc: list, wantFull: true},
+		{name: "list already has player", gs: withList(1, "p"), cc: list},
+		{name: "list missing", gs: newTestGameServer("gs-1", ""), cc: list, wantErr: true},
+		{
+			name: "counter has room", gs: withCounter(1, 2, ""), cc: counter, wantChanged: true,
+			check: func(t *testing.T, gs *agonesv1.GameServer) {
+				if got := gs.Status.Counters["players"].Count; got != 2 {
+					t.Errorf("counter = %d, want 2", got)
+				}
+			},
+		},
+		{name: "counter full", gs: withCounter(2, 2, ""), cc: counter, wantFull: true},
+		{name: "counter already has player", gs: withCounter(2, 2, buildQuilkinToken("p")), cc: counter},
+		{name: "counter missing", gs: newTestGameServer("gs-1", ""), cc: counter, wantErr: true},
+		{name: "players has room", gs: withPlayers(3, 4), cc: players},
+		{name: "players full", gs: withPlayers(4, 4), cc: players, wantFull: true},
+		{name: "players not tracked", gs: newTestGameServer("gs-1", ""), cc: players, wantErr: true},
+		{name: "annotation has room", gs: withMax("2", "t1"), cc: annotation},
+		{name: "annotation full", gs: withMax("2", "t1,t2"), cc: annotation, wantFull: true},
+		{name: "annotation already has player", gs: withMax("1", buildQuilkinToken("p")), cc: annotation},
+		{name: "annotation invalid", gs: withMax("lots", ""), cc: annotation, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed, err := reservePlayerSlot(tt.gs, tt.cc, "p")
+			if got := errors.Is(err, errGameServerFull); got != tt.wantFull {
+				t.Fatalf("full = %v (err %v), want %v", got, err, tt.wantFull)
+			}
+			if !tt.wantFull && (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("changed = %v, want %v", changed, tt.wantChanged)
+			}
+			if tt.check != nil {
+				tt.check(t, tt.gs)
+			}
+		})
+	}
+}
+
+func Test_releasePlayerSlot(t *testing.T) {
+	gs := newTestGameServer("gs-1", "")
+	gs.Status.Lists = map[string]agonesv1.ListStatus{"players": {Capacity: 3, Values: []string{"a", "p", "b"}}}
+	list := config.CapacityConfig{Source: config.CapacityList, Name: "players"}
+
+	if !releasePlayerSlot(gs, list, "p") {
+		t.Fatal("releasePlayerSlot() = false, want true")
+	}
+	if got := gs.Status.Lists["players"].Values; !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("list values = %v, want [a b]", got)
+	}
+	if releasePlayerSlot(gs, list, "p") {
+		t.Error("releasePlayerSlot() of absent player = true, want false")
+	}
+}
+
+func TestPlayerSlot_Counter(t *testing.T) {
+	gs := newTestGameServer("gs-1", "")
+	gs.Status.Counters = map[string]agonesv1.CounterStatus{"players": {Count: 1, Capacity: 4}}
+	counter := config.CapacityConfig{Source: config.CapacityCounter, Name: "players"}
+	count := func() int64 { return gs.Status.Counters["players"].Count }
+
+	// Joining twice counts the player once; the caller adds the token after the first
+	for range 2 {
+		if _, err := reservePlayerSlot(gs, counter, "p"); err != nil {
+			t.Fatalf("reservePlayerSlot() error: %v", err)
+		}
+		addTokenAnnotation(gs, buildQuilkinToken("p"))
+	}
+	if got := count(); got != 2 {
+		t.Fatalf("counter after re-join = %d, want 2", got)
+	}
+
+	if !releasePlayerSlot(gs, counter, "p") {
+		t.Fatal("releasePlayerSlot() = false, want true")
+	}
+	removeTokenAnnotation(gs, buildQuilkinToken("p"))
+	if got := count(); got != 1 {
+		t.Errorf("counter after release = %d, want 1", got)
+	}
+	if releasePlayerSlot(gs, counter, "p") {
+		t.Error("releasePlayerSlot() of a player without a token = true, want false")
+	}
+	if got := count(); got != 1 {
+		t.Errorf("counter after second release = %d, want 1", got)
+	}
+}
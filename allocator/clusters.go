@@ -0,0 +1,124 @@
+package allocator
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"agones-pubsub-allocator/config"
+	"agones-pubsub-allocator/queues"
+
+	agonesclientset "agones.dev/agones/pkg/client/clientset/versioned"
+)
+
+// Cluster is an Agones cluster new allocations may be placed in. Start gives
+// each one its own GameServer cache and TokenStore.
+type Cluster struct {
+	Name        string
+	Region      string
+	Weight      int
+	Namespace   string
+	Agones      agonesclientset.Interface
+	tokens      *TokenStore
+	gameServers *GameServerCache
+}
+
+// NewClusters builds an Agones client for each registry entry. Entries without
+// a namespace allocate in defaultNamespace.
+func NewClusters(cfgs config.ClusterConfigs, defaultNamespace string) ([]Cluster, error) {
+	clusters := make([]Cluster, 0, len(cfgs))
+	for _, cc := range cfgs {
+		cfg, err := clusterRestConfig(cc)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %q: %w", cc.Name, err)
+		}
+		client, err := agonesclientset.NewForConfig(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %q: %w", cc.Name, err)
+		}
+		ns := cc.Namespace
+		if ns == "" {
+			ns = defaultNamespace
+		}
+		clusters = append(clusters, Cluster{Name: cc.Name, Region: cc.Region, Weight: cc.Weight, Namespace: ns, Agones: client})
+	}
+	return clusters, nil
+}
+
+// WithClusters sets the cluster registry used instead of the local cluster.
+// Players' existing allocations and friends are looked up in every cluster, and
+// new allocations are placed in them in region and weight order.
+func WithClusters(clusters []Cluster) Option {
+	return func(c *Controller) {
+		c.clusters = slices.Clone(clusters)
+	}
+}
+
+// localCluster is the controller's own cluster, used when there is no registry
+func (c *Controller) localCluster() Cluster {
+	return Cluster{Namespace: c.namespace(), Agones: c.agones, tokens: c.tokens, gameServers: c.gameServers}
+}
+
+// lookupClusters returns every cluster whose GameServers may hold a player
+func (c *Controller) lookupClusters() []Cluster {
+	if len(c.clusters) == 0 {
+		return []Cluster{c.localCluster()}
+	}
+	return c.clusters
+}
+
+// allocationClusters returns the clusters to try for req in order, or just the
+// local cluster when there is no registry
+func (c *Controller) allocationClusters(req *queues.AllocationRequest) []Cluster {
+	if len(c.clusters) == 0 {
+		return []Cluster{c.localCluster()}
+	}
+	return orderClusters(c.clusters, req.PreferredRegions)
+}
+
+// gameServerKey names a GameServer across clusters, for friend candidates and
+// queues: the cluster name and "_" before the GameServer name, or the bare name
+// without a registry. Neither cluster nor GameServer names contain "_", and the
+// key is a valid ConfigMap key.
+func gameServerKey(cluster, name string) string {
+	if cluster == "" {
+		return name
+	}
+	return cluster + "_" + name
+}
+
+// gameServerCluster resolves a gameServerKey to the GameServer's cluster and
+// name, reporting false if the cluster is no longer in the registry
+func (c *Controller) gameServerCluster(key string) (Cluster, string, bool) {
+	cluster, name := "", key
+	if i := strings.LastIndex(key, "_"); i >= 0 {
+		cluster, name = key[:i], key[i+1:]
+	}
+	for _, cl := range c.lookupClusters() {
+		if cl.Name == cluster {
+			return cl, name, true
+		}
+	}
+	return Cluster{}, name, false
+}
+
+// orderClusters sorts clusters in the preferred regions first, in the order the
+// regions are given, then the rest. Within each group higher weights go first,
+// then registry order.
+func orderClusters(clusters []Cluster, preferred []string) []Cluster {
+	rank := func(cl Cluster) int {
+		if i := slices.Index(preferred, cl.Region); i >= 0 && cl.Region != "" {
+			return i
+		}
+		return len(preferred)
+	}
+	ordered := slices.Clone(clusters)
+	slices.SortStableFunc(ordered, func(a, b Cluster) int {
+		if d := cmp.Compare(rank(a), rank(b)); d != 0 {
+			return d
+		}
+		return cmp.Compare(b.Weight, a.Weight)
+	})
+	return ordered
+}
@@ -0,0 +1,280 @@
+package allocator
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"agones-pubsub-allocator/config"
+	"agones-pubsub-allocator/queues"
+
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	allocationv1 "agones.dev/agones/pkg/apis/allocation/v1"
+	agonesfake "agones.dev/agones/pkg/client/clientset/versioned/fake"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_orderClusters(t *testing.T) {
+	clusters := []Cluster{
+		{Name: "us-1", Region: "us"},
+		{Name: "eu-1", Region: "eu", Weight: 1},
+		{Name: "eu-2", Region: "eu", Weight: 5},
+		{Name: "ap-1", Region: "ap"},
+		{Name: "us-2", Region: "us", Weight: 2},
+	}
+	tests := []struct {
+		name      string
+		preferred []string
+		want      []string
+	}{
+		{name: "weight then registry order", want: []string{"eu-2", "us-2", "eu-1", "us-1", "ap-1"}},
+		{name: "preferred region first", preferred: []string{"eu"}, want: []string{"eu-2", "eu-1", "us-2", "us-1", "ap-1"}},
+		{name: "preferred regions in order", preferred: []string{"ap", "us"}, want: []string{"ap-1", "us-2", "us-1", "eu-2", "eu-1"}},
+		{name: "unknown region", preferred: []string{"sa"}, want: []string{"eu-2", "us-2", "eu-1", "us-1", "ap-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, cl := range orderClusters(clusters, tt.preferred) {
+				got = append(got, cl.Name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("orderClusters() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// allocatingClient returns a fake cluster client holding objects whose allocations
+// reply with state, or fail with err, and records that it was tried
+func allocatingClient(name string, tried *[]string, state allocationv1.GameServerAllocationState, err error, objects ...runtime.Object) *agonesfake.Clientset {
+	client := agonesfake.NewSimpleClientset(objects...)
+	client.PrependReactor("create", "gameserverallocations", func(k8stesting.Action) (bool, runtime.Object, error) {
+		*tried = append(*tried, name)
+		if err != nil {
+			return true, nil, err
+		}
+		return true, &allocationv1.GameServerAllocation{Status: allocationv1.GameServerAllocationStatus{
+			State:          state,
+			GameServerName: "gs-" + name,
+			Address:        "10.0.0.1",
+			Ports:          []agonesv1.GameServerStatusPort{{Name: "game", Port: 7000}},
+			Metadata:       &allocationv1.GameServerMetadata{Annotations: map[string]string{quilkinTokensAnnotation: buildQuilkinToken("p1")}},
+		}}, nil
+	})
+	return client
+}
+
+func TestController_Handle_ClusterFailover(t *testing.T) {
+	unavailable := apierrors.NewServiceUnavailable("down")
+	rejected := apierrors.NewBadRequest("rejected")
+	type reply struct {
+		state allocationv1.GameServerAllocationState
+		err   error
+	}
+	tests := []struct {
+		name        string
+		replies     map[string]reply
+		preferred   []string
+		wantTried   []string
+		wantStatus  queues.AllocationStatus
+		wantCluster string
+		wantRetry   bool
+	}{
+		{
+			name:        "first cluster allocates",
+			replies:     map[string]reply{"us": {state: allocationv1.GameServerAllocationAllocated}, "eu": {state: allocationv1.GameServerAllocationAllocated}},
+			wantTried:   []string{"us"},
+			wantStatus:  queues.StatusSuccess,
+			wantCluster: "us",
+		},
+		{
+			name:        "preferred region first",
+			replies:     map[string]reply{"us": {state: allocationv1.GameServerAllocationAllocated}, "eu": {state: allocationv1.GameServerAllocationAllocated}},
+			preferred:   []string{"europe"},
+			wantTried:   []string{"eu"},
+			wantStatus:  queues.StatusSuccess,
+			wantCluster: "eu",
+		},
+		{
+			name:        "fails over when unallocated",
+			replies:     map[string]reply{"us": {state: allocationv1.GameServerAllocationUnAllocated}, "eu": {state: allocationv1.GameServerAllocationAllocated}},
+			wantTried:   []string{"us", "eu"},
+			wantStatus:  queues.StatusSuccess,
+			wantCluster: "eu",
+		},
+		{
+			name:        "fails over on error",
+			replies:     map[string]reply{"us": {err: rejected}, "eu": {state: allocationv1.GameServerAllocationAllocated}},
+			wantTried:   []string{"us", "eu"},
+			wantStatus:  queues.StatusSuccess,
+			wantCluster: "eu",
+		},
+		{
+			name:       "all unallocated fails",
+			replies:    map[string]reply{"us": {state: allocationv1.GameServerAllocationUnAllocated}, "eu": {state: allocationv1.GameServerAllocationUnAllocated}},
+			wantTried:  []string{"us", "eu"},
+			wantStatus: queues.StatusFailure,
+		},
+		{
+			name:      "transient error retries when no cluster allocates",
+			replies:   map[string]reply{"us": {err: unavailable}, "eu": {state: allocationv1.GameServerAllocationUnAllocated}},
+			wantTried: []string{"us", "eu"},
+			wantRetry: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tried []string
+			clusters := []Cluster{
+				{Name: "us", Region: "america", Weight: 10, Namespace: "ns"},
+				{Name: "eu", Region: "europe", Namespace: "ns"},
+			}
+			for i, cl := range clusters {
+				r := tt.replies[cl.Name]
+				clusters[i].Agones = allocatingClient(cl.Name, &tried, r.state, r.err)
+			}
+			pub := &mockPublisher{}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			ctrl := NewController(pub, "ns", WithAgonesClient(agonesfake.NewSimpleClientset()), WithFleetConfigs(config.FleetConfigs{}), WithClusters(clusters))
+			if err := ctrl.Start(ctx); err != nil {
+				t.Fatalf("Start() error: %v", err)
+			}
+
+			err := ctrl.Handle(ctx, &queues.AllocationRequest{TicketID: "t1", Fleet: "fleet", PlayerID: "p1", PreferredRegions: tt.preferred})
+			if !slices.Equal(tried, tt.wantTried) {
+				t.Errorf("clusters tried = %v, want %v", tried, tt.wantTried)
+			}
+			if tt.wantRetry {
+				if err == nil || queues.IsPermanent(err) {
+					t.Fatalf("Handle() error = %v, want a retryable error", err)
+				}
+				if len(pub.published) != 0 {
+					t.Errorf("published %d results, want none", len(pub.published))
+				}
+				return
+			}
+			res := lastResults(pub)["t1"]
+			if res == nil || res.Status != tt.wantStatus {
+				t.Fatalf("result = %#v, want %s", res, tt.wantStatus)
+			}
+			if tt.wantCluster != "" && (res.GameServer == nil || res.GameServer.Cluster != tt.wantCluster) {
+				t.Errorf("gameServer = %#v, want cluster %s", res.GameServer, tt.wantCluster)
+			}
+		})
+	}
+}
+
+func TestController_Handle_AcrossClusters(t *testing.T) {
+	p1, f1 := buildQuilkinToken("p1"), buildQuilkinToken("f1")
+	fleets := config.FleetConfigs{"fleet": {
+		Capacity: config.CapacityConfig{Source: config.CapacityList, Name: "players"},
+		WhenFull: config.WhenFullQueue,
+	}}
+
+	// start runs a controller over the us and eu clusters holding the given GameServers
+	start := func(t *testing.T, tried *[]string, us, eu []runtime.Object) (*Controller, *mockPublisher, map[string]*agonesfake.Clientset) {
+		t.Helper()
+		clients := map[string]*agonesfake.Clientset{
+			"us": allocatingClient("us", tried, allocationv1.GameServerAllocationAllocated, nil, us...),
+			"eu": allocatingClient("eu", tried, allocationv1.GameServerAllocationAllocated, nil, eu...),
+		}
+		clusters := []Cluster{
+			{Name: "us", Region: "america", Namespace: "ns", Agones: clients["us"]},
+			{Name: "eu", Region: "europe", Namespace: "ns", Agones: clients["eu"]},
+		}
+		pub := &mockPublisher{}
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		ctrl := NewController(pub, "ns", WithAgonesClient(agonesfake.NewSimpleClientset()), WithFleetConfigs(fleets), WithClusters(clusters))
+		if err := ctrl.Start(ctx); err != nil {
+			t.Fatalf("Start() error: %v", err)
+		}
+		return ctrl, pub, clients
+	}
+	tokensOn := func(t *testing.T, client *agonesfake.Clientset, name string) string {
+		t.Helper()
+		gs, err := client.AgonesV1().GameServers("ns").Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get GameServer %s: %v", name, err)
+		}
+		return gs.Annotations[quilkinTokensAnnotation]
+	}
+
+	t.Run("existing allocation in another cluster", func(t *testing.T) {
+		var tried []string
+		ctrl, pub, _ := start(t, &tried, nil, []runtime.Object{newTestGameServer("gs-1", p1)})
+		_ = ctrl.Handle(context.Background(), &queues.AllocationRequest{TicketID: "t1", Fleet: "fleet", PlayerID: "p1", PreferredRegions: []string{"america"}})
+		if len(tried) != 0 {
+			t.Errorf("allocated in %v, want the existing allocation", tried)
+		}
+		res := lastResults(pub)["t1"]
+		if res == nil || res.Status != queues.StatusSuccess || res.GameServer.Name != "gs-1" || res.GameServer.Cluster != "eu" {
+			t.Fatalf("result = %#v, want gs-1 in eu", res)
+		}
+	})
+
+	t.Run("stale token removed in the cluster holding it", func(t *testing.T) {
+		var tried []string
+		stale := newTestGameServer("gs-old", p1)
+		stale.Status.State = agonesv1.GameServerStateReady
+		ctrl, pub, clients := start(t, &tried, nil, []runtime.Object{stale})
+		_ = ctrl.Handle(context.Background(), &queues.AllocationRequest{TicketID: "t1", Fleet: "fleet", PlayerID: "p1", PreferredRegions: []string{"america"}})
+		if res := lastResults(pub)["t1"]; res == nil || res.GameServer == nil || res.GameServer.Cluster != "us" {
+			t.Fatalf("result = %#v, want an allocation in us", res)
+		}
+		if got := tokensOn(t, clients["eu"], "gs-old"); got != "" {
+			t.Errorf("eu gs-old tokens = %q, want none", got)
+		}
+	})
+
+	t.Run("friend in another cluster", func(t *testing.T) {
+		var tried []string
+		gs := newTestGameServer("gs-1", f1)
+		gs.Status.Lists = map[string]agonesv1.ListStatus{"players": {Capacity: 4, Values: []string{"f1"}}}
+		ctrl, pub, clients := start(t, &tried, nil, []runtime.Object{gs})
+		_ = ctrl.Handle(context.Background(), &queues.AllocationRequest{TicketID: "t1", Fleet: "fleet", PlayerID: "p1", JoinOnIDs: []string{"f1"}})
+		if res := lastResults(pub)["t1"]; res == nil || res.Status != queues.StatusSuccess || res.GameServer.Cluster != "eu" {
+			t.Fatalf("result = %#v, want a join in eu", res)
+		}
+		if got := tokensOn(t, clients["eu"], "gs-1"); got != f1+","+p1 {
+			t.Errorf("eu gs-1 tokens = %q, want friend and player", got)
+		}
+	})
+
+	t.Run("queue on a friend's full server in another cluster", func(t *testing.T) {
+		var tried []string
+		gs := newTestGameServer("gs-1", f1)
+		gs.Status.Lists = map[string]agonesv1.ListStatus{"players": {Capacity: 1, Values: []string{"f1"}}}
+		ctrl, pub, clients := start(t, &tried, nil, []runtime.Object{gs})
+		ctx := context.Background()
+		_ = ctrl.Handle(ctx, &queues.AllocationRequest{TicketID: "t1", Fleet: "fleet", PlayerID: "p1", JoinOnIDs: []string{"f1"}})
+		res := lastResults(pub)["t1"]
+		if res == nil || res.Status != queues.StatusQueued || *res.QueueID != "eu_gs-1" {
+			t.Fatalf("result = %#v, want queued on eu_gs-1", res)
+		}
+
+		// A slot freeing up in eu admits the player there
+		gs = gs.DeepCopy()
+		gs.Status.Lists = map[string]agonesv1.ListStatus{"players": {Capacity: 2, Values: []string{"f1"}}}
+		if _, err := clients["eu"].AgonesV1().GameServers("ns").Update(ctx, gs, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("update GameServer: %v", err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			res = lastResults(pub)["t1"]
+			if res.Status == queues.StatusSuccess || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if res.Status != queues.StatusSuccess || res.GameServer.Cluster != "eu" {
+			t.Fatalf("result = %#v, want admitted in eu", res)
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package allocator
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// readConfigMap returns the ConfigMap's data, or nil if it doesn't exist
+func readConfigMap(ctx context.Context, client kubernetes.Interface, namespace, name string) (map[string]string, error) {
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cm.Data, nil
+}
+
+// updateConfigMap applies fn to the ConfigMap's data and writes it back, creating the
+// ConfigMap if missing and retrying on conflict. fn may be called more than once;
+// an error from fn aborts the update.
+func updateConfigMap(ctx context.Context, client kubernetes.Interface, namespace, name string, fn func(data map[string]string) error) error {
+	cms := client.CoreV1().ConfigMaps(namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := cms.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   name,
+					Labels: map[string]string{"app": "agones-allocator"},
+				},
+				Data: make(map[string]string),
+			}
+			if err := fn(cm.Data); err != nil {
+				return err
+			}
+			_, err = cms.Create(ctx, cm, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				// Lost the race to create it; retry as an update
+				return apierrors.NewConflict(corev1.Resource("configmaps"), name, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		if err := fn(cm.Data); err != nil {
+			return err
+		}
+		_, err = cms.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+}
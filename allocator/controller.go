@@ -3,10 +3,16 @@ package allocator
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"net"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"agones-pubsub-allocator/config"
 	"agones-pubsub-allocator/metrics"
 	"agones-pubsub-allocator/queues"
 
@@ -14,40 +20,120 @@ import (
 	allocationv1 "agones.dev/agones/pkg/apis/allocation/v1"
 	agonesclientset "agones.dev/agones/pkg/client/clientset/versioned"
 	"github.com/rs/zerolog/log"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 )
 
-// Controller wires queue consumption to the allocation execution
-// Allocation execution (Agones interaction) will be implemented in a follow-up
+// Controller handles allocation requests against Agones and publishes their
+// results. Sync builds the Agones clients and GameServer caches once per process;
+// Start, run by the leader, restores the queues and runs the queue worker.
 type Controller struct {
 	publisher       queues.Publisher
 	targetNamespace string
 	agones          agonesclientset.Interface
+	tokens          *TokenStore
+	gameServers     *GameServerCache
 	queueManager    *QueueManager
+	queueStore      QueueStore
+	queueMu         sync.Mutex
+	queueDirty      map[string]struct{}
+	queueWake       chan struct{}
+	fleets          config.FleetConfigs
+	ledger          TicketLedger
+	clusters        []Cluster
+
+	// syncMu serialises Sync, which sets the clients and caches above once;
+	// synced is set after they are built, so readers that check it see them whole
+	syncMu   sync.Mutex
+	syncDone bool
+	synced   atomic.Bool
+	// leading is set while Start's context is live
+	leading atomic.Bool
+
+	// inflight tracks tickets currently being handled so concurrent
+	// redeliveries of the same ticket wait instead of allocating twice
+	inflightMu sync.Mutex
+	inflight   map[string]chan struct{}
+
+	// aborts lets an allocation-cancel message abort a ticket's running allocation
+	abortMu sync.Mutex
+	aborts  map[string]*abortHandle
+}
+
+// errNotReady is returned by Handle until Sync has synced the GameServer cache
+var errNotReady = errors.New("controller not ready: GameServer cache not synced")
+
+// errNotLeader is returned by Leading while another replica handles requests
+var errNotLeader = errors.New("controller not leading: another replica handles requests")
+
+// errAllocationContention is returned, without publishing a result, when Agones
+// reports contention so the request is retried
+var errAllocationContention = errors.New("allocation not allocated (state=Contention)")
+
+// Option configures optional Controller dependencies
+type Option func(*Controller)
+
+// WithFleetConfigs sets the per-fleet allocation behaviour
+func WithFleetConfigs(fleets config.FleetConfigs) Option {
+	return func(c *Controller) {
+		c.fleets = fleets
+	}
+}
+
+// WithQueueAging sets how long a queued player waits before being promoted one priority tier
+func WithQueueAging(aging time.Duration) Option {
+	return func(c *Controller) {
+		c.queueManager.SetAging(aging)
+	}
+}
+
+// WithQueueStore sets where queues are persisted across restarts
+func WithQueueStore(store QueueStore) Option {
+	return func(c *Controller) {
+		c.queueStore = store
+	}
+}
+
+// WithAgonesClient sets the Agones client instead of building one from the
+// in-cluster or kubeconfig credentials in Start, e.g. a fake clientset
+func WithAgonesClient(client agonesclientset.Interface) Option {
+	return func(c *Controller) {
+		c.agones = client
+	}
+}
+
+// WithTicketLedger sets the ledger used to replay results for redelivered tickets
+func WithTicketLedger(l TicketLedger) Option {
+	return func(c *Controller) {
+		c.ledger = l
+	}
 }
 
 // publishFailure builds and publishes a failure AllocationResult with metrics.
+// Once published it returns a queues.PermanentError so transports ack the request,
+// or errTicketCancelled without publishing if the ticket was cancelled.
 func (c *Controller) publishFailure(ctx context.Context, req *queues.AllocationRequest, start time.Time, message string) error {
+	if !c.finishTicket(req.TicketID) {
+		return errTicketCancelled
+	}
 	status := queues.StatusFailure
 	duration := time.Since(start)
 	metrics.AllocationDuration.Observe(duration.Seconds())
 	metrics.AllocationsTotal.WithLabelValues(string(status)).Inc()
 	res := &queues.AllocationResult{
-		EnvelopeVersion: "1.0",
+		EnvelopeVersion: queues.ResultEnvelopeVersion,
 		Type:            "allocation-result",
 		TicketID:        req.TicketID,
 		Status:          status,
 		Token:           nil,
 		ErrorMessage:    &message,
 	}
-	if err := c.publisher.PublishResult(ctx, res); err != nil {
+	if err := c.publish(ctx, res); err != nil {
 		log.Error().Err(err).Str("ticketId", req.TicketID).Msg("controller: failed to publish failure result")
 		return err
 	}
 
-	return nil
+	return queues.Permanent(errors.New(message))
 }
 
 // publishQueued builds and publishes a queued AllocationResult with metrics.
@@ -56,25 +142,123 @@ func (c *Controller) publishQueued(ctx context.Context, req *queues.AllocationRe
 	duration := time.Since(start)
 	metrics.AllocationDuration.Observe(duration.Seconds())
 	metrics.AllocationsTotal.WithLabelValues(string(status)).Inc()
+	if err := c.publish(ctx, queuedResult(req.TicketID, queueID, position)); err != nil {
+		log.Error().Err(err).Str("ticketId", req.TicketID).Msg("controller: failed to publish queued result")
+		return err
+	}
+
+	return nil
+}
+
+// queuedResult builds a Queued AllocationResult; also used for position updates
+func queuedResult(ticketID, queueID string, position int) *queues.AllocationResult {
+	return &queues.AllocationResult{
+		EnvelopeVersion: queues.ResultEnvelopeVersion,
+		Type:            "allocation-result",
+		TicketID:        ticketID,
+		Status:          queues.StatusQueued,
+		QueuePosition:   &position,
+		QueueID:         &queueID,
+	}
+}
+
+// publishCancelled builds and publishes a Cancelled AllocationResult with metrics.
+func (c *Controller) publishCancelled(ctx context.Context, req *queues.AllocationRequest) error {
+	status := queues.StatusCancelled
+	metrics.AllocationsTotal.WithLabelValues(string(status)).Inc()
 	res := &queues.AllocationResult{
-		EnvelopeVersion: "1.0",
+		EnvelopeVersion: queues.ResultEnvelopeVersion,
 		Type:            "allocation-result",
 		TicketID:        req.TicketID,
 		Status:          status,
-		Token:           nil,
-		ErrorMessage:    nil,
-		QueuePosition:   &position,
-		QueueID:         &queueID,
 	}
-	if err := c.publisher.PublishResult(ctx, res); err != nil {
-		log.Error().Err(err).Str("ticketId", req.TicketID).Msg("controller: failed to publish queued result")
+	if err := c.publish(ctx, res); err != nil {
+		log.Error().Err(err).Str("ticketId", req.TicketID).Msg("controller: failed to publish cancelled result")
 		return err
 	}
+	log.Info().Str("ticketId", req.TicketID).Msg("controller: ticket cancelled")
+	return nil
+}
 
+// publish sends a result and records it in the ticket ledger so redeliveries can replay it.
+// A full ledger fails the publish so the request is redelivered, and republished,
+// until the result can be recorded.
+func (c *Controller) publish(ctx context.Context, res *queues.AllocationResult) error {
+	if err := c.publisher.PublishResult(ctx, res); err != nil {
+		return err
+	}
+	if c.ledger != nil {
+		if err := c.ledger.Put(ctx, res); errors.Is(err, ErrLedgerFull) {
+			return fmt.Errorf("result published but not recorded: %w", err)
+		} else if err != nil {
+			// The result reached the client; a missing ledger entry only weakens redelivery dedup
+			log.Warn().Err(err).Str("ticketId", res.TicketID).Msg("controller: failed to record result in ticket ledger")
+		}
+	}
 	return nil
 }
 
+// Handle processes an allocation request. Redelivered tickets that already have a
+// recorded outcome get the previous result republished instead of a new allocation.
 func (c *Controller) Handle(ctx context.Context, req *queues.AllocationRequest) error {
+	if req.Type == queues.RequestTypeCancel {
+		return c.cancel(ctx, req)
+	}
+
+	release, err := c.acquireTicket(ctx, req.TicketID)
+	if err != nil {
+		// Context ended while another delivery of this ticket was in flight
+		return err
+	}
+	defer release()
+
+	if c.ledger != nil {
+		prev, err := c.ledger.Get(ctx, req.TicketID)
+		if err != nil {
+			log.Warn().Err(err).Str("ticketId", req.TicketID).Msg("controller: ticket ledger lookup failed, handling as new request")
+		} else if prev != nil {
+			log.Info().Str("ticketId", req.TicketID).Str("status", string(prev.Status)).Msg("controller: ticket already handled, replaying previous result")
+			metrics.TicketReplaysTotal.Inc()
+			return c.publisher.PublishResult(ctx, prev)
+		}
+	}
+
+	return c.runAllocation(ctx, req)
+}
+
+// acquireTicket marks a ticket as in flight, waiting for any concurrent delivery
+// of the same ticket to finish first. The returned func releases the ticket.
+func (c *Controller) acquireTicket(ctx context.Context, ticketID string) (func(), error) {
+	for {
+		c.inflightMu.Lock()
+		if c.inflight == nil {
+			c.inflight = make(map[string]chan struct{})
+		}
+		done, busy := c.inflight[ticketID]
+		if !busy {
+			done = make(chan struct{})
+			c.inflight[ticketID] = done
+			c.inflightMu.Unlock()
+			return func() {
+				c.inflightMu.Lock()
+				delete(c.inflight, ticketID)
+				c.inflightMu.Unlock()
+				close(done)
+			}, nil
+		}
+		c.inflightMu.Unlock()
+
+		log.Debug().Str("ticketId", ticketID).Msg("controller: ticket already in flight, waiting")
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// allocate runs the allocation flow for a request that has not been handled before.
+func (c *Controller) allocate(ctx context.Context, req *queues.AllocationRequest) error {
 	start := time.Now()
 	log.Info().Str("ticketId", req.TicketID).Str("fleet", req.Fleet).Msg("controller: handling allocation request")
 
@@ -84,28 +268,43 @@ func (c *Controller) Handle(ctx context.Context, req *queues.AllocationRequest)
 		return c.publishFailure(ctx, req, start, "playerID is required for allocation")
 	}
 
-	// Lazy init Agones client
-	if c.agones == nil {
-		cli, err := newAgonesClient()
-		if err != nil {
-			log.Error().Err(err).Msg("controller: failed to initialize Agones client")
-			return c.publishFailure(ctx, req, start, fmt.Sprintf("agones client init failed: %v", err))
-		}
-		c.agones = cli
-		log.Info().Msg("controller: Agones client initialized")
-	}
-
-	ns := c.targetNamespace
-	if ns == "" {
-		ns = "default"
+	// Selectors and metadata are checked up front so a rejected request leaves the player's tokens alone
+	fc := c.fleets.For(req.Fleet)
+	selectors, err := gameServerSelectors(req, fc)
+	if err != nil {
+		log.Warn().Err(err).Str("ticketId", req.TicketID).Str("fleet", req.Fleet).Msg("controller: invalid selector")
+		return c.publishFailure(ctx, req, start, fmt.Sprintf("invalid selector: %v", err))
 	}
 
 	// Build Quilkin token for this player
 	tok := buildQuilkinToken(req.PlayerID)
 
-	// STEP 1: Check if player already has an existing allocation
+	// Ready GameServers get the token in the allocation's metadata patch
+	patch, err := metaPatch(req, tok, readyOnly(selectors))
+	if err != nil {
+		log.Warn().Err(err).Str("ticketId", req.TicketID).Str("fleet", req.Fleet).Msg("controller: invalid metadata")
+		return c.publishFailure(ctx, req, start, fmt.Sprintf("invalid metadata: %v", err))
+	}
+	spec := allocationv1.GameServerAllocationSpec{Selectors: selectors, MetaPatch: patch}
+	if err := countsAndLists(&spec, req, fc); err != nil {
+		log.Warn().Err(err).Str("ticketId", req.TicketID).Str("fleet", req.Fleet).Msg("controller: invalid counters or lists")
+		return c.publishFailure(ctx, req, start, fmt.Sprintf("invalid counters or lists: %v", err))
+	}
+	if spec.Scheduling, err = schedulingStrategy(req, fc); err != nil {
+		log.Warn().Err(err).Str("ticketId", req.TicketID).Str("fleet", req.Fleet).Msg("controller: invalid scheduling")
+		return c.publishFailure(ctx, req, start, fmt.Sprintf("invalid scheduling: %v", err))
+	}
+
+	// The GameServer cache must be synced before lookups are meaningful; nack so the
+	// request is redelivered once Sync has finished
+	if !c.synced.Load() {
+		log.Warn().Str("ticketId", req.TicketID).Msg("controller: GameServer cache not synced yet")
+		return errNotReady
+	}
+
+	// STEP 1: Check if player already has an existing allocation in any cluster
 	log.Info().Str("playerId", req.PlayerID).Msg("controller: checking for existing player allocation")
-	existingGS, err := c.findGameServerWithToken(ctx, ns, req.Fleet, tok)
+	existingGS, existingCluster, err := c.findGameServerWithToken(req.Fleet, tok)
 	if err != nil {
 		log.Error().Err(err).Msg("controller: failed to search for existing allocation")
 		return c.publishFailure(ctx, req, start, fmt.Sprintf("failed to search for existing allocation: %v", err))
@@ -113,21 +312,13 @@ func (c *Controller) Handle(ctx context.Context, req *queues.AllocationRequest)
 
 	// If player already has an allocated server, return the existing token
 	if existingGS != nil && existingGS.Status.State == agonesv1.GameServerStateAllocated {
-		log.Info().Str("gameServerName", existingGS.Name).Str("playerId", req.PlayerID).Msg("controller: found existing allocation, returning existing token")
-		
-		// Get address and port
-		addr := existingGS.Status.Address
-		var port int32
-		if len(existingGS.Status.Ports) > 0 {
-			port = existingGS.Status.Ports[0].Port
-		}
-		
-		return c.publishSuccess(ctx, req, start, tok, addr, port)
+		log.Info().Str("gameServerName", existingGS.Name).Str("cluster", existingCluster.Name).Str("playerId", req.PlayerID).Msg("controller: found existing allocation, returning existing token")
+		return c.publishSuccess(ctx, req, start, tok, clusterGameServerInfo(existingGS, req.Fleet, existingCluster))
 	}
 
 	// STEP 2: No valid existing allocation found, clean up any stale tokens
 	log.Info().Str("playerId", req.PlayerID).Msg("controller: cleaning up existing player tokens across fleet")
-	if err := c.removeTokenFromAllGameServers(ctx, ns, req.Fleet, tok); err != nil {
+	if err := c.removeTokenFromAllGameServers(ctx, req.Fleet, req.PlayerID, tok); err != nil {
 		log.Error().Err(err).Msg("controller: failed to cleanup player tokens, continuing with allocation")
 		// Continue with allocation even if cleanup fails
 	}
@@ -143,25 +334,15 @@ func (c *Controller) Handle(ctx context.Context, req *queues.AllocationRequest)
 		}
 
 		// Find gameservers with friend tokens
-		gsWithFriends, err := c.findGameServersWithFriendTokens(ctx, ns, req.Fleet, friendTokens)
+		gsWithFriends, err := c.findGameServersWithFriendTokens(req.Fleet, friendTokens)
 		if err != nil {
 			log.Error().Err(err).Msg("controller: failed to search for friend gameservers")
 			return c.publishFailure(ctx, req, start, fmt.Sprintf("failed to search for friends: %v", err))
 		}
 
 		if len(gsWithFriends) > 0 {
-			// Friends found on one or more gameservers
-			// Pick the first one (could be enhanced with better selection logic)
-			var targetGS string
-			for gsName := range gsWithFriends {
-				targetGS = gsName
-				break
-			}
-
-			log.Info().Str("gameServerName", targetGS).Strs("friendsFound", gsWithFriends[targetGS]).Msg("controller: found friends on gameserver")
-
-			// Try to join the friend's gameserver
-			return c.joinExistingGameServer(ctx, req, start, ns, targetGS, tok)
+			// Friends found on one or more gameservers; try them in order
+			return c.joinExistingGameServer(ctx, req, start, friendCandidates(gsWithFriends), tok)
 		}
 
 		// Friends not found
@@ -176,36 +357,23 @@ func (c *Controller) Handle(ctx context.Context, req *queues.AllocationRequest)
 	}
 
 	// STEP 4: Normal allocation flow (no friends or canJoinNotFound=true)
-	// Build GameServerAllocation spec using fleet label from request
+	// The spec carries the request's selectors, metadata, priorities, actions and scheduling
 	gsa := &allocationv1.GameServerAllocation{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: allocationv1.SchemeGroupVersion.String(),
 			Kind:       "GameServerAllocation",
 		},
 		ObjectMeta: metav1.ObjectMeta{},
-		Spec: allocationv1.GameServerAllocationSpec{
-			Selectors: []allocationv1.GameServerSelector{
-				{
-					LabelSelector: metav1.LabelSelector{
-						MatchLabels: map[string]string{
-							"agones.dev/fleet": req.Fleet,
-						},
-					},
-				},
-			},
-		},
+		Spec:       spec,
 	}
 
-	created, err := c.agones.AllocationV1().GameServerAllocations(ns).Create(ctx, gsa, metav1.CreateOptions{})
+	created, cl, failure, err := c.createAllocation(ctx, req, gsa, c.allocationClusters(req))
 	if err != nil {
-		log.Error().Err(err).Str("namespace", ns).Str("fleet", req.Fleet).Msg("controller: GameServerAllocation create failed")
-		return c.publishFailure(ctx, req, start, fmt.Sprintf("allocation create failed: %v", err))
+		// Nack without a result; the request is retried on redelivery
+		return err
 	}
-
-	if created.Status.State != allocationv1.GameServerAllocationAllocated {
-		msg := fmt.Sprintf("allocation not allocated (state=%s)", created.Status.State)
-		log.Warn().Str("state", string(created.Status.State)).Str("namespace", ns).Msg("controller: allocation not allocated")
-		return c.publishFailure(ctx, req, start, msg)
+	if created == nil {
+		return c.publishFailure(ctx, req, start, failure)
 	}
 
 	// Get address and port for logging/validation
@@ -223,83 +391,306 @@ func (c *Controller) Handle(ctx context.Context, req *queues.AllocationRequest)
 	gameServerName := created.Status.GameServerName
 	if gameServerName == "" {
 		msg := "allocated GameServer name is empty in allocation response"
-		log.Error().Str("namespace", ns).Msg("controller: " + msg)
+		log.Error().Str("namespace", cl.Namespace).Str("cluster", cl.Name).Msg("controller: " + msg)
 		return c.publishFailure(ctx, req, start, msg)
 	}
 
-	// Get the allocated GameServer object
-	gs, err := c.agones.AgonesV1().GameServers(ns).Get(ctx, gameServerName, metav1.GetOptions{})
-	if err != nil {
-		log.Error().Err(err).Str("namespace", ns).Str("gameServerName", gameServerName).Msg("controller: failed to get allocated GameServer")
-		return c.publishFailure(ctx, req, start, fmt.Sprintf("failed to get GameServer '%s': %v", gameServerName, err))
+	// Add the token to its annotations (append if exists, create if not) unless the
+	// metadata patch already set it, which means the GameServer was Ready
+	fresh := allocationHasToken(created, tok)
+	if fresh {
+		log.Info().Str("gameServerName", gameServerName).Str("playerId", req.PlayerID).Str("token", tok).Msg("controller: routing token set by allocation")
+	} else {
+		log.Info().Str("gameServerName", gameServerName).Str("playerId", req.PlayerID).Str("token", tok).Msg("controller: updating GameServer with routing token")
+		if _, err := cl.tokens.AddToken(ctx, cl.Namespace, gameServerName, tok); err != nil {
+			log.Error().Err(err).Str("namespace", cl.Namespace).Str("cluster", cl.Name).Str("gameServerName", gameServerName).Msg("controller: failed to update GameServer with token")
+			return c.publishFailure(ctx, req, start, fmt.Sprintf("failed to update GameServer with token: %v", err))
+		}
 	}
 
-	// Add the token to its annotations (append if exists, create if not)
-	if gs.ObjectMeta.Annotations == nil {
-		gs.ObjectMeta.Annotations = make(map[string]string)
+	info := allocationGameServerInfo(created, req.Fleet)
+	info.Cluster = cl.Name
+	err = c.publishSuccess(ctx, req, start, tok, info)
+	if errors.Is(err, errTicketCancelled) && fresh {
+		c.releaseCancelledAllocation(context.WithoutCancel(ctx), cl, gameServerName, tok)
 	}
-	gs.ObjectMeta.Annotations["quilkin.dev/tokens"] = appendToken(gs.ObjectMeta.Annotations["quilkin.dev/tokens"], tok)
-	log.Info().Str("gameServerName", gameServerName).Str("playerId", req.PlayerID).Str("token", tok).Msg("controller: updating GameServer with routing token")
+	return err
+}
 
-	// Update the GameServer object in the cluster
-	_, err = c.agones.AgonesV1().GameServers(ns).Update(ctx, gs, metav1.UpdateOptions{})
-	if err != nil {
-		log.Error().Err(err).Str("namespace", ns).Str("gameServerName", gameServerName).Msg("controller: failed to update GameServer with token")
-		return c.publishFailure(ctx, req, start, fmt.Sprintf("failed to update GameServer with token: %v", err))
+// createAllocation creates gsa in each cluster in turn until one allocates,
+// failing over when a cluster errors or has no matching GameServer. Without an
+// allocation, err is set when the request should be retried because a cluster
+// hit contention or a transient error; otherwise failure is the message for the
+// Failure result.
+func (c *Controller) createAllocation(ctx context.Context, req *queues.AllocationRequest, gsa *allocationv1.GameServerAllocation, clusters []Cluster) (*allocationv1.GameServerAllocation, Cluster, string, error) {
+	scheduling := string(gsa.Spec.Scheduling)
+	var failure string
+	var retry error
+	for _, cl := range clusters {
+		created, err := cl.Agones.AllocationV1().GameServerAllocations(cl.Namespace).Create(ctx, gsa, metav1.CreateOptions{})
+		if err != nil {
+			metrics.GameServerAllocations.WithLabelValues(cl.Name, scheduling, "error").Inc()
+			if transientAgonesError(err) {
+				log.Warn().Err(err).Str("namespace", cl.Namespace).Str("cluster", cl.Name).Str("fleet", req.Fleet).Msg("controller: GameServerAllocation create failed, will retry")
+				retry = fmt.Errorf("allocation create failed: %w", err)
+			} else {
+				log.Error().Err(err).Str("namespace", cl.Namespace).Str("cluster", cl.Name).Str("fleet", req.Fleet).Msg("controller: GameServerAllocation create failed")
+				failure = fmt.Sprintf("allocation create failed: %v", err)
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		metrics.GameServerAllocations.WithLabelValues(cl.Name, scheduling, string(created.Status.State)).Inc()
+
+		switch created.Status.State {
+		case allocationv1.GameServerAllocationAllocated:
+			return created, cl, "", nil
+		case allocationv1.GameServerAllocationContention:
+			log.Warn().Str("namespace", cl.Namespace).Str("cluster", cl.Name).Str("fleet", req.Fleet).Msg("controller: allocation hit contention, will retry")
+			retry = errAllocationContention
+		default:
+			log.Warn().Str("state", string(created.Status.State)).Str("namespace", cl.Namespace).Str("cluster", cl.Name).Msg("controller: allocation not allocated")
+			failure = fmt.Sprintf("allocation not allocated (state=%s)", created.Status.State)
+		}
 	}
+	return nil, Cluster{}, failure, retry
+}
 
-	return c.publishSuccess(ctx, req, start, tok, addr, port)
+// transientAgonesError reports whether an Agones API error may succeed on retry
+// (timeouts, throttling, server and connection errors). Anything else, such as
+// a rejected or forbidden allocation, fails the request.
+func transientAgonesError(err error) bool {
+	switch {
+	case apierrors.IsTimeout(err), apierrors.IsServerTimeout(err), apierrors.IsTooManyRequests(err),
+		apierrors.IsInternalError(err), apierrors.IsServiceUnavailable(err), apierrors.IsUnexpectedServerError(err):
+		return true
+	case errors.Is(err, context.DeadlineExceeded):
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
 }
 
-// joinExistingGameServer attempts to add a player to an existing gameserver
-// If the server is full, the player is queued
-func (c *Controller) joinExistingGameServer(ctx context.Context, req *queues.AllocationRequest, start time.Time, namespace, gameServerName, token string) error {
-	// Get the gameserver
-	gs, err := c.agones.AgonesV1().GameServers(namespace).Get(ctx, gameServerName, metav1.GetOptions{})
-	if err != nil {
-		log.Error().Err(err).Str("gameServerName", gameServerName).Msg("controller: failed to get friend's gameserver")
-		return c.publishFailure(ctx, req, start, fmt.Sprintf("failed to get friend's gameserver: %v", err))
+// joinExistingGameServer attempts to add a player to one of the friends' gameservers,
+// given as gameServerKeys. Each candidate's capacity is checked per the fleet config;
+// a full or unavailable server fails the request unless the fleet spills over to
+// the next candidate or queues the player on the first full one.
+func (c *Controller) joinExistingGameServer(ctx context.Context, req *queues.AllocationRequest, start time.Time, candidates []string, token string) error {
+	fc := c.fleets.For(req.Fleet)
+	tryAll := fc.WhenFull == config.WhenFullSpillover || fc.WhenFull == config.WhenFullQueue
+
+	var lastErr error
+	var firstFull string
+	for _, key := range candidates {
+		cl, gameServerName, ok := c.gameServerCluster(key)
+		if !ok {
+			continue
+		}
+		gs, err := c.addPlayerToGameServer(ctx, cl, gameServerName, req, fc, token)
+		if err == nil {
+			return c.publishSuccess(ctx, req, start, token, clusterGameServerInfo(gs, req.Fleet, cl))
+		}
+
+		switch {
+		case errors.Is(err, errGameServerNotAllocated):
+			log.Warn().Str("gameServerName", gameServerName).Msg("controller: friend's gameserver not in allocated state")
+		case errors.Is(err, errGameServerFull):
+			log.Info().Str("gameServerName", gameServerName).Str("capacitySource", fc.Capacity.Source).Msg("controller: friend's gameserver is full")
+			if firstFull == "" {
+				firstFull = key
+			}
+		default:
+			log.Error().Err(err).Str("gameServerName", gameServerName).Msg("controller: failed to add token to friend's gameserver")
+			return c.publishFailure(ctx, req, start, fmt.Sprintf("failed to join friend's gameserver: %v", err))
+		}
+		lastErr = err
+		if !tryAll {
+			break
+		}
+	}
+
+	if fc.WhenFull == config.WhenFullQueue && firstFull != "" {
+		return c.enqueue(ctx, req, start, firstFull)
+	}
+	if errors.Is(lastErr, errGameServerFull) {
+		if tryAll && len(candidates) > 1 {
+			return c.publishFailure(ctx, req, start, "all friends' gameservers are full")
+		}
+		return c.publishFailure(ctx, req, start, "friend's gameserver is full")
 	}
+	return c.publishFailure(ctx, req, start, "friend's gameserver is not available")
+}
 
-	// Check if gameserver is allocated
-	if gs.Status.State != agonesv1.GameServerStateAllocated {
-		log.Warn().Str("gameServerName", gameServerName).Str("state", string(gs.Status.State)).Msg("controller: friend's gameserver not in allocated state")
-		return c.publishFailure(ctx, req, start, "friend's gameserver is not available")
+// addPlayerToGameServer adds the player's token to an Allocated gameserver in cl and
+// reserves a slot in the capacity source and player list, re-checking state and
+// capacity on every attempt
+func (c *Controller) addPlayerToGameServer(ctx context.Context, cl Cluster, gameServerName string, req *queues.AllocationRequest, fc config.FleetConfig, token string) (*agonesv1.GameServer, error) {
+	log.Info().Str("gameServerName", gameServerName).Str("cluster", cl.Name).Str("playerId", req.PlayerID).Str("token", token).Msg("controller: adding player to friend's gameserver")
+	return cl.tokens.Mutate(ctx, cl.Namespace, gameServerName, "add", func(gs *agonesv1.GameServer) (bool, error) {
+		if gs.Status.State != agonesv1.GameServerStateAllocated {
+			return false, errGameServerNotAllocated
+		}
+		reserved, err := reservePlayerSlot(gs, fc.Capacity, req.PlayerID)
+		if err != nil {
+			return false, err
+		}
+		listed, err := reservePlayerSlot(gs, playerList(fc), req.PlayerID)
+		if err != nil {
+			return false, err
+		}
+		return addTokenAnnotation(gs, token) || reserved || listed, nil
+	})
+}
+
+// friendCandidates orders gameservers by how many friends they hold, then by name
+func friendCandidates(gsWithFriends map[string][]string) []string {
+	names := make([]string, 0, len(gsWithFriends))
+	for name := range gsWithFriends {
+		names = append(names, name)
 	}
+	sort.Slice(names, func(i, j int) bool {
+		ni, nj := len(gsWithFriends[names[i]]), len(gsWithFriends[names[j]])
+		if ni != nj {
+			return ni > nj
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
 
-	// TODO: Check if server has capacity (this would require game-specific logic)
-	// For now, we'll assume we can add the token and let the game server handle capacity
-	// In a production system, you'd check player count vs max players here
+// Sync initializes the Agones client and the GameServer cache of the local cluster,
+// or of every registry cluster, and blocks until they have synced. It builds them
+// once; later calls return straight away and the caches keep running until the
+// first call's ctx is done. Under leader election every replica syncs, so
+// followers are ready and take over with warm caches.
+func (c *Controller) Sync(ctx context.Context) error {
+	c.syncMu.Lock()
+	defer c.syncMu.Unlock()
+	if c.syncDone {
+		return nil
+	}
+	if c.agones == nil {
+		cli, err := newAgonesClient()
+		if err != nil {
+			return fmt.Errorf("agones client init failed: %w", err)
+		}
+		c.agones = cli
+		log.Info().Msg("controller: Agones client initialized")
+	}
+	if c.tokens == nil {
+		c.tokens = NewTokenStore(c.agones)
+	}
+	if len(c.clusters) == 0 && c.gameServers == nil {
+		gsc, err := c.startGameServerCache(ctx, c.localCluster())
+		if err != nil {
+			return err
+		}
+		c.gameServers = gsc
+	}
+	for i := range c.clusters {
+		cl := &c.clusters[i]
+		if cl.tokens == nil {
+			cl.tokens = NewTokenStore(cl.Agones)
+		}
+		if cl.gameServers != nil {
+			// Synced before an earlier attempt failed on a later cluster
+			continue
+		}
+		gsc, err := c.startGameServerCache(ctx, *cl)
+		if err != nil {
+			return fmt.Errorf("cluster %q: %w", cl.Name, err)
+		}
+		cl.gameServers = gsc
+	}
+	c.syncDone = true
+	c.synced.Store(true)
+	// The caches stop with ctx, so the controller leaves rotation
+	context.AfterFunc(ctx, func() { c.synced.Store(false) })
+	return nil
+}
 
-	// Add player's token to the gameserver
-	if gs.ObjectMeta.Annotations == nil {
-		gs.ObjectMeta.Annotations = make(map[string]string)
+// Start makes this replica the one handling requests until ctx is done: it runs
+// Sync if that has not happened yet, restores the queues and runs the queue
+// worker. Under leader election it runs each time the lease is acquired.
+func (c *Controller) Start(ctx context.Context) error {
+	if err := c.Sync(ctx); err != nil {
+		return err
 	}
-	gs.ObjectMeta.Annotations["quilkin.dev/tokens"] = appendToken(gs.ObjectMeta.Annotations["quilkin.dev/tokens"], token)
-	log.Info().Str("gameServerName", gameServerName).Str("playerId", req.PlayerID).Str("token", token).Msg("controller: adding player to friend's gameserver")
+	if err := c.restoreQueues(ctx); err != nil {
+		return err
+	}
+	go c.runQueueWorker(ctx)
+	c.leading.Store(true)
+	// Stopping (shutdown or lost leadership) hands requests to the next leader
+	context.AfterFunc(ctx, func() { c.leading.Store(false) })
+	return nil
+}
 
-	_, err = c.agones.AgonesV1().GameServers(namespace).Update(ctx, gs, metav1.UpdateOptions{})
+// startGameServerCache starts a GameServer cache for cl and waits for it to sync
+func (c *Controller) startGameServerCache(ctx context.Context, cl Cluster) (*GameServerCache, error) {
+	gsc, err := NewGameServerCache(cl.Agones, cl.Namespace)
 	if err != nil {
-		log.Error().Err(err).Str("gameServerName", gameServerName).Msg("controller: failed to add token to friend's gameserver")
-		return c.publishFailure(ctx, req, start, fmt.Sprintf("failed to join friend's gameserver: %v", err))
+		return nil, err
+	}
+	// GameServer changes may free a slot for queued players
+	if err := gsc.Notify(func(name string) { c.signalQueue(gameServerKey(cl.Name, name)) }); err != nil {
+		return nil, err
 	}
+	if err := gsc.Start(ctx); err != nil {
+		return nil, err
+	}
+	return gsc, nil
+}
 
-	// Get address and port
-	addr := gs.Status.Address
-	var port int32
-	if len(gs.Status.Ports) > 0 {
-		port = gs.Status.Ports[0].Port
+// Ready reports whether the GameServer caches have synced; used by /readyz
+func (c *Controller) Ready() error {
+	if !c.synced.Load() {
+		return errNotReady
 	}
+	return nil
+}
+
+// Leading reports whether Start is running, so this replica should take API
+// requests; followers under leader election are ready but not leading
+func (c *Controller) Leading() error {
+	if err := c.Ready(); err != nil {
+		return err
+	}
+	if !c.leading.Load() {
+		return errNotLeader
+	}
+	return nil
+}
 
-	return c.publishSuccess(ctx, req, start, token, addr, port)
+// namespace returns the namespace GameServers are allocated in
+func (c *Controller) namespace() string {
+	if c.targetNamespace == "" {
+		return "default"
+	}
+	return c.targetNamespace
 }
 
-func NewController(p queues.Publisher, ns string) *Controller {
-	return &Controller{
+func NewController(p queues.Publisher, ns string, opts ...Option) *Controller {
+	c := &Controller{
 		publisher:       p,
 		targetNamespace: ns,
 		queueManager:    NewQueueManager(),
-	}
+		queueStore:      NewMemoryQueueStore(),
+		queueDirty:      make(map[string]struct{}),
+		queueWake:       make(chan struct{}, 1),
+		ledger:          NewMemoryLedger(DefaultTicketTTL),
+		inflight:        make(map[string]chan struct{}),
+		aborts:          make(map[string]*abortHandle),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.queueManager.SetMaxPriority(func(fleet string) int {
+		return c.fleets.For(fleet).MaxPriority
+	})
+	return c
 }
 
 // appendToken adds a new token to a comma-separated list of tokens.
@@ -338,85 +729,61 @@ func splitAndTrim(s string) []string {
 	return result
 }
 
-// findGameServerWithToken searches for a GameServer in the fleet that has the specified token.
-// Returns nil if no GameServer is found with the token.
-func (c *Controller) findGameServerWithToken(ctx context.Context, namespace, fleet, token string) (*agonesv1.GameServer, error) {
-	// List all GameServers in the fleet
-	gsList, err := c.agones.AgonesV1().GameServers(namespace).List(ctx, metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("agones.dev/fleet=%s", fleet),
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	// Search for a GameServer with this token in its annotations
-	for i := range gsList.Items {
-		gs := &gsList.Items[i]
-		if gs.ObjectMeta.Annotations == nil {
+// findGameServerWithToken searches every cluster for a GameServer in the fleet that has
+// the specified token, returning it with its cluster. Returns nil if no GameServer is
+// found with the token.
+func (c *Controller) findGameServerWithToken(fleet, token string) (*agonesv1.GameServer, Cluster, error) {
+	var found *agonesv1.GameServer
+	var foundIn Cluster
+	for _, cl := range c.lookupClusters() {
+		if cl.gameServers == nil {
 			continue
 		}
-		tokens := gs.ObjectMeta.Annotations["quilkin.dev/tokens"]
-		if tokens == "" {
-			continue
+		matches, err := cl.gameServers.ByToken(fleet, token)
+		if err != nil {
+			return nil, Cluster{}, err
 		}
-		// Check if our token is in the comma-separated list
-		tokenList := splitAndTrim(tokens)
-		for _, t := range tokenList {
-			if t == token {
-				return gs, nil
+		// Prefer an Allocated match if a stale token lingers on another server
+		for _, gs := range matches {
+			if gs.Status.State == agonesv1.GameServerStateAllocated {
+				return gs, cl, nil
 			}
 		}
+		if found == nil && len(matches) > 0 {
+			found, foundIn = matches[0], cl
+		}
 	}
-
-	return nil, nil
+	return found, foundIn, nil
 }
 
-// removeTokenFromAllGameServers removes a player's token from all gameservers in the fleet
-// This ensures a player only has one active server allocation at a time
-func (c *Controller) removeTokenFromAllGameServers(ctx context.Context, namespace, fleet, token string) error {
-	gsList, err := c.agones.AgonesV1().GameServers(namespace).List(ctx, metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("agones.dev/fleet=%s", fleet),
-	})
-	if err != nil {
-		return err
-	}
-
-	for i := range gsList.Items {
-		gs := &gsList.Items[i]
-		if gs.ObjectMeta.Annotations == nil {
+// removeTokenFromAllGameServers removes a player's token from all gameservers in the fleet,
+// in every cluster, through the TokenStore of the cluster holding each one.
+// This ensures a player only has one active server allocation at a time.
+// The player is also removed from the fleet's capacity list and player list, if it uses them.
+func (c *Controller) removeTokenFromAllGameServers(ctx context.Context, fleet, playerID, token string) error {
+	fc := c.fleets.For(fleet)
+	for _, cl := range c.lookupClusters() {
+		if cl.gameServers == nil {
 			continue
 		}
-
-		tokens := gs.ObjectMeta.Annotations["quilkin.dev/tokens"]
-		if tokens == "" {
-			continue
+		matches, err := cl.gameServers.ByToken(fleet, token)
+		if err != nil {
+			return err
 		}
 
-		// Check if this gameserver has the token
-		tokenList := splitAndTrim(tokens)
-		hasToken := false
-		for _, t := range tokenList {
-			if t == token {
-				hasToken = true
-				break
+		for _, gs := range matches {
+			log.Info().Str("gameServerName", gs.Name).Str("cluster", cl.Name).Str("token", token).Msg("controller: removing token from GameServer")
+
+			_, err := cl.tokens.Mutate(ctx, cl.Namespace, gs.Name, "remove", func(gs *agonesv1.GameServer) (bool, error) {
+				released := releasePlayerSlot(gs, fc.Capacity, playerID)
+				unlisted := releasePlayerSlot(gs, playerList(fc), playerID)
+				return removeTokenAnnotation(gs, token) || released || unlisted, nil
+			})
+			if err != nil {
+				log.Error().Err(err).Str("gameServerName", gs.Name).Str("cluster", cl.Name).Msg("controller: failed to remove token from GameServer")
+				// Continue with other servers even if one fails
 			}
 		}
-
-		if !hasToken {
-			continue
-		}
-
-		// Remove the token from the list
-		newTokens := removeToken(tokens, token)
-		gs.ObjectMeta.Annotations["quilkin.dev/tokens"] = newTokens
-
-		log.Info().Str("gameServerName", gs.Name).Str("token", token).Msg("controller: removing token from GameServer")
-
-		_, err := c.agones.AgonesV1().GameServers(namespace).Update(ctx, gs, metav1.UpdateOptions{})
-		if err != nil {
-			log.Error().Err(err).Str("gameServerName", gs.Name).Msg("controller: failed to remove token from GameServer")
-			// Continue with other servers even if one fails
-		}
 	}
 
 	return nil
@@ -440,43 +807,24 @@ func removeToken(existingTokens, tokenToRemove string) string {
 	return strings.Join(newTokens, ",")
 }
 
-// findGameServersWithFriendTokens searches for gameservers that have any of the friend tokens
-// Returns a map of gameserver to the list of friend tokens found on it
-func (c *Controller) findGameServersWithFriendTokens(ctx context.Context, namespace, fleet string, friendTokens []string) (map[string][]string, error) {
-	gsList, err := c.agones.AgonesV1().GameServers(namespace).List(ctx, metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("agones.dev/fleet=%s", fleet),
-	})
-	if err != nil {
-		return nil, err
-	}
-
+// findGameServersWithFriendTokens searches every cluster for gameservers that have any
+// of the friend tokens. Returns a map of gameServerKey to the friend tokens found on it
+func (c *Controller) findGameServersWithFriendTokens(fleet string, friendTokens []string) (map[string][]string, error) {
 	result := make(map[string][]string)
 
-	for i := range gsList.Items {
-		gs := &gsList.Items[i]
-		if gs.ObjectMeta.Annotations == nil {
-			continue
-		}
-
-		tokens := gs.ObjectMeta.Annotations["quilkin.dev/tokens"]
-		if tokens == "" {
+	for _, cl := range c.lookupClusters() {
+		if cl.gameServers == nil {
 			continue
 		}
-
-		tokenList := splitAndTrim(tokens)
-		var foundFriends []string
-
 		for _, friendToken := range friendTokens {
-			for _, t := range tokenList {
-				if t == friendToken {
-					foundFriends = append(foundFriends, friendToken)
-					break
-				}
+			matches, err := cl.gameServers.ByToken(fleet, friendToken)
+			if err != nil {
+				return nil, err
+			}
+			for _, gs := range matches {
+				key := gameServerKey(cl.Name, gs.Name)
+				result[key] = append(result[key], friendToken)
 			}
-		}
-
-		if len(foundFriends) > 0 {
-			result[gs.Name] = foundFriends
 		}
 	}
 
@@ -484,28 +832,79 @@ func (c *Controller) findGameServersWithFriendTokens(ctx context.Context, namesp
 }
 
 // publishSuccess builds and publishes a success AllocationResult with metrics.
-func (c *Controller) publishSuccess(ctx context.Context, req *queues.AllocationRequest, start time.Time, token, addr string, port int32) error {
+// It returns errTicketCancelled without publishing if the ticket was cancelled.
+func (c *Controller) publishSuccess(ctx context.Context, req *queues.AllocationRequest, start time.Time, token string, gs *queues.GameServerInfo) error {
+	if !c.finishTicket(req.TicketID) {
+		return errTicketCancelled
+	}
 	status := queues.StatusSuccess
 	duration := time.Since(start)
 	metrics.AllocationDuration.Observe(duration.Seconds())
 	metrics.AllocationsTotal.WithLabelValues(string(status)).Inc()
 
 	res := &queues.AllocationResult{
-		EnvelopeVersion: "1.0",
+		EnvelopeVersion: queues.ResultEnvelopeVersion,
 		Type:            "allocation-result",
 		TicketID:        req.TicketID,
 		Status:          status,
 		Token:           &token,
 		ErrorMessage:    nil,
+		GameServer:      gs,
 	}
-	if err := c.publisher.PublishResult(ctx, res); err != nil {
+	if err := c.publish(ctx, res); err != nil {
 		log.Error().Err(err).Str("ticketId", req.TicketID).Dur("duration", duration).Msg("controller: failed to publish result")
 		return err
 	}
-	log.Info().Str("ticketId", req.TicketID).Str("status", string(status)).Dur("duration", duration).Str("addr", addr).Int32("port", port).Msg("controller: allocation successful")
+	log.Info().Str("ticketId", req.TicketID).Str("status", string(status)).Dur("duration", duration).Str("gameServerName", gs.Name).Str("addr", gs.Address).Msg("controller: allocation successful")
 	return nil
 }
 
+// gameServerInfo builds the connection details published on success from a GameServer.
+// The fleet label is preferred; fallbackFleet is used when the label is missing.
+func gameServerInfo(gs *agonesv1.GameServer, fallbackFleet string) *queues.GameServerInfo {
+	fleet := fallbackFleet
+	if f := gs.ObjectMeta.Labels["agones.dev/fleet"]; f != "" {
+		fleet = f
+	}
+	return &queues.GameServerInfo{
+		Name:     gs.Name,
+		Fleet:    fleet,
+		Address:  gs.Status.Address,
+		Ports:    toGameServerPorts(gs.Status.Ports),
+		NodeName: gs.Status.NodeName,
+	}
+}
+
+// clusterGameServerInfo is gameServerInfo for a GameServer in cl
+func clusterGameServerInfo(gs *agonesv1.GameServer, fallbackFleet string, cl Cluster) *queues.GameServerInfo {
+	info := gameServerInfo(gs, fallbackFleet)
+	info.Cluster = cl.Name
+	return info
+}
+
+// allocationGameServerInfo builds the connection details from a GameServerAllocation response.
+func allocationGameServerInfo(gsa *allocationv1.GameServerAllocation, fleet string) *queues.GameServerInfo {
+	return &queues.GameServerInfo{
+		Name:     gsa.Status.GameServerName,
+		Fleet:    fleet,
+		Address:  gsa.Status.Address,
+		Ports:    toGameServerPorts(gsa.Status.Ports),
+		NodeName: gsa.Status.NodeName,
+	}
+}
+
+// toGameServerPorts converts Agones status ports to the published port list.
+func toGameServerPorts(ports []agonesv1.GameServerStatusPort) []queues.GameServerPort {
+	if len(ports) == 0 {
+		return nil
+	}
+	out := make([]queues.GameServerPort, 0, len(ports))
+	for _, p := range ports {
+		out = append(out, queues.GameServerPort{Name: p.Name, Port: p.Port})
+	}
+	return out
+}
+
 // buildQuilkinToken creates a 16-byte token from playerID.
 // The playerID is truncated or padded to fit exactly 16 bytes, then base64 encoded.
 func buildQuilkinToken(playerID string) string {
@@ -523,19 +922,3 @@ func buildQuilkinToken(playerID string) string {
 	// Base64 encode the 16-byte buffer
 	return base64.StdEncoding.EncodeToString(buf)
 }
-
-// newAgonesClient returns an Agones typed clientset using in-cluster config or local kubeconfig.
-func newAgonesClient() (agonesclientset.Interface, error) {
-	// Try in-cluster config first
-	if cfg, err := rest.InClusterConfig(); err == nil {
-		return agonesclientset.NewForConfig(cfg)
-	}
-	// Fallback to local kubeconfig
-	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
-	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{})
-	cfg, err := clientConfig.ClientConfig()
-	if err != nil {
-		return nil, err
-	}
-	return agonesclientset.NewForConfig(cfg)
-}
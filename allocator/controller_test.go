@@ -3,15 +3,35 @@ package allocator
 import (
 	"context"
 	"encoding/base64"
+	"errors"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 
+	"agones-pubsub-allocator/config"
 	"agones-pubsub-allocator/queues"
+
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	allocationv1 "agones.dev/agones/pkg/apis/allocation/v1"
+	agonesfake "agones.dev/agones/pkg/client/clientset/versioned/fake"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
 )
 
-type mockPublisher struct{ err error }
+type mockPublisher struct {
+	err       error
+	mu        sync.Mutex
+	published []*queues.AllocationResult
+}
 
 func (m *mockPublisher) PublishResult(ctx context.Context, res *queues.AllocationResult) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.published = append(m.published, res)
 	return m.err
 }
 
@@ -43,10 +63,11 @@ func Test_publishFailure(t *testing.T) {
 		req     *queues.AllocationRequest
 		message string
 		pubErr  error
-		wantErr bool
+		// A published failure is permanent so transports ack; a failed publish is retried
+		wantPermanent bool
 	}{
-		{name: "successful publish", req: &queues.AllocationRequest{TicketID: "test-ticket", Fleet: "test-fleet"}, message: "test error", pubErr: nil, wantErr: false},
-		{name: "publish error", req: &queues.AllocationRequest{TicketID: "test-ticket", Fleet: "test-fleet"}, message: "test error", pubErr: context.Canceled, wantErr: true},
+		{name: "successful publish", req: &queues.AllocationRequest{TicketID: "test-ticket", Fleet: "test-fleet"}, message: "test error", pubErr: nil, wantPermanent: true},
+		{name: "publish error", req: &queues.AllocationRequest{TicketID: "test-ticket", Fleet: "test-fleet"}, message: "test error", pubErr: context.Canceled, wantPermanent: false},
 	}
 
 	for _, tt := range tests {
@@ -56,9 +77,11 @@ func Test_publishFailure(t *testing.T) {
 
 			err := ctrl.publishFailure(context.Background(), tt.req, time.Now(), tt.message)
 
-			gotErr := (err != nil)
-			if gotErr != tt.wantErr {
-				t.Errorf("publishFailure() error mismatch\ngotErr: %#v\nwantErr: %#v\nerr: %#v", gotErr, tt.wantErr, err)
+			if err == nil {
+				t.Fatal("publishFailure() error = nil")
+			}
+			if got := queues.IsPermanent(err); got != tt.wantPermanent {
+				t.Errorf("publishFailure() error = %v, permanent = %v, want %v", err, got, tt.wantPermanent)
 			}
 		})
 	}
@@ -221,3 +244,396 @@ func Test_removeToken(t *testing.T) {
 		})
 	}
 }
+
+func Test_gameServerInfo(t *testing.T) {
+	tests := []struct {
+		name          string
+		gs            *agonesv1.GameServer
+		fallbackFleet string
+		want          *queues.GameServerInfo
+	}{
+		{
+			name: "fleet from label",
+			gs: &agonesv1.GameServer{
+				ObjectMeta: metav1.ObjectMeta{Name: "gs-1", Labels: map[string]string{"agones.dev/fleet": "labelled"}},
+				Status: agonesv1.GameServerStatus{
+					Address:  "10.0.0.1",
+					NodeName: "node-a",
+					Ports:    []agonesv1.GameServerStatusPort{{Name: "default", Port: 7777}, {Name: "query", Port: 7778}},
+				},
+			},
+			fallbackFleet: "request-fleet",
+			want: &queues.GameServerInfo{
+				Name:     "gs-1",
+				Fleet:    "labelled",
+				Address:  "10.0.0.1",
+				NodeName: "node-a",
+				Ports:    []queues.GameServerPort{{Name: "default", Port: 7777}, {Name: "query", Port: 7778}},
+			},
+		},
+		{
+			name:          "fallback fleet and no ports",
+			gs:            &agonesv1.GameServer{ObjectMeta: metav1.ObjectMeta{Name: "gs-2"}, Status: agonesv1.GameServerStatus{Address: "10.0.0.2"}},
+			fallbackFleet: "request-fleet",
+			want:          &queues.GameServerInfo{Name: "gs-2", Fleet: "request-fleet", Address: "10.0.0.2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := gameServerInfo(tt.gs, tt.fallbackFleet)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("gameServerInfo() mismatch\ngot:  %#v\nwant: %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_allocationGameServerInfo(t *testing.T) {
+	gsa := &allocationv1.GameServerAllocation{
+		Status: allocationv1.GameServerAllocationStatus{
+			State:          allocationv1.GameServerAllocationAllocated,
+			GameServerName: "gs-1",
+			Address:        "10.0.0.1",
+			NodeName:       "node-a",
+			Ports:          []agonesv1.GameServerStatusPort{{Name: "default", Port: 7777}},
+		},
+	}
+	want := &queues.GameServerInfo{
+		Name:     "gs-1",
+		Fleet:    "starx",
+		Address:  "10.0.0.1",
+		NodeName: "node-a",
+		Ports:    []queues.GameServerPort{{Name: "default", Port: 7777}},
+	}
+	got := allocationGameServerInfo(gsa, "starx")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("allocationGameServerInfo() mismatch\ngot:  %#v\nwant: %#v", got, want)
+	}
+}
+
+func TestController_Handle_ReplaysLedgerResult(t *testing.T) {
+	tok := "tok"
+	prev := &queues.AllocationResult{EnvelopeVersion: queues.ResultEnvelopeVersion, Type: "allocation-result", TicketID: "t1", Status: queues.StatusSuccess, Token: &tok}
+	ledger := NewMemoryLedger(time.Minute)
+	if err := ledger.Put(context.Background(), prev); err != nil {
+		t.Fatalf("ledger put: %v", err)
+	}
+	pub := &mockPublisher{}
+	// No Agones client is configured: a replay must not reach the allocation path
+	ctrl := NewController(pub, "ns", WithTicketLedger(ledger))
+
+	if err := ctrl.Handle(context.Background(), &queues.AllocationRequest{TicketID: "t1", Fleet: "f", PlayerID: "p"}); err != nil {
+		t.Fatalf("Handle() error: %v", err)
+	}
+	if len(pub.published) != 1 || pub.published[0] != prev {
+		t.Errorf("Handle() published %#v, want replay of %#v", pub.published, prev)
+	}
+}
+
+func TestController_publish_RecordsInLedger(t *testing.T) {
+	ledger := NewMemoryLedger(time.Minute)
+	ctrl := &Controller{publisher: &mockPublisher{}, ledger: ledger}
+	req := &queues.AllocationRequest{TicketID: "t1", Fleet: "f"}
+
+	if err := ctrl.publishFailure(context.Background(), req, time.Now(), "boom"); !queues.IsPermanent(err) {
+		t.Fatalf("publishFailure() error = %v, want permanent", err)
+	}
+	got, _ := ledger.Get(context.Background(), "t1")
+	if got == nil || got.Status != queues.StatusFailure {
+		t.Errorf("ledger entry = %#v, want Failure result", got)
+	}
+
+	// A failed publish must not be recorded, so the redelivery allocates again
+	ctrl.publisher = &mockPublisher{err: context.Canceled}
+	req2 := &queues.AllocationRequest{TicketID: "t2", Fleet: "f"}
+	_ = ctrl.publishFailure(context.Background(), req2, time.Now(), "boom")
+	if got, _ := ledger.Get(context.Background(), "t2"); got != nil {
+		t.Errorf("ledger recorded unpublished result %#v", got)
+	}
+}
+
+func TestController_acquireTicket(t *testing.T) {
+	ctrl := &Controller{}
+	release, err := ctrl.acquireTicket(context.Background(), "t1")
+	if err != nil {
+		t.Fatalf("acquireTicket() error: %v", err)
+	}
+
+	// A second delivery of the same ticket waits until the first is released
+	acquired := make(chan struct{})
+	go func() {
+		release2, err := ctrl.acquireTicket(context.Background(), "t1")
+		if err == nil {
+			release2()
+		}
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("second acquireTicket() did not wait for the first delivery")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("second acquireTicket() not released")
+	}
+
+	// Other tickets are independent, and a cancelled wait returns the context error
+	releaseOther, err := ctrl.acquireTicket(context.Background(), "t2")
+	if err != nil {
+		t.Fatalf("acquireTicket(t2) error: %v", err)
+	}
+	defer releaseOther()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ctrl.acquireTicket(ctx, "t2"); err == nil {
+		t.Error("acquireTicket() with cancelled context should fail while ticket is in flight")
+	}
+}
+
+func TestController_StartGatesReadiness(t *testing.T) {
+	pub := &mockPublisher{}
+	ctrl := NewController(pub, "ns")
+	ctrl.agones = agonesfake.NewSimpleClientset(newTestGameServer("gs-1", "t1"))
+
+	// Before the cache has synced requests are nacked without publishing
+	req := &queues.AllocationRequest{TicketID: "t1", Fleet: "fleet", PlayerID: "p"}
+	if err := ctrl.Handle(context.Background(), req); !errors.Is(err, errNotReady) {
+		t.Errorf("Handle() before Start error = %v, want errNotReady", err)
+	}
+	if len(pub.published) != 0 {
+		t.Errorf("Handle() before Start published %#v", pub.published)
+	}
+	if ctrl.Ready() == nil {
+		t.Error("Ready() before Start should report an error")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := ctrl.Start(ctx); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	if err := ctrl.Ready(); err != nil {
+		t.Errorf("Ready() after Start error: %v", err)
+	}
+	if err := ctrl.Leading(); err != nil {
+		t.Errorf("Leading() after Start error: %v", err)
+	}
+	gs, _, err := ctrl.findGameServerWithToken("fleet", "t1")
+	if err != nil || gs == nil || gs.Name != "gs-1" {
+		t.Errorf("findGameServerWithToken() = %v, %v; want gs-1", gs, err)
+	}
+
+	// Stopping (shutdown or lost leadership) reports not ready again
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for ctrl.Ready() == nil && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if ctrl.Ready() == nil {
+		t.Error("Ready() after the Start context is done should report an error")
+	}
+}
+
+func TestController_SyncOutlivesLeadership(t *testing.T) {
+	ctrl := NewController(&mockPublisher{}, "ns")
+	ctrl.agones = agonesfake.NewSimpleClientset(newTestGameServer("gs-1", "t1"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := ctrl.Sync(ctx); err != nil {
+		t.Fatalf("Sync() error: %v", err)
+	}
+	// A follower is ready but doesn't take requests
+	if err := ctrl.Ready(); err != nil {
+		t.Errorf("Ready() after Sync error: %v", err)
+	}
+	if err := ctrl.Leading(); !errors.Is(err, errNotLeader) {
+		t.Errorf("Leading() after Sync error = %v, want errNotLeader", err)
+	}
+	agones, tokens, cache := ctrl.agones, ctrl.tokens, ctrl.gameServers
+
+	for term := range 2 {
+		leaderCtx, stepDown := context.WithCancel(ctx)
+		if err := ctrl.Start(leaderCtx); err != nil {
+			t.Fatalf("term %d: Start() error: %v", term, err)
+		}
+		if err := ctrl.Leading(); err != nil {
+			t.Errorf("term %d: Leading() after Start error: %v", term, err)
+		}
+		if ctrl.agones != agones || ctrl.tokens != tokens || ctrl.gameServers != cache {
+			t.Errorf("term %d: Start rebuilt the Agones client, token store or GameServer cache", term)
+		}
+		stepDown()
+		deadline := time.Now().Add(time.Second)
+		for ctrl.Leading() == nil && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if err := ctrl.Leading(); !errors.Is(err, errNotLeader) {
+			t.Errorf("term %d: Leading() after losing leadership error = %v, want errNotLeader", term, err)
+		}
+		if err := ctrl.Ready(); err != nil {
+			t.Errorf("term %d: Ready() after losing leadership error: %v", term, err)
+		}
+	}
+}
+
+func Test_friendCandidates(t *testing.T) {
+	got := friendCandidates(map[string][]string{
+		"gs-b": {"f1"},
+		"gs-a": {"f2"},
+		"gs-c": {"f3", "f4"},
+	})
+	want := []string{"gs-c", "gs-a", "gs-b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("friendCandidates() = %v, want %v", got, want)
+	}
+}
+
+func TestController_joinExistingGameServer_Capacity(t *testing.T) {
+	withList := func(name string, values ...string) *agonesv1.GameServer {
+		gs := newTestGameServer(name, "")
+		gs.Status.Lists = map[string]agonesv1.ListStatus{"players": {Capacity: 2, Values: values}}
+		return gs
+	}
+	capacity := config.CapacityConfig{Source: config.CapacityList, Name: "players"}
+
+	tests := []struct {
+		name       string
+		whenFull   string
+		servers    []*agonesv1.GameServer
+		wantStatus queues.AllocationStatus
+		wantGS     string
+		wantError  string
+	}{
+		{
+			name:       "joins server with room",
+			servers:    []*agonesv1.GameServer{withList("gs-1", "f1")},
+			wantStatus: queues.StatusSuccess,
+			wantGS:     "gs-1",
+		},
+		{
+			name:       "reject when full",
+			whenFull:   config.WhenFullReject,
+			servers:    []*agonesv1.GameServer{withList("gs-1", "f1", "f2"), withList("gs-2", "f3")},
+			wantStatus: queues.StatusFailure,
+			wantError:  "friend's gameserver is full",
+		},
+		{
+			name:       "spillover to next friend",
+			whenFull:   config.WhenFullSpillover,
+			servers:    []*agonesv1.GameServer{withList("gs-1", "f1", "f2"), withList("gs-2", "f3")},
+			wantStatus: queues.StatusSuccess,
+			wantGS:     "gs-2",
+		},
+		{
+			name:       "spillover exhausted",
+			whenFull:   config.WhenFullSpillover,
+			servers:    []*agonesv1.GameServer{withList("gs-1", "f1", "f2"), withList("gs-2", "f3", "f4")},
+			wantStatus: queues.StatusFailure,
+			wantError:  "all friends' gameservers are full",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs := make([]runtime.Object, 0, len(tt.servers))
+			candidates := make([]string, 0, len(tt.servers))
+			for _, gs := range tt.servers {
+				objs = append(objs, gs)
+				candidates = append(candidates, gs.Name)
+			}
+			client := agonesfake.NewSimpleClientset(objs...)
+			pub := &mockPublisher{}
+			ctrl := NewController(pub, "ns", WithFleetConfigs(config.FleetConfigs{
+				"fleet": {Capacity: capacity, WhenFull: tt.whenFull},
+			}))
+			ctrl.tokens = NewTokenStore(client)
+
+			req := &queues.AllocationRequest{TicketID: "t1", Fleet: "fleet", PlayerID: "p"}
+			tok := buildQuilkinToken("p")
+			if err := ctrl.joinExistingGameServer(context.Background(), req, time.Now(), candidates, tok); err != nil && !queues.IsPermanent(err) {
+				t.Fatalf("joinExistingGameServer() error: %v", err)
+			}
+			if len(pub.published) != 1 {
+				t.Fatalf("published %d results, want 1", len(pub.published))
+			}
+			res := pub.published[0]
+			if res.Status != tt.wantStatus {
+				t.Fatalf("status = %s, want %s", res.Status, tt.wantStatus)
+			}
+			if tt.wantError != "" && (res.ErrorMessage == nil || *res.ErrorMessage != tt.wantError) {
+				t.Errorf("error message = %v, want %q", res.ErrorMessage, tt.wantError)
+			}
+			if tt.wantGS == "" {
+				return
+			}
+			if res.GameServer == nil || res.GameServer.Name != tt.wantGS {
+				t.Fatalf("gameServer = %#v, want %s", res.GameServer, tt.wantGS)
+			}
+			gs, err := client.AgonesV1().GameServers("ns").Get(context.Background(), tt.wantGS, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("get GameServer: %v", err)
+			}
+			if !hasToken(gs, tok) {
+				t.Error("joined GameServer is missing the player's token")
+			}
+			values := gs.Status.Lists["players"].Values
+			if len(values) == 0 || values[len(values)-1] != "p" {
+				t.Errorf("players list = %v, want player appended", values)
+			}
+		})
+	}
+}
+
+func TestController_Handle_ClassifiesAllocationErrors(t *testing.T) {
+	gsa := schema.GroupResource{Group: "allocation.agones.dev", Resource: "gameserverallocations"}
+	tests := []struct {
+		name          string
+		err           error
+		state         allocationv1.GameServerAllocationState
+		wantPermanent bool
+		wantFailure   bool
+	}{
+		{name: "forbidden fails the request", err: apierrors.NewForbidden(gsa, "", errors.New("denied")), wantPermanent: true, wantFailure: true},
+		{name: "bad request fails the request", err: apierrors.NewBadRequest("bad selector"), wantPermanent: true, wantFailure: true},
+		{name: "unavailable is retried", err: apierrors.NewServiceUnavailable("down")},
+		{name: "throttled is retried", err: apierrors.NewTooManyRequests("slow down", 1)},
+		{name: "timeout is retried", err: apierrors.NewTimeoutError("timeout", 1)},
+		{name: "contention is retried", state: allocationv1.GameServerAllocationContention},
+		{name: "unallocated fails the request", state: allocationv1.GameServerAllocationUnAllocated, wantPermanent: true, wantFailure: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := agonesfake.NewSimpleClientset()
+			client.PrependReactor("create", "gameserverallocations", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if tt.err != nil {
+					return true, nil, tt.err
+				}
+				return true, &allocationv1.GameServerAllocation{Status: allocationv1.GameServerAllocationStatus{State: tt.state}}, nil
+			})
+			pub := &mockPublisher{}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			ctrl := NewController(pub, "ns", WithAgonesClient(client))
+			if err := ctrl.Start(ctx); err != nil {
+				t.Fatalf("Start() error: %v", err)
+			}
+
+			err := ctrl.Handle(ctx, &queues.AllocationRequest{TicketID: "t1", Fleet: "fleet", PlayerID: "p1"})
+			if err == nil {
+				t.Fatal("Handle() error = nil")
+			}
+			if got := queues.IsPermanent(err); got != tt.wantPermanent {
+				t.Errorf("Handle() error = %v, permanent = %v, want %v", err, got, tt.wantPermanent)
+			}
+			gotFailure := len(pub.published) == 1 && pub.published[0].Status == queues.StatusFailure
+			if gotFailure != tt.wantFailure || (!tt.wantFailure && len(pub.published) != 0) {
+				t.Errorf("published %#v, want failure result = %v", pub.published, tt.wantFailure)
+			}
+		})
+	}
+}
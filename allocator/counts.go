@@ -0,0 +1,122 @@
+package allocator
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+
+	"agones-pubsub-allocator/config"
+	"agones-pubsub-allocator/queues"
+
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	allocationv1 "agones.dev/agones/pkg/apis/allocation/v1"
+)
+
+// countsAndLists sets the allocation's priorities and Counter and List actions
+// from the fleet config and the request. The request's priorities replace the
+// fleet's; its actions are merged over the fleet's by name. Requests may only
+// name the fleet's allowed Counters and Lists and never act on its player list,
+// which gets the player ID added.
+func countsAndLists(spec *allocationv1.GameServerAllocationSpec, req *queues.AllocationRequest, fc config.FleetConfig) error {
+	if err := checkCountsAndLists(req, fc); err != nil {
+		return err
+	}
+	priorities := fc.Priorities
+	if len(req.Priorities) > 0 {
+		priorities = make([]config.Priority, len(req.Priorities))
+		for i, p := range req.Priorities {
+			priorities[i] = config.Priority(p)
+		}
+	}
+	for _, p := range priorities {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		if p.Order == "" {
+			p.Order = config.OrderAscending
+		}
+		spec.Priorities = append(spec.Priorities, agonesv1.Priority{Type: p.Type, Key: p.Key, Order: p.Order})
+	}
+
+	counters := make(map[string]config.CounterAction, len(fc.Counters)+len(req.Counters))
+	maps.Copy(counters, fc.Counters)
+	for name, a := range req.Counters {
+		counters[name] = config.CounterAction(a)
+	}
+	if len(counters) > 0 {
+		spec.Counters = make(map[string]allocationv1.CounterAction, len(counters))
+	}
+	for name, a := range counters {
+		if name == "" {
+			return errors.New("counter action requires a counter name")
+		}
+		if err := a.Validate(); err != nil {
+			return fmt.Errorf("counter %q: %w", name, err)
+		}
+		action := allocationv1.CounterAction{Capacity: a.Capacity}
+		if a.Action != "" {
+			action.Action, action.Amount = &a.Action, &a.Amount
+		}
+		spec.Counters[name] = action
+	}
+
+	lists := make(map[string]config.ListAction, len(fc.Lists)+len(req.Lists)+1)
+	maps.Copy(lists, fc.Lists)
+	for name, a := range req.Lists {
+		lists[name] = config.ListAction(a)
+	}
+	if fc.PlayerList != "" {
+		a := lists[fc.PlayerList]
+		a.AddValues = append(a.AddValues[:len(a.AddValues):len(a.AddValues)], req.PlayerID)
+		lists[fc.PlayerList] = a
+	}
+	if len(lists) > 0 {
+		spec.Lists = make(map[string]allocationv1.ListAction, len(lists))
+	}
+	for name, a := range lists {
+		if name == "" {
+			return errors.New("list action requires a list name")
+		}
+		if err := a.Validate(); err != nil {
+			return fmt.Errorf("list %q: %w", name, err)
+		}
+		spec.Lists[name] = allocationv1.ListAction{AddValues: a.AddValues, DeleteValues: a.DeleteValues, Capacity: a.Capacity}
+	}
+	return nil
+}
+
+// checkCountsAndLists rejects request priorities and actions on Counters and
+// Lists the fleet doesn't allow, and any action on its player list
+func checkCountsAndLists(req *queues.AllocationRequest, fc config.FleetConfig) error {
+	for _, p := range req.Priorities {
+		switch {
+		case p.Type == config.PriorityCounter && !fc.AllowsCounter(p.Key):
+			return fmt.Errorf("counter %q is not allowed for this fleet", p.Key)
+		case p.Type == config.PriorityList && !fc.AllowsList(p.Key):
+			return fmt.Errorf("list %q is not allowed for this fleet", p.Key)
+		}
+	}
+	for name := range req.Counters {
+		if !fc.AllowsCounter(name) {
+			return fmt.Errorf("counter %q is not allowed for this fleet", name)
+		}
+	}
+	for name := range req.Lists {
+		if fc.PlayerList != "" && name == fc.PlayerList {
+			return fmt.Errorf("list %s is the fleet's player list", name)
+		}
+		if !fc.AllowsList(name) {
+			return fmt.Errorf("list %q is not allowed for this fleet", name)
+		}
+	}
+	return nil
+}
+
+// playerList is the fleet's player list as a capacity source, so joins reserve
+// and release it like a list capacity. It is empty when the fleet has none.
+func playerList(fc config.FleetConfig) config.CapacityConfig {
+	if fc.PlayerList == "" {
+		return config.CapacityConfig{}
+	}
+	return config.CapacityConfig{Source: config.CapacityList, Name: fc.PlayerList}
+}
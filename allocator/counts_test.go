@@ -0,0 +1,154 @@
+package allocator
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"agones-pubsub-allocator/config"
+	"agones-pubsub-allocator/queues"
+
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	allocationv1 "agones.dev/agones/pkg/apis/allocation/v1"
+	agonesfake "agones.dev/agones/pkg/client/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCountsAndLists(t *testing.T) {
+	increment, one, ten, negative := config.CounterIncrement, int64(1), int64(10), int64(-1)
+	fleet := config.FleetConfig{
+		Priorities:   []config.Priority{{Type: config.PriorityList, Key: "players"}},
+		Counters:     map[string]config.CounterAction{"rooms": {Action: config.CounterIncrement, Amount: 1}},
+		Lists:        map[string]config.ListAction{"players": {Capacity: &ten}},
+		PlayerList:   "players",
+		CounterNames: []string{"rooms"},
+		ListNames:    []string{"players", "party"},
+	}
+	anyName := config.FleetConfig{CounterNames: []string{"*"}, ListNames: []string{"*"}}
+
+	tests := []struct {
+		name    string
+		req     queues.AllocationRequest
+		fc      config.FleetConfig
+		want    allocationv1.GameServerAllocationSpec
+		wantErr bool
+	}{
+		{name: "none", req: queues.AllocationRequest{PlayerID: "p1"}},
+		{
+			name: "fleet config",
+			req:  queues.AllocationRequest{PlayerID: "p1"},
+			fc:   fleet,
+			want: allocationv1.GameServerAllocationSpec{
+				Priorities: []agonesv1.Priority{{Type: "List", Key: "players", Order: "Ascending"}},
+				Counters:   map[string]allocationv1.CounterAction{"rooms": {Action: &increment, Amount: &one}},
+				Lists:      map[string]allocationv1.ListAction{"players": {AddValues: []string{"p1"}, Capacity: &ten}},
+			},
+		},
+		{
+			name: "request replaces priorities and merges actions",
+			req: queues.AllocationRequest{
+				PlayerID:   "p1",
+				Priorities: []queues.AllocationPriority{{Type: "Counter", Key: "rooms", Order: "Descending"}},
+				Counters:   map[string]queues.CounterAction{"rooms": {Capacity: &ten}},
+				Lists:      map[string]queues.ListAction{"party": {AddValues: []string{"p1"}}},
+			},
+			fc: fleet,
+			want: allocationv1.GameServerAllocationSpec{
+				Priorities: []agonesv1.Priority{{Type: "Counter", Key: "rooms", Order: "Descending"}},
+				Counters:   map[string]allocationv1.CounterAction{"rooms": {Capacity: &ten}},
+				Lists: map[string]allocationv1.ListAction{
+					"players": {AddValues: []string{"p1"}, Capacity: &ten},
+					"party":   {AddValues: []string{"p1"}},
+				},
+			},
+		},
+		{name: "counter not allowed", req: queues.AllocationRequest{Counters: map[string]queues.CounterAction{"sessions": {Action: "Increment", Amount: 1}}}, fc: fleet, wantErr: true},
+		{name: "list not allowed", req: queues.AllocationRequest{Lists: map[string]queues.ListAction{"bans": {AddValues: []string{"p2"}}}}, fc: fleet, wantErr: true},
+		{name: "priority not allowed", req: queues.AllocationRequest{Priorities: []queues.AllocationPriority{{Type: "Counter", Key: "sessions"}}}, fc: fleet, wantErr: true},
+		{name: "player list can't be changed", req: queues.AllocationRequest{Lists: map[string]queues.ListAction{"players": {AddValues: []string{"p2"}}}}, fc: fleet, wantErr: true},
+		{name: "player list can't be changed with any name allowed", req: queues.AllocationRequest{Lists: map[string]queues.ListAction{"players": {DeleteValues: []string{"f1"}}}}, fc: config.FleetConfig{ListNames: []string{"*"}, PlayerList: "players"}, wantErr: true},
+		{name: "nothing allowed by default", req: queues.AllocationRequest{Lists: map[string]queues.ListAction{"party": {AddValues: []string{"p1"}}}}, wantErr: true},
+		{name: "unknown priority type", req: queues.AllocationRequest{Priorities: []queues.AllocationPriority{{Type: "Players", Key: "p"}}}, fc: anyName, wantErr: true},
+		{name: "unknown counter action", req: queues.AllocationRequest{Counters: map[string]queues.CounterAction{"rooms": {Action: "Set", Amount: 1}}}, fc: anyName, wantErr: true},
+		{name: "counter without name", req: queues.AllocationRequest{Counters: map[string]queues.CounterAction{"": {Action: "Increment", Amount: 1}}}, fc: anyName, wantErr: true},
+		{name: "negative list capacity", req: queues.AllocationRequest{Lists: map[string]queues.ListAction{"players": {Capacity: &negative}}}, fc: anyName, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got allocationv1.GameServerAllocationSpec
+			err := countsAndLists(&got, &tt.req, tt.fc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("countsAndLists() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("countsAndLists()\n got=%#v\nwant=%#v", got, tt.want)
+			}
+		})
+	}
+	if got := fleet.Lists["players"].AddValues; len(got) != 0 {
+		t.Errorf("fleet config modified: players addValues = %v", got)
+	}
+}
+
+func TestController_Handle_PlayerList(t *testing.T) {
+	fleets := config.FleetConfigs{"fleet": {PlayerList: "players"}}
+
+	t.Run("allocation adds the player and requires room on allocated servers", func(t *testing.T) {
+		client := agonesfake.NewSimpleClientset()
+		created := make(chan *allocationv1.GameServerAllocation, 1)
+		client.PrependReactor("create", "gameserverallocations", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			created <- action.(k8stesting.CreateAction).GetObject().(*allocationv1.GameServerAllocation)
+			return true, &allocationv1.GameServerAllocation{Status: allocationv1.GameServerAllocationStatus{State: allocationv1.GameServerAllocationUnAllocated}}, nil
+		})
+		pub := &mockPublisher{}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		ctrl := NewController(pub, "ns", WithAgonesClient(client), WithFleetConfigs(fleets))
+		if err := ctrl.Start(ctx); err != nil {
+			t.Fatalf("Start() error: %v", err)
+		}
+
+		_ = ctrl.Handle(ctx, &queues.AllocationRequest{
+			TicketID:          "t1",
+			Fleet:             "fleet",
+			PlayerID:          "p1",
+			GameServerState:   "Allocated",
+			FallbackSelectors: []queues.GameServerSelector{{GameServerState: "Ready"}},
+		})
+		gsa := <-created
+		if got := gsa.Spec.Lists["players"].AddValues; !slices.Equal(got, []string{"p1"}) {
+			t.Errorf("players addValues = %v, want [p1]", got)
+		}
+		if got := gsa.Spec.Selectors[0].Lists["players"]; got.MinAvailable != 1 {
+			t.Errorf("allocated selector players list = %#v, want minAvailable 1", got)
+		}
+		if got := gsa.Spec.Selectors[1].Lists; got != nil {
+			t.Errorf("ready selector lists = %#v, want none", got)
+		}
+	})
+
+	t.Run("friend join adds the player", func(t *testing.T) {
+		gs := newTestGameServer("gs-1", buildQuilkinToken("f1"))
+		gs.Status.Lists = map[string]agonesv1.ListStatus{"players": {Capacity: 4, Values: []string{"f1"}}}
+		client := agonesfake.NewSimpleClientset(gs)
+		pub := &mockPublisher{}
+		ctrl := NewController(pub, "ns", WithFleetConfigs(fleets))
+		ctrl.tokens = NewTokenStore(client)
+
+		req := &queues.AllocationRequest{TicketID: "t1", Fleet: "fleet", PlayerID: "p1"}
+		if err := ctrl.joinExistingGameServer(context.Background(), req, time.Now(), []string{"gs-1"}, buildQuilkinToken("p1")); err != nil {
+			t.Fatalf("joinExistingGameServer() error: %v", err)
+		}
+		got, err := client.AgonesV1().GameServers("ns").Get(context.Background(), "gs-1", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get GameServer: %v", err)
+		}
+		if values := got.Status.Lists["players"].Values; !slices.Equal(values, []string{"f1", "p1"}) {
+			t.Errorf("players list = %v, want [f1 p1]", values)
+		}
+	})
+}
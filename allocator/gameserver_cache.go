@@ -0,0 +1,102 @@
+package allocator
+
+import (
+	"context"
+	"errors"
+
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	agonesclientset "agones.dev/agones/pkg/client/clientset/versioned"
+	"agones.dev/agones/pkg/client/informers/externalversions"
+	"github.com/rs/zerolog/log"
+	"k8s.io/client-go/tools/cache"
+)
+
+// tokenIndex maps each Quilkin token to the GameServers carrying it
+const tokenIndex = "quilkinToken"
+
+// GameServerCache is a shared-informer view of the GameServers in one namespace,
+// indexed by Quilkin token so lookups never list the API server.
+// Returned GameServers are shared with the informer and must not be modified.
+type GameServerCache struct {
+	factory  externalversions.SharedInformerFactory
+	informer cache.SharedIndexInformer
+}
+
+// NewGameServerCache creates a cache for the namespace. Call Start before lookups.
+func NewGameServerCache(agones agonesclientset.Interface, namespace string) (*GameServerCache, error) {
+	factory := externalversions.NewSharedInformerFactoryWithOptions(agones, 0, externalversions.WithNamespace(namespace))
+	informer := factory.Agones().V1().GameServers().Informer()
+	if err := informer.AddIndexers(cache.Indexers{
+		tokenIndex: gameServerTokens,
+	}); err != nil {
+		return nil, err
+	}
+	return &GameServerCache{factory: factory, informer: informer}, nil
+}
+
+// Start runs the informer until ctx is done and blocks until the initial list has synced
+func (gc *GameServerCache) Start(ctx context.Context) error {
+	gc.factory.Start(ctx.Done())
+	log.Info().Msg("gameserver cache: waiting for initial sync")
+	if !cache.WaitForCacheSync(ctx.Done(), gc.informer.HasSynced) {
+		return errors.New("gameserver cache: stopped before initial sync")
+	}
+	log.Info().Int("gameServers", len(gc.informer.GetStore().ListKeys())).Msg("gameserver cache: synced")
+	return nil
+}
+
+// HasSynced reports whether the initial list has been loaded
+func (gc *GameServerCache) HasSynced() bool {
+	return gc.informer.HasSynced()
+}
+
+// ByToken returns the fleet's GameServers whose token annotation contains token
+func (gc *GameServerCache) ByToken(fleet, token string) ([]*agonesv1.GameServer, error) {
+	objs, err := gc.informer.GetIndexer().ByIndex(tokenIndex, token)
+	if err != nil {
+		return nil, err
+	}
+	return filterFleet(objs, fleet), nil
+}
+
+// Notify calls fn with the name of every GameServer that is updated or deleted
+func (gc *GameServerCache) Notify(fn func(name string)) error {
+	_, err := gc.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(_, obj interface{}) {
+			if gs, ok := obj.(*agonesv1.GameServer); ok {
+				fn(gs.Name)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if gs, ok := obj.(*agonesv1.GameServer); ok {
+				fn(gs.Name)
+			}
+		},
+	})
+	return err
+}
+
+// filterFleet converts indexer results to GameServers belonging to fleet
+func filterFleet(objs []interface{}, fleet string) []*agonesv1.GameServer {
+	out := make([]*agonesv1.GameServer, 0, len(objs))
+	for _, obj := range objs {
+		gs, ok := obj.(*agonesv1.GameServer)
+		if !ok || gs.ObjectMeta.Labels["agones.dev/fleet"] != fleet {
+			continue
+		}
+		out = append(out, gs)
+	}
+	return out
+}
+
+// gameServerTokens is the tokenIndex function
+func gameServerTokens(obj interface{}) ([]string, error) {
+	gs, ok := obj.(*agonesv1.GameServer)
+	if !ok {
+		return nil, nil
+	}
+	return splitAndTrim(gs.ObjectMeta.Annotations[quilkinTokensAnnotation]), nil
+}
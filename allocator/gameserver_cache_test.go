@@ -0,0 +1,108 @@
+package allocator
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	agonesfake "agones.dev/agones/pkg/client/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGameServerCache_ByToken(t *testing.T) {
+	other := newTestGameServer("gs-other", "t1")
+	other.ObjectMeta.Labels["agones.dev/fleet"] = "other"
+	client := agonesfake.NewSimpleClientset(
+		newTestGameServer("gs-1", "t1,t2"),
+		newTestGameServer("gs-2", "t2"),
+		newTestGameServer("gs-3", ""),
+		other,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	gsc, err := NewGameServerCache(client, "ns")
+	if err != nil {
+		t.Fatalf("NewGameServerCache() error: %v", err)
+	}
+	if err := gsc.Start(ctx); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		fleet string
+		token string
+		want  []string
+	}{
+		{name: "single match", fleet: "fleet", token: "t1", want: []string{"gs-1"}},
+		{name: "multiple matches", fleet: "fleet", token: "t2", want: []string{"gs-1", "gs-2"}},
+		{name: "other fleet", fleet: "other", token: "t1", want: []string{"gs-other"}},
+		{name: "unknown token", fleet: "fleet", token: "t9", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := gsc.ByToken(tt.fleet, tt.token)
+			if err != nil {
+				t.Fatalf("ByToken() error: %v", err)
+			}
+			names := make([]string, 0, len(got))
+			for _, gs := range got {
+				names = append(names, gs.Name)
+			}
+			sort.Strings(names)
+			if len(names) != len(tt.want) {
+				t.Fatalf("ByToken() = %v, want %v", names, tt.want)
+			}
+			for i := range names {
+				if names[i] != tt.want[i] {
+					t.Errorf("ByToken() = %v, want %v", names, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestGameServerCache_FollowsUpdates(t *testing.T) {
+	client := agonesfake.NewSimpleClientset(newTestGameServer("gs-1", ""))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	gsc, err := NewGameServerCache(client, "ns")
+	if err != nil {
+		t.Fatalf("NewGameServerCache() error: %v", err)
+	}
+	if err := gsc.Start(ctx); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+
+	if _, err := NewTokenStore(client).AddToken(ctx, "ns", "gs-1", "t1"); err != nil {
+		t.Fatalf("AddToken() error: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		got, _ := gsc.ByToken("fleet", "t1")
+		if len(got) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("cache did not observe token update")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := client.AgonesV1().GameServers("ns").Delete(ctx, "gs-1", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("delete GameServer: %v", err)
+	}
+	for {
+		got, _ := gsc.ByToken("fleet", "t1")
+		if len(got) == 0 {
+			break
+		}
+		if time.Now().After(deadline.Add(2 * time.Second)) {
+			t.Fatal("cache did not observe GameServer deletion")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,54 @@
+package allocator
+
+import (
+	"agones-pubsub-allocator/config"
+
+	agonesclientset "agones.dev/agones/pkg/client/clientset/versioned"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// restConfig returns the in-cluster config, falling back to the local kubeconfig.
+func restConfig() (*rest.Config, error) {
+	// Try in-cluster config first
+	if cfg, err := rest.InClusterConfig(); err == nil {
+		return cfg, nil
+	}
+	// Fallback to local kubeconfig
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{})
+	return clientConfig.ClientConfig()
+}
+
+// clusterRestConfig returns the config for a registry cluster from its kubeconfig
+// file and context, or restConfig when it names neither
+func clusterRestConfig(cc config.ClusterConfig) (*rest.Config, error) {
+	if cc.Kubeconfig == "" && cc.Context == "" {
+		return restConfig()
+	}
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if cc.Kubeconfig != "" {
+		loadingRules.ExplicitPath = cc.Kubeconfig
+	}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: cc.Context}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+}
+
+// newAgonesClient returns an Agones typed clientset using in-cluster config or local kubeconfig.
+func newAgonesClient() (agonesclientset.Interface, error) {
+	cfg, err := restConfig()
+	if err != nil {
+		return nil, err
+	}
+	return agonesclientset.NewForConfig(cfg)
+}
+
+// NewKubernetesClient returns a core Kubernetes clientset using in-cluster config or local kubeconfig.
+func NewKubernetesClient() (kubernetes.Interface, error) {
+	cfg, err := restConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(cfg)
+}
@@ -0,0 +1,78 @@
+package allocator
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"agones-pubsub-allocator/queues"
+
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	allocationv1 "agones.dev/agones/pkg/apis/allocation/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// reservedMetadataPrefix is Agones' own label and annotation namespace
+const reservedMetadataPrefix = "agones.dev/"
+
+// metaPatch builds the allocation's metadata patch from the request's metadata.
+// When withToken is set the player's Quilkin token is written in the same call;
+// the patch replaces the annotation, so it is only used for Ready GameServers
+// that carry no other players' tokens.
+func metaPatch(req *queues.AllocationRequest, token string, withToken bool) (allocationv1.MetaPatch, error) {
+	var patch allocationv1.MetaPatch
+	if md := req.Metadata; md != nil {
+		for k, v := range md.Labels {
+			if err := checkMetadataKey(k); err != nil {
+				return patch, fmt.Errorf("label %w", err)
+			}
+			if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+				return patch, fmt.Errorf("label %q value: %s", k, strings.Join(errs, "; "))
+			}
+		}
+		for k := range md.Annotations {
+			if err := checkMetadataKey(k); err != nil {
+				return patch, fmt.Errorf("annotation %w", err)
+			}
+		}
+		patch.Labels = md.Labels
+		patch.Annotations = md.Annotations
+	}
+	if withToken {
+		annotations := make(map[string]string, len(patch.Annotations)+1)
+		maps.Copy(annotations, patch.Annotations)
+		annotations[quilkinTokensAnnotation] = token
+		patch.Annotations = annotations
+	}
+	return patch, nil
+}
+
+// checkMetadataKey rejects malformed keys and keys the allocator or Agones manage
+func checkMetadataKey(key string) error {
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		return fmt.Errorf("%q: %s", key, strings.Join(errs, "; "))
+	}
+	if strings.HasPrefix(key, reservedMetadataPrefix) || key == quilkinTokensAnnotation {
+		return fmt.Errorf("%q is reserved", key)
+	}
+	return nil
+}
+
+// readyOnly reports whether every selector allocates from Ready GameServers
+func readyOnly(selectors []allocationv1.GameServerSelector) bool {
+	for _, s := range selectors {
+		if s.GameServerState != nil && *s.GameServerState != agonesv1.GameServerStateReady {
+			return false
+		}
+	}
+	return true
+}
+
+// allocationHasToken reports whether the allocation response shows token on the GameServer
+func allocationHasToken(gsa *allocationv1.GameServerAllocation, token string) bool {
+	if gsa.Status.Metadata == nil {
+		return false
+	}
+	return slices.Contains(splitAndTrim(gsa.Status.Metadata.Annotations[quilkinTokensAnnotation]), token)
+}
@@ -0,0 +1,139 @@
+package allocator
+
+import (
+	"context"
+	"maps"
+	"reflect"
+	"testing"
+
+	"agones-pubsub-allocator/config"
+	"agones-pubsub-allocator/queues"
+
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	allocationv1 "agones.dev/agones/pkg/apis/allocation/v1"
+	agonesfake "agones.dev/agones/pkg/client/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestMetaPatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		metadata  *queues.GameServerMetadata
+		withToken bool
+		want      allocationv1.MetaPatch
+		wantErr   bool
+	}{
+		{name: "none", want: allocationv1.MetaPatch{}},
+		{name: "token only", withToken: true, want: allocationv1.MetaPatch{Annotations: map[string]string{quilkinTokensAnnotation: "tok"}}},
+		{
+			name:      "labels and annotations with token",
+			metadata:  &queues.GameServerMetadata{Labels: map[string]string{"match": "m-1", "example.com/mode": "ranked"}, Annotations: map[string]string{"party": "p 1"}},
+			withToken: true,
+			want: allocationv1.MetaPatch{
+				Labels:      map[string]string{"match": "m-1", "example.com/mode": "ranked"},
+				Annotations: map[string]string{"party": "p 1", quilkinTokensAnnotation: "tok"},
+			},
+		},
+		{
+			name:     "without token",
+			metadata: &queues.GameServerMetadata{Annotations: map[string]string{"map": "dust"}},
+			want:     allocationv1.MetaPatch{Annotations: map[string]string{"map": "dust"}},
+		},
+		{name: "invalid label key", metadata: &queues.GameServerMetadata{Labels: map[string]string{"bad key": "v"}}, wantErr: true},
+		{name: "invalid label value", metadata: &queues.GameServerMetadata{Labels: map[string]string{"map": "no spaces"}}, wantErr: true},
+		{name: "agones label", metadata: &queues.GameServerMetadata{Labels: map[string]string{"agones.dev/fleet": "other"}}, wantErr: true},
+		{name: "agones annotation", metadata: &queues.GameServerMetadata{Annotations: map[string]string{"agones.dev/sdk-version": "1"}}, wantErr: true},
+		{name: "token annotation", metadata: &queues.GameServerMetadata{Annotations: map[string]string{quilkinTokensAnnotation: "x"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := metaPatch(&queues.AllocationRequest{Metadata: tt.metadata}, "tok", tt.withToken)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("metaPatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("metaPatch()\n got=%#v\nwant=%#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestController_Handle_MetaPatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		state       string
+		wantInPatch bool
+	}{
+		{name: "ready server gets the token in the allocation", wantInPatch: true},
+		{name: "allocated server keeps other tokens", state: "Allocated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs := newTestGameServer("gs-1", "other")
+			gs.Status.Ports = []agonesv1.GameServerStatusPort{{Name: "game", Port: 7000}}
+			client := agonesfake.NewSimpleClientset(gs)
+			var spec allocationv1.GameServerAllocationSpec
+			client.PrependReactor("create", "gameserverallocations", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				spec = action.(k8stesting.CreateAction).GetObject().(*allocationv1.GameServerAllocation).Spec
+				// Agones reports the GameServer's metadata after applying the patch
+				annotations := map[string]string{quilkinTokensAnnotation: "other"}
+				maps.Copy(annotations, spec.MetaPatch.Annotations)
+				return true, &allocationv1.GameServerAllocation{Status: allocationv1.GameServerAllocationStatus{
+					State:          allocationv1.GameServerAllocationAllocated,
+					GameServerName: "gs-1",
+					Address:        "10.0.0.1",
+					Ports:          gs.Status.Ports,
+					Metadata:       &allocationv1.GameServerMetadata{Labels: spec.MetaPatch.Labels, Annotations: annotations},
+				}}, nil
+			})
+			pub := &mockPublisher{}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			ctrl := NewController(pub, "ns", WithAgonesClient(client), WithFleetConfigs(config.FleetConfigs{}))
+			if err := ctrl.Start(ctx); err != nil {
+				t.Fatalf("Start() error: %v", err)
+			}
+			client.ClearActions()
+
+			req := &queues.AllocationRequest{
+				TicketID:        "t1",
+				Fleet:           "fleet",
+				PlayerID:        "p1",
+				GameServerState: tt.state,
+				Metadata:        &queues.GameServerMetadata{Labels: map[string]string{"match": "m-1"}},
+			}
+			if err := ctrl.Handle(ctx, req); err != nil {
+				t.Fatalf("Handle() error: %v", err)
+			}
+			if res := lastResults(pub)["t1"]; res == nil || res.Status != queues.StatusSuccess {
+				t.Fatalf("result = %#v, want Success", res)
+			}
+
+			if spec.MetaPatch.Labels["match"] != "m-1" {
+				t.Errorf("patch labels = %v, want match=m-1", spec.MetaPatch.Labels)
+			}
+			tok := buildQuilkinToken("p1")
+			_, inPatch := spec.MetaPatch.Annotations[quilkinTokensAnnotation]
+			if inPatch != tt.wantInPatch {
+				t.Errorf("token in patch = %v, want %v", inPatch, tt.wantInPatch)
+			}
+			updates := 0
+			for _, a := range client.Actions() {
+				if a.Matches("update", "gameservers") {
+					updates++
+				}
+			}
+			if tt.wantInPatch && updates != 0 {
+				t.Errorf("GameServer updated %d times, want token set by the allocation only", updates)
+			}
+			if !tt.wantInPatch {
+				got, _ := client.AgonesV1().GameServers("ns").Get(ctx, "gs-1", metav1.GetOptions{})
+				if !hasToken(got, tok) || !hasToken(got, "other") {
+					t.Errorf("tokens = %q, want other and %s", got.ObjectMeta.Annotations[quilkinTokensAnnotation], tok)
+				}
+			}
+		})
+	}
+}
@@ -1,49 +1,97 @@
 package allocator
 
 import (
+	"sort"
 	"sync"
 	"time"
 
 	"agones-pubsub-allocator/queues"
 )
 
+// DefaultQueueAging is how long an entry waits before it is promoted one priority tier
+const DefaultQueueAging = time.Minute
+
 // QueueEntry represents a player waiting in queue for a gameserver
 type QueueEntry struct {
 	Request   *queues.AllocationRequest
 	Timestamp time.Time
 	Position  int
+	Seq       uint64 // Arrival order; breaks ties between equal priorities
 }
 
-// QueueManager manages FIFO queues for gameserver allocation
-// Since this allocator is intended to be a single pod, we store queues in memory
+// QueueManager manages priority queues for gameserver allocation.
+// Entries are ordered by effective priority, highest first, then by arrival.
+// The effective priority is the request's priority plus one tier for every
+// aging interval waited, capped at the fleet's top tier. Priorities above the top
+// tier are clamped to it, and entries that age up to it are served in arrival
+// order, so low tiers are not starved by a stream of high ones.
+// Positions are recomputed on every change and by Reorder as entries age.
+// Queues live in memory; the controller mirrors them to a QueueStore so they
+// survive restarts.
 type QueueManager struct {
-	mu     sync.RWMutex
-	queues map[string][]*QueueEntry // key: gameserver name, value: queue of players
+	mu          sync.RWMutex
+	queues      map[string][]*QueueEntry // key: gameserver name, value: queue of players
+	aging       time.Duration
+	maxPriority func(fleet string) int
+	seq         uint64
+	now         func() time.Time
 }
 
-// NewQueueManager creates a new queue manager
+// NewQueueManager creates a new queue manager using DefaultQueueAging and no top tier
 func NewQueueManager() *QueueManager {
 	return &QueueManager{
 		queues: make(map[string][]*QueueEntry),
+		aging:  DefaultQueueAging,
+		now:    time.Now,
+	}
+}
+
+// SetAging sets how long an entry waits before it is promoted one tier; zero disables aging
+func (qm *QueueManager) SetAging(aging time.Duration) {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	qm.aging = aging
+	now := qm.now()
+	for gsName := range qm.queues {
+		qm.reorder(gsName, now)
 	}
 }
 
-// Enqueue adds a player to the queue for a specific gameserver
+// SetMaxPriority sets the top tier of each fleet's queues; nil leaves tiers unbounded
+func (qm *QueueManager) SetMaxPriority(maxPriority func(fleet string) int) {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	qm.maxPriority = maxPriority
+	now := qm.now()
+	for gsName := range qm.queues {
+		qm.reorder(gsName, now)
+	}
+}
+
+// Enqueue adds a player to the queue for a specific gameserver.
+// A ticket already in the queue keeps its place and its position is returned.
 func (qm *QueueManager) Enqueue(gameServerName string, req *queues.AllocationRequest) int {
 	qm.mu.Lock()
 	defer qm.mu.Unlock()
 
+	for _, e := range qm.queues[gameServerName] {
+		if e.Request.TicketID == req.TicketID {
+			return e.Position
+		}
+	}
+
+	now := qm.now()
+	qm.seq++
 	entry := &QueueEntry{
 		Request:   req,
-		Timestamp: time.Now(),
+		Timestamp: now,
+		Seq:       qm.seq,
 	}
 
 	qm.queues[gameServerName] = append(qm.queues[gameServerName], entry)
-
-	// Update positions for all entries in this queue
-	for i, e := range qm.queues[gameServerName] {
-		e.Position = i + 1
-	}
+	qm.reorder(gameServerName, now)
 
 	return entry.Position
 }
@@ -60,13 +108,34 @@ func (qm *QueueManager) Dequeue(gameServerName string) *QueueEntry {
 
 	entry := queue[0]
 	qm.queues[gameServerName] = queue[1:]
+	qm.reorder(gameServerName, qm.now())
 
-	// Update positions for remaining entries
-	for i, e := range qm.queues[gameServerName] {
-		e.Position = i + 1
+	return entry
+}
+
+// Peek returns the first player in the queue for a gameserver without removing it
+func (qm *QueueManager) Peek(gameServerName string) *QueueEntry {
+	qm.mu.RLock()
+	defer qm.mu.RUnlock()
+
+	queue := qm.queues[gameServerName]
+	if len(queue) == 0 {
+		return nil
 	}
+	return queue[0]
+}
 
-	return entry
+// Entries returns a copy of the queue for a gameserver in position order
+func (qm *QueueManager) Entries(gameServerName string) []QueueEntry {
+	qm.mu.RLock()
+	defer qm.mu.RUnlock()
+
+	queue := qm.queues[gameServerName]
+	entries := make([]QueueEntry, 0, len(queue))
+	for _, e := range queue {
+		entries = append(entries, *e)
+	}
+	return entries
 }
 
 // GetPosition returns the current position of a player in the queue
@@ -101,12 +170,7 @@ func (qm *QueueManager) RemoveFromQueue(gameServerName, ticketID string) bool {
 	for i, entry := range queue {
 		if entry.Request.TicketID == ticketID {
 			qm.queues[gameServerName] = append(queue[:i], queue[i+1:]...)
-
-			// Update positions for remaining entries
-			for j, e := range qm.queues[gameServerName] {
-				e.Position = j + 1
-			}
-
+			qm.reorder(gameServerName, qm.now())
 			return true
 		}
 	}
@@ -114,6 +178,56 @@ func (qm *QueueManager) RemoveFromQueue(gameServerName, ticketID string) bool {
 	return false
 }
 
+// Restore replaces a gameserver's queue with previously saved entries, keeping
+// their arrival times and order
+func (qm *QueueManager) Restore(gameServerName string, entries []QueueEntry) {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	queue := make([]*QueueEntry, 0, len(entries))
+	for i := range entries {
+		e := entries[i]
+		if e.Seq > qm.seq {
+			qm.seq = e.Seq
+		}
+		queue = append(queue, &e)
+	}
+	qm.queues[gameServerName] = queue
+	qm.reorder(gameServerName, qm.now())
+}
+
+// Reorder re-applies aging to every queue and returns the gameservers whose
+// order changed, so their new positions can be published
+func (qm *QueueManager) Reorder() []string {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	now := qm.now()
+	var changed []string
+	for gsName := range qm.queues {
+		if qm.reorder(gsName, now) {
+			changed = append(changed, gsName)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// FindTicket returns the gameserver whose queue holds the ticket
+func (qm *QueueManager) FindTicket(ticketID string) (string, bool) {
+	qm.mu.RLock()
+	defer qm.mu.RUnlock()
+
+	for gsName, queue := range qm.queues {
+		for _, entry := range queue {
+			if entry.Request.TicketID == ticketID {
+				return gsName, true
+			}
+		}
+	}
+	return "", false
+}
+
 // GetQueueLength returns the number of players waiting for a gameserver
 func (qm *QueueManager) GetQueueLength(gameServerName string) int {
 	qm.mu.RLock()
@@ -135,6 +249,14 @@ func (qm *QueueManager) ClearQueue(gameServerName string) {
 	delete(qm.queues, gameServerName)
 }
 
+// Reset removes every queue
+func (qm *QueueManager) Reset() {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	qm.queues = make(map[string][]*QueueEntry)
+}
+
 // GetAllQueues returns a snapshot of all queues (for monitoring/debugging)
 func (qm *QueueManager) GetAllQueues() map[string]int {
 	qm.mu.RLock()
@@ -147,3 +269,41 @@ func (qm *QueueManager) GetAllQueues() map[string]int {
 
 	return snapshot
 }
+
+// reorder sorts a queue and renumbers positions, reporting whether the order changed.
+// Callers must hold the write lock.
+func (qm *QueueManager) reorder(gameServerName string, now time.Time) bool {
+	queue := qm.queues[gameServerName]
+	before := make([]*QueueEntry, len(queue))
+	copy(before, queue)
+
+	sort.SliceStable(queue, func(i, j int) bool {
+		pi, pj := qm.effectivePriority(queue[i], now), qm.effectivePriority(queue[j], now)
+		if pi != pj {
+			return pi > pj
+		}
+		return queue[i].Seq < queue[j].Seq
+	})
+
+	changed := false
+	for i, e := range queue {
+		e.Position = i + 1
+		if before[i] != e {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// effectivePriority is the request priority, from zero, plus one tier per aging
+// interval waited, at most the fleet's top tier
+func (qm *QueueManager) effectivePriority(e *QueueEntry, now time.Time) int {
+	p := max(e.Request.Priority, 0)
+	if qm.aging > 0 {
+		p += int(now.Sub(e.Timestamp) / qm.aging)
+	}
+	if qm.maxPriority != nil {
+		p = min(p, qm.maxPriority(e.Request.Fleet))
+	}
+	return p
+}
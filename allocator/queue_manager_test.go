@@ -1,7 +1,10 @@
 package allocator
 
 import (
+	"fmt"
+	"slices"
 	"testing"
+	"time"
 
 	"agones-pubsub-allocator/queues"
 )
@@ -225,3 +228,155 @@ func TestQueueManager_Concurrent(t *testing.T) {
 		t.Errorf("Queue length after concurrent enqueues = %d, want 10", length)
 	}
 }
+
+func TestQueueManager_PeekEntries(t *testing.T) {
+	qm := NewQueueManager()
+	gsName := "test-gameserver"
+
+	if qm.Peek(gsName) != nil {
+		t.Error("Peek() on empty queue should return nil")
+	}
+
+	qm.Enqueue(gsName, &queues.AllocationRequest{TicketID: "ticket1"})
+	qm.Enqueue(gsName, &queues.AllocationRequest{TicketID: "ticket2"})
+	if pos := qm.Enqueue(gsName, &queues.AllocationRequest{TicketID: "ticket1"}); pos != 1 {
+		t.Errorf("Enqueue() of queued ticket position = %d, want 1", pos)
+	}
+
+	if head := qm.Peek(gsName); head == nil || head.Request.TicketID != "ticket1" {
+		t.Errorf("Peek() = %v, want ticket1", head)
+	}
+	if got := qm.GetQueueLength(gsName); got != 2 {
+		t.Errorf("Peek() changed queue length to %d", got)
+	}
+
+	entries := qm.Entries(gsName)
+	if len(entries) != 2 || entries[0].Request.TicketID != "ticket1" || entries[1].Position != 2 {
+		t.Errorf("Entries() = %+v, want ticket1, ticket2 in order", entries)
+	}
+}
+
+func TestQueueManager_Priority(t *testing.T) {
+	tests := []struct {
+		name       string
+		priorities []int // ticket i+1 enqueued with priorities[i]
+		want       []string
+	}{
+		{name: "equal priorities stay FIFO", priorities: []int{0, 0, 0}, want: []string{"ticket1", "ticket2", "ticket3"}},
+		{name: "higher tier jumps ahead", priorities: []int{0, 0, 2}, want: []string{"ticket3", "ticket1", "ticket2"}},
+		{name: "tiers then arrival", priorities: []int{1, 0, 1, 2}, want: []string{"ticket4", "ticket1", "ticket3", "ticket2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qm := NewQueueManager()
+			for i, p := range tt.priorities {
+				qm.Enqueue("gs", &queues.AllocationRequest{TicketID: fmt.Sprintf("ticket%d", i+1), Priority: p})
+			}
+			entries := qm.Entries("gs")
+			for i, e := range entries {
+				if e.Request.TicketID != tt.want[i] {
+					t.Fatalf("order = %v, want %v", ticketIDs(entries), tt.want)
+				}
+				if pos, _ := qm.GetPosition("gs", e.Request.TicketID); pos != i+1 || e.Position != i+1 {
+					t.Errorf("%s position = %d (entry %d), want %d", e.Request.TicketID, pos, e.Position, i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestQueueManager_Aging(t *testing.T) {
+	now := time.Unix(0, 0)
+	qm := NewQueueManager()
+	qm.now = func() time.Time { return now }
+	qm.SetAging(time.Minute)
+
+	qm.Enqueue("gs", &queues.AllocationRequest{TicketID: "low", Priority: 0})
+	now = now.Add(90 * time.Second)
+	qm.Enqueue("gs", &queues.AllocationRequest{TicketID: "high", Priority: 2})
+	if got := ticketIDs(qm.Entries("gs")); got[0] != "high" {
+		t.Fatalf("order = %v, want high first", got)
+	}
+
+	// At 2m "low" has aged two tiers and, having arrived first, wins the tie
+	now = now.Add(30 * time.Second)
+	if changed := qm.Reorder(); len(changed) != 1 || changed[0] != "gs" {
+		t.Fatalf("Reorder() = %v, want [gs]", changed)
+	}
+	if changed := qm.Reorder(); len(changed) != 0 {
+		t.Errorf("second Reorder() = %v, want no change", changed)
+	}
+	if pos, _ := qm.GetPosition("gs", "high"); pos != 2 {
+		t.Errorf("high position = %d, want 2", pos)
+	}
+
+	// A new high-tier arrival no longer overtakes the aged entry
+	now = now.Add(time.Minute)
+	qm.Enqueue("gs", &queues.AllocationRequest{TicketID: "high2", Priority: 2})
+	if got := ticketIDs(qm.Entries("gs")); got[0] != "low" || got[2] != "high2" {
+		t.Errorf("order = %v, want [low high high2]", got)
+	}
+
+	// Without aging the tiers are strict
+	qm.SetAging(0)
+	if got := ticketIDs(qm.Entries("gs")); got[0] != "high" || got[2] != "low" {
+		t.Errorf("order without aging = %v, want [high high2 low]", got)
+	}
+}
+
+func TestQueueManager_MaxPriority(t *testing.T) {
+	now := time.Unix(0, 0)
+	qm := NewQueueManager()
+	qm.now = func() time.Time { return now }
+	qm.SetAging(time.Minute)
+	qm.SetMaxPriority(func(fleet string) int { return 2 })
+
+	// A priority far above the top tier is clamped to it and doesn't jump the queue
+	qm.Enqueue("gs", &queues.AllocationRequest{TicketID: "premium", Fleet: "fleet", Priority: 2})
+	qm.Enqueue("gs", &queues.AllocationRequest{TicketID: "greedy", Fleet: "fleet", Priority: 1000000})
+	if got := ticketIDs(qm.Entries("gs")); !slices.Equal(got, []string{"premium", "greedy"}) {
+		t.Fatalf("order = %v, want [premium greedy]", got)
+	}
+	qm.RemoveFromQueue("gs", "premium")
+	qm.RemoveFromQueue("gs", "greedy")
+
+	// A standard ticket ages up to the top tier and then beats every later premium arrival
+	qm.Enqueue("gs", &queues.AllocationRequest{TicketID: "standard", Fleet: "fleet", Priority: 0})
+	now = now.Add(3 * time.Hour)
+	qm.Enqueue("gs", &queues.AllocationRequest{TicketID: "premium", Fleet: "fleet", Priority: 2})
+	if got := ticketIDs(qm.Entries("gs")); !slices.Equal(got, []string{"standard", "premium"}) {
+		t.Errorf("order = %v, want [standard premium]", got)
+	}
+	if got := qm.effectivePriority(qm.queues["gs"][0], now); got != 2 {
+		t.Errorf("aged effective priority = %d, want the top tier 2", got)
+	}
+
+	// With a top tier of zero the queue is FIFO
+	qm.Enqueue("gs", &queues.AllocationRequest{TicketID: "plus", Fleet: "fleet", Priority: 1})
+	qm.SetMaxPriority(func(fleet string) int { return 0 })
+	if got := ticketIDs(qm.Entries("gs")); !slices.Equal(got, []string{"standard", "premium", "plus"}) {
+		t.Errorf("order with top tier 0 = %v, want [standard premium plus]", got)
+	}
+}
+
+func ticketIDs(entries []QueueEntry) []string {
+	ids := make([]string, len(entries))
+	for i, e := range entries {
+		ids[i] = e.Request.TicketID
+	}
+	return ids
+}
+
+func TestQueueManager_Reset(t *testing.T) {
+	qm := NewQueueManager()
+	qm.Enqueue("gs-1", &queues.AllocationRequest{TicketID: "t1"})
+	qm.Enqueue("gs-2", &queues.AllocationRequest{TicketID: "t2"})
+	qm.Reset()
+	if got := qm.GetAllQueues(); len(got) != 0 {
+		t.Errorf("GetAllQueues() after Reset = %v, want empty", got)
+	}
+	if pos := qm.Enqueue("gs-1", &queues.AllocationRequest{TicketID: "t3"}); pos != 1 {
+		t.Errorf("Enqueue() after Reset position = %d, want 1", pos)
+	}
+}
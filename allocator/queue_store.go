@@ -0,0 +1,123 @@
+package allocator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"agones-pubsub-allocator/queues"
+
+	"github.com/rs/zerolog/log"
+	"k8s.io/client-go/kubernetes"
+)
+
+// QueueStore persists QueueManager queues so queued players survive restarts
+type QueueStore interface {
+	// Load returns every saved queue keyed by gameserver name
+	Load(ctx context.Context) (map[string][]QueueEntry, error)
+	// Save replaces the saved queue for a gameserver; an empty queue is deleted
+	Save(ctx context.Context, gameServerName string, entries []QueueEntry) error
+}
+
+// MemoryQueueStore keeps nothing; queues are lost on restart
+type MemoryQueueStore struct{}
+
+// NewMemoryQueueStore returns the non-persistent QueueStore
+func NewMemoryQueueStore() MemoryQueueStore {
+	return MemoryQueueStore{}
+}
+
+func (MemoryQueueStore) Load(ctx context.Context) (map[string][]QueueEntry, error) {
+	return nil, nil
+}
+
+func (MemoryQueueStore) Save(ctx context.Context, gameServerName string, entries []QueueEntry) error {
+	return nil
+}
+
+// storedQueueEntry is the persisted form of a QueueEntry; positions are recomputed on restore
+type storedQueueEntry struct {
+	Request   *queues.AllocationRequest `json:"request"`
+	Timestamp time.Time                 `json:"timestamp"`
+	Seq       uint64                    `json:"seq"`
+}
+
+// DefaultQueueStoreMaxBytes bounds the ConfigMap queue store's data, leaving
+// headroom under the API server's 1 MiB object limit
+const DefaultQueueStoreMaxBytes = 900 << 10
+
+// ErrQueueStoreFull is returned by Save when a growing queue would take the
+// store past its size limit
+var ErrQueueStoreFull = errors.New("queue store is full")
+
+// ConfigMapQueueStore is a QueueStore kept in a Kubernetes ConfigMap, one key per
+// gameserver. Saves that would grow its data past maxBytes fail with ErrQueueStoreFull.
+type ConfigMapQueueStore struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	maxBytes  int
+}
+
+// NewConfigMapQueueStore creates a store backed by the named ConfigMap, which is created on first write
+func NewConfigMapQueueStore(client kubernetes.Interface, namespace, name string) *ConfigMapQueueStore {
+	return &ConfigMapQueueStore{client: client, namespace: namespace, name: name, maxBytes: DefaultQueueStoreMaxBytes}
+}
+
+func (s *ConfigMapQueueStore) Load(ctx context.Context) (map[string][]QueueEntry, error) {
+	data, err := readConfigMap(ctx, s.client, s.namespace, s.name)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string][]QueueEntry, len(data))
+	for gsName, raw := range data {
+		var stored []storedQueueEntry
+		if err := json.Unmarshal([]byte(raw), &stored); err != nil {
+			log.Warn().Err(err).Str("configMap", s.name).Str("gameServerName", gsName).Msg("queue store: skipping unreadable queue")
+			continue
+		}
+		entries := make([]QueueEntry, 0, len(stored))
+		for _, e := range stored {
+			entries = append(entries, QueueEntry{Request: e.Request, Timestamp: e.Timestamp, Seq: e.Seq})
+		}
+		out[gsName] = entries
+	}
+	return out, nil
+}
+
+func (s *ConfigMapQueueStore) Save(ctx context.Context, gameServerName string, entries []QueueEntry) error {
+	var raw []byte
+	if len(entries) > 0 {
+		stored := make([]storedQueueEntry, 0, len(entries))
+		for _, e := range entries {
+			stored = append(stored, storedQueueEntry{Request: e.Request, Timestamp: e.Timestamp, Seq: e.Seq})
+		}
+		b, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		raw = b
+	}
+
+	return updateConfigMap(ctx, s.client, s.namespace, s.name, func(data map[string]string) error {
+		if raw == nil {
+			delete(data, gameServerName)
+			return nil
+		}
+		if len(raw) > len(data[gameServerName]) {
+			size := len(gameServerName) + len(raw)
+			for k, v := range data {
+				if k != gameServerName {
+					size += len(k) + len(v)
+				}
+			}
+			if size > s.maxBytes {
+				return fmt.Errorf("%w: %s would hold %d bytes", ErrQueueStoreFull, s.name, size)
+			}
+		}
+		data[gameServerName] = string(raw)
+		return nil
+	})
+}
@@ -0,0 +1,163 @@
+package allocator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"agones-pubsub-allocator/queues"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigMapQueueStore(t *testing.T) {
+	ctx := context.Background()
+	client := k8sfake.NewSimpleClientset()
+	store := NewConfigMapQueueStore(client, "ns", "queues")
+
+	if got, err := store.Load(ctx); err != nil || len(got) != 0 {
+		t.Fatalf("Load() with no ConfigMap = %v, %v; want empty", got, err)
+	}
+
+	ts := time.Unix(1700000000, 0).UTC()
+	entries := []QueueEntry{
+		{Request: &queues.AllocationRequest{TicketID: "t1", Fleet: "fleet", PlayerID: "p1", Priority: 1}, Timestamp: ts, Position: 1, Seq: 4},
+		{Request: &queues.AllocationRequest{TicketID: "t2", Fleet: "fleet", PlayerID: "p2"}, Timestamp: ts.Add(time.Second), Position: 2, Seq: 5},
+	}
+	if err := store.Save(ctx, "gs-1", entries); err != nil {
+		t.Fatalf("Save() creating ConfigMap: %v", err)
+	}
+	if err := store.Save(ctx, "gs-2", entries[1:]); err != nil {
+		t.Fatalf("Save() updating ConfigMap: %v", err)
+	}
+
+	got, err := store.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if len(got) != 2 || len(got["gs-1"]) != 2 || len(got["gs-2"]) != 1 {
+		t.Fatalf("Load() = %#v, want gs-1 with 2 entries and gs-2 with 1", got)
+	}
+	e := got["gs-1"][0]
+	if e.Request.TicketID != "t1" || e.Request.Priority != 1 || !e.Timestamp.Equal(ts) || e.Seq != 4 {
+		t.Errorf("restored entry = %#v, want t1 with its priority, timestamp and seq", e)
+	}
+
+	// An emptied queue is removed from the ConfigMap
+	if err := store.Save(ctx, "gs-2", nil); err != nil {
+		t.Fatalf("Save() of empty queue: %v", err)
+	}
+	cm, err := client.CoreV1().ConfigMaps("ns").Get(ctx, "queues", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get ConfigMap: %v", err)
+	}
+	if _, ok := cm.Data["gs-2"]; ok || len(cm.Data) != 1 {
+		t.Errorf("ConfigMap data = %#v, want only gs-1", cm.Data)
+	}
+}
+
+func TestQueueManager_Restore(t *testing.T) {
+	qm := NewQueueManager()
+	ts := time.Now()
+	qm.Restore("gs", []QueueEntry{
+		{Request: &queues.AllocationRequest{TicketID: "late"}, Timestamp: ts, Seq: 9},
+		{Request: &queues.AllocationRequest{TicketID: "early"}, Timestamp: ts, Seq: 3},
+	})
+	if got := ticketIDs(qm.Entries("gs")); got[0] != "early" || got[1] != "late" {
+		t.Errorf("restored order = %v, want [early late]", got)
+	}
+
+	// New arrivals queue behind restored entries
+	if pos := qm.Enqueue("gs", &queues.AllocationRequest{TicketID: "new"}); pos != 3 {
+		t.Errorf("Enqueue() after restore position = %d, want 3", pos)
+	}
+}
+
+func TestController_PersistsAndRestoresQueues(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := NewConfigMapQueueStore(k8sfake.NewSimpleClientset(), "ns", "queues")
+
+	// First controller queues two players
+	ctrl, _, client := newQueueTestController(t)
+	ctrl.queueStore = store
+	for _, req := range []*queues.AllocationRequest{joinRequest("t1", "p1"), joinRequest("t2", "p2")} {
+		_ = ctrl.joinExistingGameServer(ctx, req, time.Now(), []string{"gs-1"}, buildQuilkinToken(req.PlayerID))
+	}
+
+	// A replacement controller restores and re-announces them on Start
+	pub := &mockPublisher{}
+	restarted := NewController(pub, "ns", WithQueueStore(store), WithFleetConfigs(ctrl.fleets))
+	restarted.agones = client
+	if err := restarted.Start(ctx); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+
+	if got := restarted.queueManager.GetQueueLength("gs-1"); got != 2 {
+		t.Fatalf("restored queue length = %d, want 2", got)
+	}
+	results := lastResults(pub)
+	for i, ticket := range []string{"t1", "t2"} {
+		res := results[ticket]
+		if res == nil || res.Status != queues.StatusQueued || *res.QueuePosition != i+1 || *res.QueueID != "gs-1" {
+			t.Errorf("%s re-announced as %#v, want Queued at %d on gs-1", ticket, res, i+1)
+		}
+	}
+}
+
+func TestController_RestartReplacesStaleQueues(t *testing.T) {
+	store := NewConfigMapQueueStore(k8sfake.NewSimpleClientset(), "ns", "queues")
+	if err := store.Save(context.Background(), "gs-1", []QueueEntry{
+		{Request: joinRequest("stored", "p1"), Timestamp: time.Now(), Seq: 1},
+	}); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+
+	ctrl, _, client := newQueueTestController(t)
+	ctrl.agones = client
+	ctrl.queueStore = store
+	// Left over from an earlier term as leader; the new leader's store wins
+	ctrl.queueManager.Enqueue("gs-2", joinRequest("stale", "p2"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := ctrl.Start(ctx); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	if got := ctrl.queueManager.GetAllQueues(); len(got) != 1 || got["gs-1"] != 1 {
+		t.Errorf("queues after Start = %v, want only gs-1 with the stored entry", got)
+	}
+}
+
+func TestController_QueueStoreFull(t *testing.T) {
+	ctx := context.Background()
+	client := k8sfake.NewSimpleClientset()
+	store := NewConfigMapQueueStore(client, "ns", "queues")
+	ctrl, pub, _ := newQueueTestController(t)
+	ctrl.queueStore = store
+
+	// Size the store to hold exactly one queued player
+	_ = ctrl.joinExistingGameServer(ctx, joinRequest("t1", "p1"), time.Now(), []string{"gs-1"}, buildQuilkinToken("p1"))
+	cm, err := client.CoreV1().ConfigMaps("ns").Get(ctx, "queues", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get ConfigMap: %v", err)
+	}
+	store.maxBytes = len("gs-1") + len(cm.Data["gs-1"])
+
+	err = ctrl.joinExistingGameServer(ctx, joinRequest("t2", "p2"), time.Now(), []string{"gs-1"}, buildQuilkinToken("p2"))
+	if !queues.IsPermanent(err) {
+		t.Errorf("joinExistingGameServer() error = %v, want a permanent failure", err)
+	}
+	if res := lastResults(pub)["t2"]; res == nil || res.Status != queues.StatusFailure || *res.ErrorMessage != "queue is full" {
+		t.Errorf("t2 result = %#v, want Failure queue is full", res)
+	}
+	if got := ctrl.queueManager.GetQueueLength("gs-1"); got != 1 {
+		t.Errorf("queue length = %d, want 1", got)
+	}
+
+	// Shrinking a queue is always saved
+	if err := store.Save(ctx, "gs-1", nil); err != nil {
+		t.Errorf("Save() of an emptied queue: %v", err)
+	}
+}
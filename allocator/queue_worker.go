@@ -0,0 +1,261 @@
+package allocator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"agones-pubsub-allocator/metrics"
+	"agones-pubsub-allocator/queues"
+
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	"github.com/rs/zerolog/log"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// queueResyncInterval bounds how long a queued player waits if a GameServer event is missed
+const queueResyncInterval = 10 * time.Second
+
+// enqueue queues a friend join on a full gameserver and publishes its position.
+// The entry is dropped again if the Queued result can't be published, so the
+// redelivered request starts over.
+func (c *Controller) enqueue(ctx context.Context, req *queues.AllocationRequest, start time.Time, gameServerName string) error {
+	position := c.queueManager.Enqueue(gameServerName, req)
+	if err := c.saveQueue(ctx, gameServerName); errors.Is(err, ErrQueueStoreFull) {
+		// The queue could not survive a restart; turn the player away instead
+		c.queueManager.RemoveFromQueue(gameServerName, req.TicketID)
+		c.updateQueueGauge()
+		return c.publishFailure(ctx, req, start, "queue is full")
+	}
+	log.Info().Str("ticketId", req.TicketID).Str("gameServerName", gameServerName).Int("position", position).Msg("controller: friend's gameserver full, player queued")
+	if err := c.publishQueued(ctx, req, start, gameServerName, position); err != nil {
+		c.queueManager.RemoveFromQueue(gameServerName, req.TicketID)
+		c.saveQueue(ctx, gameServerName)
+		c.updateQueueGauge()
+		return err
+	}
+	c.updateQueueGauge()
+	// A higher tier pushes everyone behind it back a place
+	c.publishQueuePositionsFrom(ctx, gameServerName, position+1)
+	return nil
+}
+
+// queueChanged persists a gameserver's queue after entries left it and republishes
+// the positions of everyone still waiting
+func (c *Controller) queueChanged(ctx context.Context, gameServerName string) {
+	c.saveQueue(ctx, gameServerName)
+	c.publishQueuePositions(ctx, gameServerName)
+	c.updateQueueGauge()
+}
+
+// saveQueue mirrors a gameserver's queue to the queue store. Failures are logged
+// and returned; the in-memory queue stays authoritative.
+func (c *Controller) saveQueue(ctx context.Context, gameServerName string) error {
+	if c.queueStore == nil {
+		return nil
+	}
+	err := c.queueStore.Save(ctx, gameServerName, c.queueManager.Entries(gameServerName))
+	if err != nil {
+		log.Warn().Err(err).Str("gameServerName", gameServerName).Msg("controller: failed to persist queue")
+	}
+	return err
+}
+
+// restoreQueues loads saved queues, announces every restored entry's position with a
+// fresh Queued result and schedules each queue for processing
+func (c *Controller) restoreQueues(ctx context.Context) error {
+	if c.queueStore == nil {
+		return nil
+	}
+	saved, err := c.queueStore.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to restore queues: %w", err)
+	}
+	// After a leadership change another replica may have served these queues, so a
+	// persisted store replaces whatever this replica still holds. In-memory queues
+	// are the only copy and are kept.
+	if _, inMemory := c.queueStore.(MemoryQueueStore); !inMemory {
+		c.queueManager.Reset()
+	}
+	for gsName, entries := range saved {
+		if len(entries) == 0 {
+			continue
+		}
+		c.queueManager.Restore(gsName, entries)
+		log.Info().Str("gameServerName", gsName).Int("entries", len(entries)).Msg("controller: restored queue")
+		c.publishQueuePositions(ctx, gsName)
+		c.signalQueue(gsName)
+	}
+	c.updateQueueGauge()
+	return nil
+}
+
+// signalQueue marks a gameserver's queue for processing if anyone is waiting on it
+func (c *Controller) signalQueue(gameServerName string) {
+	if c.queueManager.GetQueueLength(gameServerName) == 0 {
+		return
+	}
+	c.queueMu.Lock()
+	c.queueDirty[gameServerName] = struct{}{}
+	c.queueMu.Unlock()
+	select {
+	case c.queueWake <- struct{}{}:
+	default:
+	}
+}
+
+// runQueueWorker admits queued players as their gameservers change, and
+// periodically expires overdue entries, applies queue aging and re-checks
+// every queue in case an event was missed
+func (c *Controller) runQueueWorker(ctx context.Context) {
+	ticker := time.NewTicker(queueResyncInterval)
+	defer ticker.Stop()
+	log.Info().Msg("controller: queue worker started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("controller: queue worker stopped")
+			return
+		case <-c.queueWake:
+			c.queueMu.Lock()
+			dirty := c.queueDirty
+			c.queueDirty = make(map[string]struct{})
+			c.queueMu.Unlock()
+			for name := range dirty {
+				c.processQueue(ctx, name)
+			}
+		case <-ticker.C:
+			c.expireQueues(ctx, time.Now())
+			// Aging may have promoted entries past others
+			for _, name := range c.queueManager.Reorder() {
+				c.publishQueuePositions(ctx, name)
+			}
+			for name, length := range c.queueManager.GetAllQueues() {
+				if length > 0 {
+					c.processQueue(ctx, name)
+				}
+			}
+		}
+	}
+}
+
+// processQueue admits players from the head of a gameserver's queue while it has
+// capacity, fails them if the gameserver or its cluster is gone, and publishes the
+// new positions of everyone still waiting. Queues are keyed by gameServerKey.
+func (c *Controller) processQueue(ctx context.Context, gameServerName string) {
+	cl, name, known := c.gameServerCluster(gameServerName)
+	moved := false
+	defer func() {
+		if moved {
+			c.queueChanged(ctx, gameServerName)
+		}
+	}()
+
+	for {
+		entry := c.queueManager.Peek(gameServerName)
+		if entry == nil {
+			return
+		}
+		req := entry.Request
+		tok := buildQuilkinToken(req.PlayerID)
+
+		// Serialize with any redelivery or cancel of the same ticket being handled
+		release, err := c.acquireTicket(ctx, req.TicketID)
+		if err != nil {
+			return
+		}
+		if _, queued := c.queueManager.GetPosition(gameServerName, req.TicketID); !queued {
+			// Cancelled or expired while we waited for the ticket
+			release()
+			moved = true
+			continue
+		}
+		// A cluster dropped from the registry counts as the gameserver being gone
+		var gs *agonesv1.GameServer
+		err = errGameServerNotAllocated
+		if known {
+			gs, err = c.addPlayerToGameServer(ctx, cl, name, req, c.fleets.For(req.Fleet), tok)
+		}
+		switch {
+		case err == nil:
+			c.queueManager.RemoveFromQueue(gameServerName, req.TicketID)
+			metrics.QueueWaitDuration.WithLabelValues("success").Observe(time.Since(entry.Timestamp).Seconds())
+			log.Info().Str("ticketId", req.TicketID).Str("gameServerName", gameServerName).Msg("controller: queued player admitted")
+			if err := c.publishSuccess(ctx, req, entry.Timestamp, tok, clusterGameServerInfo(gs, req.Fleet, cl)); err != nil {
+				log.Error().Err(err).Str("ticketId", req.TicketID).Msg("controller: queued player joined but result was not published")
+			}
+		case errors.Is(err, errGameServerFull):
+			release()
+			return
+		case errors.Is(err, errGameServerNotAllocated) || apierrors.IsNotFound(err):
+			c.queueManager.RemoveFromQueue(gameServerName, req.TicketID)
+			metrics.QueueWaitDuration.WithLabelValues("failure").Observe(time.Since(entry.Timestamp).Seconds())
+			log.Warn().Str("ticketId", req.TicketID).Str("gameServerName", gameServerName).Msg("controller: queued player's gameserver is gone")
+			_ = c.publishFailure(ctx, req, entry.Timestamp, "friend's gameserver is no longer available")
+		default:
+			// Transient; leave the entry in place for the next event or resync
+			log.Error().Err(err).Str("ticketId", req.TicketID).Str("gameServerName", gameServerName).Msg("controller: failed to admit queued player")
+			release()
+			return
+		}
+		release()
+		moved = true
+	}
+}
+
+// expireQueues fails queued players that have waited longer than their fleet's
+// maxQueueWait, then republishes positions for the queues that changed
+func (c *Controller) expireQueues(ctx context.Context, now time.Time) {
+	for gsName := range c.queueManager.GetAllQueues() {
+		expired := false
+		for _, entry := range c.queueManager.Entries(gsName) {
+			req := entry.Request
+			maxWait := time.Duration(c.fleets.For(req.Fleet).MaxQueueWait)
+			if maxWait <= 0 || now.Sub(entry.Timestamp) < maxWait {
+				continue
+			}
+			release, err := c.acquireTicket(ctx, req.TicketID)
+			if err != nil {
+				return
+			}
+			if c.queueManager.RemoveFromQueue(gsName, req.TicketID) {
+				expired = true
+				metrics.QueueWaitDuration.WithLabelValues("expired").Observe(now.Sub(entry.Timestamp).Seconds())
+				log.Info().Str("ticketId", req.TicketID).Str("gameServerName", gsName).Dur("maxQueueWait", maxWait).Msg("controller: queued player expired")
+				_ = c.publishFailure(ctx, req, entry.Timestamp, fmt.Sprintf("queue wait exceeded %s", maxWait))
+			}
+			release()
+		}
+		if expired {
+			c.queueChanged(ctx, gsName)
+		}
+	}
+}
+
+// publishQueuePositions republishes Queued results for everyone waiting on a gameserver
+func (c *Controller) publishQueuePositions(ctx context.Context, gameServerName string) {
+	c.publishQueuePositionsFrom(ctx, gameServerName, 1)
+}
+
+// publishQueuePositionsFrom republishes Queued results for entries at or behind position
+func (c *Controller) publishQueuePositionsFrom(ctx context.Context, gameServerName string, position int) {
+	for _, entry := range c.queueManager.Entries(gameServerName) {
+		if entry.Position < position {
+			continue
+		}
+		if err := c.publish(ctx, queuedResult(entry.Request.TicketID, gameServerName, entry.Position)); err != nil {
+			log.Error().Err(err).Str("ticketId", entry.Request.TicketID).Msg("controller: failed to publish queue position")
+		}
+	}
+}
+
+// updateQueueGauge sets the queued players gauge from the current queues
+func (c *Controller) updateQueueGauge() {
+	total := 0
+	for _, length := range c.queueManager.GetAllQueues() {
+		total += length
+	}
+	metrics.QueuedPlayers.Set(float64(total))
+}
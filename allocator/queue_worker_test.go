@@ -0,0 +1,150 @@
+package allocator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"agones-pubsub-allocator/config"
+	"agones-pubsub-allocator/queues"
+
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	agonesfake "agones.dev/agones/pkg/client/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newQueueTestController returns a controller whose "fleet" queues on full servers,
+// and a client holding gs-1 with a full two-slot players list
+func newQueueTestController(t *testing.T) (*Controller, *mockPublisher, *agonesfake.Clientset) {
+	t.Helper()
+	gs := newTestGameServer("gs-1", "")
+	gs.Status.Lists = map[string]agonesv1.ListStatus{"players": {Capacity: 2, Values: []string{"f1", "f2"}}}
+	client := agonesfake.NewSimpleClientset(gs)
+	pub := &mockPublisher{}
+	ctrl := NewController(pub, "ns", WithFleetConfigs(config.FleetConfigs{
+		"fleet": {Capacity: config.CapacityConfig{Source: config.CapacityList, Name: "players"}, WhenFull: config.WhenFullQueue},
+	}))
+	ctrl.tokens = NewTokenStore(client)
+	return ctrl, pub, client
+}
+
+// lastResults returns the most recent published result per ticket
+func lastResults(pub *mockPublisher) map[string]*queues.AllocationResult {
+	pub.mu.Lock()
+	defer pub.mu.Unlock()
+	out := make(map[string]*queues.AllocationResult)
+	for _, res := range pub.published {
+		out[res.TicketID] = res
+	}
+	return out
+}
+
+func joinRequest(ticketID, playerID string) *queues.AllocationRequest {
+	return &queues.AllocationRequest{TicketID: ticketID, Fleet: "fleet", PlayerID: playerID, JoinOnIDs: []string{"f1"}}
+}
+
+func TestController_QueuesWhenFull(t *testing.T) {
+	ctrl, pub, _ := newQueueTestController(t)
+	ctx := context.Background()
+
+	for i, req := range []*queues.AllocationRequest{joinRequest("t1", "p1"), joinRequest("t2", "p2")} {
+		if err := ctrl.joinExistingGameServer(ctx, req, time.Now(), []string{"gs-1"}, buildQuilkinToken(req.PlayerID)); err != nil {
+			t.Fatalf("joinExistingGameServer(%s) error: %v", req.TicketID, err)
+		}
+		res := lastResults(pub)[req.TicketID]
+		if res == nil || res.Status != queues.StatusQueued {
+			t.Fatalf("result for %s = %#v, want Queued", req.TicketID, res)
+		}
+		if *res.QueuePosition != i+1 || *res.QueueID != "gs-1" {
+			t.Errorf("%s queued at %d on %s, want %d on gs-1", req.TicketID, *res.QueuePosition, *res.QueueID, i+1)
+		}
+	}
+
+	// A redelivered ticket keeps its place
+	if pos := ctrl.queueManager.Enqueue("gs-1", joinRequest("t1", "p1")); pos != 1 {
+		t.Errorf("re-enqueue position = %d, want 1", pos)
+	}
+}
+
+func TestController_processQueue_AdmitsWhenCapacityFrees(t *testing.T) {
+	ctrl, pub, client := newQueueTestController(t)
+	ctx := context.Background()
+	for _, req := range []*queues.AllocationRequest{joinRequest("t1", "p1"), joinRequest("t2", "p2")} {
+		_ = ctrl.joinExistingGameServer(ctx, req, time.Now(), []string{"gs-1"}, buildQuilkinToken(req.PlayerID))
+	}
+
+	// Still full: nothing moves
+	ctrl.processQueue(ctx, "gs-1")
+	if got := ctrl.queueManager.GetQueueLength("gs-1"); got != 2 {
+		t.Fatalf("queue length after full check = %d, want 2", got)
+	}
+
+	// One player leaves
+	gs, _ := client.AgonesV1().GameServers("ns").Get(ctx, "gs-1", metav1.GetOptions{})
+	gs.Status.Lists["players"] = agonesv1.ListStatus{Capacity: 2, Values: []string{"f1"}}
+	if _, err := client.AgonesV1().GameServers("ns").Update(ctx, gs, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update GameServer: %v", err)
+	}
+
+	ctrl.processQueue(ctx, "gs-1")
+	results := lastResults(pub)
+	if res := results["t1"]; res.Status != queues.StatusSuccess || res.GameServer == nil || res.GameServer.Name != "gs-1" {
+		t.Errorf("t1 result = %#v, want Success on gs-1", res)
+	}
+	if res := results["t2"]; res.Status != queues.StatusQueued || *res.QueuePosition != 1 {
+		t.Errorf("t2 result = %#v, want Queued at position 1", res)
+	}
+	if got := ctrl.queueManager.GetQueueLength("gs-1"); got != 1 {
+		t.Errorf("queue length = %d, want 1", got)
+	}
+	gs, _ = client.AgonesV1().GameServers("ns").Get(ctx, "gs-1", metav1.GetOptions{})
+	if !hasToken(gs, buildQuilkinToken("p1")) {
+		t.Error("admitted player's token missing from GameServer")
+	}
+}
+
+func TestController_processQueue_FailsWhenGameServerGone(t *testing.T) {
+	ctrl, pub, client := newQueueTestController(t)
+	ctx := context.Background()
+	for _, req := range []*queues.AllocationRequest{joinRequest("t1", "p1"), joinRequest("t2", "p2")} {
+		_ = ctrl.joinExistingGameServer(ctx, req, time.Now(), []string{"gs-1"}, buildQuilkinToken(req.PlayerID))
+	}
+
+	if err := client.AgonesV1().GameServers("ns").Delete(ctx, "gs-1", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("delete GameServer: %v", err)
+	}
+	ctrl.processQueue(ctx, "gs-1")
+
+	for ticket, res := range lastResults(pub) {
+		if res.Status != queues.StatusFailure {
+			t.Errorf("%s result = %s, want Failure", ticket, res.Status)
+		}
+	}
+	if got := ctrl.queueManager.GetQueueLength("gs-1"); got != 0 {
+		t.Errorf("queue length = %d, want 0", got)
+	}
+}
+
+func TestController_signalQueue(t *testing.T) {
+	ctrl, _, _ := newQueueTestController(t)
+
+	// No one waiting: no wake-up
+	ctrl.signalQueue("gs-1")
+	select {
+	case <-ctrl.queueWake:
+		t.Fatal("signalQueue() woke the worker for an empty queue")
+	default:
+	}
+
+	ctrl.queueManager.Enqueue("gs-1", joinRequest("t1", "p1"))
+	ctrl.signalQueue("gs-1")
+	ctrl.signalQueue("gs-1")
+	select {
+	case <-ctrl.queueWake:
+	default:
+		t.Fatal("signalQueue() did not wake the worker")
+	}
+	if _, ok := ctrl.queueDirty["gs-1"]; !ok {
+		t.Error("gs-1 not marked for processing")
+	}
+}
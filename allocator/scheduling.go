@@ -0,0 +1,30 @@
+package allocator
+
+import (
+	"errors"
+	"fmt"
+
+	"agones-pubsub-allocator/config"
+	"agones-pubsub-allocator/queues"
+
+	"agones.dev/agones/pkg/apis"
+)
+
+// schedulingStrategy picks the allocation's strategy: the request's when the
+// fleet allows overrides, otherwise the fleet's, defaulting to Packed like Agones
+func schedulingStrategy(req *queues.AllocationRequest, fc config.FleetConfig) (apis.SchedulingStrategy, error) {
+	strategy := fc.Scheduling
+	if req.Scheduling != "" {
+		if !fc.AllowSchedulingOverride {
+			return "", errors.New("scheduling can't be overridden for this fleet")
+		}
+		strategy = req.Scheduling
+	}
+	switch strategy {
+	case "":
+		return apis.Packed, nil
+	case config.SchedulingPacked, config.SchedulingDistributed:
+		return apis.SchedulingStrategy(strategy), nil
+	}
+	return "", fmt.Errorf("scheduling must be Packed or Distributed, got %q", strategy)
+}
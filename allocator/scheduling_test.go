@@ -0,0 +1,81 @@
+package allocator
+
+import (
+	"context"
+	"testing"
+
+	"agones-pubsub-allocator/config"
+	"agones-pubsub-allocator/metrics"
+	"agones-pubsub-allocator/queues"
+
+	"agones.dev/agones/pkg/apis"
+	allocationv1 "agones.dev/agones/pkg/apis/allocation/v1"
+	agonesfake "agones.dev/agones/pkg/client/clientset/versioned/fake"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestSchedulingStrategy(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     string
+		fc      config.FleetConfig
+		want    apis.SchedulingStrategy
+		wantErr bool
+	}{
+		{name: "default is packed", want: apis.Packed},
+		{name: "fleet", fc: config.FleetConfig{Scheduling: config.SchedulingDistributed}, want: apis.Distributed},
+		{name: "request override", req: "Packed", fc: config.FleetConfig{Scheduling: config.SchedulingDistributed, AllowSchedulingOverride: true}, want: apis.Packed},
+		{name: "override not allowed", req: "Distributed", fc: config.FleetConfig{Scheduling: config.SchedulingPacked}, wantErr: true},
+		{name: "unknown strategy", req: "Spread", fc: config.FleetConfig{AllowSchedulingOverride: true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := schedulingStrategy(&queues.AllocationRequest{Scheduling: tt.req}, tt.fc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("schedulingStrategy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("schedulingStrategy() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestController_Handle_Scheduling(t *testing.T) {
+	client := agonesfake.NewSimpleClientset()
+	created := make(chan *allocationv1.GameServerAllocation, 1)
+	client.PrependReactor("create", "gameserverallocations", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		created <- action.(k8stesting.CreateAction).GetObject().(*allocationv1.GameServerAllocation)
+		return true, &allocationv1.GameServerAllocation{Status: allocationv1.GameServerAllocationStatus{State: allocationv1.GameServerAllocationUnAllocated}}, nil
+	})
+	pub := &mockPublisher{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctrl := NewController(pub, "ns", WithAgonesClient(client), WithFleetConfigs(config.FleetConfigs{
+		"bare-metal": {Scheduling: config.SchedulingDistributed},
+	}))
+	if err := ctrl.Start(ctx); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+
+	// An override the fleet doesn't allow fails without creating an allocation
+	_ = ctrl.Handle(ctx, &queues.AllocationRequest{TicketID: "t1", Fleet: "bare-metal", PlayerID: "p1", Scheduling: "Packed"})
+	if len(created) != 0 {
+		t.Fatal("GameServerAllocation created for a disallowed scheduling override")
+	}
+	if res := lastResults(pub)["t1"]; res == nil || res.Status != queues.StatusFailure {
+		t.Fatalf("result = %#v, want Failure", res)
+	}
+
+	counter := metrics.GameServerAllocations.WithLabelValues("", "Distributed", "UnAllocated")
+	before := testutil.ToFloat64(counter)
+	_ = ctrl.Handle(ctx, &queues.AllocationRequest{TicketID: "t2", Fleet: "bare-metal", PlayerID: "p2"})
+	if gsa := <-created; gsa.Spec.Scheduling != apis.Distributed {
+		t.Errorf("scheduling = %q, want Distributed", gsa.Spec.Scheduling)
+	}
+	if got := testutil.ToFloat64(counter) - before; got != 1 {
+		t.Errorf("allocations counted = %v, want 1", got)
+	}
+}
@@ -0,0 +1,96 @@
+package allocator
+
+import (
+	"fmt"
+
+	"agones-pubsub-allocator/config"
+	"agones-pubsub-allocator/queues"
+
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	allocationv1 "agones.dev/agones/pkg/apis/allocation/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fleetLabel is the label Agones puts on every GameServer of a fleet
+const fleetLabel = "agones.dev/fleet"
+
+// gameServerSelectors translates the request's selector fields into the
+// GameServerAllocation selectors Agones tries in order: the request's own
+// selector first, then each fallback. Every selector is pinned to the request's
+// fleet and may only use label keys the fleet config allows.
+func gameServerSelectors(req *queues.AllocationRequest, fc config.FleetConfig) ([]allocationv1.GameServerSelector, error) {
+	primary := queues.GameServerSelector{
+		MatchLabels:      req.MatchLabels,
+		MatchExpressions: req.MatchExpressions,
+		GameServerState:  req.GameServerState,
+	}
+	out := make([]allocationv1.GameServerSelector, 0, 1+len(req.FallbackSelectors))
+	sel, err := gameServerSelector(req.Fleet, primary, fc)
+	if err != nil {
+		return nil, err
+	}
+	out = append(out, sel)
+	for i, fallback := range req.FallbackSelectors {
+		sel, err := gameServerSelector(req.Fleet, fallback, fc)
+		if err != nil {
+			return nil, fmt.Errorf("fallbackSelectors[%d]: %w", i, err)
+		}
+		out = append(out, sel)
+	}
+	return out, nil
+}
+
+// gameServerSelector converts one request selector, validating its labels,
+// operators and state
+func gameServerSelector(fleet string, s queues.GameServerSelector, fc config.FleetConfig) (allocationv1.GameServerSelector, error) {
+	labels := map[string]string{fleetLabel: fleet}
+	for k, v := range s.MatchLabels {
+		if err := checkSelectorLabel(k, fc); err != nil {
+			return allocationv1.GameServerSelector{}, err
+		}
+		labels[k] = v
+	}
+	var exprs []metav1.LabelSelectorRequirement
+	for _, e := range s.MatchExpressions {
+		if err := checkSelectorLabel(e.Key, fc); err != nil {
+			return allocationv1.GameServerSelector{}, err
+		}
+		exprs = append(exprs, metav1.LabelSelectorRequirement{
+			Key:      e.Key,
+			Operator: metav1.LabelSelectorOperator(e.Operator),
+			Values:   e.Values,
+		})
+	}
+	ls := metav1.LabelSelector{MatchLabels: labels, MatchExpressions: exprs}
+	// Rejects unknown operators, In/NotIn without values and invalid label values
+	if _, err := metav1.LabelSelectorAsSelector(&ls); err != nil {
+		return allocationv1.GameServerSelector{}, err
+	}
+
+	sel := allocationv1.GameServerSelector{LabelSelector: ls}
+	state := agonesv1.GameServerState(s.GameServerState)
+	switch state {
+	case "":
+	case agonesv1.GameServerStateReady, agonesv1.GameServerStateAllocated:
+		sel.GameServerState = &state
+	default:
+		return allocationv1.GameServerSelector{}, fmt.Errorf("gameServerState must be Ready or Allocated, got %q", s.GameServerState)
+	}
+	// A running GameServer is only re-used when its player list has room
+	if state == agonesv1.GameServerStateAllocated && fc.PlayerList != "" {
+		sel.Lists = map[string]allocationv1.ListSelector{fc.PlayerList: {MinAvailable: 1}}
+	}
+	return sel, nil
+}
+
+// checkSelectorLabel rejects label keys the fleet config doesn't allow; the
+// fleet label always comes from the request's fleet
+func checkSelectorLabel(key string, fc config.FleetConfig) error {
+	if key == fleetLabel {
+		return fmt.Errorf("label %s is set from fleet", fleetLabel)
+	}
+	if !fc.AllowsSelectorLabel(key) {
+		return fmt.Errorf("label %q is not allowed for this fleet", key)
+	}
+	return nil
+}
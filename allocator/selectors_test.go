@@ -0,0 +1,149 @@
+package allocator
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"agones-pubsub-allocator/config"
+	"agones-pubsub-allocator/queues"
+
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	allocationv1 "agones.dev/agones/pkg/apis/allocation/v1"
+	agonesfake "agones.dev/agones/pkg/client/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestGameServerSelectors(t *testing.T) {
+	allowed := config.FleetConfig{SelectorLabels: []string{"map", "version"}}
+	allocated := agonesv1.GameServerStateAllocated
+	ready := agonesv1.GameServerStateReady
+
+	tests := []struct {
+		name    string
+		req     queues.AllocationRequest
+		fc      config.FleetConfig
+		want    []allocationv1.GameServerSelector
+		wantErr bool
+	}{
+		{
+			name: "fleet only",
+			req:  queues.AllocationRequest{Fleet: "f"},
+			want: []allocationv1.GameServerSelector{
+				{LabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{fleetLabel: "f"}}},
+			},
+		},
+		{
+			name: "labels, expressions and state",
+			req: queues.AllocationRequest{
+				Fleet:            "f",
+				MatchLabels:      map[string]string{"map": "dust"},
+				MatchExpressions: []queues.SelectorRequirement{{Key: "version", Operator: "In", Values: []string{"1.2", "1.3"}}},
+				GameServerState:  "Allocated",
+			},
+			fc: allowed,
+			want: []allocationv1.GameServerSelector{
+				{
+					LabelSelector: metav1.LabelSelector{
+						MatchLabels:      map[string]string{fleetLabel: "f", "map": "dust"},
+						MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "version", Operator: metav1.LabelSelectorOpIn, Values: []string{"1.2", "1.3"}}},
+					},
+					GameServerState: &allocated,
+				},
+			},
+		},
+		{
+			name: "fallbacks follow in order and stay in the fleet",
+			req: queues.AllocationRequest{
+				Fleet:           "f",
+				MatchLabels:     map[string]string{"map": "dust"},
+				GameServerState: "Allocated",
+				FallbackSelectors: []queues.GameServerSelector{
+					{MatchLabels: map[string]string{"map": "dust"}, GameServerState: "Ready"},
+					{},
+				},
+			},
+			fc: allowed,
+			want: []allocationv1.GameServerSelector{
+				{LabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{fleetLabel: "f", "map": "dust"}}, GameServerState: &allocated},
+				{LabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{fleetLabel: "f", "map": "dust"}}, GameServerState: &ready},
+				{LabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{fleetLabel: "f"}}},
+			},
+		},
+		{
+			name: "wildcard allows any label",
+			req:  queues.AllocationRequest{Fleet: "f", MatchLabels: map[string]string{"mode": "ranked"}},
+			fc:   config.FleetConfig{SelectorLabels: []string{"*"}},
+			want: []allocationv1.GameServerSelector{
+				{LabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{fleetLabel: "f", "mode": "ranked"}}},
+			},
+		},
+		{name: "labels not allowed by default", req: queues.AllocationRequest{Fleet: "f", MatchLabels: map[string]string{"map": "dust"}}, wantErr: true},
+		{name: "label outside allow-list", req: queues.AllocationRequest{Fleet: "f", MatchLabels: map[string]string{"mode": "ranked"}}, fc: allowed, wantErr: true},
+		{name: "expression outside allow-list", req: queues.AllocationRequest{Fleet: "f", MatchExpressions: []queues.SelectorRequirement{{Key: "mode", Operator: "Exists"}}}, fc: allowed, wantErr: true},
+		{name: "fleet label can't be overridden", req: queues.AllocationRequest{Fleet: "f", MatchLabels: map[string]string{fleetLabel: "other"}}, fc: config.FleetConfig{SelectorLabels: []string{"*"}}, wantErr: true},
+		{name: "unknown operator", req: queues.AllocationRequest{Fleet: "f", MatchExpressions: []queues.SelectorRequirement{{Key: "map", Operator: "Like", Values: []string{"d"}}}}, fc: allowed, wantErr: true},
+		{name: "In without values", req: queues.AllocationRequest{Fleet: "f", MatchExpressions: []queues.SelectorRequirement{{Key: "map", Operator: "In"}}}, fc: allowed, wantErr: true},
+		{name: "unknown state", req: queues.AllocationRequest{Fleet: "f", GameServerState: "Shutdown"}, wantErr: true},
+		{name: "invalid fallback", req: queues.AllocationRequest{Fleet: "f", FallbackSelectors: []queues.GameServerSelector{{MatchLabels: map[string]string{"mode": "x"}}}}, fc: allowed, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := gameServerSelectors(&tt.req, tt.fc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("gameServerSelectors() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("gameServerSelectors()\n got=%#v\nwant=%#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestController_Handle_Selectors(t *testing.T) {
+	client := agonesfake.NewSimpleClientset()
+	created := make(chan *allocationv1.GameServerAllocation, 1)
+	client.PrependReactor("create", "gameserverallocations", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gsa := action.(k8stesting.CreateAction).GetObject().(*allocationv1.GameServerAllocation)
+		created <- gsa
+		return true, &allocationv1.GameServerAllocation{Status: allocationv1.GameServerAllocationStatus{State: allocationv1.GameServerAllocationUnAllocated}}, nil
+	})
+	pub := &mockPublisher{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctrl := NewController(pub, "ns", WithAgonesClient(client), WithFleetConfigs(config.FleetConfigs{
+		"fleet": {SelectorLabels: []string{"map"}},
+	}))
+	if err := ctrl.Start(ctx); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+
+	// A label outside the allow-list fails without creating an allocation
+	_ = ctrl.Handle(ctx, &queues.AllocationRequest{TicketID: "t1", Fleet: "fleet", PlayerID: "p1", MatchLabels: map[string]string{"mode": "ranked"}})
+	if len(created) != 0 {
+		t.Fatal("GameServerAllocation created for a disallowed label")
+	}
+	if res := lastResults(pub)["t1"]; res == nil || res.Status != queues.StatusFailure {
+		t.Fatalf("result = %#v, want Failure", res)
+	}
+
+	_ = ctrl.Handle(ctx, &queues.AllocationRequest{
+		TicketID:          "t2",
+		Fleet:             "fleet",
+		PlayerID:          "p2",
+		MatchLabels:       map[string]string{"map": "dust"},
+		FallbackSelectors: []queues.GameServerSelector{{}},
+	})
+	gsa := <-created
+	if n := len(gsa.Spec.Selectors); n != 2 {
+		t.Fatalf("selectors = %d, want 2", n)
+	}
+	if got := gsa.Spec.Selectors[0].MatchLabels; got["map"] != "dust" || got[fleetLabel] != "fleet" {
+		t.Errorf("first selector labels = %v", got)
+	}
+	if got := gsa.Spec.Selectors[1].MatchLabels; len(got) != 1 || got[fleetLabel] != "fleet" {
+		t.Errorf("fallback selector labels = %v", got)
+	}
+}
@@ -0,0 +1,241 @@
+package allocator
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"agones-pubsub-allocator/queues"
+
+	"github.com/rs/zerolog/log"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultTicketTTL is how long ticket outcomes are remembered when no TTL is configured
+const DefaultTicketTTL = 10 * time.Minute
+
+// TicketLedger remembers the last published result for each ticket so a
+// redelivered request can be answered without allocating again
+type TicketLedger interface {
+	// Get returns the last recorded result for a ticket, or nil if unknown or expired
+	Get(ctx context.Context, ticketID string) (*queues.AllocationResult, error)
+	// Put records a published result for its ticket
+	Put(ctx context.Context, res *queues.AllocationResult) error
+}
+
+type ledgerEntry struct {
+	Result    *queues.AllocationResult `json:"result"`
+	ExpiresAt time.Time                `json:"expiresAt"`
+}
+
+// MemoryLedger is an in-process TicketLedger. Entries are lost on restart.
+type MemoryLedger struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]ledgerEntry
+	now     func() time.Time
+}
+
+// NewMemoryLedger creates an in-memory ledger that forgets tickets after ttl
+func NewMemoryLedger(ttl time.Duration) *MemoryLedger {
+	if ttl <= 0 {
+		ttl = DefaultTicketTTL
+	}
+	return &MemoryLedger{
+		ttl:     ttl,
+		entries: make(map[string]ledgerEntry),
+		now:     time.Now,
+	}
+}
+
+func (l *MemoryLedger) Get(ctx context.Context, ticketID string) (*queues.AllocationResult, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[ticketID]
+	if !ok {
+		return nil, nil
+	}
+	if l.now().After(e.ExpiresAt) {
+		delete(l.entries, ticketID)
+		return nil, nil
+	}
+	return e.Result, nil
+}
+
+func (l *MemoryLedger) Put(ctx context.Context, res *queues.AllocationResult) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	// Prune expired entries so the map doesn't grow without bound
+	for id, e := range l.entries {
+		if now.After(e.ExpiresAt) {
+			delete(l.entries, id)
+		}
+	}
+	l.entries[res.TicketID] = ledgerEntry{Result: res, ExpiresAt: now.Add(l.ttl)}
+	return nil
+}
+
+// Defaults for the ConfigMap ledger's shard count and total entry cap. A
+// ConfigMap holds at most 1 MiB, so a shard is kept to about 1000 results.
+const (
+	DefaultLedgerShards     = 16
+	DefaultLedgerMaxEntries = 16000
+)
+
+// ErrLedgerFull is returned by Put when the ticket's shard already holds its
+// share of the entry cap
+var ErrLedgerFull = errors.New("ticket ledger is full")
+
+// ConfigMapLedger is a TicketLedger stored in Kubernetes ConfigMaps so ticket
+// outcomes survive allocator restarts. Tickets are spread by hash over shards
+// ConfigMaps named "<name>-NN", one key per ticket, so writes for different
+// tickets rarely contend and no ConfigMap outgrows the API server's size limit.
+// Tickets missing from their shard are looked up in the unsharded "<name>"
+// ConfigMap of earlier versions until its entries have expired, after which it
+// is deleted.
+type ConfigMapLedger struct {
+	client      kubernetes.Interface
+	namespace   string
+	name        string
+	ttl         time.Duration
+	shards      int
+	shardLimit  int // entries per shard
+	legacyUntil time.Time
+	legacyDone  atomic.Bool
+	now         func() time.Time
+}
+
+// NewConfigMapLedger creates a ledger backed by shards ConfigMaps, which are
+// created on first write and together hold at most maxEntries live tickets
+func NewConfigMapLedger(client kubernetes.Interface, namespace, name string, ttl time.Duration, shards, maxEntries int) *ConfigMapLedger {
+	if ttl <= 0 {
+		ttl = DefaultTicketTTL
+	}
+	if shards <= 0 {
+		shards = DefaultLedgerShards
+	}
+	if maxEntries <= 0 {
+		maxEntries = DefaultLedgerMaxEntries
+	}
+	return &ConfigMapLedger{
+		client:      client,
+		namespace:   namespace,
+		name:        name,
+		ttl:         ttl,
+		shards:      shards,
+		shardLimit:  (maxEntries + shards - 1) / shards,
+		legacyUntil: time.Now().Add(ttl),
+		now:         time.Now,
+	}
+}
+
+// shard returns the name of the ConfigMap holding ticketID
+func (l *ConfigMapLedger) shard(ticketID string) string {
+	h := fnv.New32a()
+	h.Write([]byte(ticketID))
+	return fmt.Sprintf("%s-%02d", l.name, h.Sum32()%uint32(l.shards))
+}
+
+func (l *ConfigMapLedger) Get(ctx context.Context, ticketID string) (*queues.AllocationResult, error) {
+	data, err := readConfigMap(ctx, l.client, l.namespace, l.shard(ticketID))
+	if err != nil {
+		return nil, err
+	}
+	raw, ok := data[ledgerKey(ticketID)]
+	if !ok {
+		return l.getLegacy(ctx, ticketID)
+	}
+	return l.decode(raw)
+}
+
+// getLegacy looks a ticket up in the unsharded ConfigMap, deleting it once every
+// entry it can hold has expired
+func (l *ConfigMapLedger) getLegacy(ctx context.Context, ticketID string) (*queues.AllocationResult, error) {
+	if l.legacyDone.Load() {
+		return nil, nil
+	}
+	if l.now().After(l.legacyUntil) {
+		if l.legacyDone.CompareAndSwap(false, true) {
+			err := l.client.CoreV1().ConfigMaps(l.namespace).Delete(ctx, l.name, metav1.DeleteOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				log.Warn().Err(err).Str("configMap", l.name).Msg("ledger: failed to delete unsharded ledger")
+			}
+		}
+		return nil, nil
+	}
+	data, err := readConfigMap(ctx, l.client, l.namespace, l.name)
+	if err != nil {
+		return nil, err
+	}
+	raw, ok := data[ledgerKey(ticketID)]
+	if !ok {
+		return nil, nil
+	}
+	return l.decode(raw)
+}
+
+// decode returns the result of a stored entry, or nil once it has expired
+func (l *ConfigMapLedger) decode(raw string) (*queues.AllocationResult, error) {
+	var e ledgerEntry
+	if err := json.Unmarshal([]byte(raw), &e); err != nil {
+		return nil, err
+	}
+	if l.now().After(e.ExpiresAt) {
+		return nil, nil
+	}
+	return e.Result, nil
+}
+
+func (l *ConfigMapLedger) Put(ctx context.Context, res *queues.AllocationResult) error {
+	b, err := json.Marshal(ledgerEntry{Result: res, ExpiresAt: l.now().Add(l.ttl)})
+	if err != nil {
+		return err
+	}
+	key := ledgerKey(res.TicketID)
+
+	shard := l.shard(res.TicketID)
+	return updateConfigMap(ctx, l.client, l.namespace, shard, func(data map[string]string) error {
+		if _, ok := data[key]; !ok && len(data) >= l.shardLimit {
+			// Only pay for decoding the shard when it is at the cap; expired
+			// entries make room before the write is rejected
+			l.prune(shard, data)
+			if len(data) >= l.shardLimit {
+				return fmt.Errorf("%w: %s holds %d entries", ErrLedgerFull, shard, len(data))
+			}
+		}
+		data[key] = string(b)
+		return nil
+	})
+}
+
+// prune drops expired or unreadable entries from a shard's data
+func (l *ConfigMapLedger) prune(shard string, data map[string]string) {
+	now := l.now()
+	for k, raw := range data {
+		var e ledgerEntry
+		if err := json.Unmarshal([]byte(raw), &e); err != nil {
+			log.Warn().Err(err).Str("configMap", shard).Str("key", k).Msg("ledger: dropping unreadable entry")
+			delete(data, k)
+			continue
+		}
+		if now.After(e.ExpiresAt) {
+			delete(data, k)
+		}
+	}
+}
+
+// ledgerKey encodes a ticket ID into a valid ConfigMap key ([-._a-zA-Z0-9]+)
+func ledgerKey(ticketID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(ticketID))
+}
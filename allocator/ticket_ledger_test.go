@@ -0,0 +1,171 @@
+package allocator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"agones-pubsub-allocator/queues"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMemoryLedger(t *testing.T) {
+	now := time.Now()
+	l := NewMemoryLedger(time.Minute)
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	got, err := l.Get(ctx, "t1")
+	if err != nil || got != nil {
+		t.Fatalf("Get() on empty ledger = %#v, %v; want nil, nil", got, err)
+	}
+
+	res := &queues.AllocationResult{TicketID: "t1", Status: queues.StatusSuccess}
+	if err := l.Put(ctx, res); err != nil {
+		t.Fatalf("Put() error: %v", err)
+	}
+	got, err = l.Get(ctx, "t1")
+	if err != nil || got != res {
+		t.Fatalf("Get() = %#v, %v; want %#v", got, err, res)
+	}
+
+	// Past the TTL the ticket is forgotten
+	now = now.Add(2 * time.Minute)
+	got, err = l.Get(ctx, "t1")
+	if err != nil || got != nil {
+		t.Errorf("Get() after expiry = %#v, %v; want nil, nil", got, err)
+	}
+}
+
+func TestConfigMapLedger(t *testing.T) {
+	now := time.Now()
+	client := fake.NewSimpleClientset()
+	l := NewConfigMapLedger(client, "ns", "tickets", time.Minute, 1, 2)
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	got, err := l.Get(ctx, "t1")
+	if err != nil || got != nil {
+		t.Fatalf("Get() with no ConfigMap = %#v, %v; want nil, nil", got, err)
+	}
+
+	tok := "tok"
+	if err := l.Put(ctx, &queues.AllocationResult{TicketID: "t1", Status: queues.StatusSuccess, Token: &tok}); err != nil {
+		t.Fatalf("Put() creating ConfigMap: %v", err)
+	}
+	if err := l.Put(ctx, &queues.AllocationResult{TicketID: "ticket/with:odd chars", Status: queues.StatusFailure}); err != nil {
+		t.Fatalf("Put() updating ConfigMap: %v", err)
+	}
+
+	got, err = l.Get(ctx, "t1")
+	if err != nil || got == nil || got.Status != queues.StatusSuccess || got.Token == nil || *got.Token != tok {
+		t.Fatalf("Get(t1) = %#v, %v", got, err)
+	}
+	got, err = l.Get(ctx, "ticket/with:odd chars")
+	if err != nil || got == nil || got.Status != queues.StatusFailure {
+		t.Fatalf("Get(odd key) = %#v, %v", got, err)
+	}
+
+	// A full shard rejects new tickets but still updates the ones it holds
+	if err := l.Put(ctx, &queues.AllocationResult{TicketID: "t3", Status: queues.StatusSuccess}); !errors.Is(err, ErrLedgerFull) {
+		t.Fatalf("Put() on a full ledger error = %v, want ErrLedgerFull", err)
+	}
+	if err := l.Put(ctx, &queues.AllocationResult{TicketID: "t1", Status: queues.StatusFailure}); err != nil {
+		t.Fatalf("Put() replacing an entry in a full ledger: %v", err)
+	}
+
+	// Expired entries are ignored on read and pruned when the shard is full
+	now = now.Add(2 * time.Minute)
+	if got, _ := l.Get(ctx, "t1"); got != nil {
+		t.Errorf("Get() after expiry = %#v, want nil", got)
+	}
+	if err := l.Put(ctx, &queues.AllocationResult{TicketID: "t2", Status: queues.StatusSuccess}); err != nil {
+		t.Fatalf("Put() after expiry: %v", err)
+	}
+	cm, err := client.CoreV1().ConfigMaps("ns").Get(ctx, "tickets-00", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get ConfigMap: %v", err)
+	}
+	if len(cm.Data) != 1 {
+		t.Errorf("ConfigMap data after prune has %d keys, want 1: %#v", len(cm.Data), cm.Data)
+	}
+}
+
+func TestConfigMapLedger_Shards(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	l := NewConfigMapLedger(client, "ns", "tickets", time.Minute, 4, 100)
+	ctx := context.Background()
+
+	for i := range 40 {
+		id := fmt.Sprintf("t%d", i)
+		if err := l.Put(ctx, &queues.AllocationResult{TicketID: id, Status: queues.StatusSuccess}); err != nil {
+			t.Fatalf("Put(%s) error: %v", id, err)
+		}
+		if got, err := l.Get(ctx, id); err != nil || got == nil {
+			t.Fatalf("Get(%s) = %#v, %v", id, got, err)
+		}
+	}
+	cms, err := client.CoreV1().ConfigMaps("ns").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list ConfigMaps: %v", err)
+	}
+	total := 0
+	for _, cm := range cms.Items {
+		if !strings.HasPrefix(cm.Name, "tickets-0") {
+			t.Errorf("unexpected ConfigMap %s", cm.Name)
+		}
+		total += len(cm.Data)
+	}
+	if len(cms.Items) < 2 || total != 40 {
+		t.Errorf("tickets spread over %d ConfigMaps holding %d entries, want several holding 40", len(cms.Items), total)
+	}
+}
+
+func TestConfigMapLedger_Legacy(t *testing.T) {
+	now := time.Now()
+	raw, _ := json.Marshal(ledgerEntry{Result: &queues.AllocationResult{TicketID: "old", Status: queues.StatusSuccess}, ExpiresAt: now.Add(time.Minute)})
+	client := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "tickets", Namespace: "ns"},
+		Data:       map[string]string{ledgerKey("old"): string(raw)},
+	})
+	l := NewConfigMapLedger(client, "ns", "tickets", time.Minute, 4, 100)
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// Tickets recorded before sharding are still replayed
+	if got, err := l.Get(ctx, "old"); err != nil || got == nil || got.Status != queues.StatusSuccess {
+		t.Fatalf("Get(old) = %#v, %v; want the unsharded entry", got, err)
+	}
+
+	// Once everything in it has expired the unsharded ConfigMap is deleted
+	now = now.Add(2 * time.Minute)
+	if got, err := l.Get(ctx, "old"); err != nil || got != nil {
+		t.Fatalf("Get(old) after expiry = %#v, %v; want nil, nil", got, err)
+	}
+	if _, err := client.CoreV1().ConfigMaps("ns").Get(ctx, "tickets", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("get unsharded ConfigMap error = %v, want NotFound", err)
+	}
+}
+
+func TestController_LedgerFull(t *testing.T) {
+	l := NewConfigMapLedger(fake.NewSimpleClientset(), "ns", "tickets", time.Minute, 1, 1)
+	ctx := context.Background()
+	if err := l.Put(ctx, &queues.AllocationResult{TicketID: "t0", Status: queues.StatusSuccess}); err != nil {
+		t.Fatalf("Put() error: %v", err)
+	}
+	ctrl := NewController(&mockPublisher{}, "ns", WithTicketLedger(l))
+
+	// A result that can't be recorded is nacked rather than losing dedup
+	err := ctrl.Handle(ctx, cancelRequest("t1"))
+	if !errors.Is(err, ErrLedgerFull) || queues.IsPermanent(err) {
+		t.Errorf("Handle() error = %v, want a retryable ErrLedgerFull", err)
+	}
+}
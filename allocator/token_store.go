@@ -0,0 +1,117 @@
+package allocator
+
+import (
+	"context"
+	"errors"
+
+	"agones-pubsub-allocator/metrics"
+
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	agonesclientset "agones.dev/agones/pkg/client/clientset/versioned"
+	"github.com/rs/zerolog/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// quilkinTokensAnnotation holds the comma-separated routing tokens Quilkin uses for a GameServer
+const quilkinTokensAnnotation = "quilkin.dev/tokens"
+
+// errGameServerNotAllocated is returned by mutations that require an Allocated GameServer
+var errGameServerNotAllocated = errors.New("gameserver is not allocated")
+
+// TokenStore applies Quilkin token changes to GameServer annotations.
+// Each change is a Get -> modify -> Update cycle guarded by the GameServer's
+// resourceVersion and retried on conflict, so a concurrent write by Agones or
+// another request doesn't fail the player's request.
+type TokenStore struct {
+	agones agonesclientset.Interface
+}
+
+// NewTokenStore creates a TokenStore using the given Agones client
+func NewTokenStore(agones agonesclientset.Interface) *TokenStore {
+	return &TokenStore{agones: agones}
+}
+
+// AddToken appends token to the GameServer's token annotation if not already present
+func (s *TokenStore) AddToken(ctx context.Context, namespace, name, token string) (*agonesv1.GameServer, error) {
+	return s.Mutate(ctx, namespace, name, "add", func(gs *agonesv1.GameServer) (bool, error) {
+		return addTokenAnnotation(gs, token), nil
+	})
+}
+
+// RemoveToken removes token from the GameServer's token annotation if present
+func (s *TokenStore) RemoveToken(ctx context.Context, namespace, name, token string) (*agonesv1.GameServer, error) {
+	return s.Mutate(ctx, namespace, name, "remove", func(gs *agonesv1.GameServer) (bool, error) {
+		return removeTokenAnnotation(gs, token), nil
+	})
+}
+
+// Mutate fetches the latest GameServer, applies fn and updates it, retrying the whole
+// cycle on conflict. fn reports whether it changed the object; unchanged objects are
+// not written. An error from fn aborts without retrying. op labels the retry metric.
+func (s *TokenStore) Mutate(ctx context.Context, namespace, name, op string, fn func(gs *agonesv1.GameServer) (bool, error)) (*agonesv1.GameServer, error) {
+	gameServers := s.agones.AgonesV1().GameServers(namespace)
+	attempts := 0
+	var result *agonesv1.GameServer
+
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		attempts++
+		gs, err := gameServers.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		changed, err := fn(gs)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			result = gs
+			return nil
+		}
+		updated, err := gameServers.Update(ctx, gs, metav1.UpdateOptions{})
+		if err != nil {
+			log.Debug().Err(err).Str("gameServerName", name).Str("op", op).Int("attempt", attempts).Msg("tokens: GameServer update failed")
+			return err
+		}
+		result = updated
+		return nil
+	})
+	metrics.TokenUpdateRetries.WithLabelValues(op).Observe(float64(attempts - 1))
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// addTokenAnnotation adds token to the GameServer's annotation, reporting whether it changed
+func addTokenAnnotation(gs *agonesv1.GameServer, token string) bool {
+	if gs.ObjectMeta.Annotations == nil {
+		gs.ObjectMeta.Annotations = make(map[string]string)
+	}
+	existing := gs.ObjectMeta.Annotations[quilkinTokensAnnotation]
+	updated := appendToken(existing, token)
+	if updated == existing {
+		return false
+	}
+	gs.ObjectMeta.Annotations[quilkinTokensAnnotation] = updated
+	return true
+}
+
+// removeTokenAnnotation removes token from the GameServer's annotation, reporting whether it changed
+func removeTokenAnnotation(gs *agonesv1.GameServer, token string) bool {
+	if !hasToken(gs, token) {
+		return false
+	}
+	gs.ObjectMeta.Annotations[quilkinTokensAnnotation] = removeToken(gs.ObjectMeta.Annotations[quilkinTokensAnnotation], token)
+	return true
+}
+
+// hasToken reports whether the GameServer's token annotation contains token
+func hasToken(gs *agonesv1.GameServer, token string) bool {
+	for _, t := range splitAndTrim(gs.ObjectMeta.Annotations[quilkinTokensAnnotation]) {
+		if t == token {
+			return true
+		}
+	}
+	return false
+}
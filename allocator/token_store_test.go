@@ -0,0 +1,123 @@
+package allocator
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"agones-pubsub-allocator/metrics"
+
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	agonesfake "agones.dev/agones/pkg/client/clientset/versioned/fake"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newTestGameServer(name, tokens string) *agonesv1.GameServer {
+	gs := &agonesv1.GameServer{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "ns",
+			Labels:    map[string]string{"agones.dev/fleet": "fleet"},
+		},
+		Status: agonesv1.GameServerStatus{State: agonesv1.GameServerStateAllocated, Address: "10.0.0.1"},
+	}
+	if tokens != "" {
+		gs.ObjectMeta.Annotations = map[string]string{quilkinTokensAnnotation: tokens}
+	}
+	return gs
+}
+
+// conflictOnUpdate makes the first n GameServer updates fail with a 409
+func conflictOnUpdate(client *agonesfake.Clientset, n int) {
+	client.PrependReactor("update", "gameservers", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if n <= 0 {
+			return false, nil, nil
+		}
+		n--
+		return true, nil, apierrors.NewConflict(schema.GroupResource{Group: "agones.dev", Resource: "gameservers"}, "gs-1", errors.New("object was modified"))
+	})
+}
+
+func TestTokenStore_AddRemove(t *testing.T) {
+	tests := []struct {
+		name       string
+		tokens     string
+		remove     bool
+		token      string
+		conflicts  int
+		wantTokens string
+		wantErr    bool
+	}{
+		{name: "add to empty", tokens: "", token: "t1", wantTokens: "t1"},
+		{name: "add appends", tokens: "t1", token: "t2", wantTokens: "t1,t2"},
+		{name: "add existing is a no-op", tokens: "t1,t2", token: "t1", wantTokens: "t1,t2"},
+		{name: "add retries on conflict", tokens: "t1", token: "t2", conflicts: 2, wantTokens: "t1,t2"},
+		{name: "remove", remove: true, tokens: "t1,t2", token: "t1", wantTokens: "t2"},
+		{name: "remove retries on conflict", remove: true, tokens: "t1,t2", token: "t2", conflicts: 1, wantTokens: "t1"},
+		{name: "gives up after persistent conflicts", tokens: "t1", token: "t2", conflicts: 100, wantTokens: "t1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := agonesfake.NewSimpleClientset(newTestGameServer("gs-1", tt.tokens))
+			conflictOnUpdate(client, tt.conflicts)
+			store := NewTokenStore(client)
+
+			var err error
+			if tt.remove {
+				_, err = store.RemoveToken(context.Background(), "ns", "gs-1", tt.token)
+			} else {
+				_, err = store.AddToken(context.Background(), "ns", "gs-1", tt.token)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			gs, err := client.AgonesV1().GameServers("ns").Get(context.Background(), "gs-1", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("get GameServer: %v", err)
+			}
+			if got := gs.ObjectMeta.Annotations[quilkinTokensAnnotation]; got != tt.wantTokens {
+				t.Errorf("tokens = %q, want %q", got, tt.wantTokens)
+			}
+		})
+	}
+}
+
+func TestTokenStore_Mutate_RecordsRetries(t *testing.T) {
+	client := agonesfake.NewSimpleClientset(newTestGameServer("gs-1", ""))
+	conflictOnUpdate(client, 2)
+	store := NewTokenStore(client)
+
+	before := testutil.CollectAndCount(metrics.TokenUpdateRetries)
+	if _, err := store.Mutate(context.Background(), "ns", "gs-1", "test", func(gs *agonesv1.GameServer) (bool, error) {
+		return addTokenAnnotation(gs, "t1"), nil
+	}); err != nil {
+		t.Fatalf("Mutate() error: %v", err)
+	}
+	if got := testutil.CollectAndCount(metrics.TokenUpdateRetries); got != before+1 {
+		t.Errorf("retry histogram series = %d, want %d", got, before+1)
+	}
+}
+
+func TestTokenStore_Mutate_AbortsOnCallbackError(t *testing.T) {
+	gs := newTestGameServer("gs-1", "t1")
+	gs.Status.State = agonesv1.GameServerStateShutdown
+	client := agonesfake.NewSimpleClientset(gs)
+	store := NewTokenStore(client)
+
+	_, err := store.Mutate(context.Background(), "ns", "gs-1", "add", func(gs *agonesv1.GameServer) (bool, error) {
+		if gs.Status.State != agonesv1.GameServerStateAllocated {
+			return false, errGameServerNotAllocated
+		}
+		return addTokenAnnotation(gs, "t2"), nil
+	})
+	if !errors.Is(err, errGameServerNotAllocated) {
+		t.Errorf("Mutate() error = %v, want errGameServerNotAllocated", err)
+	}
+}
@@ -0,0 +1,1011 @@
+// Synchronous allocation API. Messages mirror the JSON AllocationRequest and
+// AllocationResult in queues/types.go.
+//
+// Regenerate with:
+//   protoc -I . --go_out=. --go_opt=paths=source_relative \
+//     --go-grpc_out=. --go-grpc_opt=paths=source_relative api/allocator/v1/allocator.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.8
+// 	protoc        v5.28.3
+// source: api/allocator/v1/allocator.proto
+
+package allocatorv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type AllocationRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// "allocation-request" (default) or "allocation-cancel"
+	Type            string   `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	TicketId        string   `protobuf:"bytes,2,opt,name=ticket_id,json=ticketId,proto3" json:"ticket_id,omitempty"`
+	Fleet           string   `protobuf:"bytes,3,opt,name=fleet,proto3" json:"fleet,omitempty"`
+	PlayerId        string   `protobuf:"bytes,4,opt,name=player_id,json=playerId,proto3" json:"player_id,omitempty"`
+	JoinOnIds       []string `protobuf:"bytes,5,rep,name=join_on_ids,json=joinOnIds,proto3" json:"join_on_ids,omitempty"`
+	CanJoinNotFound bool     `protobuf:"varint,6,opt,name=can_join_not_found,json=canJoinNotFound,proto3" json:"can_join_not_found,omitempty"`
+	Priority        int32    `protobuf:"varint,7,opt,name=priority,proto3" json:"priority,omitempty"`
+	// Webhook for this ticket's later results; see ALLOCATOR_WEBHOOK_CALLBACKS
+	CallbackUrl string `protobuf:"bytes,8,opt,name=callback_url,json=callbackUrl,proto3" json:"callback_url,omitempty"`
+	// Narrow the fleet's GameServers; label keys must be allowed by the fleet's
+	// selectorLabels
+	MatchLabels      map[string]string      `protobuf:"bytes,9,rep,name=match_labels,json=matchLabels,proto3" json:"match_labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	MatchExpressions []*SelectorRequirement `protobuf:"bytes,10,rep,name=match_expressions,json=matchExpressions,proto3" json:"match_expressions,omitempty"`
+	// "Ready" (default) or "Allocated"
+	GameServerState string `protobuf:"bytes,11,opt,name=game_server_state,json=gameServerState,proto3" json:"game_server_state,omitempty"`
+	// Tried in order when the selector above matches nothing
+	FallbackSelectors []*GameServerSelector `protobuf:"bytes,12,rep,name=fallback_selectors,json=fallbackSelectors,proto3" json:"fallback_selectors,omitempty"`
+	// Labels and annotations stamped on the allocated GameServer
+	Metadata *GameServerMetadata `protobuf:"bytes,13,opt,name=metadata,proto3" json:"metadata,omitempty"`
+	// Replace the fleet's priorities and add to or override its counter and list
+	// actions
+	Priorities []*AllocationPriority     `protobuf:"bytes,14,rep,name=priorities,proto3" json:"priorities,omitempty"`
+	Counters   map[string]*CounterAction `protobuf:"bytes,15,rep,name=counters,proto3" json:"counters,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Lists      map[string]*ListAction    `protobuf:"bytes,16,rep,name=lists,proto3" json:"lists,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// "Packed" or "Distributed"; only honoured when the fleet allows overrides
+	Scheduling string `protobuf:"bytes,17,opt,name=scheduling,proto3" json:"scheduling,omitempty"`
+	// Regions tried first, in order, when the allocator has a cluster registry
+	PreferredRegions []string `protobuf:"bytes,18,rep,name=preferred_regions,json=preferredRegions,proto3" json:"preferred_regions,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *AllocationRequest) Reset() {
+	*x = AllocationRequest{}
+	mi := &file_api_allocator_v1_allocator_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AllocationRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AllocationRequest) ProtoMessage() {}
+
+func (x *AllocationRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_allocator_v1_allocator_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AllocationRequest.ProtoReflect.Descriptor instead.
+func (*AllocationRequest) Descriptor() ([]byte, []int) {
+	return file_api_allocator_v1_allocator_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *AllocationRequest) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *AllocationRequest) GetTicketId() string {
+	if x != nil {
+		return x.TicketId
+	}
+	return ""
+}
+
+func (x *AllocationRequest) GetFleet() string {
+	if x != nil {
+		return x.Fleet
+	}
+	return ""
+}
+
+func (x *AllocationRequest) GetPlayerId() string {
+	if x != nil {
+		return x.PlayerId
+	}
+	return ""
+}
+
+func (x *AllocationRequest) GetJoinOnIds() []string {
+	if x != nil {
+		return x.JoinOnIds
+	}
+	return nil
+}
+
+func (x *AllocationRequest) GetCanJoinNotFound() bool {
+	if x != nil {
+		return x.CanJoinNotFound
+	}
+	return false
+}
+
+func (x *AllocationRequest) GetPriority() int32 {
+	if x != nil {
+		return x.Priority
+	}
+	return 0
+}
+
+func (x *AllocationRequest) GetCallbackUrl() string {
+	if x != nil {
+		return x.CallbackUrl
+	}
+	return ""
+}
+
+func (x *AllocationRequest) GetMatchLabels() map[string]string {
+	if x != nil {
+		return x.MatchLabels
+	}
+	return nil
+}
+
+func (x *AllocationRequest) GetMatchExpressions() []*SelectorRequirement {
+	if x != nil {
+		return x.MatchExpressions
+	}
+	return nil
+}
+
+func (x *AllocationRequest) GetGameServerState() string {
+	if x != nil {
+		return x.GameServerState
+	}
+	return ""
+}
+
+func (x *AllocationRequest) GetFallbackSelectors() []*GameServerSelector {
+	if x != nil {
+		return x.FallbackSelectors
+	}
+	return nil
+}
+
+func (x *AllocationRequest) GetMetadata() *GameServerMetadata {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+func (x *AllocationRequest) GetPriorities() []*AllocationPriority {
+	if x != nil {
+		return x.Priorities
+	}
+	return nil
+}
+
+func (x *AllocationRequest) GetCounters() map[string]*CounterAction {
+	if x != nil {
+		return x.Counters
+	}
+	return nil
+}
+
+func (x *AllocationRequest) GetLists() map[string]*ListAction {
+	if x != nil {
+		return x.Lists
+	}
+	return nil
+}
+
+func (x *AllocationRequest) GetScheduling() string {
+	if x != nil {
+		return x.Scheduling
+	}
+	return ""
+}
+
+func (x *AllocationRequest) GetPreferredRegions() []string {
+	if x != nil {
+		return x.PreferredRegions
+	}
+	return nil
+}
+
+type AllocationPriority struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Counter or List
+	Type string `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Key  string `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	// Ascending (default) or Descending
+	Order         string `protobuf:"bytes,3,opt,name=order,proto3" json:"order,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AllocationPriority) Reset() {
+	*x = AllocationPriority{}
+	mi := &file_api_allocator_v1_allocator_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AllocationPriority) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AllocationPriority) ProtoMessage() {}
+
+func (x *AllocationPriority) ProtoReflect() protoreflect.Message {
+	mi := &file_api_allocator_v1_allocator_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AllocationPriority.ProtoReflect.Descriptor instead.
+func (*AllocationPriority) Descriptor() ([]byte, []int) {
+	return file_api_allocator_v1_allocator_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *AllocationPriority) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *AllocationPriority) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *AllocationPriority) GetOrder() string {
+	if x != nil {
+		return x.Order
+	}
+	return ""
+}
+
+type CounterAction struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Increment or Decrement by amount
+	Action        string `protobuf:"bytes,1,opt,name=action,proto3" json:"action,omitempty"`
+	Amount        int64  `protobuf:"varint,2,opt,name=amount,proto3" json:"amount,omitempty"`
+	Capacity      *int64 `protobuf:"varint,3,opt,name=capacity,proto3,oneof" json:"capacity,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CounterAction) Reset() {
+	*x = CounterAction{}
+	mi := &file_api_allocator_v1_allocator_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CounterAction) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CounterAction) ProtoMessage() {}
+
+func (x *CounterAction) ProtoReflect() protoreflect.Message {
+	mi := &file_api_allocator_v1_allocator_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CounterAction.ProtoReflect.Descriptor instead.
+func (*CounterAction) Descriptor() ([]byte, []int) {
+	return file_api_allocator_v1_allocator_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *CounterAction) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *CounterAction) GetAmount() int64 {
+	if x != nil {
+		return x.Amount
+	}
+	return 0
+}
+
+func (x *CounterAction) GetCapacity() int64 {
+	if x != nil && x.Capacity != nil {
+		return *x.Capacity
+	}
+	return 0
+}
+
+type ListAction struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AddValues     []string               `protobuf:"bytes,1,rep,name=add_values,json=addValues,proto3" json:"add_values,omitempty"`
+	DeleteValues  []string               `protobuf:"bytes,2,rep,name=delete_values,json=deleteValues,proto3" json:"delete_values,omitempty"`
+	Capacity      *int64                 `protobuf:"varint,3,opt,name=capacity,proto3,oneof" json:"capacity,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListAction) Reset() {
+	*x = ListAction{}
+	mi := &file_api_allocator_v1_allocator_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListAction) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListAction) ProtoMessage() {}
+
+func (x *ListAction) ProtoReflect() protoreflect.Message {
+	mi := &file_api_allocator_v1_allocator_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListAction.ProtoReflect.Descriptor instead.
+func (*ListAction) Descriptor() ([]byte, []int) {
+	return file_api_allocator_v1_allocator_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListAction) GetAddValues() []string {
+	if x != nil {
+		return x.AddValues
+	}
+	return nil
+}
+
+func (x *ListAction) GetDeleteValues() []string {
+	if x != nil {
+		return x.DeleteValues
+	}
+	return nil
+}
+
+func (x *ListAction) GetCapacity() int64 {
+	if x != nil && x.Capacity != nil {
+		return *x.Capacity
+	}
+	return 0
+}
+
+type GameServerMetadata struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Labels        map[string]string      `protobuf:"bytes,1,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Annotations   map[string]string      `protobuf:"bytes,2,rep,name=annotations,proto3" json:"annotations,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GameServerMetadata) Reset() {
+	*x = GameServerMetadata{}
+	mi := &file_api_allocator_v1_allocator_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GameServerMetadata) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GameServerMetadata) ProtoMessage() {}
+
+func (x *GameServerMetadata) ProtoReflect() protoreflect.Message {
+	mi := &file_api_allocator_v1_allocator_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GameServerMetadata.ProtoReflect.Descriptor instead.
+func (*GameServerMetadata) Descriptor() ([]byte, []int) {
+	return file_api_allocator_v1_allocator_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GameServerMetadata) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *GameServerMetadata) GetAnnotations() map[string]string {
+	if x != nil {
+		return x.Annotations
+	}
+	return nil
+}
+
+type SelectorRequirement struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Key   string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	// In, NotIn, Exists or DoesNotExist
+	Operator      string   `protobuf:"bytes,2,opt,name=operator,proto3" json:"operator,omitempty"`
+	Values        []string `protobuf:"bytes,3,rep,name=values,proto3" json:"values,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SelectorRequirement) Reset() {
+	*x = SelectorRequirement{}
+	mi := &file_api_allocator_v1_allocator_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SelectorRequirement) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SelectorRequirement) ProtoMessage() {}
+
+func (x *SelectorRequirement) ProtoReflect() protoreflect.Message {
+	mi := &file_api_allocator_v1_allocator_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SelectorRequirement.ProtoReflect.Descriptor instead.
+func (*SelectorRequirement) Descriptor() ([]byte, []int) {
+	return file_api_allocator_v1_allocator_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *SelectorRequirement) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *SelectorRequirement) GetOperator() string {
+	if x != nil {
+		return x.Operator
+	}
+	return ""
+}
+
+func (x *SelectorRequirement) GetValues() []string {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
+type GameServerSelector struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	MatchLabels      map[string]string      `protobuf:"bytes,1,rep,name=match_labels,json=matchLabels,proto3" json:"match_labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	MatchExpressions []*SelectorRequirement `protobuf:"bytes,2,rep,name=match_expressions,json=matchExpressions,proto3" json:"match_expressions,omitempty"`
+	GameServerState  string                 `protobuf:"bytes,3,opt,name=game_server_state,json=gameServerState,proto3" json:"game_server_state,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *GameServerSelector) Reset() {
+	*x = GameServerSelector{}
+	mi := &file_api_allocator_v1_allocator_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GameServerSelector) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GameServerSelector) ProtoMessage() {}
+
+func (x *GameServerSelector) ProtoReflect() protoreflect.Message {
+	mi := &file_api_allocator_v1_allocator_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GameServerSelector.ProtoReflect.Descriptor instead.
+func (*GameServerSelector) Descriptor() ([]byte, []int) {
+	return file_api_allocator_v1_allocator_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *GameServerSelector) GetMatchLabels() map[string]string {
+	if x != nil {
+		return x.MatchLabels
+	}
+	return nil
+}
+
+func (x *GameServerSelector) GetMatchExpressions() []*SelectorRequirement {
+	if x != nil {
+		return x.MatchExpressions
+	}
+	return nil
+}
+
+func (x *GameServerSelector) GetGameServerState() string {
+	if x != nil {
+		return x.GameServerState
+	}
+	return ""
+}
+
+type GameServerPort struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Port          int32                  `protobuf:"varint,2,opt,name=port,proto3" json:"port,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GameServerPort) Reset() {
+	*x = GameServerPort{}
+	mi := &file_api_allocator_v1_allocator_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GameServerPort) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GameServerPort) ProtoMessage() {}
+
+func (x *GameServerPort) ProtoReflect() protoreflect.Message {
+	mi := &file_api_allocator_v1_allocator_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GameServerPort.ProtoReflect.Descriptor instead.
+func (*GameServerPort) Descriptor() ([]byte, []int) {
+	return file_api_allocator_v1_allocator_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *GameServerPort) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *GameServerPort) GetPort() int32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+type GameServerInfo struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Name     string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Fleet    string                 `protobuf:"bytes,2,opt,name=fleet,proto3" json:"fleet,omitempty"`
+	Address  string                 `protobuf:"bytes,3,opt,name=address,proto3" json:"address,omitempty"`
+	Ports    []*GameServerPort      `protobuf:"bytes,4,rep,name=ports,proto3" json:"ports,omitempty"`
+	NodeName string                 `protobuf:"bytes,5,opt,name=node_name,json=nodeName,proto3" json:"node_name,omitempty"`
+	// Registry cluster the GameServer runs in
+	Cluster       string `protobuf:"bytes,6,opt,name=cluster,proto3" json:"cluster,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GameServerInfo) Reset() {
+	*x = GameServerInfo{}
+	mi := &file_api_allocator_v1_allocator_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GameServerInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GameServerInfo) ProtoMessage() {}
+
+func (x *GameServerInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_api_allocator_v1_allocator_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GameServerInfo.ProtoReflect.Descriptor instead.
+func (*GameServerInfo) Descriptor() ([]byte, []int) {
+	return file_api_allocator_v1_allocator_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *GameServerInfo) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *GameServerInfo) GetFleet() string {
+	if x != nil {
+		return x.Fleet
+	}
+	return ""
+}
+
+func (x *GameServerInfo) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *GameServerInfo) GetPorts() []*GameServerPort {
+	if x != nil {
+		return x.Ports
+	}
+	return nil
+}
+
+func (x *GameServerInfo) GetNodeName() string {
+	if x != nil {
+		return x.NodeName
+	}
+	return ""
+}
+
+func (x *GameServerInfo) GetCluster() string {
+	if x != nil {
+		return x.Cluster
+	}
+	return ""
+}
+
+type AllocationResult struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	EnvelopeVersion string                 `protobuf:"bytes,1,opt,name=envelope_version,json=envelopeVersion,proto3" json:"envelope_version,omitempty"`
+	Type            string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	TicketId        string                 `protobuf:"bytes,3,opt,name=ticket_id,json=ticketId,proto3" json:"ticket_id,omitempty"`
+	// Success, Failure, Queued or Cancelled
+	Status        string          `protobuf:"bytes,4,opt,name=status,proto3" json:"status,omitempty"`
+	Token         *string         `protobuf:"bytes,5,opt,name=token,proto3,oneof" json:"token,omitempty"`
+	ErrorMessage  *string         `protobuf:"bytes,6,opt,name=error_message,json=errorMessage,proto3,oneof" json:"error_message,omitempty"`
+	QueuePosition *int32          `protobuf:"varint,7,opt,name=queue_position,json=queuePosition,proto3,oneof" json:"queue_position,omitempty"`
+	QueueId       *string         `protobuf:"bytes,8,opt,name=queue_id,json=queueId,proto3,oneof" json:"queue_id,omitempty"`
+	GameServer    *GameServerInfo `protobuf:"bytes,9,opt,name=game_server,json=gameServer,proto3" json:"game_server,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AllocationResult) Reset() {
+	*x = AllocationResult{}
+	mi := &file_api_allocator_v1_allocator_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AllocationResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AllocationResult) ProtoMessage() {}
+
+func (x *AllocationResult) ProtoReflect() protoreflect.Message {
+	mi := &file_api_allocator_v1_allocator_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AllocationResult.ProtoReflect.Descriptor instead.
+func (*AllocationResult) Descriptor() ([]byte, []int) {
+	return file_api_allocator_v1_allocator_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *AllocationResult) GetEnvelopeVersion() string {
+	if x != nil {
+		return x.EnvelopeVersion
+	}
+	return ""
+}
+
+func (x *AllocationResult) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *AllocationResult) GetTicketId() string {
+	if x != nil {
+		return x.TicketId
+	}
+	return ""
+}
+
+func (x *AllocationResult) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *AllocationResult) GetToken() string {
+	if x != nil && x.Token != nil {
+		return *x.Token
+	}
+	return ""
+}
+
+func (x *AllocationResult) GetErrorMessage() string {
+	if x != nil && x.ErrorMessage != nil {
+		return *x.ErrorMessage
+	}
+	return ""
+}
+
+func (x *AllocationResult) GetQueuePosition() int32 {
+	if x != nil && x.QueuePosition != nil {
+		return *x.QueuePosition
+	}
+	return 0
+}
+
+func (x *AllocationResult) GetQueueId() string {
+	if x != nil && x.QueueId != nil {
+		return *x.QueueId
+	}
+	return ""
+}
+
+func (x *AllocationResult) GetGameServer() *GameServerInfo {
+	if x != nil {
+		return x.GameServer
+	}
+	return nil
+}
+
+var File_api_allocator_v1_allocator_proto protoreflect.FileDescriptor
+
+const file_api_allocator_v1_allocator_proto_rawDesc = "" +
+	"\n" +
+	" api/allocator/v1/allocator.proto\x12\fallocator.v1\"\xed\b\n" +
+	"\x11AllocationRequest\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x1b\n" +
+	"\tticket_id\x18\x02 \x01(\tR\bticketId\x12\x14\n" +
+	"\x05fleet\x18\x03 \x01(\tR\x05fleet\x12\x1b\n" +
+	"\tplayer_id\x18\x04 \x01(\tR\bplayerId\x12\x1e\n" +
+	"\vjoin_on_ids\x18\x05 \x03(\tR\tjoinOnIds\x12+\n" +
+	"\x12can_join_not_found\x18\x06 \x01(\bR\x0fcanJoinNotFound\x12\x1a\n" +
+	"\bpriority\x18\a \x01(\x05R\bpriority\x12!\n" +
+	"\fcallback_url\x18\b \x01(\tR\vcallbackUrl\x12S\n" +
+	"\fmatch_labels\x18\t \x03(\v20.allocator.v1.AllocationRequest.MatchLabelsEntryR\vmatchLabels\x12N\n" +
+	"\x11match_expressions\x18\n" +
+	" \x03(\v2!.allocator.v1.SelectorRequirementR\x10matchExpressions\x12*\n" +
+	"\x11game_server_state\x18\v \x01(\tR\x0fgameServerState\x12O\n" +
+	"\x12fallback_selectors\x18\f \x03(\v2 .allocator.v1.GameServerSelectorR\x11fallbackSelectors\x12<\n" +
+	"\bmetadata\x18\r \x01(\v2 .allocator.v1.GameServerMetadataR\bmetadata\x12@\n" +
+	"\n" +
+	"priorities\x18\x0e \x03(\v2 .allocator.v1.AllocationPriorityR\n" +
+	"priorities\x12I\n" +
+	"\bcounters\x18\x0f \x03(\v2-.allocator.v1.AllocationRequest.CountersEntryR\bcounters\x12@\n" +
+	"\x05lists\x18\x10 \x03(\v2*.allocator.v1.AllocationRequest.ListsEntryR\x05lists\x12\x1e\n" +
+	"\n" +
+	"scheduling\x18\x11 \x01(\tR\n" +
+	"scheduling\x12+\n" +
+	"\x11preferred_regions\x18\x12 \x03(\tR\x10preferredRegions\x1a>\n" +
+	"\x10MatchLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1aX\n" +
+	"\rCountersEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x121\n" +
+	"\x05value\x18\x02 \x01(\v2\x1b.allocator.v1.CounterActionR\x05value:\x028\x01\x1aR\n" +
+	"\n" +
+	"ListsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12.\n" +
+	"\x05value\x18\x02 \x01(\v2\x18.allocator.v1.ListActionR\x05value:\x028\x01\"P\n" +
+	"\x12AllocationPriority\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x10\n" +
+	"\x03key\x18\x02 \x01(\tR\x03key\x12\x14\n" +
+	"\x05order\x18\x03 \x01(\tR\x05order\"m\n" +
+	"\rCounterAction\x12\x16\n" +
+	"\x06action\x18\x01 \x01(\tR\x06action\x12\x16\n" +
+	"\x06amount\x18\x02 \x01(\x03R\x06amount\x12\x1f\n" +
+	"\bcapacity\x18\x03 \x01(\x03H\x00R\bcapacity\x88\x01\x01B\v\n" +
+	"\t_capacity\"~\n" +
+	"\n" +
+	"ListAction\x12\x1d\n" +
+	"\n" +
+	"add_values\x18\x01 \x03(\tR\taddValues\x12#\n" +
+	"\rdelete_values\x18\x02 \x03(\tR\fdeleteValues\x12\x1f\n" +
+	"\bcapacity\x18\x03 \x01(\x03H\x00R\bcapacity\x88\x01\x01B\v\n" +
+	"\t_capacity\"\xaa\x02\n" +
+	"\x12GameServerMetadata\x12D\n" +
+	"\x06labels\x18\x01 \x03(\v2,.allocator.v1.GameServerMetadata.LabelsEntryR\x06labels\x12S\n" +
+	"\vannotations\x18\x02 \x03(\v21.allocator.v1.GameServerMetadata.AnnotationsEntryR\vannotations\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a>\n" +
+	"\x10AnnotationsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"[\n" +
+	"\x13SelectorRequirement\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x1a\n" +
+	"\boperator\x18\x02 \x01(\tR\boperator\x12\x16\n" +
+	"\x06values\x18\x03 \x03(\tR\x06values\"\xa6\x02\n" +
+	"\x12GameServerSelector\x12T\n" +
+	"\fmatch_labels\x18\x01 \x03(\v21.allocator.v1.GameServerSelector.MatchLabelsEntryR\vmatchLabels\x12N\n" +
+	"\x11match_expressions\x18\x02 \x03(\v2!.allocator.v1.SelectorRequirementR\x10matchExpressions\x12*\n" +
+	"\x11game_server_state\x18\x03 \x01(\tR\x0fgameServerState\x1a>\n" +
+	"\x10MatchLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"8\n" +
+	"\x0eGameServerPort\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
+	"\x04port\x18\x02 \x01(\x05R\x04port\"\xbf\x01\n" +
+	"\x0eGameServerInfo\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x14\n" +
+	"\x05fleet\x18\x02 \x01(\tR\x05fleet\x12\x18\n" +
+	"\aaddress\x18\x03 \x01(\tR\aaddress\x122\n" +
+	"\x05ports\x18\x04 \x03(\v2\x1c.allocator.v1.GameServerPortR\x05ports\x12\x1b\n" +
+	"\tnode_name\x18\x05 \x01(\tR\bnodeName\x12\x18\n" +
+	"\acluster\x18\x06 \x01(\tR\acluster\"\x92\x03\n" +
+	"\x10AllocationResult\x12)\n" +
+	"\x10envelope_version\x18\x01 \x01(\tR\x0fenvelopeVersion\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12\x1b\n" +
+	"\tticket_id\x18\x03 \x01(\tR\bticketId\x12\x16\n" +
+	"\x06status\x18\x04 \x01(\tR\x06status\x12\x19\n" +
+	"\x05token\x18\x05 \x01(\tH\x00R\x05token\x88\x01\x01\x12(\n" +
+	"\rerror_message\x18\x06 \x01(\tH\x01R\ferrorMessage\x88\x01\x01\x12*\n" +
+	"\x0equeue_position\x18\a \x01(\x05H\x02R\rqueuePosition\x88\x01\x01\x12\x1e\n" +
+	"\bqueue_id\x18\b \x01(\tH\x03R\aqueueId\x88\x01\x01\x12=\n" +
+	"\vgame_server\x18\t \x01(\v2\x1c.allocator.v1.GameServerInfoR\n" +
+	"gameServerB\b\n" +
+	"\x06_tokenB\x10\n" +
+	"\x0e_error_messageB\x11\n" +
+	"\x0f_queue_positionB\v\n" +
+	"\t_queue_id2\xad\x01\n" +
+	"\tAllocator\x12K\n" +
+	"\bAllocate\x12\x1f.allocator.v1.AllocationRequest\x1a\x1e.allocator.v1.AllocationResult\x12S\n" +
+	"\x0eStreamAllocate\x12\x1f.allocator.v1.AllocationRequest\x1a\x1e.allocator.v1.AllocationResult0\x01B6Z4agones-pubsub-allocator/api/allocator/v1;allocatorv1b\x06proto3"
+
+var (
+	file_api_allocator_v1_allocator_proto_rawDescOnce sync.Once
+	file_api_allocator_v1_allocator_proto_rawDescData []byte
+)
+
+func file_api_allocator_v1_allocator_proto_rawDescGZIP() []byte {
+	file_api_allocator_v1_allocator_proto_rawDescOnce.Do(func() {
+		file_api_allocator_v1_allocator_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_api_allocator_v1_allocator_proto_rawDesc), len(file_api_allocator_v1_allocator_proto_rawDesc)))
+	})
+	return file_api_allocator_v1_allocator_proto_rawDescData
+}
+
+var file_api_allocator_v1_allocator_proto_msgTypes = make([]protoimpl.MessageInfo, 16)
+var file_api_allocator_v1_allocator_proto_goTypes = []any{
+	(*AllocationRequest)(nil),   // 0: allocator.v1.AllocationRequest
+	(*AllocationPriority)(nil),  // 1: allocator.v1.AllocationPriority
+	(*CounterAction)(nil),       // 2: allocator.v1.CounterAction
+	(*ListAction)(nil),          // 3: allocator.v1.ListAction
+	(*GameServerMetadata)(nil),  // 4: allocator.v1.GameServerMetadata
+	(*SelectorRequirement)(nil), // 5: allocator.v1.SelectorRequirement
+	(*GameServerSelector)(nil),  // 6: allocator.v1.GameServerSelector
+	(*GameServerPort)(nil),      // 7: allocator.v1.GameServerPort
+	(*GameServerInfo)(nil),      // 8: allocator.v1.GameServerInfo
+	(*AllocationResult)(nil),    // 9: allocator.v1.AllocationResult
+	nil,                         // 10: allocator.v1.AllocationRequest.MatchLabelsEntry
+	nil,                         // 11: allocator.v1.AllocationRequest.CountersEntry
+	nil,                         // 12: allocator.v1.AllocationRequest.ListsEntry
+	nil,                         // 13: allocator.v1.GameServerMetadata.LabelsEntry
+	nil,                         // 14: allocator.v1.GameServerMetadata.AnnotationsEntry
+	nil,                         // 15: allocator.v1.GameServerSelector.MatchLabelsEntry
+}
+var file_api_allocator_v1_allocator_proto_depIdxs = []int32{
+	10, // 0: allocator.v1.AllocationRequest.match_labels:type_name -> allocator.v1.AllocationRequest.MatchLabelsEntry
+	5,  // 1: allocator.v1.AllocationRequest.match_expressions:type_name -> allocator.v1.SelectorRequirement
+	6,  // 2: allocator.v1.AllocationRequest.fallback_selectors:type_name -> allocator.v1.GameServerSelector
+	4,  // 3: allocator.v1.AllocationRequest.metadata:type_name -> allocator.v1.GameServerMetadata
+	1,  // 4: allocator.v1.AllocationRequest.priorities:type_name -> allocator.v1.AllocationPriority
+	11, // 5: allocator.v1.AllocationRequest.counters:type_name -> allocator.v1.AllocationRequest.CountersEntry
+	12, // 6: allocator.v1.AllocationRequest.lists:type_name -> allocator.v1.AllocationRequest.ListsEntry
+	13, // 7: allocator.v1.GameServerMetadata.labels:type_name -> allocator.v1.GameServerMetadata.LabelsEntry
+	14, // 8: allocator.v1.GameServerMetadata.annotations:type_name -> allocator.v1.GameServerMetadata.AnnotationsEntry
+	15, // 9: allocator.v1.GameServerSelector.match_labels:type_name -> allocator.v1.GameServerSelector.MatchLabelsEntry
+	5,  // 10: allocator.v1.GameServerSelector.match_expressions:type_name -> allocator.v1.SelectorRequirement
+	7,  // 11: allocator.v1.GameServerInfo.ports:type_name -> allocator.v1.GameServerPort
+	8,  // 12: allocator.v1.AllocationResult.game_server:type_name -> allocator.v1.GameServerInfo
+	2,  // 13: allocator.v1.AllocationRequest.CountersEntry.value:type_name -> allocator.v1.CounterAction
+	3,  // 14: allocator.v1.AllocationRequest.ListsEntry.value:type_name -> allocator.v1.ListAction
+	0,  // 15: allocator.v1.Allocator.Allocate:input_type -> allocator.v1.AllocationRequest
+	0,  // 16: allocator.v1.Allocator.StreamAllocate:input_type -> allocator.v1.AllocationRequest
+	9,  // 17: allocator.v1.Allocator.Allocate:output_type -> allocator.v1.AllocationResult
+	9,  // 18: allocator.v1.Allocator.StreamAllocate:output_type -> allocator.v1.AllocationResult
+	17, // [17:19] is the sub-list for method output_type
+	15, // [15:17] is the sub-list for method input_type
+	15, // [15:15] is the sub-list for extension type_name
+	15, // [15:15] is the sub-list for extension extendee
+	0,  // [0:15] is the sub-list for field type_name
+}
+
+func init() { file_api_allocator_v1_allocator_proto_init() }
+func file_api_allocator_v1_allocator_proto_init() {
+	if File_api_allocator_v1_allocator_proto != nil {
+		return
+	}
+	file_api_allocator_v1_allocator_proto_msgTypes[2].OneofWrappers = []any{}
+	file_api_allocator_v1_allocator_proto_msgTypes[3].OneofWrappers = []any{}
+	file_api_allocator_v1_allocator_proto_msgTypes[9].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_api_allocator_v1_allocator_proto_rawDesc), len(file_api_allocator_v1_allocator_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   16,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_api_allocator_v1_allocator_proto_goTypes,
+		DependencyIndexes: file_api_allocator_v1_allocator_proto_depIdxs,
+		MessageInfos:      file_api_allocator_v1_allocator_proto_msgTypes,
+	}.Build()
+	File_api_allocator_v1_allocator_proto = out.File
+	file_api_allocator_v1_allocator_proto_goTypes = nil
+	file_api_allocator_v1_allocator_proto_depIdxs = nil
+}
@@ -0,0 +1,178 @@
+// Synchronous allocation API. Messages mirror the JSON AllocationRequest and
+// AllocationResult in queues/types.go.
+//
+// Regenerate with:
+//   protoc -I . --go_out=. --go_opt=paths=source_relative \
+//     --go-grpc_out=. --go-grpc_opt=paths=source_relative api/allocator/v1/allocator.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.28.3
+// source: api/allocator/v1/allocator.proto
+
+package allocatorv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Allocator_Allocate_FullMethodName       = "/allocator.v1.Allocator/Allocate"
+	Allocator_StreamAllocate_FullMethodName = "/allocator.v1.Allocator/StreamAllocate"
+)
+
+// AllocatorClient is the client API for Allocator service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AllocatorClient interface {
+	// Allocate handles one request and returns its first result. A Queued result
+	// means the player is waiting for a slot; use StreamAllocate to follow it.
+	Allocate(ctx context.Context, in *AllocationRequest, opts ...grpc.CallOption) (*AllocationResult, error)
+	// StreamAllocate handles one request and streams its results until a final
+	// (non-Queued) one.
+	StreamAllocate(ctx context.Context, in *AllocationRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[AllocationResult], error)
+}
+
+type allocatorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAllocatorClient(cc grpc.ClientConnInterface) AllocatorClient {
+	return &allocatorClient{cc}
+}
+
+func (c *allocatorClient) Allocate(ctx context.Context, in *AllocationRequest, opts ...grpc.CallOption) (*AllocationResult, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AllocationResult)
+	err := c.cc.Invoke(ctx, Allocator_Allocate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *allocatorClient) StreamAllocate(ctx context.Context, in *AllocationRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[AllocationResult], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Allocator_ServiceDesc.Streams[0], Allocator_StreamAllocate_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[AllocationRequest, AllocationResult]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Allocator_StreamAllocateClient = grpc.ServerStreamingClient[AllocationResult]
+
+// AllocatorServer is the server API for Allocator service.
+// All implementations must embed UnimplementedAllocatorServer
+// for forward compatibility.
+type AllocatorServer interface {
+	// Allocate handles one request and returns its first result. A Queued result
+	// means the player is waiting for a slot; use StreamAllocate to follow it.
+	Allocate(context.Context, *AllocationRequest) (*AllocationResult, error)
+	// StreamAllocate handles one request and streams its results until a final
+	// (non-Queued) one.
+	StreamAllocate(*AllocationRequest, grpc.ServerStreamingServer[AllocationResult]) error
+	mustEmbedUnimplementedAllocatorServer()
+}
+
+// UnimplementedAllocatorServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedAllocatorServer struct{}
+
+func (UnimplementedAllocatorServer) Allocate(context.Context, *AllocationRequest) (*AllocationResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Allocate not implemented")
+}
+func (UnimplementedAllocatorServer) StreamAllocate(*AllocationRequest, grpc.ServerStreamingServer[AllocationResult]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamAllocate not implemented")
+}
+func (UnimplementedAllocatorServer) mustEmbedUnimplementedAllocatorServer() {}
+func (UnimplementedAllocatorServer) testEmbeddedByValue()                   {}
+
+// UnsafeAllocatorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AllocatorServer will
+// result in compilation errors.
+type UnsafeAllocatorServer interface {
+	mustEmbedUnimplementedAllocatorServer()
+}
+
+func RegisterAllocatorServer(s grpc.ServiceRegistrar, srv AllocatorServer) {
+	// If the following call pancis, it indicates UnimplementedAllocatorServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Allocator_ServiceDesc, srv)
+}
+
+func _Allocator_Allocate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AllocationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AllocatorServer).Allocate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Allocator_Allocate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AllocatorServer).Allocate(ctx, req.(*AllocationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Allocator_StreamAllocate_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(AllocationRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AllocatorServer).StreamAllocate(m, &grpc.GenericServerStream[AllocationRequest, AllocationResult]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Allocator_StreamAllocateServer = grpc.ServerStreamingServer[AllocationResult]
+
+// Allocator_ServiceDesc is the grpc.ServiceDesc for Allocator service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Allocator_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "allocator.v1.Allocator",
+	HandlerType: (*AllocatorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Allocate",
+			Handler:    _Allocator_Allocate_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamAllocate",
+			Handler:       _Allocator_StreamAllocate_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "api/allocator/v1/allocator.proto",
+}
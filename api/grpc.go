@@ -0,0 +1,237 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	allocatorv1 "agones-pubsub-allocator/api/allocator/v1"
+	"agones-pubsub-allocator/queues"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// resultWait bounds the wait for a request's first result once it has been handled.
+// Handle publishes before returning on almost every path; the exceptions (a cancel
+// for an in-flight or finished ticket) publish late or not at all.
+const resultWait = 5 * time.Second
+
+var errNoResult = errors.New("no result was published for the ticket")
+
+// GRPCServer implements allocatorv1.AllocatorServer on top of Controller.Handle
+type GRPCServer struct {
+	allocatorv1.UnimplementedAllocatorServer
+	router *ResultRouter
+	handle HandleFunc
+}
+
+// NewGRPCServer serves requests with handle, collecting results from router
+func NewGRPCServer(router *ResultRouter, handle HandleFunc) *GRPCServer {
+	return &GRPCServer{router: router, handle: handle}
+}
+
+// Register adds the Allocator service to a gRPC server
+func (s *GRPCServer) Register(gs *grpc.Server) {
+	allocatorv1.RegisterAllocatorServer(gs, s)
+}
+
+func (s *GRPCServer) Allocate(ctx context.Context, in *allocatorv1.AllocationRequest) (*allocatorv1.AllocationResult, error) {
+	results, stop, err := s.start(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	defer stop()
+	res, err := firstResult(ctx, results)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProtoResult(res), nil
+}
+
+func (s *GRPCServer) StreamAllocate(in *allocatorv1.AllocationRequest, stream grpc.ServerStreamingServer[allocatorv1.AllocationResult]) error {
+	ctx := stream.Context()
+	results, stop, err := s.start(ctx, in)
+	if err != nil {
+		return err
+	}
+	defer stop()
+	res, err := firstResult(ctx, results)
+	for err == nil {
+		if err := stream.Send(toProtoResult(res)); err != nil {
+			return err
+		}
+		if isFinal(res) {
+			return nil
+		}
+		// Queued players wait as long as the queue allows
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case res = <-results:
+		}
+	}
+	return grpcError(err)
+}
+
+// start validates the request and hands it to the controller
+func (s *GRPCServer) start(ctx context.Context, in *allocatorv1.AllocationRequest) (<-chan *queues.AllocationResult, func(), error) {
+	req := fromProtoRequest(in)
+	if err := req.Validate(); err != nil {
+		return nil, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	log.Info().Str("type", req.Type).Str("ticketId", req.TicketID).Str("fleet", req.Fleet).Str("playerId", req.PlayerID).Msg("api: handling grpc allocation request")
+	results, stop, err := submit(ctx, s.router, s.handle, req)
+	if err != nil {
+		log.Error().Err(err).Str("ticketId", req.TicketID).Msg("api: grpc allocation request failed")
+		// Handle errors are the ones a queue transport would nack and redeliver
+		return nil, nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return results, stop, nil
+}
+
+// firstResult waits up to resultWait for the ticket's first result
+func firstResult(ctx context.Context, results <-chan *queues.AllocationResult) (*queues.AllocationResult, error) {
+	timer := time.NewTimer(resultWait)
+	defer timer.Stop()
+	select {
+	case res := <-results:
+		return res, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		return nil, errNoResult
+	}
+}
+
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, errNoResult):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func fromProtoRequest(in *allocatorv1.AllocationRequest) *queues.AllocationRequest {
+	return &queues.AllocationRequest{
+		Type:              in.GetType(),
+		TicketID:          in.GetTicketId(),
+		Fleet:             in.GetFleet(),
+		PlayerID:          in.GetPlayerId(),
+		JoinOnIDs:         in.GetJoinOnIds(),
+		CanJoinNotFound:   in.GetCanJoinNotFound(),
+		Priority:          int(in.GetPriority()),
+		CallbackURL:       in.GetCallbackUrl(),
+		MatchLabels:       in.GetMatchLabels(),
+		MatchExpressions:  fromProtoRequirements(in.GetMatchExpressions()),
+		GameServerState:   in.GetGameServerState(),
+		FallbackSelectors: fromProtoSelectors(in.GetFallbackSelectors()),
+		Metadata:          fromProtoMetadata(in.GetMetadata()),
+		Priorities:        fromProtoPriorities(in.GetPriorities()),
+		Counters:          fromProtoCounters(in.GetCounters()),
+		Lists:             fromProtoLists(in.GetLists()),
+		Scheduling:        in.GetScheduling(),
+		PreferredRegions:  in.GetPreferredRegions(),
+	}
+}
+
+func fromProtoPriorities(in []*allocatorv1.AllocationPriority) []queues.AllocationPriority {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make([]queues.AllocationPriority, 0, len(in))
+	for _, p := range in {
+		out = append(out, queues.AllocationPriority{Type: p.GetType(), Key: p.GetKey(), Order: p.GetOrder()})
+	}
+	return out
+}
+
+func fromProtoCounters(in map[string]*allocatorv1.CounterAction) map[string]queues.CounterAction {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make(map[string]queues.CounterAction, len(in))
+	for name, a := range in {
+		out[name] = queues.CounterAction{Action: a.GetAction(), Amount: a.GetAmount(), Capacity: a.Capacity}
+	}
+	return out
+}
+
+func fromProtoLists(in map[string]*allocatorv1.ListAction) map[string]queues.ListAction {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make(map[string]queues.ListAction, len(in))
+	for name, a := range in {
+		out[name] = queues.ListAction{AddValues: a.GetAddValues(), DeleteValues: a.GetDeleteValues(), Capacity: a.Capacity}
+	}
+	return out
+}
+
+func fromProtoMetadata(in *allocatorv1.GameServerMetadata) *queues.GameServerMetadata {
+	if in == nil {
+		return nil
+	}
+	return &queues.GameServerMetadata{Labels: in.GetLabels(), Annotations: in.GetAnnotations()}
+}
+
+func fromProtoSelectors(in []*allocatorv1.GameServerSelector) []queues.GameServerSelector {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make([]queues.GameServerSelector, 0, len(in))
+	for _, s := range in {
+		out = append(out, queues.GameServerSelector{
+			MatchLabels:      s.GetMatchLabels(),
+			MatchExpressions: fromProtoRequirements(s.GetMatchExpressions()),
+			GameServerState:  s.GetGameServerState(),
+		})
+	}
+	return out
+}
+
+func fromProtoRequirements(in []*allocatorv1.SelectorRequirement) []queues.SelectorRequirement {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make([]queues.SelectorRequirement, 0, len(in))
+	for _, r := range in {
+		out = append(out, queues.SelectorRequirement{Key: r.GetKey(), Operator: r.GetOperator(), Values: r.GetValues()})
+	}
+	return out
+}
+
+func toProtoResult(res *queues.AllocationResult) *allocatorv1.AllocationResult {
+	out := &allocatorv1.AllocationResult{
+		EnvelopeVersion: res.EnvelopeVersion,
+		Type:            res.Type,
+		TicketId:        res.TicketID,
+		Status:          string(res.Status),
+		Token:           res.Token,
+		ErrorMessage:    res.ErrorMessage,
+		QueueId:         res.QueueID,
+	}
+	if res.QueuePosition != nil {
+		pos := int32(*res.QueuePosition)
+		out.QueuePosition = &pos
+	}
+	if gs := res.GameServer; gs != nil {
+		out.GameServer = &allocatorv1.GameServerInfo{
+			Name:     gs.Name,
+			Fleet:    gs.Fleet,
+			Address:  gs.Address,
+			NodeName: gs.NodeName,
+			Cluster:  gs.Cluster,
+		}
+		for _, p := range gs.Ports {
+			out.GameServer.Ports = append(out.GameServer.Ports, &allocatorv1.GameServerPort{Name: p.Name, Port: p.Port})
+		}
+	}
+	return out
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+
+	allocatorv1 "agones-pubsub-allocator/api/allocator/v1"
+	"agones-pubsub-allocator/queues"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestClient serves handle over an in-memory listener
+func newTestClient(t *testing.T, handle func(router *ResultRouter) HandleFunc) allocatorv1.AllocatorClient {
+	t.Helper()
+	router := NewResultRouter(&recordingPublisher{})
+	lis := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	NewGRPCServer(router, handle(router)).Register(gs)
+	go func() { _ = gs.Serve(lis) }()
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("grpc.NewClient() error: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return allocatorv1.NewAllocatorClient(conn)
+}
+
+// publishing returns a handle that publishes the given statuses for the ticket
+func publishing(statuses ...queues.AllocationStatus) func(*ResultRouter) HandleFunc {
+	return func(router *ResultRouter) HandleFunc {
+		return func(ctx context.Context, req *queues.AllocationRequest) error {
+			for i, s := range statuses {
+				res := result(req.TicketID, s)
+				if s == queues.StatusQueued {
+					pos := len(statuses) - i - 1
+					res.QueuePosition = &pos
+				}
+				if s == queues.StatusSuccess {
+					token := "tok"
+					res.Token = &token
+					res.GameServer = &queues.GameServerInfo{Name: "gs-1", Address: "10.0.0.1", Ports: []queues.GameServerPort{{Name: "default", Port: 7777}}, Cluster: "eu-1"}
+				}
+				if err := router.PublishResult(ctx, res); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+}
+
+func TestGRPCServer_Allocate(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      *allocatorv1.AllocationRequest
+		handle   func(*ResultRouter) HandleFunc
+		wantCode codes.Code
+		want     queues.AllocationStatus
+	}{
+		{
+			name:   "success",
+			req:    &allocatorv1.AllocationRequest{TicketId: "t1", Fleet: "f", PlayerId: "p1"},
+			handle: publishing(queues.StatusSuccess),
+			want:   queues.StatusSuccess,
+		},
+		{
+			name:   "queued returns the first result",
+			req:    &allocatorv1.AllocationRequest{TicketId: "t1", Fleet: "f"},
+			handle: publishing(queues.StatusQueued, queues.StatusSuccess),
+			want:   queues.StatusQueued,
+		},
+		{
+			name:     "invalid request",
+			req:      &allocatorv1.AllocationRequest{TicketId: "t1"},
+			handle:   publishing(queues.StatusSuccess),
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "handle error",
+			req:  &allocatorv1.AllocationRequest{TicketId: "t1", Fleet: "f"},
+			handle: func(*ResultRouter) HandleFunc {
+				return func(context.Context, *queues.AllocationRequest) error { return errors.New("agones unavailable") }
+			},
+			wantCode: codes.Unavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, tt.handle)
+			res, err := client.Allocate(context.Background(), tt.req)
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("Allocate() code = %v, want %v (err %v)", got, tt.wantCode, err)
+			}
+			if err != nil {
+				return
+			}
+			if res.GetStatus() != string(tt.want) || res.GetTicketId() != "t1" {
+				t.Errorf("Allocate() = %v, want status %s", res, tt.want)
+			}
+			if tt.want == queues.StatusSuccess {
+				if res.GetToken() != "tok" || res.GetGameServer().GetPorts()[0].GetPort() != 7777 || res.GetGameServer().GetCluster() != "eu-1" {
+					t.Errorf("Allocate() success fields = %v", res)
+				}
+			}
+		})
+	}
+}
+
+func TestGRPCServer_StreamAllocate(t *testing.T) {
+	client := newTestClient(t, publishing(queues.StatusQueued, queues.StatusQueued, queues.StatusSuccess))
+	stream, err := client.StreamAllocate(context.Background(), &allocatorv1.AllocationRequest{TicketId: "t1", Fleet: "f"})
+	if err != nil {
+		t.Fatalf("StreamAllocate() error: %v", err)
+	}
+
+	var got []string
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv() error: %v", err)
+		}
+		got = append(got, res.GetStatus())
+	}
+	want := []string{"Queued", "Queued", "Success"}
+	if len(got) != len(want) {
+		t.Fatalf("statuses = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("statuses = %v, want %v", got, want)
+		}
+	}
+}
+
+func Test_fromProtoRequest_SelectorsAndMetadata(t *testing.T) {
+	got := fromProtoRequest(&allocatorv1.AllocationRequest{
+		TicketId:         "t1",
+		Fleet:            "f",
+		MatchLabels:      map[string]string{"map": "dust"},
+		MatchExpressions: []*allocatorv1.SelectorRequirement{{Key: "version", Operator: "In", Values: []string{"1.2"}}},
+		GameServerState:  "Allocated",
+		FallbackSelectors: []*allocatorv1.GameServerSelector{
+			{MatchLabels: map[string]string{"map": "dust"}, GameServerState: "Ready"},
+			{},
+		},
+		Metadata: &allocatorv1.GameServerMetadata{Labels: map[string]string{"match": "m-1"}, Annotations: map[string]string{"party": "p1"}},
+	})
+	want := &queues.AllocationRequest{
+		TicketID:         "t1",
+		Fleet:            "f",
+		MatchLabels:      map[string]string{"map": "dust"},
+		MatchExpressions: []queues.SelectorRequirement{{Key: "version", Operator: "In", Values: []string{"1.2"}}},
+		GameServerState:  "Allocated",
+		FallbackSelectors: []queues.GameServerSelector{
+			{MatchLabels: map[string]string{"map": "dust"}, GameServerState: "Ready"},
+			{},
+		},
+		Metadata: &queues.GameServerMetadata{Labels: map[string]string{"match": "m-1"}, Annotations: map[string]string{"party": "p1"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fromProtoRequest()\n got=%#v\nwant=%#v", got, want)
+	}
+}
+
+func Test_fromProtoRequest_AllocationOptions(t *testing.T) {
+	capacity := int64(8)
+	got := fromProtoRequest(&allocatorv1.AllocationRequest{
+		TicketId:         "t1",
+		Fleet:            "f",
+		Priorities:       []*allocatorv1.AllocationPriority{{Type: "List", Key: "players", Order: "Descending"}},
+		Counters:         map[string]*allocatorv1.CounterAction{"rooms": {Action: "Increment", Amount: 1}},
+		Lists:            map[string]*allocatorv1.ListAction{"party": {AddValues: []string{"p1"}, Capacity: &capacity}},
+		Scheduling:       "Distributed",
+		PreferredRegions: []string{"eu"},
+	})
+	want := &queues.AllocationRequest{
+		TicketID:         "t1",
+		Fleet:            "f",
+		Priorities:       []queues.AllocationPriority{{Type: "List", Key: "players", Order: "Descending"}},
+		Counters:         map[string]queues.CounterAction{"rooms": {Action: "Increment", Amount: 1}},
+		Lists:            map[string]queues.ListAction{"party": {AddValues: []string{"p1"}, Capacity: &capacity}},
+		Scheduling:       "Distributed",
+		PreferredRegions: []string{"eu"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fromProtoRequest()\n got=%#v\nwant=%#v", got, want)
+	}
+}
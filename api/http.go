@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"agones-pubsub-allocator/queues"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// maxRequestBody bounds POST /v1/allocate bodies
+	maxRequestBody = 1 << 20
+	// maxLongPoll caps the wait query parameter
+	maxLongPoll = 60 * time.Second
+)
+
+// TicketLookup returns the last recorded result for a ticket, or nil if unknown; TicketLedger.Get
+type TicketLookup func(ctx context.Context, ticketID string) (*queues.AllocationResult, error)
+
+// HTTPHandler serves the JSON allocation API:
+//
+//	POST /v1/allocate       the Pub/Sub AllocationRequest body; ?wait=30s long-polls past Queued
+//	GET  /v1/tickets/{id}   the ticket's latest result
+//
+// A Queued answer is 202 Accepted with Location pointing at the ticket resource.
+type HTTPHandler struct {
+	router *ResultRouter
+	handle HandleFunc
+	lookup TicketLookup
+	token  string
+}
+
+// NewHTTPHandler serves requests with handle and ticket status from lookup. A
+// non-empty token is required as a bearer token on every call.
+func NewHTTPHandler(router *ResultRouter, handle HandleFunc, lookup TicketLookup, token string) *HTTPHandler {
+	return &HTTPHandler{router: router, handle: handle, lookup: lookup, token: token}
+}
+
+// Register adds the API routes to mux
+func (h *HTTPHandler) Register(mux *http.ServeMux) {
+	mux.Handle("POST /v1/allocate", h.authorize(http.HandlerFunc(h.allocate)))
+	mux.Handle("GET /v1/tickets/{id}", h.authorize(http.HandlerFunc(h.ticket)))
+}
+
+func (h *HTTPHandler) authorize(next http.Handler) http.Handler {
+	if h.token == "" {
+		return next
+	}
+	want := []byte("Bearer " + h.token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="allocator"`)
+			writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *HTTPHandler) allocate(w http.ResponseWriter, r *http.Request) {
+	wait, err := longPoll(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var body json.RawMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	req, err := queues.DecodeRequest(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx := r.Context()
+	log.Info().Str("type", req.Type).Str("ticketId", req.TicketID).Str("fleet", req.Fleet).Str("playerId", req.PlayerID).Msg("api: handling http allocation request")
+	results, stop, err := submit(ctx, h.router, h.handle, req)
+	if err != nil {
+		log.Error().Err(err).Str("ticketId", req.TicketID).Msg("api: http allocation request failed")
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	defer stop()
+
+	res, err := firstResult(ctx, results)
+	if errors.Is(err, errNoResult) {
+		// Handled without an answer yet; the ticket resource will have it
+		w.Header().Set("Location", ticketPath(req.TicketID))
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if err != nil {
+		return // caller went away
+	}
+	if !isFinal(res) && wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+	poll:
+		for !isFinal(res) {
+			select {
+			case res = <-results:
+			case <-timer.C:
+				break poll
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+	writeResult(w, res)
+}
+
+func (h *HTTPHandler) ticket(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	res, err := h.lookup(r.Context(), id)
+	if err != nil {
+		log.Error().Err(err).Str("ticketId", id).Msg("api: ticket lookup failed")
+		writeError(w, http.StatusInternalServerError, "ticket lookup failed")
+		return
+	}
+	if res == nil {
+		writeError(w, http.StatusNotFound, "unknown ticket")
+		return
+	}
+	writeResult(w, res)
+}
+
+// longPoll parses the optional wait query parameter
+func longPoll(r *http.Request) (time.Duration, error) {
+	v := strings.TrimSpace(r.URL.Query().Get("wait"))
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, errors.New("wait must be a non-negative duration such as 30s")
+	}
+	return min(d, maxLongPoll), nil
+}
+
+func ticketPath(ticketID string) string {
+	return "/v1/tickets/" + url.PathEscape(ticketID)
+}
+
+// writeResult answers 200 with a final result, or 202 with a Location for Queued
+func writeResult(w http.ResponseWriter, res *queues.AllocationResult) {
+	status := http.StatusOK
+	if !isFinal(res) {
+		w.Header().Set("Location", ticketPath(res.TicketID))
+		status = http.StatusAccepted
+	}
+	writeJSON(w, status, res)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
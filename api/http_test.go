@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"agones-pubsub-allocator/queues"
+)
+
+func newTestHTTP(t *testing.T, handle func(*ResultRouter) HandleFunc, lookup TicketLookup, token string) *httptest.Server {
+	t.Helper()
+	router := NewResultRouter(&recordingPublisher{})
+	mux := http.NewServeMux()
+	NewHTTPHandler(router, handle(router), lookup, token).Register(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func noLookup(context.Context, string) (*queues.AllocationResult, error) { return nil, nil }
+
+func TestHTTPHandler_Allocate(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		query        string
+		handle       func(*ResultRouter) HandleFunc
+		wantCode     int
+		wantStatus   queues.AllocationStatus
+		wantLocation bool
+	}{
+		{
+			name:       "success",
+			body:       `{"ticketId":"t1","fleet":"f","playerId":"p1"}`,
+			handle:     publishing(queues.StatusSuccess),
+			wantCode:   http.StatusOK,
+			wantStatus: queues.StatusSuccess,
+		},
+		{
+			name:         "queued is accepted",
+			body:         `{"ticketId":"t1","fleet":"f"}`,
+			handle:       publishing(queues.StatusQueued, queues.StatusSuccess),
+			wantCode:     http.StatusAccepted,
+			wantStatus:   queues.StatusQueued,
+			wantLocation: true,
+		},
+		{
+			name:       "long poll waits past queued",
+			body:       `{"ticketId":"t1","fleet":"f"}`,
+			query:      "?wait=5s",
+			handle:     publishing(queues.StatusQueued, queues.StatusQueued, queues.StatusSuccess),
+			wantCode:   http.StatusOK,
+			wantStatus: queues.StatusSuccess,
+		},
+		{
+			name:     "missing fleet",
+			body:     `{"ticketId":"t1"}`,
+			handle:   publishing(queues.StatusSuccess),
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "malformed body",
+			body:     `{`,
+			handle:   publishing(queues.StatusSuccess),
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "invalid wait",
+			body:     `{"ticketId":"t1","fleet":"f"}`,
+			query:    "?wait=soon",
+			handle:   publishing(queues.StatusSuccess),
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "handle error",
+			body: `{"ticketId":"t1","fleet":"f"}`,
+			handle: func(*ResultRouter) HandleFunc {
+				return func(context.Context, *queues.AllocationRequest) error { return errors.New("not ready") }
+			},
+			wantCode: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestHTTP(t, tt.handle, noLookup, "")
+			resp, err := http.Post(srv.URL+"/v1/allocate"+tt.query, "application/json", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("POST error: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantCode {
+				t.Fatalf("status code = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+			if got := resp.Header.Get("Location"); (got != "") != tt.wantLocation {
+				t.Errorf("Location = %q, want set %v", got, tt.wantLocation)
+			}
+			if tt.wantStatus == "" {
+				return
+			}
+			var res queues.AllocationResult
+			if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+				t.Fatalf("decode result: %v", err)
+			}
+			if res.Status != tt.wantStatus || res.TicketID != "t1" {
+				t.Errorf("result = %+v, want status %s", res, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestHTTPHandler_Ticket(t *testing.T) {
+	lookup := func(_ context.Context, id string) (*queues.AllocationResult, error) {
+		switch id {
+		case "done":
+			return result(id, queues.StatusSuccess), nil
+		case "queued":
+			return result(id, queues.StatusQueued), nil
+		case "broken":
+			return nil, errors.New("configmap unavailable")
+		}
+		return nil, nil
+	}
+	srv := newTestHTTP(t, publishing(), lookup, "")
+
+	tests := []struct {
+		id       string
+		wantCode int
+	}{
+		{"done", http.StatusOK},
+		{"queued", http.StatusAccepted},
+		{"missing", http.StatusNotFound},
+		{"broken", http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			resp, err := http.Get(srv.URL + "/v1/tickets/" + tt.id)
+			if err != nil {
+				t.Fatalf("GET error: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("status code = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestHTTPHandler_BearerToken(t *testing.T) {
+	srv := newTestHTTP(t, publishing(queues.StatusSuccess), noLookup, "secret")
+
+	tests := []struct {
+		name     string
+		auth     string
+		wantCode int
+	}{
+		{"missing", "", http.StatusUnauthorized},
+		{"wrong", "Bearer nope", http.StatusUnauthorized},
+		{"valid", "Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/allocate", strings.NewReader(`{"ticketId":"t1","fleet":"f"}`))
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("POST error: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("status code = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+// Package api serves allocation requests synchronously over gRPC and HTTP,
+// next to the queue transports.
+package api
+
+import (
+	"context"
+	"sync"
+
+	"agones-pubsub-allocator/queues"
+
+	"github.com/rs/zerolog/log"
+)
+
+// watchBuffer is how many results a slow watcher may fall behind before results are dropped
+const watchBuffer = 16
+
+// ResultRouter is the queues.Publisher handed to the Controller. Results for
+// tickets with an active watcher (a waiting RPC) go to that watcher; all other
+// results go to the transport publisher.
+type ResultRouter struct {
+	next     queues.Publisher
+	mu       sync.Mutex
+	watchers map[string][]chan *queues.AllocationResult
+}
+
+// NewResultRouter wraps the transport publisher
+func NewResultRouter(next queues.Publisher) *ResultRouter {
+	return &ResultRouter{next: next, watchers: make(map[string][]chan *queues.AllocationResult)}
+}
+
+func (r *ResultRouter) PublishResult(ctx context.Context, res *queues.AllocationResult) error {
+	r.mu.Lock()
+	watchers := r.watchers[res.TicketID]
+	for _, ch := range watchers {
+		select {
+		case ch <- res:
+		default:
+			log.Warn().Str("ticketId", res.TicketID).Str("status", string(res.Status)).Msg("api: watcher is not keeping up; dropping result")
+		}
+	}
+	r.mu.Unlock()
+	if len(watchers) > 0 {
+		return nil
+	}
+	return r.next.PublishResult(ctx, res)
+}
+
+// Watch delivers the ticket's results to the returned channel until stop is called
+func (r *ResultRouter) Watch(ticketID string) (results <-chan *queues.AllocationResult, stop func()) {
+	ch := make(chan *queues.AllocationResult, watchBuffer)
+	r.mu.Lock()
+	r.watchers[ticketID] = append(r.watchers[ticketID], ch)
+	r.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			watchers := r.watchers[ticketID]
+			for i, w := range watchers {
+				if w == ch {
+					watchers = append(watchers[:i], watchers[i+1:]...)
+					break
+				}
+			}
+			if len(watchers) == 0 {
+				delete(r.watchers, ticketID)
+			} else {
+				r.watchers[ticketID] = watchers
+			}
+		})
+	}
+}
+
+// HandleFunc processes one request, publishing its results; Controller.Handle
+type HandleFunc func(context.Context, *queues.AllocationRequest) error
+
+// submit watches the ticket and runs handle. handle runs detached from ctx so an
+// allocation the caller walks away from still completes and its later results
+// fall through to the transport publisher. A permanent handle error has already
+// published its failure result and is not returned.
+func submit(ctx context.Context, router *ResultRouter, handle HandleFunc, req *queues.AllocationRequest) (<-chan *queues.AllocationResult, func(), error) {
+	results, stop := router.Watch(req.TicketID)
+	if err := handle(context.WithoutCancel(ctx), req); err != nil && !queues.IsPermanent(err) {
+		stop()
+		return nil, nil, err
+	}
+	return results, stop, nil
+}
+
+// isFinal reports whether no further results follow for the ticket
+func isFinal(res *queues.AllocationResult) bool {
+	return res.Status != queues.StatusQueued
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"agones-pubsub-allocator/queues"
+)
+
+type recordingPublisher struct {
+	results []*queues.AllocationResult
+}
+
+func (p *recordingPublisher) PublishResult(_ context.Context, res *queues.AllocationResult) error {
+	p.results = append(p.results, res)
+	return nil
+}
+
+func result(ticketID string, status queues.AllocationStatus) *queues.AllocationResult {
+	return &queues.AllocationResult{
+		EnvelopeVersion: queues.ResultEnvelopeVersion,
+		Type:            "allocation-result",
+		TicketID:        ticketID,
+		Status:          status,
+	}
+}
+
+func TestResultRouter_PublishResult(t *testing.T) {
+	next := &recordingPublisher{}
+	router := NewResultRouter(next)
+
+	watched, stop := router.Watch("t1")
+	if err := router.PublishResult(context.Background(), result("t1", queues.StatusSuccess)); err != nil {
+		t.Fatalf("PublishResult() error: %v", err)
+	}
+	if err := router.PublishResult(context.Background(), result("t2", queues.StatusSuccess)); err != nil {
+		t.Fatalf("PublishResult() error: %v", err)
+	}
+
+	select {
+	case res := <-watched:
+		if res.TicketID != "t1" {
+			t.Errorf("watcher got ticket %q, want t1", res.TicketID)
+		}
+	default:
+		t.Fatal("watcher got no result")
+	}
+	if len(next.results) != 1 || next.results[0].TicketID != "t2" {
+		t.Fatalf("forwarded = %+v, want only t2", next.results)
+	}
+
+	// Once the watcher stops, results fall through to the transport again
+	stop()
+	stop()
+	if err := router.PublishResult(context.Background(), result("t1", queues.StatusQueued)); err != nil {
+		t.Fatalf("PublishResult() error: %v", err)
+	}
+	if len(next.results) != 2 {
+		t.Errorf("forwarded %d results after stop, want 2", len(next.results))
+	}
+	if len(router.watchers) != 0 {
+		t.Errorf("watchers = %d after stop, want 0", len(router.watchers))
+	}
+}
+
+func TestResultRouter_SlowWatcherDoesNotBlock(t *testing.T) {
+	router := NewResultRouter(&recordingPublisher{})
+	_, stop := router.Watch("t1")
+	defer stop()
+
+	for range watchBuffer + 5 {
+		if err := router.PublishResult(context.Background(), result("t1", queues.StatusQueued)); err != nil {
+			t.Fatalf("PublishResult() error: %v", err)
+		}
+	}
+}
+
+func TestSubmit(t *testing.T) {
+	tests := []struct {
+		name      string
+		handleErr error
+		wantErr   bool
+	}{
+		{name: "handled"},
+		{name: "handle fails", handleErr: errors.New("boom"), wantErr: true},
+		{name: "permanent failure was published", handleErr: queues.Permanent(errors.New("boom"))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := NewResultRouter(&recordingPublisher{})
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			handle := func(ctx context.Context, req *queues.AllocationRequest) error {
+				if ctx.Err() != nil {
+					t.Error("handle context is cancelled with the caller")
+				}
+				_ = router.PublishResult(ctx, result(req.TicketID, queues.StatusSuccess))
+				return tt.handleErr
+			}
+
+			results, stop, err := submit(ctx, router, handle, &queues.AllocationRequest{TicketID: "t1", Fleet: "f"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("submit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if len(router.watchers) != 0 {
+					t.Error("watch left registered after handle failed")
+				}
+				return
+			}
+			defer stop()
+			if res := <-results; res.Status != queues.StatusSuccess {
+				t.Errorf("status = %s, want Success", res.Status)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"net"
+
+	"agones-pubsub-allocator/api"
+	"agones-pubsub-allocator/config"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+)
+
+// startGRPC serves the Allocator gRPC service on cfg.GRPCPort. The returned
+// stop drains in-flight RPCs until ctx is done, then closes the rest.
+func startGRPC(cfg *config.Config, router *api.ResultRouter, handle api.HandleFunc) (stop func(ctx context.Context)) {
+	lis, err := net.Listen("tcp", cfg.GRPCAddr())
+	if err != nil {
+		log.Fatal().Err(err).Str("addr", cfg.GRPCAddr()).Msg("failed to listen for grpc")
+	}
+	gs := grpc.NewServer()
+	api.NewGRPCServer(router, handle).Register(gs)
+
+	go func() {
+		log.Info().Str("addr", cfg.GRPCAddr()).Msg("starting grpc server")
+		if err := gs.Serve(lis); err != nil {
+			log.Fatal().Err(err).Msg("grpc server error")
+		}
+	}()
+
+	return func(ctx context.Context) {
+		stopped := make(chan struct{})
+		go func() {
+			gs.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			log.Warn().Msg("grpc graceful stop timed out; closing open streams")
+			gs.Stop()
+		}
+	}
+}
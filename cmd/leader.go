@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"agones-pubsub-allocator/config"
+	"agones-pubsub-allocator/metrics"
+
+	"github.com/rs/zerolog/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// runAsLeader campaigns for the allocator lease until ctx is done and calls run
+// each time this replica becomes leader. run's context is cancelled when
+// leadership is lost; the next campaign starts only after run has returned so
+// two loops never overlap in one process.
+func runAsLeader(ctx context.Context, kube kubernetes.Interface, cfg *config.Config, run func(ctx context.Context)) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      cfg.LeaderElectionLease,
+			Namespace: cfg.TargetNamespace,
+		},
+		Client:     kube.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: cfg.PodName},
+	}
+
+	for ctx.Err() == nil {
+		// client-go starts OnStartedLeading in a goroutine, so RunOrDie can return
+		// before it runs; done is made up front and closed when run returns
+		done := make(chan struct{})
+		var started atomic.Bool
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   leaseDuration,
+			RenewDeadline:   renewDeadline,
+			RetryPeriod:     retryPeriod,
+			ReleaseOnCancel: true,
+			Name:            cfg.LeaderElectionLease,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(leaderCtx context.Context) {
+					started.Store(true)
+					defer close(done)
+
+					log.Info().Str("identity", cfg.PodName).Str("lease", cfg.LeaderElectionLease).Msg("leader election: acquired lease; starting allocator")
+					metrics.Leader.Set(1)
+					defer metrics.Leader.Set(0)
+					run(leaderCtx)
+				},
+				OnStoppedLeading: func() {
+					log.Info().Str("identity", cfg.PodName).Msg("leader election: not leading")
+				},
+				OnNewLeader: func(identity string) {
+					if identity != cfg.PodName {
+						log.Info().Str("leader", identity).Msg("leader election: following")
+					}
+				},
+			},
+		})
+		// RunOrDie only returns while ctx is live after the lease was acquired and
+		// lost, so run has been started; wait for it to drain before campaigning
+		// again. On shutdown the lease may never have been acquired, so wait only
+		// when run got going.
+		if ctx.Err() == nil || started.Load() {
+			<-done
+		}
+	}
+}
+
+// drainContext returns a context that stays alive for up to grace after ctx is
+// done, so work started before a stop can finish. Call cancel once the work is over.
+func drainContext(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	drain, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancel()
+		case <-drain.Done():
+		}
+	})
+	return drain, func() {
+		stop()
+		cancel()
+	}
+}
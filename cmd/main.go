@@ -9,18 +9,22 @@ import (
 	"time"
 
 	"agones-pubsub-allocator/allocator"
+	"agones-pubsub-allocator/api"
 	"agones-pubsub-allocator/config"
 	"agones-pubsub-allocator/health"
 	"agones-pubsub-allocator/metrics"
 	"agones-pubsub-allocator/queues"
-	qpubsub "agones-pubsub-allocator/queues/pubsub"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"k8s.io/client-go/kubernetes"
 )
 
 var version = "source"
 
+// handlerDrainTimeout bounds how long in-flight requests may run after a stop
+const handlerDrainTimeout = 10 * time.Second
+
 func setLogger() {
 	zerolog.TimeFieldFormat = time.RFC3339Nano
 	//if len(os.Getenv("CONSOLE_LOG")) > 0 {
@@ -39,17 +43,6 @@ func main() {
 	cfg := config.Load()
 	log.Info().Interface("config", cfg.Redacted()).Msg("config loaded")
 
-	// Preflight required configuration
-	if cfg.GoogleProjectID == "" {
-		log.Fatal().Msg("missing Google project id; set GOOGLE_APPLICATION_CREDENTIALS or GOOGLE_PROJECT_ID or ALLOCATOR_PUBSUB_PROJECT_ID")
-	}
-	if cfg.Subscription == "" {
-		log.Fatal().Msg("missing Pub/Sub subscription; set ALLOCATION_REQUEST_SUBSCRIPTION or ALLOCATOR_PUBSUB_SUBSCRIPTION")
-	}
-	if cfg.PubsubTopic == "" {
-		log.Fatal().Msg("missing Pub/Sub topic; set ALLOCATION_RESULT_TOPIC or ALLOCATOR_PUBSUB_TOPIC")
-	}
-
 	// Context and shutdown handling
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
@@ -57,7 +50,6 @@ func main() {
 	// Metrics and health HTTP server
 	mux := http.NewServeMux()
 	metrics.Register(mux)
-	health.Register(mux)
 
 	srv := &http.Server{
 		Addr:              cfg.HTTPAddr(),
@@ -72,32 +64,145 @@ func main() {
 		}
 	}()
 
-	if cfg.CredentialsFile != "" {
-		log.Info().Str("credsFile", cfg.CredentialsFile).Msg("using explicit Google credentials file")
-	} else {
-		log.Info().Msg("using default Google credentials (in-cluster or ambient)")
+	subscriber, publisher := newTransport(cfg)
+	// track sees every request before it is handled so the webhook can note its callbackUrl
+	track := func(*queues.AllocationRequest) {}
+	closePublisher := func(context.Context) {}
+	if cfg.Webhook() {
+		wh := newWebhook(cfg)
+		publisher, track = wh, wh.Track
+		closePublisher = func(ctx context.Context) {
+			if err := wh.Close(ctx); err != nil {
+				log.Error().Err(err).Msg("webhook publisher did not drain")
+			}
+		}
+	}
+	// Kubernetes client for ConfigMap-backed state, created on first use
+	var kube kubernetes.Interface
+	kubeClient := func(purpose string) kubernetes.Interface {
+		if kube == nil {
+			k, err := allocator.NewKubernetesClient()
+			if err != nil {
+				log.Fatal().Err(err).Msgf("failed to create kubernetes client for %s", purpose)
+			}
+			kube = k
+		}
+		return kube
 	}
-	publisher := qpubsub.NewPublisher(cfg.GoogleProjectID, cfg.PubsubTopic, cfg.CredentialsFile)
-	controller := allocator.NewController(publisher, cfg.TargetNamespace)
-	subscriber := qpubsub.NewSubscriber(cfg.GoogleProjectID, cfg.Subscription, cfg.CredentialsFile)
 
-	// Start subscriber loop
-	go func() {
-		log.Info().Str("subscription", cfg.Subscription).Msg("starting subscriber loop")
-		if err := subscriber.Start(ctx, func(ctx context.Context, req *queues.AllocationRequest) error {
-			return controller.Handle(ctx, req)
+	var ledger allocator.TicketLedger
+	switch cfg.TicketLedger {
+	case "configmap":
+		log.Info().Str("configMap", cfg.TicketLedgerConfigMap).Int("shards", cfg.TicketLedgerShards).Int("maxEntries", cfg.TicketLedgerMaxEntries).Dur("ttl", cfg.TicketTTL).Msg("using ConfigMap ticket ledger")
+		ledger = allocator.NewConfigMapLedger(kubeClient("ticket ledger"), cfg.TargetNamespace, cfg.TicketLedgerConfigMap, cfg.TicketTTL, cfg.TicketLedgerShards, cfg.TicketLedgerMaxEntries)
+	default:
+		log.Info().Dur("ttl", cfg.TicketTTL).Msg("using in-memory ticket ledger")
+		ledger = allocator.NewMemoryLedger(cfg.TicketTTL)
+	}
+
+	var queueStore allocator.QueueStore
+	switch cfg.QueueStore {
+	case "configmap":
+		log.Info().Str("configMap", cfg.QueueStoreConfigMap).Msg("using ConfigMap queue store")
+		queueStore = allocator.NewConfigMapQueueStore(kubeClient("queue store"), cfg.TargetNamespace, cfg.QueueStoreConfigMap)
+	default:
+		log.Info().Msg("using in-memory queue store; queued players are lost on restart")
+		queueStore = allocator.NewMemoryQueueStore()
+	}
+
+	clusters, err := allocator.NewClusters(cfg.Clusters, cfg.TargetNamespace)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create cluster registry clients")
+	}
+	if len(clusters) > 0 {
+		log.Info().Int("clusters", len(clusters)).Msg("allocating through cluster registry")
+	}
+
+	// Results for tickets awaited by an API caller go to that caller instead of the transport
+	router := api.NewResultRouter(publisher)
+	controller := allocator.NewController(router, cfg.TargetNamespace,
+		allocator.WithTicketLedger(ledger),
+		allocator.WithFleetConfigs(cfg.Fleets),
+		allocator.WithQueueAging(cfg.QueueAging),
+		allocator.WithQueueStore(queueStore),
+		allocator.WithClusters(clusters),
+	)
+	health.Register(mux, controller.Ready)
+
+	// API requests are refused until this replica has started the controller (and leads)
+	handle := func(ctx context.Context, req *queues.AllocationRequest) error {
+		if err := controller.Leading(); err != nil {
+			return err
+		}
+		track(req)
+		return controller.Handle(ctx, req)
+	}
+	if cfg.HTTPAPI {
+		if cfg.HTTPAPIToken == "" {
+			log.Warn().Msg("HTTP allocation API enabled without ALLOCATOR_HTTP_API_TOKEN; anyone reaching the metrics port can allocate")
+		}
+		api.NewHTTPHandler(router, handle, ledger.Get, cfg.HTTPAPIToken).Register(mux)
+	}
+	stopGRPC := func(context.Context) {}
+	if cfg.GRPCPort > 0 {
+		stopGRPC = startGRPC(cfg, router, handle)
+	}
+
+	// runLoop restores queues and starts the queue worker, then receives requests
+	// until ctx is done. Handlers run on a drain context so requests already being
+	// allocated finish after a stop instead of being cut off halfway.
+	runLoop := func(ctx context.Context) {
+		if err := controller.Start(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Fatal().Err(err).Msg("failed to start controller")
+		}
+		work, cancelWork := drainContext(ctx, handlerDrainTimeout)
+		defer cancelWork()
+		log.Info().Str("transport", cfg.Transport).Msg("starting subscriber loop")
+		if err := subscriber.Start(ctx, func(_ context.Context, req *queues.AllocationRequest) error {
+			track(req)
+			return controller.Handle(work, req)
 		}); err != nil {
-			// Non-recoverable: if we can't receive from Pub/Sub, terminate the process
+			// Non-recoverable: if we can't receive requests, terminate the process
 			log.Fatal().Err(err).Msg("subscriber exited with fatal error; shutting down")
 		}
+		log.Info().Str("transport", cfg.Transport).Msg("subscriber loop stopped")
+	}
+
+	loopDone := make(chan struct{})
+	go func() {
+		defer close(loopDone)
+		// Every replica syncs the GameServer caches for its lifetime, so followers
+		// report ready and a new leader starts with warm caches
+		if err := controller.Sync(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Fatal().Err(err).Msg("failed to sync controller")
+		}
+		if cfg.LeaderElection {
+			log.Info().Str("lease", cfg.LeaderElectionLease).Str("identity", cfg.PodName).Msg("leader election enabled; waiting for lease")
+			runAsLeader(ctx, kubeClient("leader election"), cfg, runLoop)
+			return
+		}
+		runLoop(ctx)
 	}()
 
 	// Block until shutdown
 	<-ctx.Done()
 	log.Info().Msg("shutdown signal received")
+	select {
+	case <-loopDone:
+	case <-time.After(handlerDrainTimeout + 5*time.Second):
+		log.Warn().Msg("subscriber loop did not stop in time")
+	}
 
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
+	stopGRPC(shutdownCtx)
+	closePublisher(shutdownCtx)
 	if err := srv.Shutdown(shutdownCtx); err != nil {
 		log.Error().Err(err).Msg("http server graceful shutdown failed")
 	}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"agones-pubsub-allocator/config"
+	"agones-pubsub-allocator/queues"
+	qamqp "agones-pubsub-allocator/queues/amqp"
+	qfile "agones-pubsub-allocator/queues/file"
+	qkafka "agones-pubsub-allocator/queues/kafka"
+	qmemory "agones-pubsub-allocator/queues/memory"
+	qnats "agones-pubsub-allocator/queues/nats"
+	qpubsub "agones-pubsub-allocator/queues/pubsub"
+	qredis "agones-pubsub-allocator/queues/redisstream"
+	"agones-pubsub-allocator/queues/webhook"
+
+	"github.com/rs/zerolog/log"
+)
+
+// newTransport builds the request subscriber and result publisher selected by
+// ALLOCATOR_TRANSPORT, exiting on missing required settings
+func newTransport(cfg *config.Config) (queues.Subscriber, queues.Publisher) {
+	switch cfg.Transport {
+	case "nats":
+		opts := qnats.Options{
+			URL:               cfg.NATSURL,
+			CredsFile:         cfg.NATSCredsFile,
+			Stream:            cfg.NATSStream,
+			StreamMaxAge:      cfg.NATSStreamMaxAge,
+			StreamMaxBytes:    cfg.NATSStreamMaxBytes,
+			RequestSubject:    cfg.NATSRequestSubject,
+			ResultSubject:     cfg.NATSResultSubject,
+			Durable:           cfg.NATSDurable,
+			MaxDeliveries:     cfg.NATSMaxDeliveries,
+			DeadLetterSubject: cfg.NATSDeadLetterSubject,
+		}
+		log.Info().Str("url", cfg.NATSURL).Str("stream", cfg.NATSStream).Str("requestSubject", cfg.NATSRequestSubject).Str("resultSubject", cfg.NATSResultSubject).Msg("using nats jetstream transport")
+		return qnats.NewSubscriber(opts), qnats.NewPublisher(opts)
+	case "kafka":
+		if len(cfg.KafkaBrokers) == 0 {
+			log.Fatal().Msg("missing Kafka brokers; set ALLOCATOR_KAFKA_BROKERS")
+		}
+		opts := qkafka.Options{
+			Brokers:         cfg.KafkaBrokers,
+			RequestTopic:    cfg.KafkaRequestTopic,
+			ResultTopic:     cfg.KafkaResultTopic,
+			Group:           cfg.KafkaGroup,
+			MaxAttempts:     cfg.KafkaMaxAttempts,
+			DeadLetterTopic: cfg.KafkaDeadLetterTopic,
+		}
+		log.Info().Strs("brokers", cfg.KafkaBrokers).Str("requestTopic", cfg.KafkaRequestTopic).Str("resultTopic", cfg.KafkaResultTopic).Str("group", cfg.KafkaGroup).Msg("using kafka transport")
+		return qkafka.NewSubscriber(opts), qkafka.NewPublisher(opts)
+	case "redis":
+		opts := qredis.Options{
+			URL:              cfg.RedisURL,
+			RequestStream:    cfg.RedisRequestStream,
+			ResultStream:     cfg.RedisResultStream,
+			Group:            cfg.RedisGroup,
+			Consumer:         cfg.PodName,
+			MaxDeliveries:    int64(cfg.RedisMaxDeliveries),
+			DeadLetterStream: cfg.RedisDeadLetterStream,
+			ClaimIdle:        cfg.RedisClaimIdle,
+			ResultMaxLen:     int64(cfg.RedisResultMaxLen),
+		}
+		log.Info().Str("requestStream", cfg.RedisRequestStream).Str("resultStream", cfg.RedisResultStream).Str("group", cfg.RedisGroup).Str("consumer", cfg.PodName).Msg("using redis streams transport")
+		return qredis.NewSubscriber(opts), qredis.NewPublisher(opts)
+	case "amqp":
+		log.Info().Str("queue", cfg.AMQPRequestQueue).Str("exchange", cfg.AMQPResultExchange).Str("routingKey", cfg.AMQPResultRoutingKey).Bool("useReplyTo", cfg.AMQPUseReplyTo).Msg("using amqp transport")
+		return qamqp.NewTransport(qamqp.Options{
+			URL:              cfg.AMQPURL,
+			RequestQueue:     cfg.AMQPRequestQueue,
+			Prefetch:         cfg.AMQPPrefetch,
+			ResultExchange:   cfg.AMQPResultExchange,
+			ResultRoutingKey: cfg.AMQPResultRoutingKey,
+			UseReplyTo:       cfg.AMQPUseReplyTo,
+			MaxDeliveries:    cfg.AMQPMaxDeliveries,
+			ConsumerTag:      cfg.PodName,
+		})
+	case "memory":
+		// Requests only arrive through the HTTP or gRPC API; unwatched results are logged
+		if !cfg.HTTPAPI && cfg.GRPCPort <= 0 {
+			log.Warn().Msg("memory transport without ALLOCATOR_HTTP_API or ALLOCATOR_GRPC_PORT receives no requests")
+		}
+		t := qmemory.NewTransport(100)
+		go func() {
+			for res := range t.Results() {
+				log.Info().Interface("result", res).Msg("allocation result")
+			}
+		}()
+		log.Info().Msg("using in-memory transport")
+		return t, t
+	case "file":
+		opts := qfile.Options{
+			RequestPath: cfg.FileRequests,
+			ResultPath:  cfg.FileResults,
+			Follow:      cfg.FileFollow,
+		}
+		log.Info().Str("requests", cfg.FileRequests).Str("results", cfg.FileResults).Bool("follow", cfg.FileFollow).Msg("using file transport")
+		return qfile.NewSubscriber(opts), qfile.NewPublisher(opts)
+	default:
+		// Preflight required configuration
+		if cfg.GoogleProjectID == "" {
+			log.Fatal().Msg("missing Google project id; set GOOGLE_APPLICATION_CREDENTIALS or GOOGLE_PROJECT_ID or ALLOCATOR_PUBSUB_PROJECT_ID")
+		}
+		if cfg.Subscription == "" {
+			log.Fatal().Msg("missing Pub/Sub subscription; set ALLOCATION_REQUEST_SUBSCRIPTION or ALLOCATOR_PUBSUB_SUBSCRIPTION")
+		}
+		if cfg.PubsubTopic == "" {
+			log.Fatal().Msg("missing Pub/Sub topic; set ALLOCATION_RESULT_TOPIC or ALLOCATOR_PUBSUB_TOPIC")
+		}
+		opts := qpubsub.Options{
+			ProjectID:       cfg.GoogleProjectID,
+			Subscription:    cfg.Subscription,
+			RequestTopic:    cfg.PubsubRequestTopic,
+			ResultTopic:     cfg.PubsubTopic,
+			CredentialsFile: cfg.CredentialsFile,
+			EmulatorHost:    cfg.PubsubEmulatorHost,
+			MaxDeliveries:   cfg.PubsubMaxDeliveries,
+			DeadLetterTopic: cfg.PubsubDeadLetterTopic,
+		}
+		switch {
+		case cfg.PubsubEmulatorHost != "":
+			log.Info().Str("emulator", cfg.PubsubEmulatorHost).Str("projectID", cfg.GoogleProjectID).Msg("using Pub/Sub emulator; missing topics and subscription will be created")
+		case cfg.CredentialsFile != "":
+			log.Info().Str("credsFile", cfg.CredentialsFile).Msg("using explicit Google credentials file")
+		default:
+			log.Info().Msg("using default Google credentials (in-cluster or ambient)")
+		}
+		log.Info().Str("subscription", cfg.Subscription).Str("topic", cfg.PubsubTopic).Msg("using pubsub transport")
+		return qpubsub.NewSubscriber(opts), qpubsub.NewPublisher(opts)
+	}
+}
+
+// newWebhook builds the webhook result publisher used in place of the
+// transport's publisher when ALLOCATOR_WEBHOOK_URL or callbacks are set
+func newWebhook(cfg *config.Config) *webhook.Publisher {
+	if cfg.WebhookSecret == "" {
+		log.Warn().Msg("webhook results are unsigned; set ALLOCATOR_WEBHOOK_SECRET")
+	}
+	log.Info().Bool("defaultURL", cfg.WebhookURL != "").Bool("callbacks", cfg.WebhookCallbacks).Strs("callbackHosts", cfg.WebhookCallbackHosts).Int("maxAttempts", cfg.WebhookMaxAttempts).Int("queueSize", cfg.WebhookQueueSize).Msg("publishing results to webhook")
+	return webhook.NewPublisher(webhook.Options{
+		URL:            cfg.WebhookURL,
+		Secret:         cfg.WebhookSecret,
+		AllowCallbacks: cfg.WebhookCallbacks,
+		CallbackHosts:  cfg.WebhookCallbackHosts,
+		Timeout:        cfg.WebhookTimeout,
+		MaxAttempts:    cfg.WebhookMaxAttempts,
+		QueueSize:      cfg.WebhookQueueSize,
+	})
+}
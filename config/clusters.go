@@ -0,0 +1,69 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ClusterConfig is one Agones cluster new allocations may be placed in
+type ClusterConfig struct {
+	Name   string `json:"name"`
+	Region string `json:"region,omitempty"`
+	// Weight orders clusters within the same region preference; higher is tried first
+	Weight int `json:"weight,omitempty"`
+	// Kubeconfig is a kubeconfig file, e.g. mounted from a Secret, and Context
+	// the context to use in it. Both empty means in-cluster or the local kubeconfig.
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	Context    string `json:"context,omitempty"`
+	// Namespace GameServers are allocated in; defaults to TARGET_NAMESPACE
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// ClusterConfigs is the cluster registry; empty means allocate in the local cluster only
+type ClusterConfigs []ClusterConfig
+
+// validate checks every entry has a unique name that is a DNS-1123 label, as the
+// name prefixes GameServer names in queue IDs and ConfigMap keys
+func (cs ClusterConfigs) validate() error {
+	seen := make(map[string]bool, len(cs))
+	for i, c := range cs {
+		if c.Name == "" {
+			return fmt.Errorf("cluster %d: name is required", i)
+		}
+		if errs := validation.IsDNS1123Label(c.Name); len(errs) > 0 {
+			return fmt.Errorf("cluster %q: invalid name: %s", c.Name, strings.Join(errs, "; "))
+		}
+		if seen[c.Name] {
+			return fmt.Errorf("cluster %q: duplicate name", c.Name)
+		}
+		seen[c.Name] = true
+	}
+	return nil
+}
+
+// loadClusterConfigs parses inline JSON, or the file at path when inline is empty
+func loadClusterConfigs(inline, path string) (ClusterConfigs, error) {
+	raw := []byte(inline)
+	if inline == "" {
+		if path == "" {
+			return nil, nil
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		raw = b
+	}
+	var clusters ClusterConfigs
+	if err := json.Unmarshal(raw, &clusters); err != nil {
+		return nil, err
+	}
+	if err := clusters.validate(); err != nil {
+		return nil, err
+	}
+	return clusters, nil
+}
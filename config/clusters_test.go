@@ -0,0 +1,53 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_loadClusterConfigs(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "clusters.json")
+	if err := os.WriteFile(file, []byte(`[{"name":"eu-1","region":"europe"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		inline  string
+		path    string
+		want    ClusterConfigs
+		wantErr bool
+	}{
+		{name: "none"},
+		{
+			name:   "inline",
+			inline: `[{"name":"us-1","region":"us","weight":10,"kubeconfig":"/etc/clusters/us-1","context":"us-1","namespace":"games"},{"name":"local"}]`,
+			want: ClusterConfigs{
+				{Name: "us-1", Region: "us", Weight: 10, Kubeconfig: "/etc/clusters/us-1", Context: "us-1", Namespace: "games"},
+				{Name: "local"},
+			},
+		},
+		{name: "file", path: file, want: ClusterConfigs{{Name: "eu-1", Region: "europe"}}},
+		{name: "missing file", path: filepath.Join(dir, "nope.json"), wantErr: true},
+		{name: "bad json", inline: `{"name":"a"}`, wantErr: true},
+		{name: "missing name", inline: `[{"region":"us"}]`, wantErr: true},
+		{name: "duplicate name", inline: `[{"name":"a"},{"name":"a"}]`, wantErr: true},
+		{name: "name with a slash", inline: `[{"name":"eu/prod"}]`, wantErr: true},
+		{name: "name with a space", inline: `[{"name":"us east"}]`, wantErr: true},
+		{name: "name with an underscore", inline: `[{"name":"us_east"}]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadClusterConfigs(tt.inline, tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadClusterConfigs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadClusterConfigs()\n got=%#v\nwant=%#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func withEnv(k, v string, fn func()) {
@@ -100,6 +101,61 @@ func Test_getEnvInt(t *testing.T) {
 	}
 }
 
+func Test_getEnvBool(t *testing.T) {
+	tests := []struct {
+		name string
+		set  string
+		def  bool
+		want bool
+	}{
+		{"no env -> default", "", true, true},
+		{"true", "true", false, true},
+		{"numeric false", "0", true, false},
+		{"invalid bool -> default", "maybe", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.set == "" {
+				_ = os.Unsetenv("XBOOL")
+			} else {
+				_ = os.Setenv("XBOOL", tt.set)
+				defer os.Unsetenv("XBOOL")
+			}
+			got := getEnvBool("XBOOL", tt.def)
+			if got != tt.want {
+				t.Errorf("getEnvBool() got=%#v want=%#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_getEnvDuration(t *testing.T) {
+	tests := []struct {
+		name string
+		set  string
+		def  time.Duration
+		want time.Duration
+	}{
+		{"no env -> default", "", time.Minute, time.Minute},
+		{"valid duration", "90s", time.Minute, 90 * time.Second},
+		{"invalid duration -> default", "soon", time.Minute, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.set == "" {
+				_ = os.Unsetenv("XDUR")
+			} else {
+				_ = os.Setenv("XDUR", tt.set)
+				defer os.Unsetenv("XDUR")
+			}
+			got := getEnvDuration("XDUR", tt.def)
+			if got != tt.want {
+				t.Errorf("getEnvDuration() got=%#v want=%#v", got, tt.want)
+			}
+		})
+	}
+}
+
 func Test_Config_HTTPAddr(t *testing.T) {
 	tests := []struct {
 		name string
@@ -119,17 +175,40 @@ func Test_Config_HTTPAddr(t *testing.T) {
 	}
 }
 
+func Test_Config_GRPCAddr(t *testing.T) {
+	c := &Config{GRPCPort: 9000}
+	if got := c.GRPCAddr(); got != "0.0.0.0:9000" {
+		t.Errorf("GRPCAddr() got=%#v want=%#v", got, "0.0.0.0:9000")
+	}
+}
+
 func Test_Config_Redacted(t *testing.T) {
 	c := &Config{GoogleProjectID: "pid", Subscription: "sub", PubsubTopic: "topic", TargetNamespace: "ns", MetricsPort: 8081, LogLevel: "debug", CredentialsFile: "creds.json"}
 	got := c.Redacted()
 	want := map[string]any{
+		"transport":           "",
 		"projectID":           "pid",
 		"requestSubscription": "sub",
 		"resultTopic":         "topic",
+		"pubsubEmulator":      "",
+		"pubsubDeadLetter":    "",
 		"targetNamespace":     "ns",
 		"metricsPort":         8081,
+		"grpcPort":            0,
+		"httpApi":             false,
+		"httpApiAuth":         false,
 		"logLevel":            "debug",
 		"credentialsProvided": true,
+		"ticketLedger":        "",
+		"ticketTTL":           "0s",
+		"queueStore":          "",
+		"queueAging":          "0s",
+		"fleetConfigs":        0,
+		"clusters":            0,
+		"leaderElection":      false,
+		"podName":             "",
+		"resultWebhook":       false,
+		"webhookSigned":       false,
 	}
 	if !reflect.DeepEqual(got, want) {
 		t.Errorf("Redacted()\n got=%#v\nwant=%#v", got, want)
@@ -225,3 +304,74 @@ func Test_Load(t *testing.T) {
 		t.Errorf("Load() unexpected cfg: %#v", string(b))
 	}
 }
+
+func Test_Load_PubsubEmulator(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		wantHost    string
+		wantProject string
+	}{
+		{name: "no emulator", env: map[string]string{}, wantHost: "", wantProject: ""},
+		{name: "explicit", env: map[string]string{"ALLOCATOR_PUBSUB_EMULATOR_HOST": "localhost:8085"}, wantHost: "localhost:8085", wantProject: "local-project"},
+		{name: "standard variable", env: map[string]string{"PUBSUB_EMULATOR_HOST": "emulator:8681"}, wantHost: "emulator:8681", wantProject: "local-project"},
+		{name: "configured project wins", env: map[string]string{"PUBSUB_EMULATOR_HOST": "emulator:8681", "ALLOCATOR_PUBSUB_PROJECT_ID": "pid"}, wantHost: "emulator:8681", wantProject: "pid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{"ALLOCATOR_PUBSUB_EMULATOR_HOST", "PUBSUB_EMULATOR_HOST", "ALLOCATOR_PUBSUB_PROJECT_ID", "GOOGLE_PROJECT_ID", "GOOGLE_CLOUD_PROJECT", "GCLOUD_PROJECT", "GCP_PROJECT", "GOOGLE_APPLICATION_CREDENTIALS", "ALLOCATOR_GSA_CREDENTIALS", "ALLOCATOR_TRANSPORT"} {
+				t.Setenv(k, tt.env[k])
+			}
+			cfg := Load()
+			if cfg.PubsubEmulatorHost != tt.wantHost || cfg.GoogleProjectID != tt.wantProject {
+				t.Errorf("Load() emulator=%q project=%q, want %q %q", cfg.PubsubEmulatorHost, cfg.GoogleProjectID, tt.wantHost, tt.wantProject)
+			}
+		})
+	}
+}
+
+func Test_Load_Transport(t *testing.T) {
+	tests := []struct {
+		name string
+		set  string
+		want string
+	}{
+		{"default", "", "pubsub"},
+		{"nats", "NATS", "nats"},
+		{"kafka", "kafka", "kafka"},
+		{"redis", "redis", "redis"},
+		{"amqp", "amqp", "amqp"},
+		{"memory", "memory", "memory"},
+		{"file", "file", "file"},
+		{"unknown falls back", "carrier-pigeon", "pubsub"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.set == "" {
+				_ = os.Unsetenv("ALLOCATOR_TRANSPORT")
+			} else {
+				_ = os.Setenv("ALLOCATOR_TRANSPORT", tt.set)
+				defer os.Unsetenv("ALLOCATOR_TRANSPORT")
+			}
+			if got := Load().Transport; got != tt.want {
+				t.Errorf("Load().Transport = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_splitList(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"a:9092", []string{"a:9092"}},
+		{" a:9092, ,b:9092 ", []string{"a:9092", "b:9092"}},
+	}
+	for _, tt := range tests {
+		if got := splitList(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitList(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
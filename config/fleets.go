@@ -0,0 +1,273 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// DefaultFleet is the FleetConfigs key applied to fleets without their own entry
+const DefaultFleet = "*"
+
+// Capacity sources for friend joins
+const (
+	CapacityCounter    = "counter"    // Status.Counters[Name]; a join increments the count
+	CapacityList       = "list"       // Status.Lists[Name]; a join appends the player ID
+	CapacityPlayers    = "players"    // legacy Status.Players, maintained by the game server SDK
+	CapacityAnnotation = "annotation" // annotation Name holds the max player count, compared to the token count
+)
+
+// Behaviours when a friend's GameServer is full
+const (
+	WhenFullReject    = "reject"
+	WhenFullSpillover = "spillover"
+	WhenFullQueue     = "queue"
+)
+
+// CapacityConfig says where a fleet's GameServers report player capacity.
+// An empty Source means capacity is not checked.
+type CapacityConfig struct {
+	Source string `json:"source,omitempty"`
+	Name   string `json:"name,omitempty"`
+}
+
+// FleetConfig is the per-fleet allocation behaviour
+type FleetConfig struct {
+	Capacity CapacityConfig `json:"capacity,omitempty"`
+	// WhenFull is "reject" (default), "spillover" to try another friend's GameServer,
+	// or "queue" to spill over and then wait for a slot on the first full one
+	WhenFull string `json:"whenFull,omitempty"`
+	// MaxQueueWait expires queued players with a Failure; zero waits indefinitely
+	MaxQueueWait Duration `json:"maxQueueWait,omitempty"`
+	// MaxPriority is the top queue tier. Request priorities are clamped to
+	// 0..MaxPriority and queued players age up to it; zero queues in arrival order.
+	MaxPriority int `json:"maxPriority,omitempty"`
+	// SelectorLabels are the label keys requests may select on; "*" allows any.
+	// Requests using other keys fail.
+	SelectorLabels []string `json:"selectorLabels,omitempty"`
+	// Priorities order candidate GameServers by Counter or List, e.g. least
+	// available room first to pack players; requests may replace them
+	Priorities []Priority `json:"priorities,omitempty"`
+	// Counters and Lists are applied to the allocated GameServer; requests may
+	// add or override entries
+	Counters map[string]CounterAction `json:"counters,omitempty"`
+	Lists    map[string]ListAction    `json:"lists,omitempty"`
+	// CounterNames and ListNames are the Counters and Lists requests may set
+	// actions or priorities on; "*" allows any. Requests using others fail.
+	CounterNames []string `json:"counterNames,omitempty"`
+	ListNames    []string `json:"listNames,omitempty"`
+	// PlayerList is the List the player ID is added to on every allocation and
+	// join, and removed from with the player's token. Allocated GameServers are
+	// only picked when it has room.
+	PlayerList string `json:"playerList,omitempty"`
+	// Scheduling is the allocation strategy, "Packed" (default) or "Distributed"
+	Scheduling string `json:"scheduling,omitempty"`
+	// AllowSchedulingOverride lets requests choose their own strategy; otherwise
+	// requests that set one fail
+	AllowSchedulingOverride bool `json:"allowSchedulingOverride,omitempty"`
+}
+
+// Scheduling strategies, as named by Agones
+const (
+	SchedulingPacked      = "Packed"
+	SchedulingDistributed = "Distributed"
+)
+
+// Priority types and orders, and Counter actions, as named by Agones
+const (
+	PriorityCounter  = "Counter"
+	PriorityList     = "List"
+	OrderAscending   = "Ascending"
+	OrderDescending  = "Descending"
+	CounterIncrement = "Increment"
+	CounterDecrement = "Decrement"
+)
+
+// Priority sorts GameServers by a Counter's or List's available capacity
+type Priority struct {
+	Type  string `json:"type"`
+	Key   string `json:"key"`
+	Order string `json:"order,omitempty"` // Ascending (default) or Descending
+}
+
+// CounterAction changes a Counter on the allocated GameServer
+type CounterAction struct {
+	Action   string `json:"action,omitempty"` // Increment or Decrement by Amount
+	Amount   int64  `json:"amount,omitempty"`
+	Capacity *int64 `json:"capacity,omitempty"`
+}
+
+// ListAction changes a List on the allocated GameServer
+type ListAction struct {
+	AddValues    []string `json:"addValues,omitempty"`
+	DeleteValues []string `json:"deleteValues,omitempty"`
+	Capacity     *int64   `json:"capacity,omitempty"`
+}
+
+// Validate checks the priority's type and order
+func (p Priority) Validate() error {
+	if p.Type != PriorityCounter && p.Type != PriorityList {
+		return fmt.Errorf("priority %q: type must be Counter or List, got %q", p.Key, p.Type)
+	}
+	if p.Key == "" {
+		return fmt.Errorf("%s priority requires a key", p.Type)
+	}
+	switch p.Order {
+	case "", OrderAscending, OrderDescending:
+	default:
+		return fmt.Errorf("priority %q: order must be Ascending or Descending, got %q", p.Key, p.Order)
+	}
+	return nil
+}
+
+// Validate checks the action and that Amount and Capacity are usable
+func (a CounterAction) Validate() error {
+	switch a.Action {
+	case "":
+		if a.Amount != 0 {
+			return errors.New("amount requires an action")
+		}
+	case CounterIncrement, CounterDecrement:
+		if a.Amount <= 0 {
+			return fmt.Errorf("%s requires a positive amount", a.Action)
+		}
+	default:
+		return fmt.Errorf("action must be Increment or Decrement, got %q", a.Action)
+	}
+	if a.Capacity != nil && *a.Capacity < 0 {
+		return errors.New("capacity must not be negative")
+	}
+	return nil
+}
+
+// Validate checks that Capacity is usable
+func (a ListAction) Validate() error {
+	if a.Capacity != nil && *a.Capacity < 0 {
+		return errors.New("capacity must not be negative")
+	}
+	return nil
+}
+
+// AllowsSelectorLabel reports whether requests for the fleet may select on the label key
+func (fc FleetConfig) AllowsSelectorLabel(key string) bool {
+	return allows(fc.SelectorLabels, key)
+}
+
+// AllowsCounter reports whether requests for the fleet may use the Counter
+func (fc FleetConfig) AllowsCounter(name string) bool {
+	return allows(fc.CounterNames, name)
+}
+
+// AllowsList reports whether requests for the fleet may use the List
+func (fc FleetConfig) AllowsList(name string) bool {
+	return allows(fc.ListNames, name)
+}
+
+// allows reports whether an allow-list names key or holds the "*" wildcard
+func allows(names []string, key string) bool {
+	return slices.Contains(names, key) || slices.Contains(names, "*")
+}
+
+// Duration is a time.Duration written as a Go duration string ("90s", "5m") in JSON
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"5m\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// FleetConfigs maps fleet names to their config
+type FleetConfigs map[string]FleetConfig
+
+// For returns the config for fleet, falling back to the "*" entry
+func (f FleetConfigs) For(fleet string) FleetConfig {
+	if fc, ok := f[fleet]; ok {
+		return fc
+	}
+	return f[DefaultFleet]
+}
+
+// validate checks the enumerated fields of every entry
+func (f FleetConfigs) validate() error {
+	for name, fc := range f {
+		switch fc.Capacity.Source {
+		case "", CapacityPlayers:
+		case CapacityCounter, CapacityList, CapacityAnnotation:
+			if fc.Capacity.Name == "" {
+				return fmt.Errorf("fleet %q: capacity source %q requires a name", name, fc.Capacity.Source)
+			}
+		default:
+			return fmt.Errorf("fleet %q: unknown capacity source %q", name, fc.Capacity.Source)
+		}
+		switch fc.WhenFull {
+		case "", WhenFullReject, WhenFullSpillover, WhenFullQueue:
+		default:
+			return fmt.Errorf("fleet %q: unknown whenFull %q", name, fc.WhenFull)
+		}
+		if fc.MaxQueueWait < 0 {
+			return fmt.Errorf("fleet %q: maxQueueWait must not be negative", name)
+		}
+		if fc.MaxPriority < 0 {
+			return fmt.Errorf("fleet %q: maxPriority must not be negative", name)
+		}
+		switch fc.Scheduling {
+		case "", SchedulingPacked, SchedulingDistributed:
+		default:
+			return fmt.Errorf("fleet %q: unknown scheduling %q", name, fc.Scheduling)
+		}
+		for _, p := range fc.Priorities {
+			if err := p.Validate(); err != nil {
+				return fmt.Errorf("fleet %q: %w", name, err)
+			}
+		}
+		for counter, a := range fc.Counters {
+			if err := a.Validate(); err != nil {
+				return fmt.Errorf("fleet %q: counter %q: %w", name, counter, err)
+			}
+		}
+		for list, a := range fc.Lists {
+			if err := a.Validate(); err != nil {
+				return fmt.Errorf("fleet %q: list %q: %w", name, list, err)
+			}
+		}
+	}
+	return nil
+}
+
+// loadFleetConfigs parses inline JSON, or the file at path when inline is empty
+func loadFleetConfigs(inline, path string) (FleetConfigs, error) {
+	raw := []byte(inline)
+	if inline == "" {
+		if path == "" {
+			return FleetConfigs{}, nil
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		raw = b
+	}
+	fleets := FleetConfigs{}
+	if err := json.Unmarshal(raw, &fleets); err != nil {
+		return nil, err
+	}
+	if err := fleets.validate(); err != nil {
+		return nil, err
+	}
+	return fleets, nil
+}
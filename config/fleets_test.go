@@ -0,0 +1,141 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_loadFleetConfigs(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "fleets.json")
+	if err := os.WriteFile(file, []byte(`{"file-fleet":{"whenFull":"spillover"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		inline  string
+		path    string
+		want    FleetConfigs
+		wantErr bool
+	}{
+		{name: "none", want: FleetConfigs{}},
+		{
+			name:   "inline",
+			inline: `{"a":{"capacity":{"source":"list","name":"players"},"whenFull":"spillover"},"*":{"capacity":{"source":"players"}}}`,
+			want: FleetConfigs{
+				"a": {Capacity: CapacityConfig{Source: CapacityList, Name: "players"}, WhenFull: WhenFullSpillover},
+				"*": {Capacity: CapacityConfig{Source: CapacityPlayers}},
+			},
+		},
+		{name: "inline wins over file", inline: `{}`, path: file, want: FleetConfigs{}},
+		{name: "file", path: file, want: FleetConfigs{"file-fleet": {WhenFull: WhenFullSpillover}}},
+		{name: "missing file", path: filepath.Join(dir, "nope.json"), wantErr: true},
+		{name: "bad json", inline: `{`, wantErr: true},
+		{name: "unknown source", inline: `{"a":{"capacity":{"source":"magic"}}}`, wantErr: true},
+		{name: "list without name", inline: `{"a":{"capacity":{"source":"list"}}}`, wantErr: true},
+		{name: "unknown whenFull", inline: `{"a":{"whenFull":"explode"}}`, wantErr: true},
+		{
+			name:   "max queue wait",
+			inline: `{"a":{"whenFull":"queue","maxQueueWait":"5m"}}`,
+			want:   FleetConfigs{"a": {WhenFull: WhenFullQueue, MaxQueueWait: Duration(5 * time.Minute)}},
+		},
+		{name: "max queue wait not a string", inline: `{"a":{"maxQueueWait":300}}`, wantErr: true},
+		{name: "negative max queue wait", inline: `{"a":{"maxQueueWait":"-1s"}}`, wantErr: true},
+		{name: "negative max priority", inline: `{"a":{"maxPriority":-1}}`, wantErr: true},
+		{
+			name:   "selector labels",
+			inline: `{"a":{"selectorLabels":["map","mode"]}}`,
+			want:   FleetConfigs{"a": {SelectorLabels: []string{"map", "mode"}}},
+		},
+		{
+			name:   "priorities and actions",
+			inline: `{"a":{"priorities":[{"type":"List","key":"players","order":"Ascending"}],"counters":{"rooms":{"action":"Increment","amount":1}},"lists":{"players":{"capacity":10}},"playerList":"players"}}`,
+			want: FleetConfigs{"a": {
+				Priorities: []Priority{{Type: PriorityList, Key: "players", Order: OrderAscending}},
+				Counters:   map[string]CounterAction{"rooms": {Action: CounterIncrement, Amount: 1}},
+				Lists:      map[string]ListAction{"players": {Capacity: ptr(int64(10))}},
+				PlayerList: "players",
+			}},
+		},
+		{
+			name:   "scheduling",
+			inline: `{"a":{"scheduling":"Distributed","allowSchedulingOverride":true}}`,
+			want:   FleetConfigs{"a": {Scheduling: SchedulingDistributed, AllowSchedulingOverride: true}},
+		},
+		{name: "unknown scheduling", inline: `{"a":{"scheduling":"Spread"}}`, wantErr: true},
+		{name: "unknown priority type", inline: `{"a":{"priorities":[{"type":"Players","key":"p"}]}}`, wantErr: true},
+		{name: "priority without key", inline: `{"a":{"priorities":[{"type":"Counter"}]}}`, wantErr: true},
+		{name: "unknown priority order", inline: `{"a":{"priorities":[{"type":"Counter","key":"rooms","order":"Random"}]}}`, wantErr: true},
+		{name: "unknown counter action", inline: `{"a":{"counters":{"rooms":{"action":"Set","amount":1}}}}`, wantErr: true},
+		{name: "counter action without amount", inline: `{"a":{"counters":{"rooms":{"action":"Increment"}}}}`, wantErr: true},
+		{name: "negative list capacity", inline: `{"a":{"lists":{"players":{"capacity":-1}}}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadFleetConfigs(tt.inline, tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadFleetConfigs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadFleetConfigs()\n got=%#v\nwant=%#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_FleetConfigs_For(t *testing.T) {
+	fleets := FleetConfigs{
+		"a":          {WhenFull: WhenFullSpillover},
+		DefaultFleet: {WhenFull: WhenFullReject},
+	}
+	if got := fleets.For("a").WhenFull; got != WhenFullSpillover {
+		t.Errorf("For(a).WhenFull = %q, want %q", got, WhenFullSpillover)
+	}
+	if got := fleets.For("b").WhenFull; got != WhenFullReject {
+		t.Errorf("For(b).WhenFull = %q, want default %q", got, WhenFullReject)
+	}
+	if got := (FleetConfigs{}).For("b"); !reflect.DeepEqual(got, FleetConfig{}) {
+		t.Errorf("empty For(b) = %#v, want zero value", got)
+	}
+}
+
+func Test_FleetConfig_AllowsSelectorLabel(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels []string
+		key    string
+		want   bool
+	}{
+		{name: "none allowed by default", key: "map", want: false},
+		{name: "listed", labels: []string{"map", "mode"}, key: "mode", want: true},
+		{name: "not listed", labels: []string{"map"}, key: "version", want: false},
+		{name: "wildcard", labels: []string{"*"}, key: "version", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := FleetConfig{SelectorLabels: tt.labels}
+			if got := fc.AllowsSelectorLabel(tt.key); got != tt.want {
+				t.Errorf("AllowsSelectorLabel(%q) = %v, want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_FleetConfig_AllowsCounterAndList(t *testing.T) {
+	fc := FleetConfig{CounterNames: []string{"rooms"}, ListNames: []string{"*"}}
+	if !fc.AllowsCounter("rooms") || fc.AllowsCounter("sessions") {
+		t.Error("AllowsCounter() doesn't follow counterNames")
+	}
+	if !fc.AllowsList("party") {
+		t.Error("AllowsList() doesn't honour the wildcard")
+	}
+	if (FleetConfig{}).AllowsList("party") {
+		t.Error("AllowsList() allows lists by default")
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
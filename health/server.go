@@ -4,13 +4,23 @@ import (
 	"net/http"
 )
 
-func Register(mux *http.ServeMux) {
+// ReadinessCheck returns a non-nil error while a component is not ready to serve
+type ReadinessCheck func() error
+
+// Register adds /healthz and /readyz to mux. /readyz reports 503 until every check passes.
+func Register(mux *http.ServeMux, checks ...ReadinessCheck) {
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ok"))
 	})
 	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
-		// In future, check subscriber/allocator readiness
+		for _, check := range checks {
+			if err := check(); err != nil {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = w.Write([]byte(err.Error()))
+				return
+			}
+		}
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ready"))
 	})
@@ -1,6 +1,7 @@
 package health
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -12,18 +13,37 @@ func TestRegister_Handlers(t *testing.T) {
 		body string
 	}
 	tests := []struct {
-		name string
-		path string
-		want want
+		name   string
+		path   string
+		checks []ReadinessCheck
+		want   want
 	}{
 		{name: "healthz ok", path: "/healthz", want: want{code: http.StatusOK, body: "ok"}},
 		{name: "readyz ok", path: "/readyz", want: want{code: http.StatusOK, body: "ready"}},
+		{
+			name:   "readyz checks pass",
+			path:   "/readyz",
+			checks: []ReadinessCheck{func() error { return nil }},
+			want:   want{code: http.StatusOK, body: "ready"},
+		},
+		{
+			name:   "readyz check fails",
+			path:   "/readyz",
+			checks: []ReadinessCheck{func() error { return nil }, func() error { return errors.New("cache not synced") }},
+			want:   want{code: http.StatusServiceUnavailable, body: "cache not synced"},
+		},
+		{
+			name:   "healthz ignores checks",
+			path:   "/healthz",
+			checks: []ReadinessCheck{func() error { return errors.New("cache not synced") }},
+			want:   want{code: http.StatusOK, body: "ok"},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mux := http.NewServeMux()
-			Register(mux)
+			Register(mux, tt.checks...)
 
 			rec := httptest.NewRecorder()
 			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
@@ -16,6 +16,69 @@ var (
 		[]string{"result"}, // success|failure
 	)
 
+	TicketReplaysTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "allocator_ticket_replays_total",
+			Help: "Redelivered tickets answered from the ticket ledger instead of allocating again",
+		},
+	)
+
+	TokenUpdateRetries = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "allocator_token_update_retries",
+			Help:    "Conflict retries needed to apply a GameServer token annotation change",
+			Buckets: []float64{0, 1, 2, 3, 5},
+		},
+		[]string{"op"}, // add|remove
+	)
+
+	QueuedPlayers = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "allocator_queued_players",
+			Help: "Players waiting in friend-join queues",
+		},
+	)
+
+	QueueWaitDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "allocator_queue_wait_seconds",
+			Help:    "Time queued players waited before their final result",
+			Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600},
+		},
+		[]string{"result"}, // success|failure|expired|cancelled
+	)
+
+	Leader = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "allocator_leader",
+			Help: "1 while this replica holds the allocator lease",
+		},
+	)
+
+	WebhookDeliveries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "allocator_webhook_deliveries_total",
+			Help: "Webhook result delivery attempts by outcome",
+		},
+		[]string{"outcome"}, // delivered|retried|failed|dropped
+	)
+
+	DeadLetters = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "allocator_dead_letters_total",
+			Help: "Requests moved to a dead-letter topic or stream by reason",
+		},
+		[]string{"reason"}, // malformed|max-deliveries
+	)
+
+	GameServerAllocations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "allocator_gameserver_allocations_total",
+			Help: "GameServerAllocations created by cluster, scheduling strategy and resulting state",
+		},
+		[]string{"cluster", "scheduling", "state"}, // registry cluster ("" for local), Packed|Distributed, Allocated|UnAllocated|Contention|error
+	)
+
 	AllocationDuration = prometheus.NewHistogram(
 		prometheus.HistogramOpts{
 			Name:    "allocator_allocation_duration_seconds",
@@ -28,6 +91,14 @@ var (
 func init() {
 	prometheus.MustRegister(AllocationsTotal)
 	prometheus.MustRegister(AllocationDuration)
+	prometheus.MustRegister(TicketReplaysTotal)
+	prometheus.MustRegister(TokenUpdateRetries)
+	prometheus.MustRegister(QueuedPlayers)
+	prometheus.MustRegister(QueueWaitDuration)
+	prometheus.MustRegister(Leader)
+	prometheus.MustRegister(WebhookDeliveries)
+	prometheus.MustRegister(DeadLetters)
+	prometheus.MustRegister(GameServerAllocations)
 }
 
 func Register(mux *http.ServeMux) {
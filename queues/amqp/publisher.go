@@ -0,0 +1,101 @@
+package amqp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"agones-pubsub-allocator/queues"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog/log"
+)
+
+// publishChannel is the part of *amqp.Channel the Publisher uses
+type publishChannel interface {
+	PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) (*amqp.DeferredConfirmation, error)
+	IsClosed() bool
+}
+
+// Publisher publishes allocation results as persistent JSON messages and waits for
+// the broker confirm. With UseReplyTo, results for a request that set reply_to go
+// to that queue through the default exchange, carrying its correlation_id.
+type Publisher struct {
+	opts    Options
+	replies *replyRoutes
+	mu      sync.Mutex
+	conn    *amqp.Connection
+	ch      publishChannel
+}
+
+func (p *Publisher) PublishResult(ctx context.Context, res *queues.AllocationResult) error {
+	ch, err := p.channel()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		log.Error().Err(err).Interface("result", res).Msg("failed to marshal allocation result")
+		return err
+	}
+
+	exchange, key, correlationID := p.opts.ResultExchange, p.opts.ResultRoutingKey, res.TicketID
+	if route, ok := p.replies.take(res); ok {
+		exchange, key = "", route.replyTo
+		if route.correlationID != "" {
+			correlationID = route.correlationID
+		}
+	}
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, false, false, amqp.Publishing{
+		ContentType:   "application/json",
+		DeliveryMode:  amqp.Persistent,
+		CorrelationId: correlationID,
+		Body:          b,
+	})
+	if err != nil {
+		log.Error().Err(err).Str("ticketId", res.TicketID).Msg("failed to publish allocation result")
+		return err
+	}
+	if confirm != nil {
+		acked, err := confirm.WaitContext(ctx)
+		if err != nil {
+			return err
+		}
+		if !acked {
+			log.Error().Str("ticketId", res.TicketID).Msg("broker nacked allocation result")
+			return errors.New("amqp: broker nacked result")
+		}
+	}
+	log.Debug().Str("exchange", exchange).Str("routingKey", key).Str("ticketId", res.TicketID).Str("status", string(res.Status)).Msg("published allocation result")
+	return nil
+}
+
+// channel returns an open confirm-mode channel, redialling after connection loss
+func (p *Publisher) channel() (publishChannel, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ch != nil && !p.ch.IsClosed() {
+		return p.ch, nil
+	}
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	conn, err := amqp.Dial(p.opts.URL)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to connect amqp publisher")
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err == nil {
+		err = ch.Confirm(false)
+	}
+	if err != nil {
+		conn.Close()
+		log.Error().Err(err).Msg("failed to open amqp publisher channel")
+		return nil, err
+	}
+	p.conn, p.ch = conn, ch
+	log.Info().Str("exchange", p.opts.ResultExchange).Str("routingKey", p.opts.ResultRoutingKey).Msg("amqp publisher initialized")
+	return ch, nil
+}
@@ -0,0 +1,72 @@
+package amqp
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"agones-pubsub-allocator/queues"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type published struct {
+	exchange, key string
+	msg           amqp.Publishing
+}
+
+// fakeChannel captures publishes; a nil confirmation skips the confirm wait
+type fakeChannel struct {
+	published []published
+}
+
+func (f *fakeChannel) PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) (*amqp.DeferredConfirmation, error) {
+	f.published = append(f.published, published{exchange: exchange, key: key, msg: msg})
+	return nil, nil
+}
+
+func (f *fakeChannel) IsClosed() bool { return false }
+
+func TestPublisher_PublishResult(t *testing.T) {
+	_, pub := NewTransport(Options{ResultExchange: "results", ResultRoutingKey: "allocator.results", UseReplyTo: true})
+	ch := &fakeChannel{}
+	pub.ch = ch
+	pub.replies.set("t2", "client-q", "c2")
+	ctx := context.Background()
+
+	results := []*queues.AllocationResult{
+		{TicketID: "t1", Status: queues.StatusSuccess},
+		{TicketID: "t2", Status: queues.StatusQueued},
+		{TicketID: "t2", Status: queues.StatusSuccess},
+		// The route is forgotten after the final result
+		{TicketID: "t2", Status: queues.StatusSuccess},
+	}
+	for _, res := range results {
+		if err := pub.PublishResult(ctx, res); err != nil {
+			t.Fatalf("PublishResult(%s) error: %v", res.TicketID, err)
+		}
+	}
+
+	want := []struct{ exchange, key, correlationID string }{
+		{"results", "allocator.results", "t1"},
+		{"", "client-q", "c2"},
+		{"", "client-q", "c2"},
+		{"results", "allocator.results", "t2"},
+	}
+	if len(ch.published) != len(want) {
+		t.Fatalf("published %d messages, want %d", len(ch.published), len(want))
+	}
+	for i, w := range want {
+		got := ch.published[i]
+		if got.exchange != w.exchange || got.key != w.key || got.msg.CorrelationId != w.correlationID {
+			t.Errorf("publish %d went to %q/%q (correlation %q), want %q/%q (%q)", i, got.exchange, got.key, got.msg.CorrelationId, w.exchange, w.key, w.correlationID)
+		}
+		if got.msg.DeliveryMode != amqp.Persistent || got.msg.ContentType != "application/json" {
+			t.Errorf("publish %d is not persistent JSON: %#v", i, got.msg)
+		}
+	}
+	var res queues.AllocationResult
+	if err := json.Unmarshal(ch.published[0].msg.Body, &res); err != nil || res.TicketID != "t1" {
+		t.Errorf("body = %s, %v; want the t1 result", ch.published[0].msg.Body, err)
+	}
+}
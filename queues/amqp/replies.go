@@ -0,0 +1,76 @@
+package amqp
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"agones-pubsub-allocator/queues"
+)
+
+// replyTTL bounds how long a ticket's reply_to route is kept without a final result
+const replyTTL = time.Hour
+
+// replySweepInterval is how often routes past replyTTL are dropped
+const replySweepInterval = time.Minute
+
+type replyRoute struct {
+	replyTo       string
+	correlationID string
+	seen          time.Time
+}
+
+// replyRoutes remembers the reply_to queue of each request so its results can be
+// sent there. Routes are dropped after a final result or replyTTL.
+type replyRoutes struct {
+	mu     sync.Mutex
+	routes map[string]replyRoute
+	now    func() time.Time
+}
+
+func newReplyRoutes() *replyRoutes {
+	return &replyRoutes{routes: make(map[string]replyRoute), now: time.Now}
+}
+
+func (r *replyRoutes) set(ticketID, replyTo, correlationID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes[ticketID] = replyRoute{replyTo: replyTo, correlationID: correlationID, seen: r.now()}
+}
+
+// sweep drops routes older than replyTTL
+func (r *replyRoutes) sweep() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	for id, route := range r.routes {
+		if now.Sub(route.seen) > replyTTL {
+			delete(r.routes, id)
+		}
+	}
+}
+
+// run sweeps every interval until ctx is done
+func (r *replyRoutes) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.sweep()
+		}
+	}
+}
+
+// take returns the route for a result, forgetting it once the result is final
+func (r *replyRoutes) take(res *queues.AllocationResult) (replyRoute, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	route, ok := r.routes[res.TicketID]
+	if ok && res.Status != queues.StatusQueued {
+		delete(r.routes, res.TicketID)
+	}
+	return route, ok
+}
@@ -0,0 +1,270 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"agones-pubsub-allocator/metrics"
+	"agones-pubsub-allocator/queues"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog/log"
+)
+
+// Options configures the AMQP 0-9-1 broker, queue and exchange
+type Options struct {
+	URL string
+	// Durable queue requests are consumed from; declared if missing
+	RequestQueue string
+	// Unacked requests the broker delivers at once (default 10)
+	Prefetch int
+	// Exchange and routing key results are published to ("" is the default exchange)
+	ResultExchange   string
+	ResultRoutingKey string
+	// Send results to the request's reply_to queue when it has one
+	UseReplyTo bool
+	// Consumer tag; empty lets the broker pick one
+	ConsumerTag string
+	// Requests failing transiently this many deliveries (default 5) are rejected
+	// without requeue, to the queue's dead-letter exchange if it has one
+	MaxDeliveries int
+}
+
+const (
+	defaultPrefetch      = 10
+	defaultMaxDeliveries = 5
+	reconnectMin         = time.Second
+	reconnectMax         = 30 * time.Second
+	retryMin             = 500 * time.Millisecond
+	retryMax             = 30 * time.Second
+	// Set by quorum queues on redelivery
+	headerDeliveryCount = "x-delivery-count"
+	// Set on the copy requeued after a failed attempt on other queues
+	headerAttempts = "x-allocator-attempts"
+)
+
+// NewTransport returns a Subscriber and Publisher that share reply_to routes
+func NewTransport(opts Options) (*Subscriber, *Publisher) {
+	if opts.Prefetch <= 0 {
+		opts.Prefetch = defaultPrefetch
+	}
+	if opts.MaxDeliveries <= 0 {
+		opts.MaxDeliveries = defaultMaxDeliveries
+	}
+	replies := newReplyRoutes()
+	return &Subscriber{opts: opts, replies: replies, retryMin: retryMin}, &Publisher{opts: opts, replies: replies}
+}
+
+// Subscriber consumes allocation requests with manual acks. A transiently failed
+// request is requeued after a backoff, up to MaxDeliveries deliveries, and then
+// rejected. Quorum queues count deliveries themselves; on other queues the
+// request is republished with an attempts header and the original acked. Lost
+// connections are redialled with backoff.
+type Subscriber struct {
+	opts    Options
+	replies *replyRoutes
+	// First requeue backoff, doubling per attempt up to retryMax
+	retryMin time.Duration
+}
+
+// requeuer republishes a failed request; *amqp.Channel implements it
+type requeuer interface {
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// Start consumes until ctx is done, then waits for in-flight handlers before closing
+// the channel; prefetched requests that were not started are requeued by the broker.
+func (s *Subscriber) Start(ctx context.Context, handler func(context.Context, *queues.AllocationRequest) error) error {
+	if s.opts.UseReplyTo {
+		go s.replies.run(ctx, replySweepInterval)
+	}
+	backoff := reconnectMin
+	for {
+		err := s.consume(ctx, handler)
+		if ctx.Err() != nil {
+			return nil
+		}
+		log.Error().Err(err).Str("queue", s.opts.RequestQueue).Dur("backoff", backoff).Msg("amqp consumer stopped; reconnecting")
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, reconnectMax)
+	}
+}
+
+// consume runs one connection until ctx is done or the connection fails
+func (s *Subscriber) consume(ctx context.Context, handler func(context.Context, *queues.AllocationRequest) error) error {
+	conn, err := amqp.Dial(s.opts.URL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	if err := ch.Qos(s.opts.Prefetch, 0, false); err != nil {
+		return err
+	}
+	if _, err := ch.QueueDeclare(s.opts.RequestQueue, true, false, false, false, nil); err != nil {
+		return err
+	}
+	deliveries, err := ch.Consume(s.opts.RequestQueue, s.opts.ConsumerTag, false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("queue", s.opts.RequestQueue).Int("prefetch", s.opts.Prefetch).Msg("amqp subscriber initialized")
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case d, ok := <-deliveries:
+			if !ok {
+				return errors.New("amqp delivery channel closed")
+			}
+			// The broker caps unacked deliveries at Prefetch, which bounds these goroutines
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.handle(ctx, ch, d, handler)
+			}()
+		}
+	}
+}
+
+func (s *Subscriber) handle(ctx context.Context, ch requeuer, d amqp.Delivery, handler func(context.Context, *queues.AllocationRequest) error) {
+	log.Debug().Str("queue", s.opts.RequestQueue).Uint64("deliveryTag", d.DeliveryTag).Int("size", len(d.Body)).Msg("received amqp message")
+	recvAt := time.Now()
+	req, err := queues.DecodeRequest(d.Body)
+	if err != nil {
+		if queues.Drop(err) {
+			log.Warn().Err(err).Str("queue", s.opts.RequestQueue).Msg("dropping message")
+			ack(d)
+			return
+		}
+		// Requeueing can't fix the body; reject so a dead-letter exchange can pick it up
+		log.Error().Err(err).Str("queue", s.opts.RequestQueue).Msg("failed to decode allocation request; rejecting")
+		s.reject(d, "malformed")
+		return
+	}
+	if s.opts.UseReplyTo && d.ReplyTo != "" {
+		s.replies.set(req.TicketID, d.ReplyTo, d.CorrelationId)
+	}
+	log.Info().Str("queue", s.opts.RequestQueue).Str("type", req.Type).Str("ticketId", req.TicketID).Str("fleet", req.Fleet).Str("playerId", req.PlayerID).Msg("handling allocation request")
+	if err := handler(ctx, req); err != nil {
+		if queues.IsPermanent(err) {
+			log.Warn().Err(err).Str("queue", s.opts.RequestQueue).Str("ticketId", req.TicketID).Msg("request failed permanently; acking message")
+			ack(d)
+			return
+		}
+		attempts := deliveryAttempts(d)
+		if attempts >= s.opts.MaxDeliveries {
+			log.Error().Err(err).Str("queue", s.opts.RequestQueue).Str("ticketId", req.TicketID).Int("attempts", attempts).Msg("handler failed; giving up and rejecting")
+			s.reject(d, "max-deliveries")
+			return
+		}
+		backoff := min(s.retryMin<<min(attempts-1, 16), retryMax)
+		log.Error().Err(err).Str("queue", s.opts.RequestQueue).Str("ticketId", req.TicketID).Int("attempts", attempts).Dur("backoff", backoff).Msg("handler failed; will retry")
+		s.requeue(ctx, ch, d, attempts, backoff)
+		return
+	}
+	log.Debug().Str("queue", s.opts.RequestQueue).Str("ticketId", req.TicketID).Dur("latency", time.Since(recvAt)).Msg("handler succeeded; acking message")
+	ack(d)
+}
+
+// requeue puts a failed request back on the queue after backoff. The delivery
+// holds its prefetch slot meanwhile, which throttles redelivery while the
+// handler keeps failing.
+func (s *Subscriber) requeue(ctx context.Context, ch requeuer, d amqp.Delivery, attempts int, backoff time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(backoff):
+	}
+	if _, ok := d.Headers[headerDeliveryCount]; ok || ctx.Err() != nil {
+		nack(d)
+		return
+	}
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[headerAttempts] = int32(attempts)
+	err := ch.PublishWithContext(ctx, "", s.opts.RequestQueue, false, false, amqp.Publishing{
+		Headers:       headers,
+		ContentType:   d.ContentType,
+		DeliveryMode:  d.DeliveryMode,
+		CorrelationId: d.CorrelationId,
+		ReplyTo:       d.ReplyTo,
+		MessageId:     d.MessageId,
+		Timestamp:     d.Timestamp,
+		Type:          d.Type,
+		AppId:         d.AppId,
+		Body:          d.Body,
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("queue", s.opts.RequestQueue).Msg("failed to requeue amqp message with attempts; nacking instead")
+		nack(d)
+		return
+	}
+	ack(d)
+}
+
+// reject drops a request without requeue, to the queue's dead-letter exchange if any
+func (s *Subscriber) reject(d amqp.Delivery, reason string) {
+	if err := d.Reject(false); err != nil {
+		log.Warn().Err(err).Str("queue", s.opts.RequestQueue).Msg("failed to reject amqp message")
+		return
+	}
+	metrics.DeadLetters.WithLabelValues(reason).Inc()
+}
+
+// deliveryAttempts counts this delivery of a request, from the quorum queue's
+// delivery count or the attempts header, plus one if the broker redelivered it
+// after an unsettled attempt
+func deliveryAttempts(d amqp.Delivery) int {
+	if n, ok := headerInt(d.Headers[headerDeliveryCount]); ok {
+		return n + 1
+	}
+	n, _ := headerInt(d.Headers[headerAttempts])
+	if d.Redelivered {
+		n++
+	}
+	return n + 1
+}
+
+// headerInt reads an integer header of any AMQP integer type
+func headerInt(v any) (int, bool) {
+	switch n := v.(type) {
+	case int8:
+		return int(n), true
+	case int16:
+		return int(n), true
+	case int32:
+		return int(n), true
+	case int64:
+		return int(n), true
+	case int:
+		return n, true
+	}
+	return 0, false
+}
+
+func nack(d amqp.Delivery) {
+	if err := d.Nack(false, true); err != nil {
+		log.Warn().Err(err).Uint64("deliveryTag", d.DeliveryTag).Msg("failed to nack amqp message")
+	}
+}
+
+func ack(d amqp.Delivery) {
+	if err := d.Ack(false); err != nil {
+		log.Warn().Err(err).Uint64("deliveryTag", d.DeliveryTag).Msg("failed to ack amqp message")
+	}
+}
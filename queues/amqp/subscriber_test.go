@@ -0,0 +1,181 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"agones-pubsub-allocator/queues"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeAcknowledger records how a delivery was settled
+type fakeAcknowledger struct {
+	outcome string
+}
+
+func (f *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	f.outcome = "ack"
+	return nil
+}
+
+func (f *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	f.outcome = "nack"
+	if requeue {
+		f.outcome = "nack-requeue"
+	}
+	return nil
+}
+
+func (f *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	f.outcome = "reject"
+	return nil
+}
+
+// fakeRequeuer captures requests republished for another attempt
+type fakeRequeuer struct {
+	published []published
+}
+
+func (f *fakeRequeuer) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	f.published = append(f.published, published{exchange: exchange, key: key, msg: msg})
+	return nil
+}
+
+func TestSubscriber_handle(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		handlerErr error
+		want       string
+		wantCalled bool
+	}{
+		{name: "success acks", body: `{"ticketId":"t1","fleet":"f"}`, want: "ack", wantCalled: true},
+		{name: "permanent handler error acks", body: `{"ticketId":"t1","fleet":"f"}`, handlerErr: queues.Permanent(errors.New("boom")), want: "ack", wantCalled: true},
+		{name: "non-request is dropped", body: `{"type":"allocation-result","ticketId":"t1"}`, want: "ack"},
+		{name: "invalid request is dropped", body: `{"ticketId":"t1"}`, want: "ack"},
+		{name: "malformed is rejected", body: `{`, want: "reject"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, _ := NewTransport(Options{RequestQueue: "requests"})
+			acker := &fakeAcknowledger{}
+			called := false
+			sub.handle(context.Background(), &fakeRequeuer{}, amqp.Delivery{Acknowledger: acker, Body: []byte(tt.body)}, func(context.Context, *queues.AllocationRequest) error {
+				called = true
+				return tt.handlerErr
+			})
+			if acker.outcome != tt.want {
+				t.Errorf("delivery settled with %q, want %q", acker.outcome, tt.want)
+			}
+			if called != tt.wantCalled {
+				t.Errorf("handler called = %v, want %v", called, tt.wantCalled)
+			}
+		})
+	}
+}
+
+func TestSubscriber_handle_RecordsReplyTo(t *testing.T) {
+	for _, useReplyTo := range []bool{false, true} {
+		sub, _ := NewTransport(Options{RequestQueue: "requests", UseReplyTo: useReplyTo})
+		d := amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: []byte(`{"ticketId":"t1","fleet":"f"}`), ReplyTo: "client-q", CorrelationId: "c1"}
+		sub.handle(context.Background(), &fakeRequeuer{}, d, func(context.Context, *queues.AllocationRequest) error { return nil })
+
+		route, ok := sub.replies.take(&queues.AllocationResult{TicketID: "t1", Status: queues.StatusQueued})
+		if ok != useReplyTo {
+			t.Fatalf("UseReplyTo=%v: route recorded = %v", useReplyTo, ok)
+		}
+		if ok && (route.replyTo != "client-q" || route.correlationID != "c1") {
+			t.Errorf("route = %#v, want client-q/c1", route)
+		}
+	}
+}
+
+func TestSubscriber_handle_Retries(t *testing.T) {
+	tests := []struct {
+		name         string
+		headers      amqp.Table
+		redelivered  bool
+		want         string
+		wantAttempts int32 // of the republished copy, 0 for none
+	}{
+		{name: "first failure republishes", want: "ack", wantAttempts: 1},
+		{name: "counts previous attempts", headers: amqp.Table{"x-allocator-attempts": int32(2)}, want: "ack", wantAttempts: 3},
+		{name: "counts an unsettled redelivery", headers: amqp.Table{"x-allocator-attempts": int32(2)}, redelivered: true, want: "ack", wantAttempts: 4},
+		{name: "rejects at max deliveries", headers: amqp.Table{"x-allocator-attempts": int32(2)}, redelivered: true, want: "reject"},
+		{name: "quorum queue requeues", headers: amqp.Table{"x-delivery-count": int64(1)}, want: "nack-requeue"},
+		{name: "quorum queue rejects at max deliveries", headers: amqp.Table{"x-delivery-count": int64(3)}, want: "reject"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxDeliveries := 5
+			if tt.want == "reject" {
+				maxDeliveries = 4
+			}
+			sub, _ := NewTransport(Options{RequestQueue: "requests", MaxDeliveries: maxDeliveries})
+			sub.retryMin = time.Millisecond
+			acker := &fakeAcknowledger{}
+			ch := &fakeRequeuer{}
+			d := amqp.Delivery{Acknowledger: acker, Headers: tt.headers, Redelivered: tt.redelivered, ReplyTo: "client-q", CorrelationId: "c1", Body: []byte(`{"ticketId":"t1","fleet":"f"}`)}
+			sub.handle(context.Background(), ch, d, func(context.Context, *queues.AllocationRequest) error {
+				return errors.New("boom")
+			})
+			if acker.outcome != tt.want {
+				t.Errorf("delivery settled with %q, want %q", acker.outcome, tt.want)
+			}
+			if tt.wantAttempts == 0 {
+				if len(ch.published) != 0 {
+					t.Fatalf("republished %d copies, want none", len(ch.published))
+				}
+				return
+			}
+			if len(ch.published) != 1 {
+				t.Fatalf("republished %d copies, want 1", len(ch.published))
+			}
+			got := ch.published[0]
+			if got.exchange != "" || got.key != "requests" {
+				t.Errorf("republished to %q/%q, want default exchange/requests", got.exchange, got.key)
+			}
+			if got.msg.Headers["x-allocator-attempts"] != tt.wantAttempts {
+				t.Errorf("attempts header = %v, want %d", got.msg.Headers["x-allocator-attempts"], tt.wantAttempts)
+			}
+			if got.msg.ReplyTo != "client-q" || got.msg.CorrelationId != "c1" || string(got.msg.Body) != string(d.Body) {
+				t.Errorf("republished copy lost reply_to, correlation_id or body: %#v", got.msg)
+			}
+		})
+	}
+}
+
+func TestSubscriber_handle_ShutdownDuringBackoff(t *testing.T) {
+	sub, _ := NewTransport(Options{RequestQueue: "requests"})
+	sub.retryMin = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	acker := &fakeAcknowledger{}
+	ch := &fakeRequeuer{}
+	sub.handle(ctx, ch, amqp.Delivery{Acknowledger: acker, Body: []byte(`{"ticketId":"t1","fleet":"f"}`)}, func(context.Context, *queues.AllocationRequest) error {
+		return errors.New("boom")
+	})
+	if acker.outcome != "nack-requeue" || len(ch.published) != 0 {
+		t.Errorf("settled with %q after %d republishes, want nack-requeue and none", acker.outcome, len(ch.published))
+	}
+}
+
+func TestReplyRoutes_Sweep(t *testing.T) {
+	r := newReplyRoutes()
+	now := time.Now()
+	r.now = func() time.Time { return now }
+	r.set("old", "client-q", "c1")
+	now = now.Add(replyTTL + time.Second)
+	r.set("new", "client-q", "c2")
+
+	r.sweep()
+	if _, ok := r.take(&queues.AllocationResult{TicketID: "old", Status: queues.StatusSuccess}); ok {
+		t.Error("route past replyTTL survived the sweep")
+	}
+	if _, ok := r.take(&queues.AllocationResult{TicketID: "new", Status: queues.StatusSuccess}); !ok {
+		t.Error("fresh route was swept")
+	}
+}
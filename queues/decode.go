@@ -0,0 +1,54 @@
+package queues
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Errors from DecodeRequest. Transports ack (drop) messages failing with
+// ErrNotRequest or ErrInvalidRequest; any other decode error is a malformed
+// payload, which each transport skips, rejects, retries or dead-letters.
+var (
+	ErrNotRequest     = errors.New("not an allocation request")
+	ErrInvalidRequest = errors.New("invalid request payload")
+)
+
+// DecodeRequest parses a request message body shared by every transport.
+// Messages of another type return ErrNotRequest; requests missing ticketId, or
+// fleet for non-cancel requests, return ErrInvalidRequest.
+func DecodeRequest(data []byte) (*AllocationRequest, error) {
+	var env struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("unmarshal message envelope: %w", err)
+	}
+	if env.Type != "" && env.Type != RequestTypeAllocate && env.Type != RequestTypeCancel {
+		return nil, fmt.Errorf("%w: type %q", ErrNotRequest, env.Type)
+	}
+	var req AllocationRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("unmarshal allocation request: %w", err)
+	}
+	if err := req.Validate(); err != nil {
+		return &req, err
+	}
+	return &req, nil
+}
+
+// Validate checks the fields every request needs, returning ErrInvalidRequest
+func (r *AllocationRequest) Validate() error {
+	if r.Type != "" && r.Type != RequestTypeAllocate && r.Type != RequestTypeCancel {
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidRequest, r.Type)
+	}
+	if r.TicketID == "" || (r.Fleet == "" && r.Type != RequestTypeCancel) {
+		return fmt.Errorf("%w: ticketId=%q fleet=%q", ErrInvalidRequest, r.TicketID, r.Fleet)
+	}
+	return nil
+}
+
+// Drop reports whether a DecodeRequest error means the message should be acked and discarded
+func Drop(err error) bool {
+	return errors.Is(err, ErrNotRequest) || errors.Is(err, ErrInvalidRequest)
+}
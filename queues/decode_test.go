@@ -0,0 +1,69 @@
+package queues
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecodeRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		wantErr  error
+		wantDrop bool
+		ticket   string
+	}{
+		{name: "allocation", data: `{"ticketId":"t1","fleet":"f"}`, ticket: "t1"},
+		{name: "typed allocation", data: `{"type":"allocation-request","ticketId":"t1","fleet":"f"}`, ticket: "t1"},
+		{name: "cancel without fleet", data: `{"type":"allocation-cancel","ticketId":"t1"}`, ticket: "t1"},
+		{name: "other type is dropped", data: `{"type":"allocation-result","ticketId":"t1"}`, wantErr: ErrNotRequest, wantDrop: true},
+		{name: "missing fleet is dropped", data: `{"ticketId":"t1"}`, wantErr: ErrInvalidRequest, wantDrop: true},
+		{name: "missing ticket is dropped", data: `{"fleet":"f"}`, wantErr: ErrInvalidRequest, wantDrop: true},
+		{name: "malformed json is retried", data: `{`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := DecodeRequest([]byte(tt.data))
+			if tt.ticket != "" {
+				if err != nil || req.TicketID != tt.ticket {
+					t.Fatalf("DecodeRequest() = %#v, %v; want ticket %s", req, err, tt.ticket)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("DecodeRequest() = %#v, want error", req)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("DecodeRequest() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := Drop(err); got != tt.wantDrop {
+				t.Errorf("Drop(%v) = %v, want %v", err, got, tt.wantDrop)
+			}
+		})
+	}
+}
+
+func TestAllocationRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     AllocationRequest
+		wantErr bool
+	}{
+		{name: "allocation", req: AllocationRequest{TicketID: "t1", Fleet: "f"}},
+		{name: "cancel without fleet", req: AllocationRequest{Type: RequestTypeCancel, TicketID: "t1"}},
+		{name: "unknown type", req: AllocationRequest{Type: "allocation-result", TicketID: "t1", Fleet: "f"}, wantErr: true},
+		{name: "missing fleet", req: AllocationRequest{TicketID: "t1"}, wantErr: true},
+		{name: "missing ticket", req: AllocationRequest{Fleet: "f"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidRequest) {
+				t.Errorf("Validate() error = %v, want ErrInvalidRequest", err)
+			}
+		})
+	}
+}
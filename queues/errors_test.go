@@ -0,0 +1,35 @@
+package queues
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestIsPermanent(t *testing.T) {
+	base := errors.New("boom")
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "plain", err: base, want: false},
+		{name: "permanent", err: Permanent(base), want: true},
+		{name: "wrapped permanent", err: fmt.Errorf("handle: %w", Permanent(base)), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPermanent(tt.err); got != tt.want {
+				t.Errorf("IsPermanent(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+
+	if Permanent(nil) != nil {
+		t.Error("Permanent(nil) != nil")
+	}
+	if err := Permanent(base); !errors.Is(err, base) || err.Error() != "boom" {
+		t.Errorf("Permanent(base) = %v, want to wrap %v", err, base)
+	}
+}
@@ -0,0 +1,214 @@
+// Package file reads newline-delimited JSON requests from a file or stdin and
+// appends results to a file or stdout, for local runs without a message bus.
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"agones-pubsub-allocator/queues"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Stdio is the path meaning stdin for requests and stdout for results
+const Stdio = "-"
+
+const (
+	retryMin     = 500 * time.Millisecond
+	retryMax     = 30 * time.Second
+	pollInterval = 500 * time.Millisecond
+)
+
+type Options struct {
+	// RequestPath is read line by line; "-" or empty reads stdin
+	RequestPath string
+	// ResultPath is appended to, one JSON result per line; "-" or empty writes stdout
+	ResultPath string
+	// Follow keeps reading RequestPath for appended lines after EOF, like tail -f
+	Follow bool
+}
+
+// Subscriber handles one request line at a time, in order. A file can't be
+// nacked, so a failed request is retried with backoff until it succeeds or ctx is
+// done; malformed lines are logged and skipped.
+type Subscriber struct {
+	opts  Options
+	stdin io.Reader
+}
+
+func NewSubscriber(opts Options) *Subscriber {
+	return &Subscriber{opts: opts, stdin: os.Stdin}
+}
+
+// Start reads requests until ctx is done or, without Follow, the input ends
+func (s *Subscriber) Start(ctx context.Context, handler func(context.Context, *queues.AllocationRequest) error) error {
+	in, name, err := s.open()
+	if err != nil {
+		log.Error().Err(err).Str("path", s.opts.RequestPath).Msg("failed to open request file")
+		return err
+	}
+	defer in.Close()
+	log.Info().Str("input", name).Bool("follow", s.opts.Follow).Msg("file subscriber started")
+
+	lines := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- s.read(ctx, in, lines)
+	}()
+
+	line := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-readErr:
+			if err != nil {
+				log.Error().Err(err).Str("input", name).Msg("failed to read requests")
+				return err
+			}
+			log.Info().Str("input", name).Int("lines", line).Msg("end of request input")
+			return nil
+		case b := <-lines:
+			line++
+			if !handle(ctx, name, line, b, handler) {
+				return nil
+			}
+		}
+	}
+}
+
+func (s *Subscriber) open() (io.ReadCloser, string, error) {
+	if s.opts.RequestPath == "" || s.opts.RequestPath == Stdio {
+		return io.NopCloser(s.stdin), "stdin", nil
+	}
+	f, err := os.Open(s.opts.RequestPath)
+	return f, s.opts.RequestPath, err
+}
+
+// read sends each non-blank line on lines. At EOF it returns nil, or with Follow
+// polls for more until ctx is done.
+func (s *Subscriber) read(ctx context.Context, in io.Reader, lines chan<- []byte) error {
+	r := bufio.NewReader(in)
+	var partial []byte
+	for {
+		b, err := r.ReadBytes('\n')
+		partial = append(partial, b...)
+		if err == nil || (errors.Is(err, io.EOF) && !s.opts.Follow && len(partial) > 0) {
+			if line := bytes.TrimSpace(partial); len(line) > 0 {
+				select {
+				case lines <- line:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+			partial = nil
+			if err == nil {
+				continue
+			}
+		}
+		if !errors.Is(err, io.EOF) {
+			return err
+		}
+		if !s.opts.Follow {
+			return nil
+		}
+		// Wait for the rest of the line or the next one to be appended
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// handle processes one line, retrying transient handler failures until they succeed.
+// It reports false only if ctx ended before the line was handled.
+func handle(ctx context.Context, input string, line int, data []byte, handler func(context.Context, *queues.AllocationRequest) error) bool {
+	req, err := queues.DecodeRequest(data)
+	if err != nil {
+		if queues.Drop(err) {
+			log.Warn().Err(err).Str("input", input).Int("line", line).Msg("dropping message")
+		} else {
+			log.Error().Err(err).Str("input", input).Int("line", line).Msg("failed to decode allocation request; skipping")
+		}
+		return true
+	}
+	log.Info().Int("line", line).Str("type", req.Type).Str("ticketId", req.TicketID).Str("fleet", req.Fleet).Str("playerId", req.PlayerID).Msg("handling allocation request")
+
+	backoff := retryMin
+	for {
+		err := handler(ctx, req)
+		if err == nil {
+			return true
+		}
+		if queues.IsPermanent(err) {
+			log.Warn().Err(err).Int("line", line).Str("ticketId", req.TicketID).Msg("request failed permanently; skipping")
+			return true
+		}
+		log.Error().Err(err).Int("line", line).Str("ticketId", req.TicketID).Dur("backoff", backoff).Msg("handler failed; will retry")
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, retryMax)
+	}
+}
+
+// Publisher appends each result as one JSON line
+type Publisher struct {
+	opts   Options
+	mu     sync.Mutex
+	out    io.Writer
+	stdout io.Writer
+}
+
+func NewPublisher(opts Options) *Publisher {
+	return &Publisher{opts: opts, stdout: os.Stdout}
+}
+
+func (p *Publisher) PublishResult(ctx context.Context, res *queues.AllocationResult) error {
+	b, err := json.Marshal(res)
+	if err != nil {
+		log.Error().Err(err).Interface("result", res).Msg("failed to marshal allocation result")
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out, err := p.writer()
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(append(b, '\n')); err != nil {
+		log.Error().Err(err).Str("ticketId", res.TicketID).Msg("failed to write allocation result")
+		return err
+	}
+	log.Debug().Str("ticketId", res.TicketID).Str("status", string(res.Status)).Msg("published allocation result")
+	return nil
+}
+
+// writer opens the result file on first use; the caller holds p.mu
+func (p *Publisher) writer() (io.Writer, error) {
+	if p.out != nil {
+		return p.out, nil
+	}
+	if p.opts.ResultPath == "" || p.opts.ResultPath == Stdio {
+		p.out = p.stdout
+		return p.out, nil
+	}
+	f, err := os.OpenFile(p.opts.ResultPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		log.Error().Err(err).Str("path", p.opts.ResultPath).Msg("failed to open result file")
+		return nil, err
+	}
+	p.out = f
+	return p.out, nil
+}
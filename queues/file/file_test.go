@@ -0,0 +1,161 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"agones-pubsub-allocator/queues"
+)
+
+func TestSubscriber_Start(t *testing.T) {
+	input := strings.Join([]string{
+		`{"ticketId":"t1","fleet":"f"}`,
+		``,
+		`{not json`,
+		`{"type":"allocation-result","ticketId":"r1"}`,
+		`{"ticketId":"missing-fleet"}`,
+		`{"ticketId":"flaky","fleet":"f"}`,
+		`{"type":"allocation-cancel","ticketId":"t1"}`, // no trailing newline
+	}, "\n")
+	path := filepath.Join(t.TempDir(), "requests.ndjson")
+	if err := os.WriteFile(path, []byte(input), 0o600); err != nil {
+		t.Fatalf("write requests: %v", err)
+	}
+
+	var handled []string
+	failures := 1
+	s := NewSubscriber(Options{RequestPath: path})
+	err := s.Start(context.Background(), func(_ context.Context, req *queues.AllocationRequest) error {
+		if req.TicketID == "flaky" && failures > 0 {
+			failures--
+			return errors.New("not ready")
+		}
+		handled = append(handled, req.Type+":"+req.TicketID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	want := []string{":t1", ":flaky", "allocation-cancel:t1"}
+	if strings.Join(handled, ",") != strings.Join(want, ",") {
+		t.Errorf("handled = %v, want %v", handled, want)
+	}
+}
+
+func TestSubscriber_Stdin(t *testing.T) {
+	s := NewSubscriber(Options{RequestPath: Stdio})
+	s.stdin = strings.NewReader(`{"ticketId":"t1","fleet":"f"}` + "\n")
+	var handled int
+	if err := s.Start(context.Background(), func(context.Context, *queues.AllocationRequest) error {
+		handled++
+		return nil
+	}); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	if handled != 1 {
+		t.Errorf("handled = %d, want 1", handled)
+	}
+}
+
+func TestSubscriber_Follow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.ndjson")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatalf("write requests: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handled := make(chan string, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- NewSubscriber(Options{RequestPath: path, Follow: true}).Start(ctx, func(_ context.Context, req *queues.AllocationRequest) error {
+			handled <- req.TicketID
+			return nil
+		})
+	}()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("open requests: %v", err)
+	}
+	defer f.Close()
+	// The line arrives in two writes; it is handled only once complete
+	_, _ = f.WriteString(`{"ticketId":"t1",`)
+	time.Sleep(2 * pollInterval)
+	_, _ = f.WriteString(`"fleet":"f"}` + "\n")
+
+	select {
+	case id := <-handled:
+		if id != "t1" {
+			t.Errorf("handled %q, want t1", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("appended request was not handled")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Start() error: %v", err)
+	}
+}
+
+func TestSubscriber_MissingFile(t *testing.T) {
+	s := NewSubscriber(Options{RequestPath: filepath.Join(t.TempDir(), "missing")})
+	if err := s.Start(context.Background(), func(context.Context, *queues.AllocationRequest) error { return nil }); err == nil {
+		t.Error("Start() with a missing file should fail")
+	}
+}
+
+func TestPublisher_PublishResult(t *testing.T) {
+	tests := []struct {
+		name   string
+		toFile bool
+	}{
+		{name: "file", toFile: true},
+		{name: "stdout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			path := filepath.Join(t.TempDir(), "results.ndjson")
+			opts := Options{ResultPath: Stdio}
+			if tt.toFile {
+				// Results are appended to whatever is already there
+				if err := os.WriteFile(path, []byte("{}\n"), 0o600); err != nil {
+					t.Fatalf("write results: %v", err)
+				}
+				opts.ResultPath = path
+			}
+			p := NewPublisher(opts)
+			p.stdout = &stdout
+			for _, id := range []string{"t1", "t2"} {
+				if err := p.PublishResult(context.Background(), &queues.AllocationResult{TicketID: id, Status: queues.StatusSuccess}); err != nil {
+					t.Fatalf("PublishResult() error: %v", err)
+				}
+			}
+
+			out := stdout.Bytes()
+			if tt.toFile {
+				b, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatalf("read results: %v", err)
+				}
+				out = bytes.TrimPrefix(b, []byte("{}\n"))
+			}
+			lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+			if len(lines) != 2 {
+				t.Fatalf("lines = %q, want 2 results", lines)
+			}
+			var res queues.AllocationResult
+			if err := json.Unmarshal([]byte(lines[1]), &res); err != nil || res.TicketID != "t2" {
+				t.Errorf("second line = %q (%v), want t2", lines[1], err)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+
+	"agones-pubsub-allocator/metrics"
+
+	"github.com/rs/zerolog/log"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Headers added to a dead-lettered request alongside its original headers
+const (
+	HeaderFailureReason    = "failureReason"
+	HeaderFailureError     = "failureError"
+	HeaderDeliveryAttempts = "deliveryAttempts"
+	HeaderSourceTopic      = "sourceTopic"
+	HeaderSourcePartition  = "sourcePartition"
+	HeaderSourceOffset     = "sourceOffset"
+)
+
+// Values of HeaderFailureReason
+const (
+	ReasonMalformed     = "malformed"
+	ReasonMaxDeliveries = "max-deliveries"
+)
+
+// deadLetter produces the original record to the dead-letter topic with the
+// failure as headers so its offset can be committed. Without a dead-letter topic
+// the record is skipped. If producing fails it is retried with backoff, holding
+// back the partition, and false is reported if the worker stops first.
+func (s *Subscriber) deadLetter(ctx context.Context, quit <-chan struct{}, cl *kgo.Client, r *kgo.Record, reason string, cause error, attempts int) bool {
+	if s.opts.DeadLetterTopic == "" {
+		log.Error().Err(cause).Str("topic", r.Topic).Int32("partition", r.Partition).Int64("offset", r.Offset).Str("reason", reason).Int("attempts", attempts).Msg("no dead-letter topic configured; skipping record")
+		return true
+	}
+	headers := append([]kgo.RecordHeader(nil), r.Headers...)
+	headers = append(headers,
+		kgo.RecordHeader{Key: HeaderFailureReason, Value: []byte(reason)},
+		kgo.RecordHeader{Key: HeaderFailureError, Value: []byte(cause.Error())},
+		kgo.RecordHeader{Key: HeaderDeliveryAttempts, Value: []byte(strconv.Itoa(attempts))},
+		kgo.RecordHeader{Key: HeaderSourceTopic, Value: []byte(r.Topic)},
+		kgo.RecordHeader{Key: HeaderSourcePartition, Value: []byte(strconv.Itoa(int(r.Partition)))},
+		kgo.RecordHeader{Key: HeaderSourceOffset, Value: []byte(strconv.FormatInt(r.Offset, 10))},
+	)
+	dl := &kgo.Record{Topic: s.opts.DeadLetterTopic, Key: r.Key, Value: r.Value, Headers: headers}
+
+	backoff := retryMin
+	for {
+		err := cl.ProduceSync(ctx, dl).FirstErr()
+		if err == nil {
+			break
+		}
+		log.Error().Err(err).Str("deadLetterTopic", s.opts.DeadLetterTopic).Str("topic", r.Topic).Int64("offset", r.Offset).Dur("backoff", backoff).Msg("failed to dead-letter record; will retry")
+		if !sleep(ctx, quit, backoff) {
+			return false
+		}
+		backoff = min(backoff*2, retryMax)
+	}
+	metrics.DeadLetters.WithLabelValues(reason).Inc()
+	log.Warn().Err(cause).Str("topic", r.Topic).Int32("partition", r.Partition).Int64("offset", r.Offset).Str("reason", reason).Int("attempts", attempts).Str("deadLetterTopic", s.opts.DeadLetterTopic).Msg("record dead-lettered")
+	return true
+}
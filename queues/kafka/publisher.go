@@ -0,0 +1,62 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"agones-pubsub-allocator/queues"
+
+	"github.com/rs/zerolog/log"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Publisher produces allocation results keyed by ticketId, so every result for a
+// ticket lands on the same partition in order
+type Publisher struct {
+	opts   Options
+	mu     sync.Mutex
+	client *kgo.Client
+}
+
+func NewPublisher(opts Options) *Publisher {
+	return &Publisher{opts: opts}
+}
+
+func (p *Publisher) PublishResult(ctx context.Context, res *queues.AllocationResult) error {
+	cl, err := p.producer()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		log.Error().Err(err).Interface("result", res).Msg("failed to marshal allocation result")
+		return err
+	}
+	// Wait for the broker ack
+	r, err := cl.ProduceSync(ctx, &kgo.Record{Topic: p.opts.ResultTopic, Key: []byte(res.TicketID), Value: b}).First()
+	if err != nil {
+		log.Error().Err(err).Str("ticketId", res.TicketID).Msg("failed to publish allocation result")
+		return err
+	}
+	log.Debug().Int32("partition", r.Partition).Int64("offset", r.Offset).Str("ticketId", res.TicketID).Str("status", string(res.Status)).Msg("published allocation result")
+	return nil
+}
+
+func (p *Publisher) producer() (*kgo.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client == nil {
+		cl, err := kgo.NewClient(
+			kgo.SeedBrokers(p.opts.Brokers...),
+			kgo.ClientID("agones-allocator"),
+		)
+		if err != nil {
+			log.Error().Err(err).Strs("brokers", p.opts.Brokers).Msg("failed to create kafka producer")
+			return nil, err
+		}
+		p.client = cl
+		log.Info().Str("topic", p.opts.ResultTopic).Msg("kafka publisher initialized")
+	}
+	return p.client, nil
+}
@@ -0,0 +1,46 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"agones-pubsub-allocator/queues"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestPublisher_PublishResult(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short")
+	}
+	opts := runCluster(t)
+
+	res := &queues.AllocationResult{EnvelopeVersion: queues.ResultEnvelopeVersion, Type: "allocation-result", TicketID: "t1", Status: queues.StatusSuccess}
+	if err := NewPublisher(opts).PublishResult(context.Background(), res); err != nil {
+		t.Fatalf("PublishResult() error: %v", err)
+	}
+
+	cl, err := kgo.NewClient(kgo.SeedBrokers(opts.Brokers...), kgo.ConsumeTopics(opts.ResultTopic))
+	if err != nil {
+		t.Fatalf("new consumer: %v", err)
+	}
+	defer cl.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	records := cl.PollRecords(ctx, 1).Records()
+	if len(records) != 1 {
+		t.Fatalf("consumed %d records, want 1", len(records))
+	}
+	if key := string(records[0].Key); key != "t1" {
+		t.Errorf("record key = %q, want the ticket id", key)
+	}
+	var got queues.AllocationResult
+	if err := json.Unmarshal(records[0].Value, &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if got.TicketID != "t1" || got.Status != queues.StatusSuccess {
+		t.Errorf("published %#v, want t1 Success", got)
+	}
+}
@@ -0,0 +1,309 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"agones-pubsub-allocator/queues"
+
+	"github.com/rs/zerolog/log"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Options configures the Kafka brokers and topics used by Subscriber and Publisher
+type Options struct {
+	Brokers      []string
+	RequestTopic string
+	ResultTopic  string
+	// Consumer group; replicas sharing it split the request partitions
+	Group string
+	// Requests failing transiently this many attempts (default 5) are produced to
+	// DeadLetterTopic, if set, and committed past
+	MaxAttempts     int
+	DeadLetterTopic string
+}
+
+const (
+	maxPollRecords     = 100
+	defaultMaxAttempts = 5
+	retryMin           = 500 * time.Millisecond
+	retryMax           = 30 * time.Second
+	commitTimeout      = 5 * time.Second
+	// Polled batches buffered per partition before its fetching is paused
+	partitionBacklog = 4
+)
+
+// Subscriber consumes allocation requests as a member of a consumer group.
+// Each assigned partition is handled by its own goroutine, which commits its
+// offsets after the handler succeeds. Kafka has no per-message nack, so a failed
+// request is retried in place with backoff, holding back only its partition, up
+// to MaxAttempts times. It is then dead-lettered and committed past. Partitions
+// taken away in a rebalance stop after the request in hand, commit, and the new
+// owner resumes from there. A partition whose worker falls behind is paused until
+// it catches up, so polling never waits on a worker.
+type Subscriber struct {
+	opts Options
+}
+
+func NewSubscriber(opts Options) *Subscriber {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+	return &Subscriber{opts: opts}
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// partitionWorker handles the records polled for one partition in order
+type partitionWorker struct {
+	tp      topicPartition
+	mu      sync.Mutex
+	pending [][]*kgo.Record
+	paused  bool
+	wake    chan struct{}
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+// partitionWorkers tracks the workers of the partitions currently assigned
+type partitionWorkers struct {
+	s       *Subscriber
+	ctx     context.Context
+	handler func(context.Context, *queues.AllocationRequest) error
+	mu      sync.Mutex
+	workers map[topicPartition]*partitionWorker
+}
+
+// Start consumes until ctx is done, then commits what was handled and leaves the group
+func (s *Subscriber) Start(ctx context.Context, handler func(context.Context, *queues.AllocationRequest) error) error {
+	pw := &partitionWorkers{s: s, ctx: ctx, handler: handler, workers: make(map[topicPartition]*partitionWorker)}
+	cl, err := kgo.NewClient(
+		kgo.SeedBrokers(s.opts.Brokers...),
+		kgo.ClientID("agones-allocator"),
+		kgo.ConsumerGroup(s.opts.Group),
+		kgo.ConsumeTopics(s.opts.RequestTopic),
+		kgo.DisableAutoCommit(),
+		// Partitions only move between polls, once their workers have committed
+		kgo.BlockRebalanceOnPoll(),
+		kgo.OnPartitionsAssigned(pw.assigned),
+		kgo.OnPartitionsRevoked(pw.revoked),
+		kgo.OnPartitionsLost(pw.revoked),
+	)
+	if err != nil {
+		log.Error().Err(err).Strs("brokers", s.opts.Brokers).Msg("failed to create kafka consumer")
+		return err
+	}
+	defer cl.CloseAllowingRebalance()
+	log.Info().Strs("brokers", s.opts.Brokers).Str("topic", s.opts.RequestTopic).Str("group", s.opts.Group).Int("maxAttempts", s.opts.MaxAttempts).Str("deadLetterTopic", s.opts.DeadLetterTopic).Msg("kafka subscriber initialized")
+
+	for {
+		fetches := cl.PollRecords(ctx, maxPollRecords)
+		if fetches.IsClientClosed() || ctx.Err() != nil {
+			break
+		}
+		fetches.EachError(func(topic string, partition int32, err error) {
+			log.Warn().Err(err).Str("topic", topic).Int32("partition", partition).Msg("kafka fetch error")
+		})
+		fetches.EachPartition(func(p kgo.FetchTopicPartition) {
+			pw.dispatch(cl, p)
+		})
+		cl.AllowRebalance()
+	}
+	pw.stopAll()
+	return nil
+}
+
+// assigned starts a worker for each newly assigned partition. Pauses outlive
+// rebalances, so a partition paused during an earlier assignment is resumed.
+func (pw *partitionWorkers) assigned(_ context.Context, cl *kgo.Client, assigned map[string][]int32) {
+	cl.ResumeFetchPartitions(assigned)
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	for topic, partitions := range assigned {
+		for _, partition := range partitions {
+			tp := topicPartition{topic, partition}
+			w := &partitionWorker{
+				tp:   tp,
+				wake: make(chan struct{}, 1),
+				quit: make(chan struct{}),
+				done: make(chan struct{}),
+			}
+			pw.workers[tp] = w
+			go pw.run(cl, w)
+		}
+	}
+}
+
+// revoked stops the workers of partitions leaving this member and waits for
+// them to commit what they handled
+func (pw *partitionWorkers) revoked(_ context.Context, _ *kgo.Client, revoked map[string][]int32) {
+	pw.mu.Lock()
+	var stopping []*partitionWorker
+	for topic, partitions := range revoked {
+		for _, partition := range partitions {
+			tp := topicPartition{topic, partition}
+			if w, ok := pw.workers[tp]; ok {
+				close(w.quit)
+				stopping = append(stopping, w)
+				delete(pw.workers, tp)
+			}
+		}
+	}
+	pw.mu.Unlock()
+	for _, w := range stopping {
+		<-w.done
+	}
+}
+
+// stopAll stops every worker on shutdown
+func (pw *partitionWorkers) stopAll() {
+	pw.mu.Lock()
+	assigned := make(map[string][]int32)
+	for tp := range pw.workers {
+		assigned[tp.topic] = append(assigned[tp.topic], tp.partition)
+	}
+	pw.mu.Unlock()
+	pw.revoked(pw.ctx, nil, assigned)
+}
+
+// dispatch queues polled records for their partition's worker without waiting.
+// A partition with partitionBacklog batches queued is paused until its worker
+// has drained them.
+func (pw *partitionWorkers) dispatch(cl *kgo.Client, p kgo.FetchTopicPartition) {
+	pw.mu.Lock()
+	w := pw.workers[topicPartition{p.Topic, p.Partition}]
+	pw.mu.Unlock()
+	if w == nil || len(p.Records) == 0 {
+		return
+	}
+	w.mu.Lock()
+	w.pending = append(w.pending, p.Records)
+	if len(w.pending) >= partitionBacklog && !w.paused {
+		// Under the lock, so the worker can't resume before the pause lands
+		w.paused = true
+		cl.PauseFetchPartitions(map[string][]int32{p.Topic: {p.Partition}})
+		log.Debug().Str("topic", p.Topic).Int32("partition", p.Partition).Msg("kafka partition behind; pausing fetches")
+	}
+	w.mu.Unlock()
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next takes the worker's oldest pending batch, resuming the partition once the
+// last one is taken
+func (w *partitionWorker) next(cl *kgo.Client) ([]*kgo.Record, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.pending) == 0 {
+		return nil, false
+	}
+	records := w.pending[0]
+	w.pending = w.pending[1:]
+	if len(w.pending) == 0 && w.paused {
+		w.paused = false
+		cl.ResumeFetchPartitions(map[string][]int32{w.tp.topic: {w.tp.partition}})
+	}
+	return records, true
+}
+
+// run handles batches in order and commits after each one, until quit
+func (pw *partitionWorkers) run(cl *kgo.Client, w *partitionWorker) {
+	defer close(w.done)
+	for {
+		select {
+		case <-w.quit:
+			return
+		case <-w.wake:
+		}
+		for {
+			select {
+			case <-w.quit:
+				return
+			default:
+			}
+			records, ok := w.next(cl)
+			if !ok {
+				break
+			}
+			var last *kgo.Record
+			stopped := false
+			for _, r := range records {
+				if !pw.s.handle(pw.ctx, w.quit, cl, r, pw.handler) {
+					stopped = true
+					break
+				}
+				last = r
+			}
+			if last != nil {
+				commitCtx, cancel := context.WithTimeout(context.WithoutCancel(pw.ctx), commitTimeout)
+				if err := cl.CommitRecords(commitCtx, last); err != nil {
+					log.Error().Err(err).Str("topic", last.Topic).Int32("partition", last.Partition).Int64("offset", last.Offset).Msg("failed to commit kafka offset; requests may be redelivered")
+				}
+				cancel()
+			}
+			if stopped {
+				return
+			}
+		}
+	}
+}
+
+// handle processes one record, retrying transient handler failures up to
+// MaxAttempts before dead-lettering it. It reports false only if the worker was
+// stopped before the record was handled, leaving it for the next owner.
+func (s *Subscriber) handle(ctx context.Context, quit <-chan struct{}, cl *kgo.Client, r *kgo.Record, handler func(context.Context, *queues.AllocationRequest) error) bool {
+	log.Debug().Str("topic", r.Topic).Int32("partition", r.Partition).Int64("offset", r.Offset).Int("size", len(r.Value)).Msg("received kafka message")
+	recvAt := time.Now()
+	req, err := queues.DecodeRequest(r.Value)
+	if err != nil {
+		if queues.Drop(err) {
+			log.Warn().Err(err).Str("topic", r.Topic).Int64("offset", r.Offset).Msg("dropping message")
+			return true
+		}
+		// A malformed record never decodes on redelivery; move it aside rather than block the partition
+		log.Error().Err(err).Str("topic", r.Topic).Int64("offset", r.Offset).Msg("failed to decode allocation request")
+		return s.deadLetter(ctx, quit, cl, r, ReasonMalformed, err, 1)
+	}
+	log.Info().Str("topic", r.Topic).Str("type", req.Type).Str("ticketId", req.TicketID).Str("fleet", req.Fleet).Str("playerId", req.PlayerID).Msg("handling allocation request")
+
+	backoff := retryMin
+	for attempt := 1; ; attempt++ {
+		err := handler(ctx, req)
+		if err == nil {
+			log.Debug().Str("topic", r.Topic).Str("ticketId", req.TicketID).Dur("latency", time.Since(recvAt)).Msg("handler succeeded; committing offset")
+			return true
+		}
+		if queues.IsPermanent(err) {
+			log.Warn().Err(err).Str("topic", r.Topic).Str("ticketId", req.TicketID).Msg("request failed permanently; committing offset")
+			return true
+		}
+		if attempt >= s.opts.MaxAttempts {
+			return s.deadLetter(ctx, quit, cl, r, ReasonMaxDeliveries, err, attempt)
+		}
+		log.Error().Err(err).Str("topic", r.Topic).Str("ticketId", req.TicketID).Int("attempts", attempt).Dur("backoff", backoff).Msg("handler failed; will retry")
+		if !sleep(ctx, quit, backoff) {
+			return false
+		}
+		backoff = min(backoff*2, retryMax)
+	}
+}
+
+// sleep waits for d, reporting false if ctx or quit ended the wait first
+func sleep(ctx context.Context, quit <-chan struct{}, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-quit:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,245 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"agones-pubsub-allocator/queues"
+
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// runCluster starts an in-process Kafka cluster with the request, result and dead-letter topics
+func runCluster(t *testing.T) Options {
+	t.Helper()
+	c, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, "requests", "results", "dead"))
+	if err != nil {
+		t.Fatalf("new kafka cluster: %v", err)
+	}
+	t.Cleanup(c.Close)
+	return Options{Brokers: c.ListenAddrs(), RequestTopic: "requests", ResultTopic: "results", Group: "allocator"}
+}
+
+func produce(t *testing.T, opts Options, bodies ...string) {
+	t.Helper()
+	cl, err := kgo.NewClient(kgo.SeedBrokers(opts.Brokers...))
+	if err != nil {
+		t.Fatalf("new producer: %v", err)
+	}
+	defer cl.Close()
+	for _, body := range bodies {
+		if err := cl.ProduceSync(context.Background(), &kgo.Record{Topic: opts.RequestTopic, Value: []byte(body)}).FirstErr(); err != nil {
+			t.Fatalf("produce %s: %v", body, err)
+		}
+	}
+}
+
+// consumeUntil runs a Subscriber until want tickets have been handled successfully
+func consumeUntil(t *testing.T, opts Options, want int, fail map[string]int) []string {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		mu      sync.Mutex
+		handled []string
+		done    = make(chan struct{})
+	)
+	handler := func(_ context.Context, req *queues.AllocationRequest) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail[req.TicketID] > 0 {
+			fail[req.TicketID]--
+			return errors.New("transient")
+		}
+		handled = append(handled, req.TicketID)
+		if len(handled) == want {
+			close(done)
+		}
+		return nil
+	}
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- NewSubscriber(opts).Start(ctx, handler) }()
+	select {
+	case <-done:
+	case <-time.After(20 * time.Second):
+		t.Fatalf("handled %v, want %d requests", handled, want)
+	}
+	cancel()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("Start() error: %v", err)
+		}
+	case <-time.After(20 * time.Second):
+		t.Fatal("Start() did not return after cancel")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]string(nil), handled...)
+}
+
+func TestSubscriber_Start(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short")
+	}
+	opts := runCluster(t)
+	produce(t, opts,
+		`{"type":"allocation-result","ticketId":"ignored"}`,
+		`{"ticketId":"no-fleet"}`,
+		`{"ticketId":"t1","fleet":"f"}`,
+		`{"ticketId":"retry","fleet":"f"}`,
+		`{"ticketId":"t2","fleet":"f"}`,
+	)
+
+	// A failed request is retried in place, keeping partition order
+	got := consumeUntil(t, opts, 3, map[string]int{"retry": 1})
+	if want := []string{"t1", "retry", "t2"}; !equal(got, want) {
+		t.Errorf("handled %v, want %v", got, want)
+	}
+
+	// Offsets were committed, so the group resumes after what was handled
+	produce(t, opts, `{"ticketId":"t3","fleet":"f"}`)
+	if got := consumeUntil(t, opts, 1, nil); !equal(got, []string{"t3"}) {
+		t.Errorf("after restart handled %v, want [t3]", got)
+	}
+}
+
+func TestSubscriber_DeadLetter(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short")
+	}
+	opts := runCluster(t)
+	opts.MaxAttempts = 2
+	opts.DeadLetterTopic = "dead"
+	produce(t, opts,
+		`not json`,
+		`{"ticketId":"stuck","fleet":"f"}`,
+		`{"ticketId":"t1","fleet":"f"}`,
+	)
+
+	// The stuck request is given up on after two attempts and t1 still handled
+	fail := map[string]int{"stuck": 100}
+	if got := consumeUntil(t, opts, 1, fail); !equal(got, []string{"t1"}) {
+		t.Errorf("handled %v, want [t1]", got)
+	}
+	if fail["stuck"] != 98 {
+		t.Errorf("stuck attempted %d times, want 2", 100-fail["stuck"])
+	}
+
+	cl, err := kgo.NewClient(kgo.SeedBrokers(opts.Brokers...), kgo.ConsumeTopics(opts.DeadLetterTopic), kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()))
+	if err != nil {
+		t.Fatalf("new consumer: %v", err)
+	}
+	defer cl.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var dead []*kgo.Record
+	for len(dead) < 2 && ctx.Err() == nil {
+		dead = append(dead, cl.PollFetches(ctx).Records()...)
+	}
+	if len(dead) != 2 {
+		t.Fatalf("dead-lettered %d records, want 2", len(dead))
+	}
+	headers := func(r *kgo.Record) map[string]string {
+		h := map[string]string{}
+		for _, kv := range r.Headers {
+			h[kv.Key] = string(kv.Value)
+		}
+		return h
+	}
+	if h := headers(dead[0]); string(dead[0].Value) != "not json" || h[HeaderFailureReason] != ReasonMalformed || h[HeaderSourceOffset] != "0" {
+		t.Errorf("malformed dead letter = %s %v", dead[0].Value, h)
+	}
+	if h := headers(dead[1]); h[HeaderFailureReason] != ReasonMaxDeliveries || h[HeaderDeliveryAttempts] != "2" || h[HeaderFailureError] != "transient" || h[HeaderSourceOffset] != "1" {
+		t.Errorf("max-attempts dead letter = %s %v", dead[1].Value, h)
+	}
+
+	// Both were committed past, so a restart doesn't see them again
+	produce(t, opts, `{"ticketId":"t2","fleet":"f"}`)
+	if got := consumeUntil(t, opts, 1, nil); !equal(got, []string{"t2"}) {
+		t.Errorf("after restart handled %v, want [t2]", got)
+	}
+}
+
+func TestSubscriber_PartitionsIndependent(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short")
+	}
+	c, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(2, "requests"))
+	if err != nil {
+		t.Fatalf("new kafka cluster: %v", err)
+	}
+	t.Cleanup(c.Close)
+	opts := Options{Brokers: c.ListenAddrs(), RequestTopic: "requests", Group: "allocator", MaxAttempts: 100}
+
+	cl, err := kgo.NewClient(kgo.SeedBrokers(opts.Brokers...), kgo.RecordPartitioner(kgo.ManualPartitioner()))
+	if err != nil {
+		t.Fatalf("new producer: %v", err)
+	}
+	defer cl.Close()
+	for _, r := range []*kgo.Record{
+		{Partition: 0, Value: []byte(`{"ticketId":"stuck","fleet":"f"}`)},
+		{Partition: 0, Value: []byte(`{"ticketId":"behind","fleet":"f"}`)},
+		{Partition: 1, Value: []byte(`{"ticketId":"t1","fleet":"f"}`)},
+		{Partition: 1, Value: []byte(`{"ticketId":"t2","fleet":"f"}`)},
+	} {
+		r.Topic = opts.RequestTopic
+		if err := cl.ProduceSync(context.Background(), r).FirstErr(); err != nil {
+			t.Fatalf("produce: %v", err)
+		}
+	}
+
+	// A request being retried holds back its own partition only
+	got := consumeUntil(t, opts, 2, map[string]int{"stuck": 100})
+	if !equal(got, []string{"t1", "t2"}) {
+		t.Errorf("handled %v, want [t1 t2]", got)
+	}
+}
+
+func TestPartitionWorkers_PausesBehindPartition(t *testing.T) {
+	cl, err := kgo.NewClient(kgo.SeedBrokers("127.0.0.1:1"))
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	defer cl.Close()
+	tp := topicPartition{"requests", 0}
+	w := &partitionWorker{tp: tp, wake: make(chan struct{}, 1), quit: make(chan struct{}), done: make(chan struct{})}
+	pw := &partitionWorkers{ctx: context.Background(), workers: map[topicPartition]*partitionWorker{tp: w}}
+
+	// With no worker draining, dispatch still returns and pauses the partition
+	batch := kgo.FetchTopicPartition{Topic: "requests", FetchPartition: kgo.FetchPartition{Partition: 0, Records: []*kgo.Record{{}}}}
+	for range partitionBacklog + 2 {
+		pw.dispatch(cl, batch)
+	}
+	if paused := cl.PauseFetchPartitions(nil); len(paused["requests"]) != 1 {
+		t.Fatalf("paused = %v, want requests/0", paused)
+	}
+
+	// Draining the backlog resumes it
+	for range partitionBacklog + 2 {
+		if _, ok := w.next(cl); !ok {
+			t.Fatal("next() = false, want a pending batch")
+		}
+	}
+	if paused := cl.PauseFetchPartitions(nil); len(paused) != 0 {
+		t.Errorf("paused after draining = %v, want none", paused)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+// Package memory is an in-process channel transport for local runs and tests.
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"agones-pubsub-allocator/queues"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultRetryDelay is how long a failed request waits before it is redelivered
+const DefaultRetryDelay = time.Second
+
+// Transport is both the Subscriber and the Publisher: Send feeds the subscriber
+// and results are read from Results. Failed requests are redelivered after
+// RetryDelay; invalid ones are dropped, as the queue transports do.
+type Transport struct {
+	requests   chan *queues.AllocationRequest
+	results    chan *queues.AllocationResult
+	RetryDelay time.Duration
+}
+
+// NewTransport creates a transport whose channels buffer buffer messages each
+func NewTransport(buffer int) *Transport {
+	return &Transport{
+		requests:   make(chan *queues.AllocationRequest, buffer),
+		results:    make(chan *queues.AllocationResult, buffer),
+		RetryDelay: DefaultRetryDelay,
+	}
+}
+
+// Send queues a request, blocking while the buffer is full
+func (t *Transport) Send(ctx context.Context, req *queues.AllocationRequest) error {
+	select {
+	case t.requests <- req:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Results delivers published results. Publishing blocks while nobody reads and the buffer is full.
+func (t *Transport) Results() <-chan *queues.AllocationResult {
+	return t.results
+}
+
+func (t *Transport) PublishResult(ctx context.Context, res *queues.AllocationResult) error {
+	select {
+	case t.results <- res:
+		log.Debug().Str("ticketId", res.TicketID).Str("status", string(res.Status)).Msg("published allocation result")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Start handles requests concurrently until ctx is done and waits for running handlers
+func (t *Transport) Start(ctx context.Context, handler func(context.Context, *queues.AllocationRequest) error) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	log.Info().Int("buffer", cap(t.requests)).Msg("memory subscriber started")
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case req := <-t.requests:
+			if err := req.Validate(); err != nil {
+				log.Warn().Err(err).Str("ticketId", req.TicketID).Msg("dropping message")
+				continue
+			}
+			log.Info().Str("type", req.Type).Str("ticketId", req.TicketID).Str("fleet", req.Fleet).Str("playerId", req.PlayerID).Msg("handling allocation request")
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// A permanent failure already published its result
+				if err := handler(ctx, req); err != nil && !queues.IsPermanent(err) {
+					log.Error().Err(err).Str("ticketId", req.TicketID).Dur("retryIn", t.RetryDelay).Msg("handler failed; will redeliver")
+					t.redeliver(ctx, req)
+				}
+			}()
+		}
+	}
+}
+
+func (t *Transport) redeliver(ctx context.Context, req *queues.AllocationRequest) {
+	timer := time.NewTimer(t.RetryDelay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		_ = t.Send(ctx, req)
+	case <-ctx.Done():
+	}
+}
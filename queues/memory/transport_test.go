@@ -0,0 +1,126 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"agones-pubsub-allocator/allocator"
+	"agones-pubsub-allocator/queues"
+
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	allocationv1 "agones.dev/agones/pkg/apis/allocation/v1"
+	agonesfake "agones.dev/agones/pkg/client/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func nextResult(t *testing.T, tr *Transport) *queues.AllocationResult {
+	t.Helper()
+	select {
+	case res := <-tr.Results():
+		return res
+	case <-time.After(5 * time.Second):
+		t.Fatal("no result published")
+		return nil
+	}
+}
+
+func TestTransport_Redelivery(t *testing.T) {
+	tr := NewTransport(10)
+	tr.RetryDelay = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var calls atomic.Int32
+	handled := make(chan string, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- tr.Start(ctx, func(_ context.Context, req *queues.AllocationRequest) error {
+			if req.TicketID == "flaky" && calls.Add(1) < 3 {
+				return errors.New("not ready")
+			}
+			handled <- req.TicketID
+			return nil
+		})
+	}()
+
+	for _, req := range []*queues.AllocationRequest{
+		{TicketID: "invalid"},
+		{TicketID: "flaky", Fleet: "f"},
+		{TicketID: "ok", Fleet: "f"},
+	} {
+		if err := tr.Send(ctx, req); err != nil {
+			t.Fatalf("Send() error: %v", err)
+		}
+	}
+
+	got := map[string]bool{}
+	for range 2 {
+		select {
+		case id := <-handled:
+			got[id] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("handled = %v, want flaky and ok", got)
+		}
+	}
+	if !got["flaky"] || !got["ok"] {
+		t.Errorf("handled = %v, want flaky and ok", got)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("flaky handled %d times, want 3", n)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Start() error: %v", err)
+	}
+}
+
+// TestTransport_Pipeline runs requests through a Controller backed by a fake Agones clientset
+func TestTransport_Pipeline(t *testing.T) {
+	gs := &agonesv1.GameServer{
+		ObjectMeta: metav1.ObjectMeta{Name: "gs-1", Namespace: "ns", Labels: map[string]string{"agones.dev/fleet": "fleet"}},
+		Status:     agonesv1.GameServerStatus{State: agonesv1.GameServerStateReady, Address: "10.0.0.1"},
+	}
+	client := agonesfake.NewSimpleClientset(gs)
+	client.PrependReactor("create", "gameserverallocations", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, &allocationv1.GameServerAllocation{Status: allocationv1.GameServerAllocationStatus{
+			State:          allocationv1.GameServerAllocationAllocated,
+			GameServerName: "gs-1",
+			Address:        "10.0.0.1",
+			Ports:          []agonesv1.GameServerStatusPort{{Name: "default", Port: 7777}},
+		}}, nil
+	})
+
+	tr := NewTransport(10)
+	ctrl := allocator.NewController(tr, "ns", allocator.WithAgonesClient(client))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := ctrl.Start(ctx); err != nil {
+		t.Fatalf("Controller.Start() error: %v", err)
+	}
+	go func() { _ = tr.Start(ctx, ctrl.Handle) }()
+
+	if err := tr.Send(ctx, &queues.AllocationRequest{TicketID: "t1", Fleet: "fleet", PlayerID: "p1"}); err != nil {
+		t.Fatalf("Send() error: %v", err)
+	}
+	res := nextResult(t, tr)
+	if res.TicketID != "t1" || res.Status != queues.StatusSuccess || res.Token == nil {
+		t.Fatalf("result = %+v, want Success with a token", res)
+	}
+	if res.GameServer == nil || res.GameServer.Name != "gs-1" {
+		t.Errorf("gameServer = %+v, want gs-1", res.GameServer)
+	}
+
+	// A redelivered ticket replays its result instead of allocating again
+	if err := tr.Send(ctx, &queues.AllocationRequest{TicketID: "t1", Fleet: "fleet", PlayerID: "p1"}); err != nil {
+		t.Fatalf("Send() error: %v", err)
+	}
+	if replay := nextResult(t, tr); replay.Status != queues.StatusSuccess || *replay.Token != *res.Token {
+		t.Errorf("replay = %+v, want the original Success", replay)
+	}
+}
@@ -0,0 +1,96 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	gnats "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog/log"
+)
+
+// Options configures the JetStream connection used by Subscriber and Publisher
+type Options struct {
+	URL       string
+	CredsFile string
+	// Stream holding both subjects; created with them if it doesn't exist.
+	// Messages in a created stream are discarded after StreamMaxAge (default 24h)
+	// or once it holds StreamMaxBytes (default 1 GiB), oldest first.
+	Stream         string
+	StreamMaxAge   time.Duration
+	StreamMaxBytes int64
+	RequestSubject string
+	ResultSubject  string
+	// Durable pull consumer name; replicas sharing it share the work
+	Durable string
+	// Requests handled concurrently (default 10)
+	MaxInFlight int
+	// Requests failing transiently this many deliveries (default 5) are copied to
+	// DeadLetterSubject, if set, and terminated; the consumer's MaxDeliver
+	MaxDeliveries     int
+	DeadLetterSubject string
+}
+
+const (
+	defaultMaxInFlight    = 10
+	defaultMaxDeliveries  = 5
+	defaultStreamMaxAge   = 24 * time.Hour
+	defaultStreamMaxBytes = 1 << 30
+)
+
+// subjects are the subjects a stream created by connect captures
+func (o Options) subjects() []string {
+	subjects := []string{o.RequestSubject, o.ResultSubject}
+	if o.DeadLetterSubject != "" {
+		subjects = append(subjects, o.DeadLetterSubject)
+	}
+	return subjects
+}
+
+// streamConfig is the config of a stream created by connect
+func (o Options) streamConfig() jetstream.StreamConfig {
+	cfg := jetstream.StreamConfig{
+		Name:     o.Stream,
+		Subjects: o.subjects(),
+		MaxAge:   o.StreamMaxAge,
+		MaxBytes: o.StreamMaxBytes,
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = defaultStreamMaxAge
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = defaultStreamMaxBytes
+	}
+	return cfg
+}
+
+// connect opens a JetStream context and makes sure the stream exists
+func connect(ctx context.Context, opts Options, name string) (jetstream.JetStream, error) {
+	natsOpts := []gnats.Option{gnats.Name(name), gnats.MaxReconnects(-1)}
+	if opts.CredsFile != "" {
+		natsOpts = append(natsOpts, gnats.UserCredentials(opts.CredsFile))
+	}
+	nc, err := gnats.Connect(opts.URL, natsOpts...)
+	if err != nil {
+		return nil, err
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	if _, err := js.Stream(ctx, opts.Stream); errors.Is(err, jetstream.ErrStreamNotFound) {
+		cfg := opts.streamConfig()
+		log.Info().Str("stream", opts.Stream).Strs("subjects", cfg.Subjects).Dur("maxAge", cfg.MaxAge).Int64("maxBytes", cfg.MaxBytes).Msg("creating nats stream")
+		_, err = js.CreateStream(ctx, cfg)
+		if err != nil && !errors.Is(err, jetstream.ErrStreamNameAlreadyInUse) {
+			nc.Close()
+			return nil, err
+		}
+	} else if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return js, nil
+}
@@ -0,0 +1,78 @@
+package nats
+
+import (
+	"context"
+	"strconv"
+
+	"agones-pubsub-allocator/metrics"
+
+	gnats "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog/log"
+)
+
+// Headers added to a dead-lettered request alongside its original headers
+const (
+	HeaderFailureReason    = "Failure-Reason"
+	HeaderFailureError     = "Failure-Error"
+	HeaderDeliveryAttempts = "Delivery-Attempts"
+	HeaderSourceSubject    = "Source-Subject"
+	HeaderSourceSequence   = "Source-Sequence"
+)
+
+// Values of HeaderFailureReason
+const (
+	ReasonMalformed     = "malformed"
+	ReasonMaxDeliveries = "max-deliveries"
+)
+
+// deliveries returns how many times the consumer has delivered m
+func deliveries(m jetstream.Msg) int {
+	md, err := m.Metadata()
+	if err != nil {
+		return 1
+	}
+	return int(md.NumDelivered)
+}
+
+// deadLetter copies the original payload to the dead-letter subject with the
+// failure as headers and terminates it so it isn't redelivered. Without a
+// dead-letter subject the message is terminated and dropped. If the copy fails
+// the message is nak'd; once the consumer's MaxDeliver is reached the server
+// stops redelivering it.
+func (s *Subscriber) deadLetter(ctx context.Context, m jetstream.Msg, reason string, cause error, deliveries int) {
+	if s.opts.DeadLetterSubject == "" {
+		log.Error().Err(cause).Str("subject", m.Subject()).Str("reason", reason).Int("deliveries", deliveries).Msg("no dead-letter subject configured; dropping message")
+		term(m)
+		return
+	}
+	dl := gnats.NewMsg(s.opts.DeadLetterSubject)
+	dl.Data = m.Data()
+	for k, vs := range m.Headers() {
+		for _, v := range vs {
+			dl.Header.Add(k, v)
+		}
+	}
+	dl.Header.Set(HeaderFailureReason, reason)
+	dl.Header.Set(HeaderFailureError, cause.Error())
+	dl.Header.Set(HeaderDeliveryAttempts, strconv.Itoa(deliveries))
+	dl.Header.Set(HeaderSourceSubject, m.Subject())
+	if md, err := m.Metadata(); err == nil {
+		dl.Header.Set(HeaderSourceSequence, strconv.FormatUint(md.Sequence.Stream, 10))
+	}
+
+	if _, err := s.js.PublishMsg(ctx, dl); err != nil {
+		log.Error().Err(err).Str("deadLetterSubject", s.opts.DeadLetterSubject).Str("subject", m.Subject()).Msg("failed to dead-letter message; will retry")
+		nak(m)
+		return
+	}
+	metrics.DeadLetters.WithLabelValues(reason).Inc()
+	log.Warn().Err(cause).Str("subject", m.Subject()).Str("reason", reason).Int("deliveries", deliveries).Str("deadLetterSubject", s.opts.DeadLetterSubject).Msg("message dead-lettered")
+	term(m)
+}
+
+func term(m jetstream.Msg) {
+	if err := m.Term(); err != nil {
+		log.Warn().Err(err).Str("subject", m.Subject()).Msg("failed to term nats message")
+	}
+}
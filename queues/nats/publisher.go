@@ -0,0 +1,57 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"agones-pubsub-allocator/queues"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog/log"
+)
+
+// Publisher publishes allocation results to a JetStream subject and waits for the stream ack
+type Publisher struct {
+	opts Options
+	mu   sync.Mutex
+	js   jetstream.JetStream
+}
+
+func NewPublisher(opts Options) *Publisher {
+	return &Publisher{opts: opts}
+}
+
+func (p *Publisher) PublishResult(ctx context.Context, res *queues.AllocationResult) error {
+	js, err := p.client(ctx)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		log.Error().Err(err).Interface("result", res).Msg("failed to marshal allocation result")
+		return err
+	}
+	ack, err := js.Publish(ctx, p.opts.ResultSubject, b)
+	if err != nil {
+		log.Error().Err(err).Str("ticketId", res.TicketID).Msg("failed to publish allocation result")
+		return err
+	}
+	log.Debug().Uint64("seq", ack.Sequence).Str("ticketId", res.TicketID).Str("status", string(res.Status)).Msg("published allocation result")
+	return nil
+}
+
+func (p *Publisher) client(ctx context.Context) (jetstream.JetStream, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.js == nil {
+		js, err := connect(ctx, p.opts, "agones-allocator-publisher")
+		if err != nil {
+			log.Error().Err(err).Str("url", p.opts.URL).Str("stream", p.opts.Stream).Msg("failed to connect nats publisher")
+			return nil, err
+		}
+		p.js = js
+		log.Info().Str("subject", p.opts.ResultSubject).Msg("nats publisher initialized")
+	}
+	return p.js, nil
+}
@@ -0,0 +1,67 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"agones-pubsub-allocator/queues"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestPublisher_PublishResult(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short")
+	}
+	opts := runServer(t)
+	ctx := context.Background()
+
+	res := &queues.AllocationResult{EnvelopeVersion: queues.ResultEnvelopeVersion, Type: "allocation-result", TicketID: "t1", Status: queues.StatusSuccess}
+	if err := NewPublisher(opts).PublishResult(ctx, res); err != nil {
+		t.Fatalf("PublishResult() error: %v", err)
+	}
+
+	js, err := connect(ctx, opts, "test")
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	consumer, err := js.OrderedConsumer(ctx, opts.Stream, jetstream.OrderedConsumerConfig{FilterSubjects: []string{opts.ResultSubject}})
+	if err != nil {
+		t.Fatalf("ordered consumer: %v", err)
+	}
+	msg, err := consumer.Next(jetstream.FetchMaxWait(5 * time.Second))
+	if err != nil {
+		t.Fatalf("next: %v", err)
+	}
+	var got queues.AllocationResult
+	if err := json.Unmarshal(msg.Data(), &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if got.TicketID != "t1" || got.Status != queues.StatusSuccess {
+		t.Errorf("published %#v, want t1 Success", got)
+	}
+}
+
+func TestConnect_StreamLimits(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short")
+	}
+	opts := runServer(t)
+	opts.StreamMaxAge = time.Hour
+	ctx := context.Background()
+
+	js, err := connect(ctx, opts, "test")
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	stream, err := js.Stream(ctx, opts.Stream)
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	cfg := stream.CachedInfo().Config
+	if cfg.MaxAge != time.Hour || cfg.MaxBytes != defaultStreamMaxBytes {
+		t.Errorf("stream limits = %s, %d bytes; want 1h, %d bytes", cfg.MaxAge, cfg.MaxBytes, defaultStreamMaxBytes)
+	}
+}
@@ -0,0 +1,129 @@
+package nats
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"agones-pubsub-allocator/queues"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/rs/zerolog/log"
+)
+
+// Subscriber receives allocation requests from a durable JetStream pull consumer.
+// Handled messages are acked and transient failures nak'd for redelivery until
+// the MaxDeliveries'th delivery, which is copied to DeadLetterSubject if set and
+// terminated, matching the Pub/Sub subscriber. Malformed payloads are
+// dead-lettered straight away.
+type Subscriber struct {
+	opts     Options
+	js       jetstream.JetStream
+	consumer jetstream.Consumer
+}
+
+func NewSubscriber(opts Options) *Subscriber {
+	if opts.MaxInFlight <= 0 {
+		opts.MaxInFlight = defaultMaxInFlight
+	}
+	if opts.MaxDeliveries <= 0 {
+		opts.MaxDeliveries = defaultMaxDeliveries
+	}
+	return &Subscriber{opts: opts}
+}
+
+// Start consumes until ctx is done, then stops fetching and waits for in-flight handlers
+func (s *Subscriber) Start(ctx context.Context, handler func(context.Context, *queues.AllocationRequest) error) error {
+	if s.consumer == nil {
+		js, err := connect(ctx, s.opts, "agones-allocator-subscriber")
+		if err != nil {
+			log.Error().Err(err).Str("url", s.opts.URL).Str("stream", s.opts.Stream).Msg("failed to connect nats subscriber")
+			return err
+		}
+		consumer, err := js.CreateOrUpdateConsumer(ctx, s.opts.Stream, jetstream.ConsumerConfig{
+			Durable:       s.opts.Durable,
+			FilterSubject: s.opts.RequestSubject,
+			AckPolicy:     jetstream.AckExplicitPolicy,
+			MaxAckPending: s.opts.MaxInFlight * 10,
+			MaxDeliver:    s.opts.MaxDeliveries,
+		})
+		if err != nil {
+			log.Error().Err(err).Str("stream", s.opts.Stream).Str("durable", s.opts.Durable).Msg("failed to create nats consumer")
+			return err
+		}
+		s.js = js
+		s.consumer = consumer
+		log.Info().Str("stream", s.opts.Stream).Str("subject", s.opts.RequestSubject).Str("durable", s.opts.Durable).Int("maxDeliveries", s.opts.MaxDeliveries).Str("deadLetterSubject", s.opts.DeadLetterSubject).Msg("nats subscriber initialized")
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, s.opts.MaxInFlight)
+	cc, err := s.consumer.Consume(func(m jetstream.Msg) {
+		// Blocking here applies backpressure to the consumer
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			s.handle(ctx, m, handler)
+		}()
+	}, jetstream.PullMaxMessages(s.opts.MaxInFlight), jetstream.ConsumeErrHandler(func(_ jetstream.ConsumeContext, err error) {
+		log.Warn().Err(err).Str("durable", s.opts.Durable).Msg("nats consume error")
+	}))
+	if err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+	cc.Drain()
+	<-cc.Closed()
+	wg.Wait()
+	return nil
+}
+
+func (s *Subscriber) handle(ctx context.Context, m jetstream.Msg, handler func(context.Context, *queues.AllocationRequest) error) {
+	log.Debug().Str("subject", m.Subject()).Int("size", len(m.Data())).Msg("received nats message")
+	recvAt := time.Now()
+	req, err := queues.DecodeRequest(m.Data())
+	if err != nil {
+		if queues.Drop(err) {
+			log.Warn().Err(err).Str("subject", m.Subject()).Msg("dropping message")
+			ack(m)
+			return
+		}
+		// A malformed payload never decodes on redelivery
+		log.Error().Err(err).Str("subject", m.Subject()).Msg("failed to decode allocation request")
+		s.deadLetter(ctx, m, ReasonMalformed, err, deliveries(m))
+		return
+	}
+	log.Info().Str("subject", m.Subject()).Str("type", req.Type).Str("ticketId", req.TicketID).Str("fleet", req.Fleet).Str("playerId", req.PlayerID).Msg("handling allocation request")
+	if err := handler(ctx, req); err != nil {
+		if queues.IsPermanent(err) {
+			log.Warn().Err(err).Str("subject", m.Subject()).Str("ticketId", req.TicketID).Msg("request failed permanently; acking message")
+			ack(m)
+			return
+		}
+		n := deliveries(m)
+		if n >= s.opts.MaxDeliveries {
+			s.deadLetter(ctx, m, ReasonMaxDeliveries, err, n)
+			return
+		}
+		log.Error().Err(err).Str("subject", m.Subject()).Str("ticketId", req.TicketID).Int("deliveries", n).Msg("handler failed; will retry")
+		nak(m)
+		return
+	}
+	log.Debug().Str("subject", m.Subject()).Str("ticketId", req.TicketID).Dur("latency", time.Since(recvAt)).Msg("handler succeeded; acking message")
+	ack(m)
+}
+
+func ack(m jetstream.Msg) {
+	if err := m.Ack(); err != nil {
+		log.Warn().Err(err).Str("subject", m.Subject()).Msg("failed to ack nats message")
+	}
+}
+
+func nak(m jetstream.Msg) {
+	if err := m.Nak(); err != nil {
+		log.Warn().Err(err).Str("subject", m.Subject()).Msg("failed to nak nats message")
+	}
+}
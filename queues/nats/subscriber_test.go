@@ -0,0 +1,184 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"agones-pubsub-allocator/queues"
+
+	"github.com/nats-io/nats-server/v2/server"
+	gnats "github.com/nats-io/nats.go"
+)
+
+// runServer starts an embedded JetStream server for the test
+func runServer(t *testing.T) Options {
+	t.Helper()
+	srv, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir(), NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatalf("new nats server: %v", err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+	t.Cleanup(srv.Shutdown)
+	return Options{
+		URL:            srv.ClientURL(),
+		Stream:         "ALLOCATOR",
+		RequestSubject: "allocator.requests",
+		ResultSubject:  "allocator.results",
+		Durable:        "allocator",
+	}
+}
+
+func TestSubscriber_Start(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short")
+	}
+	opts := runServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Create the stream up front so requests published before Start are kept
+	if _, err := connect(ctx, opts, "test"); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	nc, err := gnats.Connect(opts.URL)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer nc.Close()
+	for _, body := range []string{
+		`{"type":"allocation-result","ticketId":"ignored"}`,
+		`{"ticketId":"no-fleet"}`,
+		`{"ticketId":"t1","fleet":"f"}`,
+		`{"ticketId":"retry","fleet":"f"}`,
+	} {
+		if _, err := nc.Request(opts.RequestSubject, []byte(body), 5*time.Second); err != nil {
+			t.Fatalf("publish %s: %v", body, err)
+		}
+	}
+
+	var (
+		mu       sync.Mutex
+		attempts = map[string]int{}
+		done     = make(chan struct{})
+	)
+	handler := func(_ context.Context, req *queues.AllocationRequest) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[req.TicketID]++
+		// Fail the first delivery so the nak causes a redelivery
+		if req.TicketID == "retry" && attempts[req.TicketID] == 1 {
+			return errors.New("transient")
+		}
+		if attempts["t1"] >= 1 && attempts["retry"] >= 2 {
+			select {
+			case <-done:
+			default:
+				close(done)
+			}
+		}
+		return nil
+	}
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- NewSubscriber(opts).Start(ctx, handler) }()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("requests not handled; attempts = %v", attempts)
+	}
+	cancel()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("Start() error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Start() did not return after cancel")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts["ignored"] != 0 || attempts["no-fleet"] != 0 {
+		t.Errorf("dropped messages reached the handler: %v", attempts)
+	}
+	if attempts["t1"] != 1 || attempts["retry"] != 2 {
+		t.Errorf("attempts = %v, want t1 once and retry twice", attempts)
+	}
+}
+
+func TestSubscriber_DeadLetter(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short")
+	}
+	opts := runServer(t)
+	opts.MaxDeliveries = 2
+	opts.DeadLetterSubject = "allocator.dead"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, err := connect(ctx, opts, "test"); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	nc, err := gnats.Connect(opts.URL)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer nc.Close()
+	dead, err := nc.SubscribeSync(opts.DeadLetterSubject)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	for _, body := range []string{`not json`, `{"ticketId":"stuck","fleet":"f"}`} {
+		if _, err := nc.Request(opts.RequestSubject, []byte(body), 5*time.Second); err != nil {
+			t.Fatalf("publish %s: %v", body, err)
+		}
+	}
+
+	var (
+		mu       sync.Mutex
+		attempts = map[string]int{}
+	)
+	handler := func(_ context.Context, req *queues.AllocationRequest) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[req.TicketID]++
+		return errors.New("transient")
+	}
+	stopped := make(chan error, 1)
+	go func() { stopped <- NewSubscriber(opts).Start(ctx, handler) }()
+
+	got := map[string]*gnats.Msg{}
+	for len(got) < 2 {
+		m, err := dead.NextMsg(10 * time.Second)
+		if err != nil {
+			t.Fatalf("dead-lettered %d of 2 messages: %v", len(got), err)
+		}
+		got[m.Header.Get(HeaderFailureReason)] = m
+	}
+	if m := got[ReasonMalformed]; m == nil || string(m.Data) != "not json" || m.Header.Get(HeaderDeliveryAttempts) != "1" {
+		t.Errorf("malformed dead letter = %#v", m)
+	}
+	m := got[ReasonMaxDeliveries]
+	if m == nil || m.Header.Get(HeaderDeliveryAttempts) != "2" || m.Header.Get(HeaderSourceSubject) != opts.RequestSubject || m.Header.Get(HeaderFailureError) != "transient" {
+		t.Errorf("max-deliveries dead letter = %#v", m)
+	}
+
+	// Terminated messages are not redelivered
+	time.Sleep(500 * time.Millisecond)
+	cancel()
+	if err := <-stopped; err != nil {
+		t.Errorf("Start() error: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts["stuck"] != 2 {
+		t.Errorf("stuck attempts = %d, want 2", attempts["stuck"])
+	}
+}
@@ -0,0 +1,95 @@
+package pubsub
+
+import (
+	"context"
+
+	gpubsub "cloud.google.com/go/pubsub"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// Options configures the Google project, resources and credentials used by Subscriber and Publisher
+type Options struct {
+	ProjectID    string
+	Subscription string
+	// RequestTopic is the topic the subscription is created on in emulator mode; defaults to the subscription ID
+	RequestTopic    string
+	ResultTopic     string
+	CredentialsFile string
+	// EmulatorHost connects to a Pub/Sub emulator (host:port) without credentials
+	// and creates missing topics and the subscription
+	EmulatorHost string
+	// Requests failing transiently this many deliveries are forwarded to
+	// DeadLetterTopic; without a topic they are retried indefinitely
+	MaxDeliveries   int
+	DeadLetterTopic string
+}
+
+func (o Options) requestTopic() string {
+	if o.RequestTopic != "" {
+		return o.RequestTopic
+	}
+	return o.Subscription
+}
+
+// newClient creates a client for the emulator, an explicit credentials file or the default credentials
+func newClient(ctx context.Context, opts Options, role string) (*gpubsub.Client, error) {
+	var clientOpts []option.ClientOption
+	switch {
+	case opts.EmulatorHost != "":
+		log.Debug().Str("projectID", opts.ProjectID).Str("emulator", opts.EmulatorHost).Msgf("initializing pubsub %s against emulator", role)
+		clientOpts = []option.ClientOption{
+			option.WithEndpoint(opts.EmulatorHost),
+			option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+			option.WithoutAuthentication(),
+			option.WithTelemetryDisabled(),
+		}
+	case opts.CredentialsFile != "":
+		log.Debug().Str("projectID", opts.ProjectID).Str("credsFile", opts.CredentialsFile).Msgf("initializing pubsub %s with explicit credentials", role)
+		clientOpts = []option.ClientOption{option.WithCredentialsFile(opts.CredentialsFile)}
+	default:
+		log.Debug().Str("projectID", opts.ProjectID).Msgf("initializing pubsub %s with default credentials", role)
+	}
+	client, err := gpubsub.NewClient(ctx, opts.ProjectID, clientOpts...)
+	if err != nil {
+		log.Error().Err(err).Str("projectID", opts.ProjectID).Msgf("failed to create pubsub client for %s", role)
+		return nil, err
+	}
+	return client, nil
+}
+
+// ensureTopic creates the topic if it doesn't exist; emulator mode only
+func ensureTopic(ctx context.Context, client *gpubsub.Client, id string) (*gpubsub.Topic, error) {
+	topic, err := client.CreateTopic(ctx, id)
+	if status.Code(err) == codes.AlreadyExists {
+		return client.Topic(id), nil
+	}
+	if err != nil {
+		log.Error().Err(err).Str("topic", id).Msg("failed to create pubsub topic")
+		return nil, err
+	}
+	log.Info().Str("topic", id).Msg("created pubsub topic")
+	return topic, nil
+}
+
+// ensureSubscription creates the subscription on topicID if it doesn't exist; emulator mode only
+func ensureSubscription(ctx context.Context, client *gpubsub.Client, id, topicID string) (*gpubsub.Subscription, error) {
+	topic, err := ensureTopic(ctx, client, topicID)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := client.CreateSubscription(ctx, id, gpubsub.SubscriptionConfig{Topic: topic})
+	if status.Code(err) == codes.AlreadyExists {
+		return client.Subscription(id), nil
+	}
+	if err != nil {
+		log.Error().Err(err).Str("subscription", id).Str("topic", topicID).Msg("failed to create pubsub subscription")
+		return nil, err
+	}
+	log.Info().Str("subscription", id).Str("topic", topicID).Msg("created pubsub subscription")
+	return sub, nil
+}
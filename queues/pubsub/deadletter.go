@@ -0,0 +1,123 @@
+package pubsub
+
+import (
+	"context"
+	"maps"
+	"strconv"
+	"sync"
+	"time"
+
+	"agones-pubsub-allocator/metrics"
+
+	gpubsub "cloud.google.com/go/pubsub"
+	"github.com/rs/zerolog/log"
+)
+
+// Attributes added to a dead-lettered request alongside its original attributes
+const (
+	AttrFailureReason      = "failureReason"
+	AttrFailureError       = "failureError"
+	AttrDeliveryAttempts   = "deliveryAttempts"
+	AttrSourceSubscription = "sourceSubscription"
+	AttrSourceMessageID    = "sourceMessageId"
+)
+
+// Values of AttrFailureReason
+const (
+	ReasonMalformed     = "malformed"
+	ReasonMaxDeliveries = "max-deliveries"
+)
+
+const (
+	defaultMaxDeliveries = 5
+	// attemptsTTL is how long a message's local delivery count is kept after its
+	// last failure, e.g. when another replica ends up acking it
+	attemptsTTL = time.Hour
+)
+
+// attempts counts deliveries per message ID for subscriptions without a
+// Pub/Sub dead-letter policy, which don't report Message.DeliveryAttempt.
+// Counts are per replica, so a message shared between replicas may be
+// delivered more than MaxDeliveries times in total before it is dead-lettered.
+type attempts struct {
+	mu   sync.Mutex
+	seen map[string]attempt
+	now  func() time.Time
+}
+
+type attempt struct {
+	n    int
+	last time.Time
+}
+
+func newAttempts() *attempts {
+	return &attempts{seen: make(map[string]attempt), now: time.Now}
+}
+
+// next records a delivery of the message and returns how many this replica has seen
+func (a *attempts) next(id string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	// Prune stale counts so the map doesn't grow without bound
+	for k, e := range a.seen {
+		if now.Sub(e.last) > attemptsTTL {
+			delete(a.seen, k)
+		}
+	}
+	e := a.seen[id]
+	e.n++
+	e.last = now
+	a.seen[id] = e
+	return e.n
+}
+
+// forget drops the count of a message that has been acked
+func (a *attempts) forget(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.seen, id)
+}
+
+// deliveryAttempt returns the message's delivery count, from Pub/Sub when the
+// subscription has a dead-letter policy and the local count otherwise
+func (s *Subscriber) deliveryAttempt(m *gpubsub.Message) int {
+	if m.DeliveryAttempt != nil {
+		return *m.DeliveryAttempt
+	}
+	return s.attempts.next(m.ID)
+}
+
+// deadLetter forwards the original payload to the dead-letter topic with the
+// failure as attributes and acks it. Without a dead-letter topic the message is
+// dropped. If forwarding fails the message is nacked and retried.
+func (s *Subscriber) deadLetter(ctx context.Context, m *gpubsub.Message, reason string, cause error, deliveries int) {
+	if s.deadLetters == nil {
+		log.Error().Err(cause).Str("subscription", s.subscriptionName).Str("messageID", m.ID).Str("reason", reason).Msg("no dead-letter topic configured; dropping message")
+		s.ack(m)
+		return
+	}
+	attrs := make(map[string]string, len(m.Attributes)+5)
+	maps.Copy(attrs, m.Attributes)
+	attrs[AttrFailureReason] = reason
+	attrs[AttrFailureError] = cause.Error()
+	attrs[AttrDeliveryAttempts] = strconv.Itoa(deliveries)
+	attrs[AttrSourceSubscription] = s.subscriptionName
+	attrs[AttrSourceMessageID] = m.ID
+
+	r := s.deadLetters.Publish(ctx, &gpubsub.Message{Data: m.Data, Attributes: attrs})
+	if _, err := r.Get(ctx); err != nil {
+		log.Error().Err(err).Str("topic", s.opts.DeadLetterTopic).Str("messageID", m.ID).Msg("failed to dead-letter message; will retry")
+		m.Nack()
+		return
+	}
+	metrics.DeadLetters.WithLabelValues(reason).Inc()
+	log.Warn().Err(cause).Str("subscription", s.subscriptionName).Str("messageID", m.ID).Str("reason", reason).Int("deliveries", deliveries).Str("deadLetterTopic", s.opts.DeadLetterTopic).Msg("message dead-lettered")
+	s.ack(m)
+}
+
+func (s *Subscriber) ack(m *gpubsub.Message) {
+	s.attempts.forget(m.ID)
+	m.Ack()
+}
@@ -0,0 +1,34 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAttempts(t *testing.T) {
+	now := time.Unix(0, 0)
+	a := newAttempts()
+	a.now = func() time.Time { return now }
+
+	for want := 1; want <= 3; want++ {
+		if got := a.next("m1"); got != want {
+			t.Fatalf("next(m1) = %d, want %d", got, want)
+		}
+	}
+	if got := a.next("m2"); got != 1 {
+		t.Errorf("next(m2) = %d, want 1", got)
+	}
+
+	// Acked messages start over
+	a.forget("m1")
+	if got := a.next("m1"); got != 1 {
+		t.Errorf("next(m1) after forget = %d, want 1", got)
+	}
+
+	// Counts idle longer than attemptsTTL are pruned
+	now = now.Add(attemptsTTL + time.Second)
+	a.next("m3")
+	if _, ok := a.seen["m2"]; ok {
+		t.Error("stale count for m2 was not pruned")
+	}
+}
@@ -0,0 +1,298 @@
+package pubsub_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"agones-pubsub-allocator/queues"
+	qpubsub "agones-pubsub-allocator/queues/pubsub"
+
+	gpubsub "cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const project = "e2e-project"
+
+// emulator starts a pstest server and returns Options pointing the transport at it
+func emulator(t *testing.T) (*pstest.Server, qpubsub.Options) {
+	t.Helper()
+	srv := pstest.NewServer()
+	t.Cleanup(func() { _ = srv.Close() })
+	return srv, qpubsub.Options{
+		ProjectID:    project,
+		Subscription: "requests-sub",
+		RequestTopic: "requests",
+		ResultTopic:  "results",
+		EmulatorHost: srv.Addr,
+	}
+}
+
+// inspect is a plain client for checking what the transport created
+func inspect(t *testing.T, srv *pstest.Server) *gpubsub.Client {
+	t.Helper()
+	client, err := gpubsub.NewClient(context.Background(), project,
+		option.WithEndpoint(srv.Addr),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())))
+	if err != nil {
+		t.Fatalf("inspect client: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestSubscriber_AckNack(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short")
+	}
+	srv, opts := emulator(t)
+	client := inspect(t, srv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var (
+		mu      sync.Mutex
+		handled = map[string]int{}
+	)
+	done := make(chan error, 1)
+	go func() {
+		done <- qpubsub.NewSubscriber(opts).Start(ctx, func(_ context.Context, req *queues.AllocationRequest) error {
+			mu.Lock()
+			handled[req.TicketID]++
+			mu.Unlock()
+			switch req.TicketID {
+			case "fail":
+				return errors.New("agones unavailable")
+			case "rejected":
+				return queues.Permanent(errors.New("allocation forbidden"))
+			}
+			return nil
+		})
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Start() error: %v", err)
+		}
+	}()
+
+	// Emulator mode creates the request topic and subscription
+	eventually(t, "subscription to be created", func() bool {
+		ok, err := client.Subscription(opts.Subscription).Exists(ctx)
+		return err == nil && ok
+	})
+
+	tests := []struct {
+		name    string
+		data    string
+		wantAck bool
+		handled string
+	}{
+		{name: "malformed payload is dropped without a dead-letter topic", data: `{not json`, wantAck: true},
+		{name: "non-request is acked", data: `{"type":"allocation-result","ticketId":"r1","status":"Success"}`, wantAck: true},
+		{name: "invalid payload is acked", data: `{"ticketId":"no-fleet"}`, wantAck: true},
+		{name: "handler error is nacked", data: `{"ticketId":"fail","fleet":"f"}`, handled: "fail"},
+		{name: "permanent handler error is acked", data: `{"ticketId":"rejected","fleet":"f"}`, wantAck: true, handled: "rejected"},
+		{name: "success is acked", data: `{"ticketId":"ok","fleet":"f","playerId":"p1"}`, wantAck: true, handled: "ok"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := srv.Publish("projects/"+project+"/topics/"+opts.RequestTopic, []byte(tt.data), nil)
+			if tt.wantAck {
+				eventually(t, "ack", func() bool { return srv.Message(id).Acks > 0 })
+			} else {
+				// A nack makes the emulator redeliver straight away
+				eventually(t, "redelivery", func() bool { return srv.Message(id).Deliveries > 1 })
+				if acks := srv.Message(id).Acks; acks != 0 {
+					t.Errorf("acks = %d, want 0", acks)
+				}
+			}
+			if tt.handled != "" {
+				mu.Lock()
+				n := handled[tt.handled]
+				mu.Unlock()
+				if n == 0 {
+					t.Errorf("handler was not called for %s", tt.handled)
+				}
+			}
+		})
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if handled["r1"] != 0 || handled["no-fleet"] != 0 {
+		t.Errorf("handler called for dropped messages: %v", handled)
+	}
+}
+
+func TestSubscriber_DeadLetter(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short")
+	}
+	srv, opts := emulator(t)
+	client := inspect(t, srv)
+	opts.DeadLetterTopic = "requests-dead"
+	opts.MaxDeliveries = 3
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- qpubsub.NewSubscriber(opts).Start(ctx, func(_ context.Context, req *queues.AllocationRequest) error {
+			if req.TicketID == "ok" {
+				return nil
+			}
+			return errors.New("agones unavailable")
+		})
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Start() error: %v", err)
+		}
+	}()
+
+	eventually(t, "subscription to be created", func() bool {
+		ok, err := client.Subscription(opts.Subscription).Exists(ctx)
+		return err == nil && ok
+	})
+
+	// deadLettered finds the copy of message id on the dead-letter topic
+	deadLettered := func(id string) *pstest.Message {
+		for _, m := range srv.Messages() {
+			if m.Attributes[qpubsub.AttrSourceMessageID] == id {
+				return m
+			}
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name           string
+		data           string
+		wantReason     string
+		wantDeliveries int
+	}{
+		{name: "malformed payload is dead-lettered at once", data: `{not json`, wantReason: qpubsub.ReasonMalformed, wantDeliveries: 1},
+		{name: "transient failure is dead-lettered after max deliveries", data: `{"ticketId":"fail","fleet":"f"}`, wantReason: qpubsub.ReasonMaxDeliveries, wantDeliveries: 3},
+		{name: "success is not dead-lettered", data: `{"ticketId":"ok","fleet":"f"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := srv.Publish("projects/"+project+"/topics/"+opts.RequestTopic, []byte(tt.data), map[string]string{"origin": "e2e"})
+			eventually(t, "ack", func() bool { return srv.Message(id).Acks > 0 })
+
+			dead := deadLettered(id)
+			if tt.wantReason == "" {
+				if dead != nil {
+					t.Errorf("dead-lettered %#v, want none", dead.Attributes)
+				}
+				return
+			}
+			if dead == nil {
+				t.Fatal("message was not dead-lettered")
+			}
+			if got := srv.Message(id).Deliveries; got != tt.wantDeliveries {
+				t.Errorf("deliveries = %d, want %d", got, tt.wantDeliveries)
+			}
+			if string(dead.Data) != tt.data {
+				t.Errorf("dead-letter payload = %q, want original %q", dead.Data, tt.data)
+			}
+			want := map[string]string{
+				"origin":                       "e2e",
+				qpubsub.AttrFailureReason:      tt.wantReason,
+				qpubsub.AttrDeliveryAttempts:   strconv.Itoa(tt.wantDeliveries),
+				qpubsub.AttrSourceSubscription: opts.Subscription,
+				qpubsub.AttrSourceMessageID:    id,
+			}
+			for k, v := range want {
+				if dead.Attributes[k] != v {
+					t.Errorf("attribute %s = %q, want %q", k, dead.Attributes[k], v)
+				}
+			}
+			if dead.Attributes[qpubsub.AttrFailureError] == "" {
+				t.Errorf("attribute %s is empty", qpubsub.AttrFailureError)
+			}
+		})
+	}
+}
+
+func TestSubscriber_DeadLetterAfterRestart(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short")
+	}
+	srv, opts := emulator(t)
+	client := inspect(t, srv)
+	opts.DeadLetterTopic = "requests-dead"
+	sub := qpubsub.NewSubscriber(opts)
+	handler := func(context.Context, *queues.AllocationRequest) error { return nil }
+
+	// The first run ends, e.g. when leadership is lost
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- sub.Start(ctx, handler) }()
+	eventually(t, "subscription to be created", func() bool {
+		ok, err := client.Subscription(opts.Subscription).Exists(ctx)
+		return err == nil && ok
+	})
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("first Start() error: %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() { done <- sub.Start(ctx, handler) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("second Start() error: %v", err)
+		}
+	}()
+
+	id := srv.Publish("projects/"+project+"/topics/"+opts.RequestTopic, []byte(`{not json`), nil)
+	eventually(t, "ack", func() bool { return srv.Message(id).Acks > 0 })
+	for _, m := range srv.Messages() {
+		if m.Attributes[qpubsub.AttrSourceMessageID] == id {
+			return
+		}
+	}
+	t.Error("message was not dead-lettered on the second run")
+}
+
+func TestPublisher_Emulator(t *testing.T) {
+	if testing.Short() {
+		t.Skip("short")
+	}
+	srv, opts := emulator(t)
+	client := inspect(t, srv)
+	ctx := context.Background()
+
+	p := qpubsub.NewPublisher(opts)
+	res := &queues.AllocationResult{EnvelopeVersion: queues.ResultEnvelopeVersion, Type: "allocation-result", TicketID: "t1", Status: queues.StatusSuccess}
+	if err := p.PublishResult(ctx, res); err != nil {
+		t.Fatalf("PublishResult() error: %v", err)
+	}
+	// Emulator mode created the result topic on first publish
+	if ok, err := client.Topic(opts.ResultTopic).Exists(ctx); err != nil || !ok {
+		t.Errorf("result topic exists = %v, %v; want true", ok, err)
+	}
+	if n := len(srv.Messages()); n != 1 {
+		t.Errorf("messages = %d, want 1", n)
+	}
+}
@@ -3,46 +3,29 @@ package pubsub
 import (
 	"context"
 	"encoding/json"
+	"sync"
 
 	"agones-pubsub-allocator/queues"
 
 	gpubsub "cloud.google.com/go/pubsub"
 	"github.com/rs/zerolog/log"
-	"google.golang.org/api/option"
 )
 
 type Publisher struct {
-	projectID   string
-	resultTopic string
-	credsFile   string
-	client      *gpubsub.Client
-	topic       *gpubsub.Topic
+	opts   Options
+	mu     sync.Mutex
+	client *gpubsub.Client
+	topic  *gpubsub.Topic
 }
 
-func NewPublisher(projectID, resultTopic, credsFile string) *Publisher {
-	return &Publisher{projectID: projectID, resultTopic: resultTopic, credsFile: credsFile}
+func NewPublisher(opts Options) *Publisher {
+	return &Publisher{opts: opts}
 }
 
 func (p *Publisher) PublishResult(ctx context.Context, res *queues.AllocationResult) error {
-	if p.client == nil {
-		var (
-			client *gpubsub.Client
-			err    error
-		)
-		if p.credsFile != "" {
-			log.Debug().Str("projectID", p.projectID).Str("topic", p.resultTopic).Str("credsFile", p.credsFile).Msg("initializing pubsub publisher with explicit credentials")
-			client, err = gpubsub.NewClient(ctx, p.projectID, option.WithCredentialsFile(p.credsFile))
-		} else {
-			log.Debug().Str("projectID", p.projectID).Str("topic", p.resultTopic).Msg("initializing pubsub publisher with default credentials")
-			client, err = gpubsub.NewClient(ctx, p.projectID)
-		}
-		if err != nil {
-			log.Error().Err(err).Str("projectID", p.projectID).Str("topic", p.resultTopic).Msg("failed to create pubsub client for publisher")
-			return err
-		}
-		p.client = client
-		p.topic = client.Topic(p.resultTopic)
-		log.Info().Str("topic", p.resultTopic).Msg("pubsub publisher initialized")
+	topic, err := p.resultTopic(ctx)
+	if err != nil {
+		return err
 	}
 	b, err := json.Marshal(res)
 	if err != nil {
@@ -50,7 +33,7 @@ func (p *Publisher) PublishResult(ctx context.Context, res *queues.AllocationRes
 		return err
 	}
 	// Publish and wait for server ack
-	r := p.topic.Publish(ctx, &gpubsub.Message{Data: b})
+	r := topic.Publish(ctx, &gpubsub.Message{Data: b})
 	id, err := r.Get(ctx)
 	if err != nil {
 		log.Error().Err(err).Str("ticketId", res.TicketID).Msg("failed to publish allocation result")
@@ -59,3 +42,29 @@ func (p *Publisher) PublishResult(ctx context.Context, res *queues.AllocationRes
 	log.Debug().Str("messageID", id).Str("ticketId", res.TicketID).Str("status", string(res.Status)).Msg("published allocation result")
 	return nil
 }
+
+func (p *Publisher) resultTopic(ctx context.Context) (*gpubsub.Topic, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.topic != nil {
+		return p.topic, nil
+	}
+	if p.client == nil {
+		client, err := newClient(ctx, p.opts, "publisher")
+		if err != nil {
+			return nil, err
+		}
+		p.client = client
+	}
+	if p.opts.EmulatorHost != "" {
+		topic, err := ensureTopic(ctx, p.client, p.opts.ResultTopic)
+		if err != nil {
+			return nil, err
+		}
+		p.topic = topic
+	} else {
+		p.topic = p.client.Topic(p.opts.ResultTopic)
+	}
+	log.Info().Str("topic", p.opts.ResultTopic).Msg("pubsub publisher initialized")
+	return p.topic, nil
+}
@@ -54,7 +54,7 @@ func TestPublisher_PublishResult(t *testing.T) {
 					t.Fatalf("create topic: %#v", err)
 				}
 				// Build publisher with injected client/topic
-				return &Publisher{projectID: "test-project", resultTopic: "test-topic", client: client, topic: topic}
+				return &Publisher{opts: Options{ProjectID: "test-project", ResultTopic: "test-topic"}, client: client, topic: topic}
 			},
 			args:    args{res: &queues.AllocationResult{EnvelopeVersion: "1.0", Type: "allocated-result", TicketID: "t1", Status: queues.StatusSuccess}},
 			wantErr: false,
@@ -64,7 +64,7 @@ func TestPublisher_PublishResult(t *testing.T) {
 			setup: func() *Publisher {
 				// Get handle to non-existent topic
 				topic := client.Topic("missing-topic")
-				return &Publisher{projectID: "test-project", resultTopic: "missing-topic", client: client, topic: topic}
+				return &Publisher{opts: Options{ProjectID: "test-project", ResultTopic: "missing-topic"}, client: client, topic: topic}
 			},
 			args:    args{res: &queues.AllocationResult{EnvelopeVersion: "1.0", Type: "allocated-result", TicketID: "t2", Status: queues.StatusFailure}},
 			wantErr: true,
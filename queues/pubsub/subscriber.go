@@ -2,86 +2,108 @@ package pubsub
 
 import (
 	"context"
-	"encoding/json"
 	"time"
 
 	"agones-pubsub-allocator/queues"
 
 	gpubsub "cloud.google.com/go/pubsub"
 	"github.com/rs/zerolog/log"
-	"google.golang.org/api/option"
 )
 
+// Subscriber receives allocation requests from a Pub/Sub subscription. Transient
+// handler failures are nacked until a request has been delivered MaxDeliveries
+// times, after which it is forwarded to DeadLetterTopic if one is configured.
+// Malformed payloads and permanent failures are never retried.
 type Subscriber struct {
-	projectID        string
+	opts             Options
 	subscriptionName string
-	credsFile        string
 	client           *gpubsub.Client
 	sub              *gpubsub.Subscription
+	deadLetters      *gpubsub.Topic
+	attempts         *attempts
 }
 
-func NewSubscriber(projectID, subscriptionName, credsFile string) *Subscriber {
-	return &Subscriber{projectID: projectID, subscriptionName: subscriptionName, credsFile: credsFile}
+func NewSubscriber(opts Options) *Subscriber {
+	if opts.MaxDeliveries <= 0 {
+		opts.MaxDeliveries = defaultMaxDeliveries
+	}
+	return &Subscriber{opts: opts, subscriptionName: opts.Subscription, attempts: newAttempts()}
 }
 
 func (s *Subscriber) Start(ctx context.Context, handler func(context.Context, *queues.AllocationRequest) error) error {
 	if s.client == nil {
-		var (
-			client *gpubsub.Client
-			err    error
-		)
-		if s.credsFile != "" {
-			log.Debug().Str("projectID", s.projectID).Str("subscription", s.subscriptionName).Str("credsFile", s.credsFile).Msg("initializing pubsub subscriber with explicit credentials")
-			client, err = gpubsub.NewClient(ctx, s.projectID, option.WithCredentialsFile(s.credsFile))
-		} else {
-			log.Debug().Str("projectID", s.projectID).Str("subscription", s.subscriptionName).Msg("initializing pubsub subscriber with default credentials")
-			client, err = gpubsub.NewClient(ctx, s.projectID)
-		}
+		client, err := newClient(ctx, s.opts, "subscriber")
 		if err != nil {
-			log.Error().Err(err).Str("projectID", s.projectID).Str("subscription", s.subscriptionName).Msg("failed to create pubsub client for subscriber")
 			return err
 		}
 		s.client = client
-		s.sub = client.Subscription(s.subscriptionName)
+	}
+	if s.sub == nil {
+		if s.opts.EmulatorHost != "" {
+			sub, err := ensureSubscription(ctx, s.client, s.subscriptionName, s.opts.requestTopic())
+			if err != nil {
+				return err
+			}
+			s.sub = sub
+		} else {
+			s.sub = s.client.Subscription(s.subscriptionName)
+		}
 		log.Info().Str("subscription", s.subscriptionName).Msg("pubsub subscriber initialized")
 	}
+	if s.deadLetters == nil && s.opts.DeadLetterTopic != "" {
+		if s.opts.EmulatorHost != "" {
+			topic, err := ensureTopic(ctx, s.client, s.opts.DeadLetterTopic)
+			if err != nil {
+				return err
+			}
+			s.deadLetters = topic
+		} else {
+			s.deadLetters = s.client.Topic(s.opts.DeadLetterTopic)
+		}
+		// A stopped topic can't publish, so the next Start sets it up again
+		defer func() {
+			s.deadLetters.Stop()
+			s.deadLetters = nil
+		}()
+		log.Info().Str("topic", s.opts.DeadLetterTopic).Int("maxDeliveries", s.opts.MaxDeliveries).Msg("pubsub dead-letter topic initialized")
+	}
 
 	return s.sub.Receive(ctx, func(ctx context.Context, m *gpubsub.Message) {
-		log.Debug().Str("subscription", s.subscriptionName).Str("messageID", m.ID).Int("size", len(m.Data)).Msg("received pubsub message")
-		recvAt := time.Now()
-		// Parse envelope to inspect type
-		var env struct {
-			Type string `json:"type"`
-		}
-		if err := json.Unmarshal(m.Data, &env); err != nil {
-			log.Error().Err(err).Str("subscription", s.subscriptionName).Msg("failed to unmarshal message envelope")
-			m.Nack()
-			return
-		}
-		if env.Type != "" && env.Type != "allocation-request" {
-			log.Debug().Str("subscription", s.subscriptionName).Str("type", env.Type).Msg("ignoring non-request message")
-			m.Ack()
-			return
-		}
-		// Unmarshal request
-		var req queues.AllocationRequest
-		if err := json.Unmarshal(m.Data, &req); err != nil {
-			log.Error().Err(err).Str("subscription", s.subscriptionName).Msg("failed to unmarshal allocation request")
-			m.Nack()
+		s.handle(ctx, m, handler)
+	})
+}
+
+func (s *Subscriber) handle(ctx context.Context, m *gpubsub.Message, handler func(context.Context, *queues.AllocationRequest) error) {
+	log.Debug().Str("subscription", s.subscriptionName).Str("messageID", m.ID).Int("size", len(m.Data)).Msg("received pubsub message")
+	recvAt := time.Now()
+	req, err := queues.DecodeRequest(m.Data)
+	if err != nil {
+		if queues.Drop(err) {
+			log.Warn().Err(err).Str("subscription", s.subscriptionName).Msg("dropping message")
+			s.ack(m)
 			return
 		}
-		if req.TicketID == "" || req.Fleet == "" {
-			log.Error().Str("subscription", s.subscriptionName).Str("ticketId", req.TicketID).Str("fleet", req.Fleet).Msg("invalid request payload")
-			m.Ack()
+		// A malformed payload never decodes on redelivery
+		log.Error().Err(err).Str("subscription", s.subscriptionName).Msg("failed to decode allocation request")
+		s.deadLetter(ctx, m, ReasonMalformed, err, s.deliveryAttempt(m))
+		return
+	}
+	log.Info().Str("subscription", s.subscriptionName).Str("type", req.Type).Str("ticketId", req.TicketID).Str("fleet", req.Fleet).Str("playerId", req.PlayerID).Msg("handling allocation request")
+	if err := handler(ctx, req); err != nil {
+		if queues.IsPermanent(err) {
+			log.Warn().Err(err).Str("subscription", s.subscriptionName).Str("ticketId", req.TicketID).Msg("request failed permanently; acking message")
+			s.ack(m)
 			return
 		}
-		log.Info().Str("subscription", s.subscriptionName).Str("ticketId", req.TicketID).Str("fleet", req.Fleet).Str("playerId", req.PlayerID).Msg("handling allocation request")
-		if err := handler(ctx, &req); err != nil {
-			log.Error().Err(err).Str("subscription", s.subscriptionName).Str("ticketId", req.TicketID).Msg("handler failed; will retry")
-			m.Nack()
+		n := s.deliveryAttempt(m)
+		if s.deadLetters != nil && n >= s.opts.MaxDeliveries {
+			s.deadLetter(ctx, m, ReasonMaxDeliveries, err, n)
 			return
 		}
-		log.Debug().Str("subscription", s.subscriptionName).Str("ticketId", req.TicketID).Dur("latency", time.Since(recvAt)).Msg("handler succeeded; acking message")
-		m.Ack()
-	})
+		log.Error().Err(err).Str("subscription", s.subscriptionName).Str("ticketId", req.TicketID).Int("deliveries", n).Msg("handler failed; will retry")
+		m.Nack()
+		return
+	}
+	log.Debug().Str("subscription", s.subscriptionName).Str("ticketId", req.TicketID).Dur("latency", time.Since(recvAt)).Msg("handler succeeded; acking message")
+	s.ack(m)
 }
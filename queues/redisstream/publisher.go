@@ -0,0 +1,62 @@
+package redisstream
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"agones-pubsub-allocator/queues"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+// Publisher appends allocation results to the result stream with XADD
+type Publisher struct {
+	opts   Options
+	mu     sync.Mutex
+	client *redis.Client
+}
+
+func NewPublisher(opts Options) *Publisher {
+	return &Publisher{opts: opts}
+}
+
+func (p *Publisher) PublishResult(ctx context.Context, res *queues.AllocationResult) error {
+	client, err := p.redis()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		log.Error().Err(err).Interface("result", res).Msg("failed to marshal allocation result")
+		return err
+	}
+	id, err := client.XAdd(ctx, &redis.XAddArgs{
+		Stream: p.opts.ResultStream,
+		MaxLen: p.opts.ResultMaxLen,
+		Approx: p.opts.ResultMaxLen > 0,
+		Values: map[string]any{payloadField: string(b), "ticketId": res.TicketID},
+	}).Result()
+	if err != nil {
+		log.Error().Err(err).Str("ticketId", res.TicketID).Msg("failed to publish allocation result")
+		return err
+	}
+	log.Debug().Str("id", id).Str("ticketId", res.TicketID).Str("status", string(res.Status)).Msg("published allocation result")
+	return nil
+}
+
+func (p *Publisher) redis() (*redis.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client == nil {
+		client, err := newClient(p.opts.URL)
+		if err != nil {
+			log.Error().Err(err).Msg("invalid redis url for publisher")
+			return nil, err
+		}
+		p.client = client
+		log.Info().Str("stream", p.opts.ResultStream).Msg("redis stream publisher initialized")
+	}
+	return p.client, nil
+}
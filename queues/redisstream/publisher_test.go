@@ -0,0 +1,32 @@
+package redisstream
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"agones-pubsub-allocator/queues"
+)
+
+func TestPublisher_PublishResult(t *testing.T) {
+	opts, client := runRedis(t)
+	opts.ResultMaxLen = 100
+	ctx := context.Background()
+
+	res := &queues.AllocationResult{EnvelopeVersion: queues.ResultEnvelopeVersion, Type: "allocation-result", TicketID: "t1", Status: queues.StatusSuccess}
+	if err := NewPublisher(opts).PublishResult(ctx, res); err != nil {
+		t.Fatalf("PublishResult() error: %v", err)
+	}
+
+	entries, err := client.XRange(ctx, opts.ResultStream, "-", "+").Result()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("result entries = %v, %v; want 1", entries, err)
+	}
+	var got queues.AllocationResult
+	if err := json.Unmarshal([]byte(entries[0].Values[payloadField].(string)), &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if got.TicketID != "t1" || got.Status != queues.StatusSuccess || entries[0].Values["ticketId"] != "t1" {
+		t.Errorf("published %#v, want t1 Success", entries[0].Values)
+	}
+}
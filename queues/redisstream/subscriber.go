@@ -0,0 +1,233 @@
+package redisstream
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"agones-pubsub-allocator/metrics"
+	"agones-pubsub-allocator/queues"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+// payloadField is the stream entry field holding the JSON message
+const payloadField = "data"
+
+// Options configures the Redis streams used by Subscriber and Publisher
+type Options struct {
+	// redis:// or rediss:// URL
+	URL           string
+	RequestStream string
+	ResultStream  string
+	// Consumer group; replicas sharing it split the requests
+	Group string
+	// Consumer name within the group, unique per replica
+	Consumer string
+	// Requests failing this many deliveries are moved to DeadLetterStream
+	MaxDeliveries    int64
+	DeadLetterStream string
+	// Pending requests idle this long are reclaimed from crashed or failed handlers
+	ClaimIdle time.Duration
+	// Approximate cap on the result stream length; 0 keeps everything
+	ResultMaxLen int64
+}
+
+const (
+	defaultMaxDeliveries = 5
+	defaultClaimIdle     = 30 * time.Second
+	readCount            = 10
+	readBlock            = 2 * time.Second
+)
+
+func newClient(url string) (*redis.Client, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return redis.NewClient(opts), nil
+}
+
+// Subscriber reads allocation requests with XREADGROUP and XACKs them once handled.
+// A failed request stays pending and is redelivered by XAUTOCLAIM after ClaimIdle,
+// which plays the role of a Pub/Sub nack; after MaxDeliveries it is dead-lettered.
+type Subscriber struct {
+	opts   Options
+	client *redis.Client
+}
+
+func NewSubscriber(opts Options) *Subscriber {
+	if opts.MaxDeliveries <= 0 {
+		opts.MaxDeliveries = defaultMaxDeliveries
+	}
+	if opts.ClaimIdle <= 0 {
+		opts.ClaimIdle = defaultClaimIdle
+	}
+	return &Subscriber{opts: opts}
+}
+
+// Start reads until ctx is done, finishing the current batch before returning
+func (s *Subscriber) Start(ctx context.Context, handler func(context.Context, *queues.AllocationRequest) error) error {
+	if s.client == nil {
+		client, err := newClient(s.opts.URL)
+		if err != nil {
+			log.Error().Err(err).Msg("invalid redis url for subscriber")
+			return err
+		}
+		s.client = client
+	}
+	// Start from the beginning of the stream so requests sent before the group existed are served
+	if err := s.client.XGroupCreateMkStream(ctx, s.opts.RequestStream, s.opts.Group, "0").Err(); err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		log.Error().Err(err).Str("stream", s.opts.RequestStream).Str("group", s.opts.Group).Msg("failed to create redis consumer group")
+		return err
+	}
+	log.Info().Str("stream", s.opts.RequestStream).Str("group", s.opts.Group).Str("consumer", s.opts.Consumer).Msg("redis stream subscriber initialized")
+
+	var lastClaim time.Time
+	for ctx.Err() == nil {
+		var msgs []redis.XMessage
+		if time.Since(lastClaim) >= s.opts.ClaimIdle/2 {
+			lastClaim = time.Now()
+			claimed, err := s.reclaim(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Warn().Err(err).Str("stream", s.opts.RequestStream).Msg("failed to reclaim pending requests")
+			}
+			msgs = claimed
+		}
+		if len(msgs) == 0 {
+			streams, err := s.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+				Group:    s.opts.Group,
+				Consumer: s.opts.Consumer,
+				Streams:  []string{s.opts.RequestStream, ">"},
+				Count:    readCount,
+				Block:    max(min(readBlock, s.opts.ClaimIdle/2), time.Millisecond),
+			}).Result()
+			if err != nil && !errors.Is(err, redis.Nil) {
+				if ctx.Err() != nil {
+					break
+				}
+				log.Error().Err(err).Str("stream", s.opts.RequestStream).Msg("failed to read redis stream")
+				sleep(ctx, time.Second)
+				continue
+			}
+			for _, st := range streams {
+				msgs = append(msgs, st.Messages...)
+			}
+		}
+
+		var wg sync.WaitGroup
+		for _, m := range msgs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.handle(ctx, m, handler)
+			}()
+		}
+		wg.Wait()
+	}
+	return nil
+}
+
+// reclaim claims requests left pending longer than ClaimIdle and dead-letters
+// those that have used up their deliveries
+func (s *Subscriber) reclaim(ctx context.Context) ([]redis.XMessage, error) {
+	msgs, _, err := s.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   s.opts.RequestStream,
+		Group:    s.opts.Group,
+		Consumer: s.opts.Consumer,
+		MinIdle:  s.opts.ClaimIdle,
+		Start:    "0-0",
+		Count:    readCount,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := msgs[:0]
+	for _, m := range msgs {
+		pending, err := s.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: s.opts.RequestStream,
+			Group:  s.opts.Group,
+			Start:  m.ID,
+			End:    m.ID,
+			Count:  1,
+		}).Result()
+		if err != nil {
+			return out, err
+		}
+		if len(pending) == 1 && pending[0].RetryCount > s.opts.MaxDeliveries {
+			s.deadLetter(ctx, m, pending[0].RetryCount-1)
+			continue
+		}
+		log.Info().Str("stream", s.opts.RequestStream).Str("id", m.ID).Msg("reclaimed pending request")
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// deadLetter copies a request to the dead-letter stream and acks the original
+func (s *Subscriber) deadLetter(ctx context.Context, m redis.XMessage, deliveries int64) {
+	if s.opts.DeadLetterStream != "" {
+		err := s.client.XAdd(ctx, &redis.XAddArgs{
+			Stream: s.opts.DeadLetterStream,
+			Values: map[string]any{
+				payloadField: m.Values[payloadField],
+				"stream":     s.opts.RequestStream,
+				"id":         m.ID,
+				"deliveries": deliveries,
+			},
+		}).Err()
+		if err != nil {
+			log.Error().Err(err).Str("stream", s.opts.DeadLetterStream).Str("id", m.ID).Msg("failed to dead-letter request; will retry")
+			return
+		}
+		metrics.DeadLetters.WithLabelValues("max-deliveries").Inc()
+	}
+	log.Warn().Str("stream", s.opts.RequestStream).Str("id", m.ID).Int64("deliveries", deliveries).Str("deadLetterStream", s.opts.DeadLetterStream).Msg("request exceeded max deliveries; dead-lettered")
+	s.ack(ctx, m.ID)
+}
+
+func (s *Subscriber) handle(ctx context.Context, m redis.XMessage, handler func(context.Context, *queues.AllocationRequest) error) {
+	data, _ := m.Values[payloadField].(string)
+	log.Debug().Str("stream", s.opts.RequestStream).Str("id", m.ID).Int("size", len(data)).Msg("received redis stream message")
+	recvAt := time.Now()
+	req, err := queues.DecodeRequest([]byte(data))
+	if err != nil {
+		if queues.Drop(err) {
+			log.Warn().Err(err).Str("stream", s.opts.RequestStream).Str("id", m.ID).Msg("dropping message")
+			s.ack(ctx, m.ID)
+			return
+		}
+		// Left pending; dead-lettered once it runs out of deliveries
+		log.Error().Err(err).Str("stream", s.opts.RequestStream).Str("id", m.ID).Msg("failed to decode allocation request")
+		return
+	}
+	log.Info().Str("stream", s.opts.RequestStream).Str("type", req.Type).Str("ticketId", req.TicketID).Str("fleet", req.Fleet).Str("playerId", req.PlayerID).Msg("handling allocation request")
+	if err := handler(ctx, req); err != nil {
+		if queues.IsPermanent(err) {
+			log.Warn().Err(err).Str("stream", s.opts.RequestStream).Str("ticketId", req.TicketID).Msg("request failed permanently; acking message")
+			s.ack(ctx, m.ID)
+			return
+		}
+		log.Error().Err(err).Str("stream", s.opts.RequestStream).Str("ticketId", req.TicketID).Msg("handler failed; will retry")
+		return
+	}
+	log.Debug().Str("stream", s.opts.RequestStream).Str("ticketId", req.TicketID).Dur("latency", time.Since(recvAt)).Msg("handler succeeded; acking message")
+	s.ack(ctx, m.ID)
+}
+
+func (s *Subscriber) ack(ctx context.Context, id string) {
+	// Ack even if ctx ended while handling, so finished work isn't redelivered
+	if err := s.client.XAck(context.WithoutCancel(ctx), s.opts.RequestStream, s.opts.Group, id).Err(); err != nil {
+		log.Warn().Err(err).Str("stream", s.opts.RequestStream).Str("id", id).Msg("failed to ack redis stream message")
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,103 @@
+package redisstream
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"agones-pubsub-allocator/queues"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// runRedis starts an in-process Redis stand-in
+func runRedis(t *testing.T) (Options, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	opts := Options{
+		URL:              "redis://" + mr.Addr(),
+		RequestStream:    "requests",
+		ResultStream:     "results",
+		Group:            "allocator",
+		Consumer:         "replica-1",
+		MaxDeliveries:    2,
+		DeadLetterStream: "requests:dlq",
+		ClaimIdle:        50 * time.Millisecond,
+	}
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return opts, client
+}
+
+func TestSubscriber_Start(t *testing.T) {
+	opts, client := runRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, body := range []string{
+		`{"type":"allocation-result","ticketId":"ignored"}`,
+		`{"ticketId":"t1","fleet":"f"}`,
+		`{"ticketId":"retry","fleet":"f"}`,
+		`{"ticketId":"poison","fleet":"f"}`,
+	} {
+		if err := client.XAdd(ctx, &redis.XAddArgs{Stream: opts.RequestStream, Values: map[string]any{payloadField: body}}).Err(); err != nil {
+			t.Fatalf("xadd: %v", err)
+		}
+	}
+
+	var (
+		mu       sync.Mutex
+		attempts = map[string]int{}
+	)
+	handler := func(_ context.Context, req *queues.AllocationRequest) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[req.TicketID]++
+		if req.TicketID == "poison" || (req.TicketID == "retry" && attempts[req.TicketID] == 1) {
+			return errors.New("transient")
+		}
+		return nil
+	}
+	stopped := make(chan error, 1)
+	go func() { stopped <- NewSubscriber(opts).Start(ctx, handler) }()
+
+	// The poison request ends up in the dead-letter stream and nothing stays pending
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		dlq, _ := client.XLen(ctx, opts.DeadLetterStream).Result()
+		pending, _ := client.XPending(ctx, opts.RequestStream, opts.Group).Result()
+		if dlq == 1 && pending != nil && pending.Count == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("dead letters = %d, pending = %#v; want 1 and none", dlq, pending)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	if err := <-stopped; err != nil {
+		t.Errorf("Start() error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string]int{"t1": 1, "retry": 2, "poison": 2}
+	for ticket, n := range want {
+		if attempts[ticket] != n {
+			t.Errorf("%s handled %d times, want %d (attempts %v)", ticket, attempts[ticket], n, attempts)
+		}
+	}
+	if attempts["ignored"] != 0 {
+		t.Errorf("non-request message reached the handler")
+	}
+
+	entries, err := client.XRange(context.Background(), opts.DeadLetterStream, "-", "+").Result()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("dead-letter entries = %v, %v", entries, err)
+	}
+	if got := entries[0].Values[payloadField]; got != `{"ticketId":"poison","fleet":"f"}` {
+		t.Errorf("dead-lettered payload = %v, want the poison request", got)
+	}
+}
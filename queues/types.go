@@ -2,22 +2,111 @@ package queues
 
 import "context"
 
+// Request message types. An empty type is an allocation request.
+const (
+	RequestTypeAllocate = "allocation-request"
+	RequestTypeCancel   = "allocation-cancel" // only ticketId is required
+)
+
 type AllocationRequest struct {
+	Type            string   `json:"type,omitempty"`
 	TicketID        string   `json:"ticketId"`
 	Fleet           string   `json:"fleet"`
 	PlayerID        string   `json:"playerId,omitempty"`
 	JoinOnIDs       []string `json:"joinOnIds,omitempty"`       // Array of player IDs to join (friends/party lead)
 	CanJoinNotFound bool     `json:"canJoinNotFound,omitempty"` // Allow allocation if joinOnIds not found on any server
+	Priority        int      `json:"priority,omitempty"`        // Queue tier; higher tiers are admitted first (default 0)
+	CallbackURL     string   `json:"callbackUrl,omitempty"`     // Webhook for this ticket's results when the webhook publisher allows callbacks
+	// Narrow the fleet's GameServers; label keys must be allowed by the fleet config
+	MatchLabels       map[string]string     `json:"matchLabels,omitempty"`
+	MatchExpressions  []SelectorRequirement `json:"matchExpressions,omitempty"`
+	GameServerState   string                `json:"gameServerState,omitempty"`   // "Ready" (default) or "Allocated" to re-use a running server
+	FallbackSelectors []GameServerSelector  `json:"fallbackSelectors,omitempty"` // Tried in order when the selector above matches nothing
+	// Labels and annotations stamped on the allocated GameServer, e.g. match ID or map name
+	Metadata *GameServerMetadata `json:"metadata,omitempty"`
+	// Replace the fleet's priorities and add to or override its counter and list actions
+	Priorities []AllocationPriority     `json:"priorities,omitempty"`
+	Counters   map[string]CounterAction `json:"counters,omitempty"`
+	Lists      map[string]ListAction    `json:"lists,omitempty"`
+	// "Packed" or "Distributed"; only honoured when the fleet allows overrides
+	Scheduling string `json:"scheduling,omitempty"`
+	// Regions tried first, in order, when the allocator has a cluster registry
+	PreferredRegions []string `json:"preferredRegions,omitempty"`
+}
+
+// AllocationPriority sorts GameServers by a Counter's or List's available capacity
+type AllocationPriority struct {
+	Type  string `json:"type"` // Counter or List
+	Key   string `json:"key"`
+	Order string `json:"order,omitempty"` // Ascending (default) or Descending
+}
+
+// CounterAction changes a Counter on the allocated GameServer
+type CounterAction struct {
+	Action   string `json:"action,omitempty"` // Increment or Decrement by Amount
+	Amount   int64  `json:"amount,omitempty"`
+	Capacity *int64 `json:"capacity,omitempty"`
+}
+
+// ListAction changes a List on the allocated GameServer
+type ListAction struct {
+	AddValues    []string `json:"addValues,omitempty"`
+	DeleteValues []string `json:"deleteValues,omitempty"`
+	Capacity     *int64   `json:"capacity,omitempty"`
+}
+
+// GameServerMetadata is applied to the allocated GameServer through the allocation's metadata patch
+type GameServerMetadata struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// SelectorRequirement is a Kubernetes label selector requirement
+type SelectorRequirement struct {
+	Key      string   `json:"key"`
+	Operator string   `json:"operator"` // In, NotIn, Exists or DoesNotExist
+	Values   []string `json:"values,omitempty"`
+}
+
+// GameServerSelector is one preferred set of GameServers within the request's fleet
+type GameServerSelector struct {
+	MatchLabels      map[string]string     `json:"matchLabels,omitempty"`
+	MatchExpressions []SelectorRequirement `json:"matchExpressions,omitempty"`
+	GameServerState  string                `json:"gameServerState,omitempty"`
 }
 
+// ResultEnvelopeVersion is stamped on every published AllocationResult.
+// 1.1 added the optional gameServer block; 1.0 consumers can ignore it.
+// 1.2 added the Cancelled status.
+// 1.3 added gameServer.cluster.
+const ResultEnvelopeVersion = "1.3"
+
 type AllocationStatus string
 
 const (
-	StatusSuccess AllocationStatus = "Success"
-	StatusFailure AllocationStatus = "Failure"
-	StatusQueued  AllocationStatus = "Queued" // Player is queued waiting for a slot
+	StatusSuccess   AllocationStatus = "Success"
+	StatusFailure   AllocationStatus = "Failure"
+	StatusQueued    AllocationStatus = "Queued"    // Player is queued waiting for a slot
+	StatusCancelled AllocationStatus = "Cancelled" // Ticket was cancelled by an allocation-cancel message
 )
 
+// GameServerPort is a named port exposed by the allocated GameServer
+type GameServerPort struct {
+	Name string `json:"name"`
+	Port int32  `json:"port"`
+}
+
+// GameServerInfo describes the GameServer a player was placed on so clients
+// that don't route through Quilkin can connect directly
+type GameServerInfo struct {
+	Name     string           `json:"name"`
+	Fleet    string           `json:"fleet,omitempty"`
+	Address  string           `json:"address"`
+	Ports    []GameServerPort `json:"ports,omitempty"`
+	NodeName string           `json:"nodeName,omitempty"`
+	Cluster  string           `json:"cluster,omitempty"` // Registry cluster the GameServer runs in
+}
+
 type AllocationResult struct {
 	EnvelopeVersion string           `json:"envelopeVersion"`
 	Type            string           `json:"type"`
@@ -27,6 +116,7 @@ type AllocationResult struct {
 	ErrorMessage    *string          `json:"errorMessage,omitempty"`
 	QueuePosition   *int             `json:"queuePosition,omitempty"` // Position in queue if status is Queued
 	QueueID         *string          `json:"queueId,omitempty"`       // Identifier for the queue (e.g., gameserver name)
+	GameServer      *GameServerInfo  `json:"gameServer,omitempty"`    // Present on Success since envelope 1.1
 }
 
 type Subscriber interface {
@@ -15,6 +15,22 @@ func TestAllocationRequest_JSON(t *testing.T) {
 		{"empty optional", AllocationRequest{TicketID: "t2", Fleet: "f2"}},
 		{"with joinOnIds", AllocationRequest{TicketID: "t3", Fleet: "f3", PlayerID: "p3", JoinOnIDs: []string{"friend1", "friend2"}, CanJoinNotFound: true}},
 		{"joinOnIds empty", AllocationRequest{TicketID: "t4", Fleet: "f4", PlayerID: "p4", JoinOnIDs: []string{}, CanJoinNotFound: false}},
+		{"cancel", AllocationRequest{Type: RequestTypeCancel, TicketID: "t5"}},
+		{"priority", AllocationRequest{TicketID: "t6", Fleet: "f6", PlayerID: "p6", Priority: 2}},
+		{"selectors", AllocationRequest{TicketID: "t7", Fleet: "f7", PlayerID: "p7",
+			MatchLabels:       map[string]string{"map": "dust"},
+			MatchExpressions:  []SelectorRequirement{{Key: "version", Operator: "In", Values: []string{"1.2"}}},
+			GameServerState:   "Allocated",
+			FallbackSelectors: []GameServerSelector{{MatchLabels: map[string]string{"map": "dust"}}},
+		}},
+		{"metadata", AllocationRequest{TicketID: "t8", Fleet: "f8", PlayerID: "p8", Metadata: &GameServerMetadata{Labels: map[string]string{"match": "m-1"}, Annotations: map[string]string{"party": "p1"}}}},
+		{"counters and lists", AllocationRequest{TicketID: "t9", Fleet: "f9", PlayerID: "p9",
+			Priorities: []AllocationPriority{{Type: "List", Key: "players", Order: "Ascending"}},
+			Counters:   map[string]CounterAction{"rooms": {Action: "Increment", Amount: 1}},
+			Lists:      map[string]ListAction{"party": {AddValues: []string{"p9"}}},
+		}},
+		{"scheduling", AllocationRequest{TicketID: "t10", Fleet: "f10", PlayerID: "p10", Scheduling: "Distributed"}},
+		{"preferred regions", AllocationRequest{TicketID: "t11", Fleet: "f11", PlayerID: "p11", PreferredRegions: []string{"eu", "us"}}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -26,7 +42,7 @@ func TestAllocationRequest_JSON(t *testing.T) {
 			if err := json.Unmarshal(b, &out); err != nil {
 				t.Fatalf("unmarshal err: %#v", err)
 			}
-			if out.TicketID != tt.in.TicketID || out.Fleet != tt.in.Fleet || out.PlayerID != tt.in.PlayerID {
+			if out.Type != tt.in.Type || out.TicketID != tt.in.TicketID || out.Fleet != tt.in.Fleet || out.PlayerID != tt.in.PlayerID {
 				t.Errorf("round-trip mismatch\nin:  %#v\nout: %#v", tt.in, out)
 			}
 			if len(out.JoinOnIDs) != len(tt.in.JoinOnIDs) {
@@ -37,6 +53,9 @@ func TestAllocationRequest_JSON(t *testing.T) {
 					t.Errorf("JoinOnIDs[%d] mismatch: got %q, want %q", i, out.JoinOnIDs[i], tt.in.JoinOnIDs[i])
 				}
 			}
+			if out.Priority != tt.in.Priority {
+				t.Errorf("Priority mismatch: got %d, want %d", out.Priority, tt.in.Priority)
+			}
 			if out.CanJoinNotFound != tt.in.CanJoinNotFound {
 				t.Errorf("CanJoinNotFound mismatch: got %v, want %v", out.CanJoinNotFound, tt.in.CanJoinNotFound)
 			}
@@ -54,6 +73,19 @@ func TestAllocationResult_JSON(t *testing.T) {
 		{"success", AllocationResult{EnvelopeVersion: "1.0", Type: "allocation-result", TicketID: "t1", Status: StatusSuccess, Token: strPtr("tok")}},
 		{"failure", AllocationResult{EnvelopeVersion: "1.0", Type: "allocation-result", TicketID: "t2", Status: StatusFailure, ErrorMessage: strPtr("err")}},
 		{"queued", AllocationResult{EnvelopeVersion: "1.0", Type: "allocation-result", TicketID: "t3", Status: StatusQueued, QueuePosition: &queuePos, QueueID: &queueID}},
+		{"cancelled", AllocationResult{EnvelopeVersion: ResultEnvelopeVersion, Type: "allocation-result", TicketID: "t5", Status: StatusCancelled}},
+		{"success with gameServer", AllocationResult{EnvelopeVersion: ResultEnvelopeVersion, Type: "allocation-result", TicketID: "t4", Status: StatusSuccess, Token: strPtr("tok"), GameServer: &GameServerInfo{
+			Name:     "gs-1",
+			Fleet:    "f1",
+			Address:  "10.0.0.1",
+			Ports:    []GameServerPort{{Name: "default", Port: 7777}, {Name: "query", Port: 7778}},
+			NodeName: "node-a",
+		}}},
+		{"success with cluster", AllocationResult{EnvelopeVersion: ResultEnvelopeVersion, Type: "allocation-result", TicketID: "t6", Status: StatusSuccess, Token: strPtr("tok"), GameServer: &GameServerInfo{
+			Name:    "gs-2",
+			Address: "10.0.0.2",
+			Cluster: "eu-west",
+		}}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -0,0 +1,71 @@
+package webhook
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"agones-pubsub-allocator/queues"
+)
+
+// callbackTTL bounds how long a ticket's callback URL is kept without a final result
+const callbackTTL = time.Hour
+
+type callback struct {
+	url  string
+	seen time.Time
+}
+
+// callbacks remembers the callbackUrl of each request so its results can be
+// posted there. Entries are dropped after a final result or callbackTTL.
+type callbacks struct {
+	mu     sync.Mutex
+	routes map[string]callback
+	now    func() time.Time
+}
+
+func newCallbacks() *callbacks {
+	return &callbacks{routes: make(map[string]callback), now: time.Now}
+}
+
+func (c *callbacks) set(ticketID, url string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for id, cb := range c.routes {
+		if now.Sub(cb.seen) > callbackTTL {
+			delete(c.routes, id)
+		}
+	}
+	c.routes[ticketID] = callback{url: url, seen: now}
+}
+
+// take returns the callback URL for a result, forgetting it once the result is final
+func (c *callbacks) take(res *queues.AllocationResult) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cb, ok := c.routes[res.TicketID]
+	if ok && res.Status != queues.StatusQueued {
+		delete(c.routes, res.TicketID)
+	}
+	return cb.url, ok
+}
+
+// validCallback accepts absolute http(s) URLs whose host is allowed. A host
+// entry matches that host exactly; an entry starting with "." matches any
+// subdomain of it. Ports are not compared.
+func validCallback(raw string, hosts []string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	for _, allowed := range hosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || (strings.HasPrefix(allowed, ".") && strings.HasSuffix(host, allowed)) {
+			return true
+		}
+	}
+	return false
+}
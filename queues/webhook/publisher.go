@@ -0,0 +1,296 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"agones-pubsub-allocator/metrics"
+	"agones-pubsub-allocator/queues"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Headers set on every delivery. The signature is only sent when a secret is configured.
+const (
+	HeaderTimestamp = "X-Allocator-Timestamp"
+	HeaderSignature = "X-Allocator-Signature"
+	HeaderAttempt   = "X-Allocator-Attempt"
+)
+
+var (
+	// ErrQueueFull is returned by PublishResult when the retry queue has no room
+	ErrQueueFull = errors.New("webhook: delivery queue is full")
+	// ErrNoURL is returned for results with neither a callback nor a default URL
+	ErrNoURL  = errors.New("webhook: no URL for result")
+	errClosed = errors.New("webhook: publisher is closed")
+)
+
+type Options struct {
+	// URL receives every result whose request carried no accepted callbackUrl
+	URL    string
+	Secret string
+	// AllowCallbacks honours the per-request callbackUrl when its host is in CallbackHosts
+	AllowCallbacks bool
+	// CallbackHosts lists hosts, or ".domain" suffixes, callbacks may target
+	CallbackHosts  []string
+	Timeout        time.Duration
+	MaxAttempts    int
+	QueueSize      int
+	Workers        int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+type delivery struct {
+	url      string
+	ticketID string
+	body     []byte
+	attempt  int
+}
+
+// Publisher POSTs allocation results as JSON to a webhook. PublishResult only
+// queues the delivery; workers send it and retry failures with exponential
+// backoff, so a slow endpoint never blocks Controller.Handle.
+type Publisher struct {
+	opts      Options
+	client    *http.Client
+	callbacks *callbacks
+	queue     chan *delivery
+	stop      chan struct{}
+	stopOnce  sync.Once
+	pending   sync.WaitGroup // queued or waiting to retry
+	workers   sync.WaitGroup
+	mu        sync.RWMutex
+	closed    bool
+	now       func() time.Time
+}
+
+// NewPublisher starts the delivery workers; call Close to drain them
+func NewPublisher(opts Options) *Publisher {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 8
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1000
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 4
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 5 * time.Minute
+	}
+	p := &Publisher{
+		opts:      opts,
+		client:    &http.Client{Timeout: opts.Timeout},
+		callbacks: newCallbacks(),
+		queue:     make(chan *delivery, opts.QueueSize),
+		stop:      make(chan struct{}),
+		now:       time.Now,
+	}
+	for range opts.Workers {
+		p.workers.Add(1)
+		go p.work()
+	}
+	return p
+}
+
+// Track records the request's callbackUrl so its results are posted there.
+// Invalid URLs, URLs to hosts outside CallbackHosts, or any URL when callbacks
+// are not allowed, are ignored.
+func (p *Publisher) Track(req *queues.AllocationRequest) {
+	if req.CallbackURL == "" {
+		return
+	}
+	if !p.opts.AllowCallbacks || !validCallback(req.CallbackURL, p.opts.CallbackHosts) {
+		log.Warn().Str("ticketId", req.TicketID).Bool("allowed", p.opts.AllowCallbacks).Msg("webhook: ignoring request callbackUrl")
+		return
+	}
+	p.callbacks.set(req.TicketID, req.CallbackURL)
+}
+
+func (p *Publisher) PublishResult(ctx context.Context, res *queues.AllocationResult) error {
+	url := p.opts.URL
+	if cb, ok := p.callbacks.take(res); ok {
+		url = cb
+	}
+	if url == "" {
+		log.Error().Str("ticketId", res.TicketID).Msg("webhook: no callback or default URL for result")
+		return ErrNoURL
+	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		log.Error().Err(err).Interface("result", res).Msg("failed to marshal allocation result")
+		return err
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errClosed
+	}
+	p.pending.Add(1)
+	if !p.enqueue(&delivery{url: url, ticketID: res.TicketID, body: b}) {
+		p.pending.Done()
+		metrics.WebhookDeliveries.WithLabelValues("dropped").Inc()
+		log.Error().Str("ticketId", res.TicketID).Int("queueSize", p.opts.QueueSize).Msg("webhook: delivery queue full; result not queued")
+		return ErrQueueFull
+	}
+	log.Debug().Str("ticketId", res.TicketID).Str("status", string(res.Status)).Msg("webhook: queued allocation result")
+	return nil
+}
+
+// Close stops accepting results and waits until queued deliveries finish or ctx
+// is done; deliveries still waiting after that are abandoned.
+func (p *Publisher) Close(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		p.pending.Wait()
+		close(drained)
+	}()
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = fmt.Errorf("webhook: abandoning undelivered results: %w", ctx.Err())
+	}
+	p.stopOnce.Do(func() { close(p.stop) })
+	p.workers.Wait()
+	return err
+}
+
+func (p *Publisher) enqueue(d *delivery) bool {
+	select {
+	case p.queue <- d:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *Publisher) work() {
+	defer p.workers.Done()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case d := <-p.queue:
+			p.deliver(d)
+		}
+	}
+}
+
+// deliver makes one attempt and schedules the next on a transient failure
+func (p *Publisher) deliver(d *delivery) {
+	d.attempt++
+	err := p.post(d)
+	if err == nil {
+		metrics.WebhookDeliveries.WithLabelValues("delivered").Inc()
+		log.Debug().Str("ticketId", d.ticketID).Int("attempt", d.attempt).Msg("webhook: delivered allocation result")
+		p.pending.Done()
+		return
+	}
+	var perm *permanentError
+	if errors.As(err, &perm) || d.attempt >= p.opts.MaxAttempts {
+		metrics.WebhookDeliveries.WithLabelValues("failed").Inc()
+		log.Error().Err(err).Str("ticketId", d.ticketID).Int("attempt", d.attempt).Msg("webhook: giving up on allocation result")
+		p.pending.Done()
+		return
+	}
+
+	wait := p.backoff(d.attempt)
+	metrics.WebhookDeliveries.WithLabelValues("retried").Inc()
+	log.Warn().Err(err).Str("ticketId", d.ticketID).Int("attempt", d.attempt).Dur("retryIn", wait).Msg("webhook: delivery failed; will retry")
+	time.AfterFunc(wait, func() {
+		select {
+		case <-p.stop:
+			p.pending.Done()
+			return
+		default:
+		}
+		if !p.enqueue(d) {
+			metrics.WebhookDeliveries.WithLabelValues("dropped").Inc()
+			log.Error().Str("ticketId", d.ticketID).Msg("webhook: delivery queue full; dropping retry")
+			p.pending.Done()
+		}
+	})
+}
+
+// backoff is InitialBackoff doubled per failed attempt, capped at MaxBackoff
+func (p *Publisher) backoff(attempt int) time.Duration {
+	wait := p.opts.InitialBackoff
+	for i := 1; i < attempt && wait < p.opts.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, p.opts.MaxBackoff)
+}
+
+// permanentError is a failure retrying won't fix, such as a 4xx other than 408 or 429
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (p *Publisher) post(d *delivery) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(d.body))
+	if err != nil {
+		return &permanentError{err: err}
+	}
+	ts := strconv.FormatInt(p.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderTimestamp, ts)
+	req.Header.Set(HeaderAttempt, strconv.Itoa(d.attempt))
+	if p.opts.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(p.opts.Secret, ts, d.body))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	_ = resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("webhook: status %d", resp.StatusCode)
+	}
+	return &permanentError{err: fmt.Errorf("webhook: rejected with status %d", resp.StatusCode)}
+}
+
+// Sign returns the X-Allocator-Signature value: "sha256=" and the hex
+// HMAC-SHA256 of "<timestamp>.<body>" keyed with secret. Receivers recompute it
+// over the raw body and compare in constant time.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,255 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"agones-pubsub-allocator/queues"
+)
+
+type received struct {
+	path      string
+	body      []byte
+	timestamp string
+	signature string
+}
+
+// newEndpoint answers each POST with the next status in statuses, then 200
+func newEndpoint(t *testing.T, statuses ...int) (*httptest.Server, func() []received) {
+	t.Helper()
+	var (
+		mu    sync.Mutex
+		calls []received
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		n := len(calls)
+		calls = append(calls, received{path: r.URL.Path, body: body, timestamp: r.Header.Get(HeaderTimestamp), signature: r.Header.Get(HeaderSignature)})
+		mu.Unlock()
+		if n < len(statuses) {
+			w.WriteHeader(statuses[n])
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []received {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]received(nil), calls...)
+	}
+}
+
+func testOptions(url string) Options {
+	return Options{URL: url, Secret: "s3cret", MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+}
+
+func closePublisher(t *testing.T, p *Publisher) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.Close(ctx); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+}
+
+func result(ticketID string, status queues.AllocationStatus) *queues.AllocationResult {
+	return &queues.AllocationResult{EnvelopeVersion: queues.ResultEnvelopeVersion, Type: "allocation-result", TicketID: ticketID, Status: status}
+}
+
+func TestPublisher_Delivery(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []int
+		wantCalls int
+	}{
+		{name: "delivered", wantCalls: 1},
+		{name: "retries server errors", statuses: []int{500, 503}, wantCalls: 3},
+		{name: "retries too many requests", statuses: []int{429}, wantCalls: 2},
+		{name: "gives up after max attempts", statuses: []int{500, 500, 500, 500}, wantCalls: 3},
+		{name: "client error is not retried", statuses: []int{400}, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, calls := newEndpoint(t, tt.statuses...)
+			p := NewPublisher(testOptions(srv.URL + "/results"))
+			if err := p.PublishResult(context.Background(), result("t1", queues.StatusSuccess)); err != nil {
+				t.Fatalf("PublishResult() error: %v", err)
+			}
+			closePublisher(t, p)
+
+			got := calls()
+			if len(got) != tt.wantCalls {
+				t.Fatalf("calls = %d, want %d", len(got), tt.wantCalls)
+			}
+			last := got[len(got)-1]
+			if last.path != "/results" {
+				t.Errorf("path = %q, want /results", last.path)
+			}
+			if want := Sign("s3cret", last.timestamp, last.body); last.signature != want {
+				t.Errorf("signature = %q, want %q", last.signature, want)
+			}
+		})
+	}
+}
+
+func TestPublisher_Callbacks(t *testing.T) {
+	srv, calls := newEndpoint(t)
+
+	tests := []struct {
+		name     string
+		allow    bool
+		hosts    []string
+		callback string
+		wantPath []string
+	}{
+		{name: "default url", allow: true, hosts: []string{"127.0.0.1"}, wantPath: []string{"/default", "/default", "/default"}},
+		{name: "callback until final", allow: true, hosts: []string{"127.0.0.1"}, callback: srv.URL + "/cb", wantPath: []string{"/cb", "/cb", "/default"}},
+		{name: "callbacks disabled", hosts: []string{"127.0.0.1"}, callback: srv.URL + "/cb", wantPath: []string{"/default", "/default", "/default"}},
+		{name: "host not allowed", allow: true, hosts: []string{"hooks.example.com"}, callback: srv.URL + "/cb", wantPath: []string{"/default", "/default", "/default"}},
+		{name: "no hosts allowed", allow: true, callback: srv.URL + "/cb", wantPath: []string{"/default", "/default", "/default"}},
+		{name: "invalid callback", allow: true, hosts: []string{"127.0.0.1"}, callback: "file:///etc/passwd", wantPath: []string{"/default", "/default", "/default"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(calls())
+			opts := testOptions(srv.URL + "/default")
+			opts.AllowCallbacks = tt.allow
+			opts.CallbackHosts = tt.hosts
+			opts.Workers = 1
+			p := NewPublisher(opts)
+			p.Track(&queues.AllocationRequest{TicketID: "t1", Fleet: "f", CallbackURL: tt.callback})
+
+			// The second Success is a replay after the final result
+			for _, s := range []queues.AllocationStatus{queues.StatusQueued, queues.StatusSuccess, queues.StatusSuccess} {
+				if err := p.PublishResult(context.Background(), result("t1", s)); err != nil {
+					t.Fatalf("PublishResult() error: %v", err)
+				}
+			}
+			closePublisher(t, p)
+
+			got := calls()[before:]
+			if len(got) != len(tt.wantPath) {
+				t.Fatalf("calls = %d, want %d", len(got), len(tt.wantPath))
+			}
+			counts := map[string]int{}
+			for _, c := range got {
+				counts[c.path]++
+			}
+			want := map[string]int{}
+			for _, p := range tt.wantPath {
+				want[p]++
+			}
+			for path, n := range want {
+				if counts[path] != n {
+					t.Errorf("calls to %s = %d, want %d", path, counts[path], n)
+				}
+			}
+		})
+	}
+}
+
+func TestPublisher_NoURL(t *testing.T) {
+	p := NewPublisher(Options{AllowCallbacks: true})
+	defer closePublisher(t, p)
+	if err := p.PublishResult(context.Background(), result("t1", queues.StatusSuccess)); !errors.Is(err, ErrNoURL) {
+		t.Errorf("PublishResult() error = %v, want ErrNoURL", err)
+	}
+}
+
+func TestPublisher_QueueFull(t *testing.T) {
+	release := make(chan struct{})
+	var served atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served.Add(1)
+		<-release
+	}))
+	defer srv.Close()
+
+	opts := testOptions(srv.URL)
+	opts.Workers, opts.QueueSize = 1, 1
+	p := NewPublisher(opts)
+
+	// One delivery in flight, one queued, the third has no room
+	var err error
+	for i := 0; i < 3 && err == nil; i++ {
+		err = p.PublishResult(context.Background(), result("t1", queues.StatusSuccess))
+		for i == 0 && served.Load() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if !errors.Is(err, ErrQueueFull) {
+		t.Errorf("PublishResult() error = %v, want ErrQueueFull", err)
+	}
+	close(release)
+	closePublisher(t, p)
+}
+
+func TestPublisher_CloseRejectsResults(t *testing.T) {
+	p := NewPublisher(testOptions("http://127.0.0.1:1"))
+	closePublisher(t, p)
+	if err := p.PublishResult(context.Background(), result("t1", queues.StatusSuccess)); err == nil {
+		t.Error("PublishResult() after Close succeeded")
+	}
+}
+
+func TestPublisher_Backoff(t *testing.T) {
+	p := &Publisher{opts: Options{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second}}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{60, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := p.backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '1700000000.{}' | openssl dgst -sha256 -hmac key
+	want := "sha256=9d713ed406bb7076d4123f0dc2c39d2df5c654ed4b0cd56b52c8b4c940bd63ae"
+	if got := Sign("key", "1700000000", []byte("{}")); got != want {
+		t.Errorf("Sign() = %q, want %q", got, want)
+	}
+}
+
+func Test_validCallback(t *testing.T) {
+	hosts := []string{"hooks.example.com", ".svc.example.net"}
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://hooks.example.com/results", true},
+		{"http://HOOKS.example.com:8080/results", true},
+		{"https://hooks.example.com./results", true},
+		{"https://a.svc.example.net/results", true},
+		{"https://a.b.svc.example.net/results", true},
+		{"https://svc.example.net/results", false},
+		{"https://evilsvc.example.net/results", false},
+		{"https://other.example.com/results", false},
+		{"https://hooks.example.com.evil.io/results", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"ftp://hooks.example.com/results", false},
+		{"/results", false},
+	}
+	for _, tt := range tests {
+		if got := validCallback(tt.url, hosts); got != tt.want {
+			t.Errorf("validCallback(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}